@@ -1,17 +1,24 @@
 package GoSNMPServer
 
 import (
+	"bytes"
+	"context"
 	"reflect"
-	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gosnmp/gosnmp"
 	"github.com/pkg/errors"
-	"github.com/shirou/gopsutil/v3/host"
 )
 
 type FuncGetAuthoritativeEngineTime func() uint32
 
+// DefaultMaxMessageSize is the default max size of messages. The max payload of UDP over IPv4
+const DefaultMaxMessageSize = 65507
+
+// minMaxMessageSize is the least msgMaxSize an SNMP engine should support. See RFC 3412 section 6
+const minMaxMessageSize = 484
+
 // MasterAgent identifys software which runs on managed devices
 //
 //	One server (port) could ONLY have one MasterAgent
@@ -20,11 +27,32 @@ type MasterAgent struct {
 
 	SubAgents []*SubAgent
 
+	// VACM is the View-based Access Control Model for all SubAgents.
+	//      set to nil to disable VACM (all OIDs are accessable)
+	VACM *VACMConfig
+
+	// NotificationOriginator sends traps / informs from this agent.
+	NotificationOriginator NotificationOriginator
+
+	// MaxMessageSize limits the size of responses. 0 for DefaultMaxMessageSize.
+	//                The msgMaxSize of SNMPV3 requests is honoured as well. See RFC 3416 section 4.2
+	MaxMessageSize int
+
 	Logger ILogger
 
 	priv struct {
 		communityToSubAgent map[string]*SubAgent
+		contextToSubAgent   map[contextKey]*SubAgent
 		defaultSubAgent     *SubAgent
+		agentx              *agentxMaster
+		engine              *engineClock
+		// authFailures counts requests dropped for authentication. See AuthenticationFailures
+		authFailures uint32
+		// usmStats are counters of USM. See USMStats
+		usmStats [usmStatCount]uint32
+		usmKeys  *usmKeyCache
+		// builtin serves OIDs of MasterAgent itself, as usmStats
+		builtin *SubAgent
 	}
 }
 
@@ -34,15 +62,32 @@ type SecurityConfig struct {
 	// SnmpV3Only is used for mark only snmpv3 is supported
 	SnmpV3Only bool
 
+	// ServeUSMStats serves the usmStats counters as OIDUsmStats* in all SubAgents. See MasterAgent.USMStats
+	ServeUSMStats bool
+
 	// AuthoritativeEngineID is SNMPV3 AuthoritativeEngineID
 	AuthoritativeEngineID SNMPEngineID
 	// AuthoritativeEngineBoots is SNMPV3 AuthoritativeEngineBoots
+	//                          It is set by ReadyForWork to the boots of EngineStateStore if not nil
 	AuthoritativeEngineBoots uint32
+	// EngineStateStore keeps AuthoritativeEngineBoots, which increases on every ReadyForWork.
+	//                  set to nil to use the static AuthoritativeEngineBoots. See FileEngineStateStore
+	EngineStateStore EngineStateStore
 	// OnGetAuthoritativeEngineTime will be called to get SNMPV3 AuthoritativeEngineTime
-	//      if sets to nil, the sys boottime will be used
+	//      if sets to nil, the seconds since ReadyForWork will be used, which increases
+	//      AuthoritativeEngineBoots when it wraps. See RFC 3414 section 2.2.2
 	OnGetAuthoritativeEngineTime FuncGetAuthoritativeEngineTime
 
 	Users []gosnmp.UsmSecurityParameters
+
+	// UserACLs restricts Users to source networks. See SourceACL
+	UserACLs []*SourceACL
+
+	// UserContexts restricts users to contexts. See SubAgent.ContextNames
+	UserContexts []*UserContexts
+	// CommunityContexts lets SNMPV3 requests reach SubAgents without ContextNames by CommunityIDs of the
+	//                   contextName, as of old versions. Communities could open contexts of the same name then
+	CommunityContexts bool
 }
 
 func (v *SecurityConfig) FindForUser(name string) *gosnmp.UsmSecurityParameters {
@@ -57,33 +102,6 @@ func (v *SecurityConfig) FindForUser(name string) *gosnmp.UsmSecurityParameters
 	return nil
 }
 
-type SNMPEngineID struct {
-	// See https://tools.ietf.org/html/rfc3411#section-5
-	// 			SnmpEngineID ::= TEXTUAL-CONVENTION
-	//      SYNTAX       OCTET STRING (SIZE(5..32))
-	EngineIDData string
-}
-
-func (t *SNMPEngineID) Marshal() []byte {
-
-	// msgAuthoritativeEngineID: 80004fb8054445534b544f502d4a3732533245343ab63bc8
-	// 1... .... = Engine ID Conformance: RFC3411 (SNMPv3)
-	// Engine Enterprise ID: pysnmp (20408)
-	// Engine ID Format: Octets, administratively assigned (5)
-	// Engine ID Data: 4445534b544f502d4a3732533245343ab63bc8
-
-	var tm = []byte{
-		0x80, 0x00, 0x4f, 0xb8, 0x05,
-	}
-	toAppend := []byte(t.EngineIDData)
-	maxDefineallowed := 32 - 5
-	if len(toAppend) > maxDefineallowed { //Max 32 bytes
-		toAppend = toAppend[:maxDefineallowed]
-	}
-	tm = append(tm, toAppend...)
-	return tm
-}
-
 func (t *MasterAgent) syncAndCheck() error {
 	if len(t.SubAgents) == 0 {
 		return errors.WithStack(errors.Errorf("MasterAgent shell have at least one SubAgents"))
@@ -96,16 +114,66 @@ func (t *MasterAgent) syncAndCheck() error {
 		//Set New NIL Logger
 		t.Logger = NewDiscardLogger()
 	}
-	if t.SecurityConfig.OnGetAuthoritativeEngineTime == nil {
-		t.SecurityConfig.OnGetAuthoritativeEngineTime = DefaultGetAuthoritativeEngineTime
+	if t.SecurityConfig.AuthoritativeEngineID.isZero() {
+		engineID, err := t.defaultEngineID()
+		if err != nil {
+			return err
+		}
+		t.SecurityConfig.AuthoritativeEngineID = engineID
+	}
+	if _, err := t.SecurityConfig.AuthoritativeEngineID.marshal(); err != nil {
+		return err
 	}
+	return t.startEngine()
+}
 
-	if t.SecurityConfig.AuthoritativeEngineID.EngineIDData == "" {
-		t.SecurityConfig.AuthoritativeEngineID = DefaultAuthoritativeEngineID()
+// defaultEngineID returns the engineID of the running engine, or the one of EngineStateStore,
+//
+//	or a new one which is stored with boots by startEngine.
+func (t *MasterAgent) defaultEngineID() (SNMPEngineID, error) {
+	if t.priv.engine != nil {
+		return SNMPEngineID{Raw: t.priv.engine.engineID}, nil
+	}
+	store := t.SecurityConfig.EngineStateStore
+	if store == nil {
+		return DefaultAuthoritativeEngineID(), nil
 	}
+	stored, err := store.LoadEngineID()
+	if err != nil {
+		return SNMPEngineID{}, errors.WithMessage(err, "EngineStateStore")
+	}
+	if stored != nil {
+		return SNMPEngineID{Raw: stored}, nil
+	}
+	return RandomAuthoritativeEngineID(), nil
+}
+
+// startEngine starts the engineClock. A started engine of the same engineID is kept,
+//
+//	so that boots are not increased by ReadyForWork again or by SNMPServer.Reload
+func (t *MasterAgent) startEngine() error {
+	engineID := t.SecurityConfig.AuthoritativeEngineID.Marshal()
+	if t.priv.engine == nil || !bytes.Equal(t.priv.engine.engineID, engineID) {
+		engine, err := newEngineClock(engineID, t.SecurityConfig.EngineStateStore,
+			t.SecurityConfig.AuthoritativeEngineBoots, t.Logger, time.Now)
+		if err != nil {
+			return errors.WithMessage(err, "EngineStateStore")
+		}
+		t.priv.engine = engine
+	}
+	t.SecurityConfig.AuthoritativeEngineBoots, _ = t.priv.engine.bootsAndTime()
 	return nil
 }
 
+// engineBootsAndTime returns snmpEngineBoots and snmpEngineTime
+func (t *MasterAgent) engineBootsAndTime() (uint32, uint32) {
+	boots, engineTime := t.priv.engine.bootsAndTime()
+	if t.SecurityConfig.OnGetAuthoritativeEngineTime != nil {
+		engineTime = t.SecurityConfig.OnGetAuthoritativeEngineTime()
+	}
+	return boots, engineTime
+}
+
 func (t *MasterAgent) ReadyForWork() error {
 	if err := t.syncAndCheck(); err != nil {
 		return err
@@ -124,6 +192,13 @@ func (t *MasterAgent) getUserNameFromRequest(request *gosnmp.SnmpPacket) string
 }
 
 func (t *MasterAgent) ResponseForBuffer(i []byte) ([]byte, error) {
+	return t.ResponseForBufferContext(context.Background(), i)
+}
+
+// ResponseForBufferContext serves a request with ctx passed to OnGetContext / OnSetContext.
+//
+//	The source address of the request could be set with WithSourceAddress.
+func (t *MasterAgent) ResponseForBufferContext(ctx context.Context, i []byte) ([]byte, error) {
 	// Decode
 	vhandle := gosnmp.GoSNMP{}
 	vhandle.Logger = gosnmp.NewLogger(&SnmpLoggerAdapter{t.Logger})
@@ -137,12 +212,18 @@ func (t *MasterAgent) ResponseForBuffer(i []byte) ([]byte, error) {
 			return nil, errors.WithMessagef(ErrUnsupportedProtoVersion, "Server sets snmpV3 Only")
 		}
 
-		return t.marshalPkt(t.ResponseForPkt(request))
+		val, err := t.ResponseForPktContext(ctx, request)
+		return t.marshalResponse(request, val, err)
 		//
 	case gosnmp.Version3:
+		if !t.SecurityConfig.NoSecurity {
+			if report, ok, err := t.checkUSM(i); !ok {
+				return report, err
+			}
+		}
 		// check for initial - discover response / non Privacy Items
 		if decodeError == nil && len(request.Variables) == 0 {
-			val, err := t.ResponseForPkt(request)
+			val, err := t.ResponseForPktContext(ctx, request)
 
 			if val == nil {
 				return t.marshalPkt(request, err)
@@ -160,25 +241,15 @@ func (t *MasterAgent) ResponseForBuffer(i []byte) ([]byte, error) {
 			return nil, err
 		}
 
-		if !t.SecurityConfig.NoSecurity{
+		if !t.SecurityConfig.NoSecurity {
 			// https://pkg.go.dev/github.com/gosnmp/gosnmp#SnmpV3MsgFlags
-			userAuthMode := gosnmp.NoAuthNoPriv
-
-
-			if usm.AuthenticationProtocol > gosnmp.NoAuth {
-					userAuthMode = gosnmp.AuthNoPriv
-			}
-
-
-			if usm.PrivacyProtocol > gosnmp.NoPriv {
-					userAuthMode = gosnmp.AuthPriv
-			}
+			userAuthMode := getUserSecurityLevel(usm)
 
-			requestAuthMode := request.MsgFlags&gosnmp.AuthPriv /*3*/ 
+			requestAuthMode := request.MsgFlags & gosnmp.AuthPriv /*3*/
 
 			if requestAuthMode != gosnmp.SnmpV3MsgFlags(userAuthMode) {
-				return nil, 
-					errors.WithMessagef(ErrNoPermission, 
+				return nil,
+					errors.WithMessagef(ErrNoPermission,
 						"user %v required %v, got %v", username, userAuthMode.String(), request.MsgFlags.String())
 			}
 		}
@@ -193,13 +264,18 @@ func (t *MasterAgent) ResponseForBuffer(i []byte) ([]byte, error) {
 				PrivacyPassphrase:        usm.PrivacyPassphrase,
 				Logger:                   vhandle.Logger,
 			}
+			// GoSNMP decrypts in place. keep the message for the report
+			raw := append([]byte{}, i...)
 			request, err = vhandle.SnmpDecodePacket(i)
+			if err != nil && !t.SecurityConfig.NoSecurity && request.MsgFlags&gosnmp.AuthPriv == gosnmp.AuthPriv {
+				return t.reportDecryptionError(raw, usm)
+			}
 			if err != nil {
 				return nil, errors.WithMessagef(ErrUnsupportedPacketData, "GoSNMP Returns %v", err)
 			}
 		}
 
-		val, err := t.ResponseForPkt(request)
+		val, err := t.ResponseForPktContext(ctx, request)
 		if val == nil {
 			request.SecurityParameters = vhandle.SecurityParameters
 			return t.marshalPkt(request, err)
@@ -209,7 +285,7 @@ func (t *MasterAgent) ResponseForBuffer(i []byte) ([]byte, error) {
 			GenSalt(securityParamters)
 			val.SecurityParameters = securityParamters
 
-			return t.marshalPkt(val, err)
+			return t.marshalResponse(request, val, err)
 		}
 	}
 	return nil, errors.WithStack(ErrUnsupportedProtoVersion)
@@ -217,6 +293,9 @@ func (t *MasterAgent) ResponseForBuffer(i []byte) ([]byte, error) {
 
 func (t *MasterAgent) marshalPkt(pkt *gosnmp.SnmpPacket, err error) ([]byte, error) {
 	// when err. marshal error pkt
+	if errors.Is(err, ErrSourceNotAllowed) {
+		return nil, err
+	}
 	if pkt == nil {
 		pkt = &gosnmp.SnmpPacket{}
 	}
@@ -235,13 +314,75 @@ func (t *MasterAgent) marshalPkt(pkt *gosnmp.SnmpPacket, err error) ([]byte, err
 	return out, err
 }
 
+// getMaxMessageSize returns the max size of the response to request
+func (t *MasterAgent) getMaxMessageSize(request *gosnmp.SnmpPacket) int {
+	size := t.MaxMessageSize
+	if size <= 0 {
+		size = DefaultMaxMessageSize
+	}
+	if request != nil && request.Version == gosnmp.Version3 && request.MsgMaxSize >= minMaxMessageSize &&
+		int(request.MsgMaxSize) < size {
+		size = int(request.MsgMaxSize)
+	}
+	return size
+}
+
+// marshalResponse marshals the response of request within the max message size. See RFC 3416 section 4.2
+//
+//	Responses of GetBulkRequest are trimmed from the end. Others become tooBig with no varbind,
+//	which will be dropped silently if it still does not fit.
+func (t *MasterAgent) marshalResponse(request, response *gosnmp.SnmpPacket, err error) ([]byte, error) {
+	if err != nil || response == nil || response.PDUType != gosnmp.GetResponse {
+		return t.marshalPkt(response, err)
+	}
+	maxSize := t.getMaxMessageSize(request)
+	if response.Version == gosnmp.Version3 {
+		response.MsgMaxSize = uint32(t.getMaxMessageSize(nil))
+	}
+	out, err := t.marshalPkt(response, nil)
+	if err != nil || len(out) <= maxSize {
+		return out, err
+	}
+	t.Logger.Debugf("response of %v varbinds is %v bytes, larger than %v", len(response.Variables), len(out), maxSize)
+	if request.PDUType == gosnmp.GetBulkRequest {
+		all := response.Variables
+		var fitted []byte
+		// the most varbinds fit in (low, high) are searched
+		for low, high := -1, len(all); high-low > 1; {
+			mid := (low + high) / 2
+			response.Variables = all[:mid]
+			if out, err := response.MarshalMsg(); err == nil && len(out) <= maxSize {
+				fitted, low = out, mid
+			} else {
+				high = mid
+			}
+		}
+		if fitted != nil {
+			return fitted, nil
+		}
+	}
+	response.Error = gosnmp.TooBig
+	response.ErrorIndex = 0
+	response.Variables = []gosnmp.SnmpPDU{}
+	out, err = response.MarshalMsg()
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxSize {
+		t.Logger.Warnf("tooBig response of %v bytes is dropped", len(out))
+		return nil, nil
+	}
+	return out, nil
+}
+
 func (t *MasterAgent) getUsmSecurityParametersFromUser(username string) (*gosnmp.UsmSecurityParameters, error) {
+	boots, engineTime := t.engineBootsAndTime()
 	if username == "" {
 		return &gosnmp.UsmSecurityParameters{
 			Logger:                   gosnmp.NewLogger(&SnmpLoggerAdapter{t.Logger}),
 			AuthoritativeEngineID:    string(t.SecurityConfig.AuthoritativeEngineID.Marshal()),
-			AuthoritativeEngineBoots: t.SecurityConfig.AuthoritativeEngineBoots,
-			AuthoritativeEngineTime:  t.SecurityConfig.OnGetAuthoritativeEngineTime(),
+			AuthoritativeEngineBoots: boots,
+			AuthoritativeEngineTime:  engineTime,
 		}, nil
 
 	}
@@ -249,8 +390,11 @@ func (t *MasterAgent) getUsmSecurityParametersFromUser(username string) (*gosnmp
 		fval := val.Copy().(*gosnmp.UsmSecurityParameters)
 		fval.Logger = gosnmp.NewLogger(&SnmpLoggerAdapter{t.Logger})
 		fval.AuthoritativeEngineID = string(t.SecurityConfig.AuthoritativeEngineID.Marshal())
-		fval.AuthoritativeEngineBoots = t.SecurityConfig.AuthoritativeEngineBoots
-		fval.AuthoritativeEngineTime = t.SecurityConfig.OnGetAuthoritativeEngineTime()
+		fval.AuthoritativeEngineBoots = boots
+		fval.AuthoritativeEngineTime = engineTime
+		if t.priv.usmKeys != nil {
+			t.priv.usmKeys.localize(fval)
+		}
 		return fval, nil
 	} else {
 		return nil, errors.WithStack(ErrNoPermission)
@@ -276,19 +420,75 @@ func (t *MasterAgent) fillErrorPkt(err error, io *gosnmp.SnmpPacket) error {
 }
 
 func (t *MasterAgent) ResponseForPkt(i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket, error) {
+	return t.ResponseForPktContext(context.Background(), i)
+}
+
+// ResponseForPktContext serves a decoded request. The RequestInfo of it is added into ctx
+func (t *MasterAgent) ResponseForPktContext(ctx context.Context, i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket, error) {
 	// Find for which SubAgent
-	community := getPktContextOrCommunity(i)
-	subAgent := t.findForSubAgent(community)
+	var subAgent *SubAgent
+	if i.Version == gosnmp.Version3 {
+		subAgent = t.findForContext(i)
+	} else {
+		subAgent = t.findForSubAgent(i.Community)
+	}
 	if subAgent == nil {
 		return i, errors.WithStack(ErrNoSNMPInstance)
 	}
-	return subAgent.Serve(i)
+	if err := t.checkUserContext(i); err != nil {
+		return subAgent.getAuthorizationErrorPacket(i, err), nil
+	}
+	ctx = newRequestContext(ctx, i)
+	if err := t.checkSourceAccess(subAgent, i, RequestInfoFromContext(ctx).SourceAddress); err != nil {
+		if errors.Is(err, ErrNoPermission) {
+			return subAgent.getAuthorizationErrorPacket(i, err), nil
+		}
+		t.Logger.Warnf("request dropped: %v", err)
+		return nil, err
+	}
+	return subAgent.ServeContext(ctx, i)
+}
+
+// AuthenticationFailures returns the number of requests dropped for authentication, as sources not allowed
+func (t *MasterAgent) AuthenticationFailures() uint32 {
+	return atomic.LoadUint32(&t.priv.authFailures)
+}
+
+func (t *MasterAgent) countAuthenticationFailure() {
+	atomic.AddUint32(&t.priv.authFailures, 1)
 }
 
 func (t *MasterAgent) SyncConfig() error {
 	t.priv.defaultSubAgent = nil
 	t.priv.communityToSubAgent = make(map[string]*SubAgent)
 
+	if t.VACM != nil {
+		if err := t.VACM.SyncConfig(); err != nil {
+			return err
+		}
+	}
+	users := []string{}
+	for id := range t.SecurityConfig.Users {
+		users = append(users, t.SecurityConfig.Users[id].UserName)
+	}
+	if err := syncSourceACLs(t.SecurityConfig.UserACLs, users); err != nil {
+		return err
+	}
+	if err := t.NotificationOriginator.syncConfig(t); err != nil {
+		return err
+	}
+	if t.priv.agentx == nil {
+		t.priv.agentx = newAgentXMaster(t)
+	}
+	t.priv.usmKeys = newUsmKeyCache()
+	t.priv.builtin = nil
+	if t.SecurityConfig.ServeUSMStats {
+		t.priv.builtin = &SubAgent{Logger: t.Logger, master: t, OIDs: t.usmStatsOIDs()}
+		if err := t.priv.builtin.SyncConfig(); err != nil {
+			return err
+		}
+	}
+
 	for id, current := range t.SubAgents {
 		t.SubAgents[id].Logger = t.Logger
 		t.SubAgents[id].master = t
@@ -296,7 +496,7 @@ func (t *MasterAgent) SyncConfig() error {
 			return err
 		}
 
-		if len(current.CommunityIDs) == 0 || t.SecurityConfig.NoSecurity {
+		if (len(current.CommunityIDs) == 0 && len(current.ContextNames) == 0) || t.SecurityConfig.NoSecurity {
 			if t.priv.defaultSubAgent != nil {
 				return errors.Errorf("SyncConfig: Config Error: duplicate default agent")
 			}
@@ -312,7 +512,7 @@ func (t *MasterAgent) SyncConfig() error {
 		}
 
 	}
-	return nil
+	return t.syncContexts()
 }
 
 func (t *MasterAgent) findForSubAgent(community string) *SubAgent {
@@ -326,19 +526,12 @@ func (t *MasterAgent) findForSubAgent(community string) *SubAgent {
 	} 
 }
 
-func DefaultAuthoritativeEngineID() SNMPEngineID {
-	// XXX:TODO: return random
-	val, _ := host.Info()
-	data := strings.Replace(val.HostID, "-", "", -1)
-	return SNMPEngineID{
-		EngineIDData: data,
-	}
-}
+// processStart is when this process starts. See DefaultGetAuthoritativeEngineTime
+var processStart = time.Now()
 
+// DefaultGetAuthoritativeEngineTime returns the seconds since this process starts.
+//
+//	MasterAgent counts from ReadyForWork when OnGetAuthoritativeEngineTime is nil.
 func DefaultGetAuthoritativeEngineTime() uint32 {
-	val, err := host.Uptime()
-	if err != nil {
-		return uint32(time.Now().Unix())
-	}
-	return uint32(val)
+	return uint32(time.Since(processStart) / time.Second)
 }
@@ -1,9 +1,11 @@
 package GoSNMPServer
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/gosnmp/gosnmp"
 	"github.com/pkg/errors"
@@ -15,15 +17,48 @@ type SubAgent struct {
 
 	CommunityIDs []string
 
+	// ContextNames selects SNMPV3 requests of contextNames, which never match SNMPV1 / V2c communities.
+	//              SubAgents without ContextNames take SNMPV3 requests of contextNames in CommunityIDs
+	//              only with SecurityConfig.CommunityContexts, as of old versions
+	ContextNames []string
+	// ContextEngineID is the contextEngineID of ContextNames. zero for SecurityConfig.AuthoritativeEngineID
+	ContextEngineID SNMPEngineID
+
+	// CommunityACLs restricts CommunityIDs to source networks. See SourceACL
+	CommunityACLs []*SourceACL
+
 	// OIDs for Read/Write actions
 	OIDs []*PDUValueControlItem
 
+	// Tables for Read/Write actions. Rows are read on each request.
+	Tables []*Table
+
+	// Subtrees are served by their handlers, merged with OIDs by order. See SubtreeHandler
+	Subtrees []*Subtree
+
+	// Proxies forward requests of their subtrees to other SNMP agents. See ProxyTarget
+	Proxies []*ProxyTarget
+
+	// OnCreateOID will be called on SET of an OID not in OIDs. set to nil to disable creation.
+	//             see FuncPDUControlCreate
+	OnCreateOID FuncPDUControlCreate
+
 	// UserErrorMarkPacket decides if shll treat user returned error as generr
 	UserErrorMarkPacket bool
 
 	Logger ILogger
 
 	master *MasterAgent
+	// proxyRegions are the subtrees of Proxies by order
+	proxyRegions []proxyRegion
+	// mu guards OIDs, which are added / removed at runtime while requests are served concurrently
+	mu sync.RWMutex
+	// oids indexes OIDs. built by SyncConfig
+	oids *oidIndex
+	// setMu serializes SET requests, so that phases of two requests never interleave
+	setMu sync.Mutex
+	// setObservers follow SET requests, as RowStatusTable for the rows created by them
+	setObservers []setObserver
 }
 
 func (t *SubAgent) SyncConfig() error {
@@ -36,27 +71,130 @@ func (t *SubAgent) SyncConfig() error {
 		}
 	}
 	t.Logger.Debugf("Total OIDs of %v: %v", t.CommunityIDs, len(t.OIDs))
+	for _, table := range t.Tables {
+		if err = table.SyncConfig(); err != nil {
+			return err
+		}
+	}
 
+	if err = t.syncSubtrees(); err != nil {
+		return err
+	}
+	if err = t.syncProxies(); err != nil {
+		return err
+	}
+	var communities []string
+	if len(t.CommunityIDs) != 0 {
+		communities = t.CommunityIDs
+	}
+	if err = syncSourceACLs(t.CommunityACLs, communities); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	index := &oidIndex{}
+	for _, each := range t.OIDs {
+		if each.arcs, err = parseOID(each.OID); err != nil {
+			return err
+		}
+		if !index.insert(each.arcs, each) {
+			return fmt.Errorf("community %v: meet duplicate oid %v", t.CommunityIDs, each.OID)
+		}
+	}
 	sort.Sort(byOID(t.OIDs))
-	for id, each := range t.OIDs {
+	for _, each := range t.OIDs {
 		t.Logger.Infof("OIDs of %v: %v", t.CommunityIDs, each.OID)
-		if id != 0 && t.OIDs[id].OID == t.OIDs[id-1].OID {
+	}
+	t.oids = index
+	return nil
+}
+
+// indexOIDs returns the index of OIDs, which is built if SyncConfig is not called yet. t.mu shell be held
+func (t *SubAgent) indexOIDs() *oidIndex {
+	if t.oids == nil {
+		t.oids = &oidIndex{}
+		for _, each := range t.OIDs {
+			if arcs, err := parseOID(each.OID); err == nil {
+				each.arcs = arcs
+				t.oids.insert(arcs, each)
+			}
+		}
+		sort.Sort(byOID(t.OIDs))
+	}
+	return t.oids
+}
+
+// AddOIDs adds items into OIDs at runtime.
+func (t *SubAgent) AddOIDs(items ...*PDUValueControlItem) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	index := t.indexOIDs()
+	for _, each := range items {
+		arcs, err := parseOID(each.OID)
+		if err != nil {
+			return err
+		}
+		if !index.insert(arcs, each) {
 			return fmt.Errorf("community %v: meet duplicate oid %v", t.CommunityIDs, each.OID)
 		}
+		each.arcs = arcs
+		id := t.searchOIDs(arcs)
+		t.OIDs = append(t.OIDs, nil)
+		copy(t.OIDs[id+1:], t.OIDs[id:])
+		t.OIDs[id] = each
 	}
 	return nil
 }
 
+// RemoveOIDs removes items from OIDs at runtime. Not existing OIDs are ignored.
+func (t *SubAgent) RemoveOIDs(oids ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	index := t.indexOIDs()
+	for _, each := range oids {
+		arcs, err := parseOID(each)
+		if err != nil {
+			continue
+		}
+		if item := index.remove(arcs); item != nil {
+			id := t.searchOIDs(arcs)
+			t.OIDs = append(t.OIDs[:id], t.OIDs[id+1:]...)
+		}
+	}
+}
+
+// createForPDUValueControl asks OnCreateOID for a not existing OID
+func (t *SubAgent) createForPDUValueControl(oid string) (*PDUValueControlItem, error) {
+	if t.OnCreateOID == nil {
+		return nil, nil
+	}
+	items, err := t.OnCreateOID(oid)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.AddOIDs(items...); err != nil {
+		return nil, err
+	}
+	item := t.getForPDUValueControl(oid)
+	return item, nil
+}
+
 func (t *SubAgent) Serve(i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket, error) {
+	return t.ServeContext(newRequestContext(context.Background(), i), i)
+}
+
+// ServeContext serves a request with ctx passed to OnGetContext / OnSetContext. See MasterAgent.ResponseForPktContext
+func (t *SubAgent) ServeContext(ctx context.Context, i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket, error) {
 	switch i.PDUType {
 	case gosnmp.GetRequest:
-		return t.serveGetRequest(i)
+		return t.serveGetRequest(ctx, i)
 	case gosnmp.GetNextRequest:
-		return t.serveGetNextRequest(i)
+		return t.serveGetNextRequest(ctx, i)
 	case gosnmp.GetBulkRequest:
-		return t.serveGetBulkRequest(i)
+		return t.serveGetBulkRequest(ctx, i)
 	case gosnmp.SetRequest:
-		return t.serveSetRequest(i)
+		return t.serveSetRequest(ctx, i)
 	case gosnmp.Trap, gosnmp.SNMPv2Trap, gosnmp.InformRequest:
 		return t.serveTrap(i)
 	default:
@@ -71,6 +209,209 @@ func (t *SubAgent) checkPermission(whichPDU *PDUValueControlItem, request *gosnm
 	return whichPDU.OnCheckPermission(request.Version, request.PDUType, getPktContextOrCommunity(request))
 }
 
+// getMaxMessageSize returns the max size of the response to request
+func (t *SubAgent) getMaxMessageSize(request *gosnmp.SnmpPacket) int {
+	if t.master == nil {
+		return DefaultMaxMessageSize
+	}
+	return t.master.getMaxMessageSize(request)
+}
+
+// getVACMView returns the VACM view for the request. nil view allows all.
+func (t *SubAgent) getVACMView(request *gosnmp.SnmpPacket, viewType VACMViewType) (*vacmView, error) {
+	if t.master == nil || t.master.VACM == nil {
+		return nil, nil
+	}
+	return t.master.VACM.view(getPktSecurityModel(request), getPktSecurityName(request),
+		getPktSecurityLevel(request), getPktVACMContextName(request), viewType)
+}
+
+// oidResolver finds items in OIDs, Tables, Subtrees, AgentX subagents and Proxies for one request.
+//
+//	Rows of Tables are read once.
+type oidResolver struct {
+	agent     *SubAgent
+	snapshots []*tableSnapshot
+	// remote is nil if no AgentX subagent registers in the context of the request
+	remote *agentxRequest
+	// proxy is nil if the SubAgent has no Proxies
+	proxy *proxyRequest
+	// builtin serves OIDs of the MasterAgent, as usmStats. nil without a MasterAgent
+	builtin *SubAgent
+}
+
+func (t *SubAgent) newOIDResolver(request *gosnmp.SnmpPacket) *oidResolver {
+	ret := &oidResolver{agent: t, proxy: t.newProxyRequest(request)}
+	if t.master != nil && t.master.priv.agentx != nil {
+		ret.remote = t.master.priv.agentx.newRequest(request)
+	}
+	if t.master != nil && t.master.priv.builtin != t {
+		ret.builtin = t.master.priv.builtin
+	}
+	return ret
+}
+
+// setMaxRepetitions lets AgentX subagents and Proxies prefetch results of GetNext
+func (r *oidResolver) setMaxRepetitions(maxRepetitions int) {
+	if r.remote != nil {
+		r.remote.maxRepetitions = maxRepetitions
+	}
+	if r.proxy != nil {
+		r.proxy.maxRepetitions = maxRepetitions
+	}
+}
+
+func (r *oidResolver) tables() []*tableSnapshot {
+	if r.snapshots == nil {
+		r.snapshots = make([]*tableSnapshot, 0, len(r.agent.Tables))
+		for _, each := range r.agent.Tables {
+			r.snapshots = append(r.snapshots, newTableSnapshot(each, r.agent.Logger))
+		}
+	}
+	return r.snapshots
+}
+
+// get returns the item of oid. nil if not exists
+func (r *oidResolver) get(oid string) *PDUValueControlItem {
+	if item := r.getLocal(oid); item != nil {
+		return item
+	}
+	if VerifyOid(oid) != nil {
+		return nil
+	}
+	if r.remote != nil {
+		if item := r.remote.get(oid); item != nil {
+			return item
+		}
+	}
+	if r.proxy != nil {
+		return r.proxy.get(oid)
+	}
+	return nil
+}
+
+// getForSet returns the item of oid to set. OIDs registered by AgentX subagents or forwarded by
+//
+//	Proxies are set by them with the callbacks of the resolver.
+func (r *oidResolver) getForSet(oid string) *PDUValueControlItem {
+	if item := r.getLocal(oid); item != nil {
+		return item
+	}
+	if VerifyOid(oid) != nil {
+		return nil
+	}
+	if r.remote != nil {
+		if item := r.remote.setItem(oid); item != nil {
+			return item
+		}
+	}
+	if r.proxy != nil {
+		return r.proxy.setItem(oid)
+	}
+	return nil
+}
+
+// getLocal returns the item of oid in OIDs, Tables, Subtrees and OIDs of the MasterAgent
+func (r *oidResolver) getLocal(oid string) *PDUValueControlItem {
+	if item := r.agent.getForPDUValueControl(oid); item != nil {
+		return item
+	}
+	if r.builtin != nil {
+		if item := r.builtin.getForPDUValueControl(oid); item != nil {
+			return item
+		}
+	}
+	if len(r.agent.Tables) == 0 && len(r.agent.Subtrees) == 0 {
+		return nil
+	}
+	query, err := parseOID(oid)
+	if err != nil {
+		return nil
+	}
+	for _, each := range r.tables() {
+		if item := each.get(query); item != nil {
+			return item
+		}
+	}
+	for _, each := range r.agent.Subtrees {
+		if item := each.get(query, r.agent.Logger); item != nil {
+			return item
+		}
+	}
+	return nil
+}
+
+// next returns the first item after oid which is in the view. nil for endOfMibView
+//
+//	walkableOnly skips NonWalkable and write-only items.
+func (r *oidResolver) next(oid string, view *vacmView, walkableOnly bool) *PDUValueControlItem {
+	query, err := parseOID(oid)
+	if err != nil {
+		return nil
+	}
+	for {
+		found := r.agent.nextForArcs(query)
+		var foundArcs ByteString
+		if found != nil {
+			foundArcs = found.oidArcs()
+		}
+		// keep takes item if it is before found
+		keep := func(item *PDUValueControlItem) {
+			if item == nil {
+				return
+			}
+			arcs := item.oidArcs()
+			if arcs == nil {
+				r.agent.Logger.Warnf("next of %v: not valid oid %v", byteStringToOID(query), item.OID)
+				return
+			}
+			if found == nil || compareByteString(arcs, foundArcs) == ByteStringCompareResultLessThen {
+				found, foundArcs = item, arcs
+			}
+		}
+		if r.builtin != nil {
+			keep(r.builtin.nextForArcs(query))
+		}
+		for _, each := range r.tables() {
+			keep(each.next(query))
+		}
+		for _, each := range r.agent.Subtrees {
+			// items of a subtree are after its OID, and Subtrees are sorted. The rest are not asked then
+			if found != nil && compareByteString(each.arcs, foundArcs) != ByteStringCompareResultLessThen {
+				break
+			}
+			keep(each.next(query, r.agent.Logger))
+		}
+		if r.remote != nil {
+			keep(r.remote.next(query))
+		}
+		if r.proxy != nil {
+			keep(r.proxy.next(query))
+		}
+		if found == nil {
+			return nil
+		}
+		if view.containsArcs(foundArcs) && (!walkableOnly || (!found.NonWalkable && found.readable())) {
+			return found
+		}
+		query = foundArcs
+	}
+}
+
+// getAuthorizationErrorPacket returns a response for requests denied by VACM
+func (t *SubAgent) getAuthorizationErrorPacket(i *gosnmp.SnmpPacket, err error) *gosnmp.SnmpPacket {
+	var ret gosnmp.SnmpPacket = copySnmpPacket(i)
+	t.Logger.Debugf("request denied: %v", err)
+	ret.PDUType = gosnmp.GetResponse
+	ret.Error = gosnmp.AuthorizationError
+	if i.Version == gosnmp.Version1 {
+		// SNMPv1 has no authorizationError. See RFC 3584 section 4.4
+		ret.Error = gosnmp.NoSuchName
+	}
+	ret.ErrorIndex = 0
+	return &ret
+}
+
 func (t *SubAgent) getPDU(Name string, Type gosnmp.Asn1BER, Value interface{}) gosnmp.SnmpPDU {
 	return gosnmp.SnmpPDU{
 		Name:  Name,
@@ -121,12 +462,12 @@ func (t *SubAgent) getPDUOctetString(Name, str string) gosnmp.SnmpPDU {
 	)
 }
 
-func (t *SubAgent) getForPDUValueControlResult(item *PDUValueControlItem,
+func (t *SubAgent) getForPDUValueControlResult(ctx context.Context, item *PDUValueControlItem,
 	i *gosnmp.SnmpPacket) (pdu gosnmp.SnmpPDU, errret gosnmp.SNMPError) {
 	if t.checkPermission(item, i) != PermissionAllowanceAllowed {
 		return t.getPDUNil(item.OID), gosnmp.NoAccess
 	}
-	if item.OnGet == nil {
+	if !item.readable() {
 		return t.getPDUNil(item.OID), gosnmp.ResourceUnavailable
 	}
 	defer func() {
@@ -139,7 +480,7 @@ func (t *SubAgent) getForPDUValueControlResult(item *PDUValueControlItem,
 			return
 		}
 	}()
-	valtoRet, err := item.OnGet()
+	valtoRet, err := item.get(ctx)
 	if err != nil {
 		if t.UserErrorMarkPacket {
 			errret = gosnmp.GenErr
@@ -193,7 +534,7 @@ func (t *SubAgent) trapForPDUValueControlResult(item *PDUValueControlItem,
 	}, gosnmp.NoError
 }
 
-func (t *SubAgent) serveGetRequest(i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket, error) {
+func (t *SubAgent) serveGetRequest(ctx context.Context, i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket, error) {
 	var ret gosnmp.SnmpPacket = copySnmpPacket(i)
 	t.Logger.Debugf("before copy: %v...After copy:%v",
 		i.SecurityParameters.(*gosnmp.UsmSecurityParameters),
@@ -209,9 +550,17 @@ func (t *SubAgent) serveGetRequest(i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket, er
 		ret.Variables = append(ret.Variables, t.getPDUHelloVariable())
 		return &ret, nil
 	}
+	view, err := t.getVACMView(i, VACMViewRead)
+	if err != nil {
+		return t.getAuthorizationErrorPacket(i, err), nil
+	}
+	resolver := t.newOIDResolver(i)
+	if resolver.proxy != nil {
+		resolver.proxy.prefetch(i.Variables)
+	}
 	for id, varItem := range i.Variables {
-		item, _ := t.getForPDUValueControl(varItem.Name)
-		if item == nil {
+		item := resolver.get(varItem.Name)
+		if item == nil || !view.contains(item.OID) {
 			if ret.Error == gosnmp.NoError {
 				ret.Error = gosnmp.NoSuchName
 				ret.ErrorIndex = uint8(id)
@@ -220,7 +569,7 @@ func (t *SubAgent) serveGetRequest(i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket, er
 			continue
 		}
 
-		ctl, snmperr := t.getForPDUValueControlResult(item, i)
+		ctl, snmperr := t.getForPDUValueControlResult(ctx, item, i)
 		if snmperr != gosnmp.NoError && ret.Error == gosnmp.NoError {
 			ret.Error = snmperr
 			ret.ErrorIndex = uint8(id)
@@ -242,7 +591,7 @@ func (t *SubAgent) serveTrap(i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket, error) {
 	ret.Variables = []gosnmp.SnmpPDU{}
 	t.Logger.Debugf("i.Version == %v len(i.Variables) = %v.", i.Version, len(i.Variables))
 	for id, varItem := range i.Variables {
-		item, _ := t.getForPDUValueControl(varItem.Name)
+		item := t.getForPDUValueControl(varItem.Name)
 		if item == nil {
 			if ret.Error == gosnmp.NoError {
 				ret.Error = gosnmp.NoSuchName
@@ -267,67 +616,89 @@ func (t *SubAgent) serveTrap(i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket, error) {
 
 }
 
-func (t *SubAgent) serveGetBulkRequest(i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket, error) {
+func (t *SubAgent) serveGetBulkRequest(ctx context.Context, i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket, error) {
+	view, err := t.getVACMView(i, VACMViewRead)
+	if err != nil {
+		return t.getAuthorizationErrorPacket(i, err), nil
+	}
 	var ret gosnmp.SnmpPacket = copySnmpPacket(i)
 	ret.PDUType = gosnmp.GetResponse
 	ret.Variables = []gosnmp.SnmpPDU{}
 	vc := uint8(len(i.Variables))
 	t.Logger.Debugf("serveGetBulkRequest (vars=%d, non-repeaters=%d, max-repetitions=%d", vc, i.NonRepeaters, i.MaxRepetitions)
 
+	resolver := t.newOIDResolver(i)
+	resolver.setMaxRepetitions(int(i.MaxRepetitions))
+	// repetitions stop when the varbinds could not fit in a message. trimmed on marshal
+	maxSize := t.getMaxMessageSize(i)
+	size := 0
 	// handle Non-Repeaters
 	t.Logger.Debugf("handle non-repeaters (%d)", i.NonRepeaters)
 	for j := uint8(0); j < i.NonRepeaters; j++ {
 		queryForOid := i.Variables[j].Name
 		queryForOidStriped := strings.TrimLeft(queryForOid, ".0")
-		item, id := t.getForPDUValueControl(queryForOidStriped)
-		t.Logger.Debugf("(non-repeater) t.getForPDUValueControl. query_for_oid=%v item=%v id=%v", queryForOid, item, id)
-		if id >= len(t.OIDs) {
+		item := resolver.next(queryForOidStriped, view, false)
+		t.Logger.Debugf("(non-repeater) resolver.next. query_for_oid=%v item=%v", queryForOid, item)
+		if item == nil {
 			ret.Variables = append(ret.Variables, t.getPDUEndOfMibView(queryForOid))
 			continue
 		}
-		item = t.OIDs[id]
 
-		ctl, snmperr := t.getForPDUValueControlResult(item, i)
+		ctl, snmperr := t.getForPDUValueControlResult(ctx, item, i)
 		if snmperr != gosnmp.NoError && ret.Error == gosnmp.NoError {
 			ret.Error = snmperr
 			ret.ErrorIndex = j
 		}
 		ret.Variables = append(ret.Variables, ctl)
+		size += estimateVarBindSize(ctl)
 	}
 
 	t.Logger.Debugf("handle remaining (%d, max-repetitions=%d)", vc-i.NonRepeaters, i.MaxRepetitions)
+	// cursors keeps the last OID returned for each repeater
+	cursors := make([]string, vc)
+	for k := i.NonRepeaters; k < vc; k++ {
+		cursors[k] = strings.TrimLeft(i.Variables[k].Name, ".0")
+	}
 	eomv := make(map[string]struct{})
-	for j := uint32(0); j < i.MaxRepetitions; j++ { // loop through repetitions
+	for j := uint32(0); j < i.MaxRepetitions && size <= maxSize && ctx.Err() == nil; j++ { // loop through repetitions
+		more := false
 		for k := i.NonRepeaters; k < vc; k++ { // loop through "repeaters"
 			queryForOid := i.Variables[k].Name
-			queryForOidStriped := strings.TrimLeft(queryForOid, ".0")
-			item, id := t.getForPDUValueControl(queryForOidStriped)
-			if item != nil {
-				id += 1
+			if _, found := eomv[queryForOid]; found {
+				continue
 			}
-			nextIndex := id + int(j)
-			if nextIndex >= len(t.OIDs) {
-				if _, found := eomv[queryForOid]; !found {
-					ret.Variables = append(ret.Variables, t.getPDUEndOfMibView(queryForOid))
-					eomv[queryForOid] = struct{}{}
-				}
+			item := resolver.next(cursors[k], view, false) // repetition next
+			if item == nil {
+				ret.Variables = append(ret.Variables, t.getPDUEndOfMibView(queryForOid))
+				size += estimateVarBindSize(ret.Variables[len(ret.Variables)-1])
+				eomv[queryForOid] = struct{}{}
 				continue
 			}
-			item = t.OIDs[nextIndex] // repetition next
-			t.Logger.Debugf("t.getForPDUValueControl. query_for_oid=%v item=%v id=%v", queryForOid, item, id)
-			ctl, snmperr := t.getForPDUValueControlResult(item, i)
+			cursors[k] = item.OID
+			more = true
+			t.Logger.Debugf("resolver.next. query_for_oid=%v item=%v", queryForOid, item.OID)
+			ctl, snmperr := t.getForPDUValueControlResult(ctx, item, i)
 			if snmperr != gosnmp.NoError && ret.Error == gosnmp.NoError {
 				ret.Error = snmperr
 				ret.ErrorIndex = k
 			}
 			ret.Variables = append(ret.Variables, ctl)
+			size += estimateVarBindSize(ctl)
+		}
+		if !more {
+			// all repeaters reach endOfMibView
+			break
 		}
 	}
 
 	return &ret, nil
 }
 
-func (t *SubAgent) serveGetNextRequest(i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket, error) {
+func (t *SubAgent) serveGetNextRequest(ctx context.Context, i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket, error) {
+	view, err := t.getVACMView(i, VACMViewRead)
+	if err != nil {
+		return t.getAuthorizationErrorPacket(i, err), nil
+	}
 	var ret gosnmp.SnmpPacket = copySnmpPacket(i)
 
 	ret.PDUType = gosnmp.GetResponse
@@ -336,46 +707,24 @@ func (t *SubAgent) serveGetNextRequest(i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket
 	queryForOid := i.Variables[length-1].Name
 	queryForOidStriped := strings.TrimLeft(queryForOid, ".0")
 	t.Logger.Debugf("serveGetNextRequest of %v", queryForOid)
-	item, id := t.getForPDUValueControl(queryForOidStriped)
-	t.Logger.Debugf("t.getForPDUValueControl. query_for_oid=%v item=%v id=%v", queryForOid, item, id)
-	if item != nil {
-		id += 1
-	}
-	if id >= len(t.OIDs) {
-		// NOT find for the last
-		ret.Variables = append(ret.Variables, t.getPDUEndOfMibView(queryForOid))
-		return &ret, nil
-	}
 	if i.MaxRepetitions != 0 {
 		length = int(i.MaxRepetitions)
 	}
-	if length+id > len(t.OIDs) {
-		length = len(t.OIDs) - id
-	}
-	t.Logger.Debugf("i.Variables[id: length]. id=%v length =%v. len(t.OIDs)=%v", id, length, len(t.OIDs))
-	iid := id
-	for {
-		if iid >= len(t.OIDs) {
-			break
-		}
-		item := t.OIDs[iid]
-		if len(ret.Variables) >= length {
+	resolver := t.newOIDResolver(i)
+	cursor := queryForOidStriped
+	for len(ret.Variables) < length && (len(ret.Variables) == 0 || ctx.Err() == nil) {
+		item := resolver.next(cursor, view, true)
+		if item == nil {
 			break
 		}
-
-		if item.NonWalkable || item.OnGet == nil {
-			t.Logger.Debugf("getnext: oid=%v. skip for non walkable", item.OID)
-			iid += 1
-			continue // skip non-walkable items
-		}
-		ctl, snmperr := t.getForPDUValueControlResult(item, i)
+		cursor = item.OID
+		ctl, snmperr := t.getForPDUValueControlResult(ctx, item, i)
 		if snmperr != gosnmp.NoError && ret.Error == gosnmp.NoError {
 			ret.Error = snmperr
-			ret.ErrorIndex = uint8(iid)
+			ret.ErrorIndex = uint8(len(ret.Variables))
 		}
 		t.Logger.Debugf("getnext: append oid=%v. result=%v err=%v", item.OID, ctl, snmperr)
 		ret.Variables = append(ret.Variables, ctl)
-		iid += 1
 	}
 
 	if len(ret.Variables) == 0 {
@@ -386,15 +735,66 @@ func (t *SubAgent) serveGetNextRequest(i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket
 	return &ret, nil
 }
 
-// serveSetRequest for SetReqeust.
+// setRequestItem is a varbind of a SET request resolved to its PDUValueControlItem
+type setRequestItem struct {
+	id      int
+	varItem gosnmp.SnmpPDU
+	item    *PDUValueControlItem
+}
+
+// setObserver follows SET requests of a SubAgent, as RowStatusTable does for the rows created on SET
+type setObserver interface {
+	// testSet checks all the varbinds of a SET together in the test phase. returns the failed one and its status
+	testSet(items []setRequestItem) (*setRequestItem, gosnmp.SNMPError)
+	// endSet is called when a SET is done, failed or not
+	endSet()
+}
+
+// testSetObservers calls testSet of setObservers. returns the first failed item
+func (t *SubAgent) testSetObservers(items []setRequestItem) (*setRequestItem, gosnmp.SNMPError) {
+	for _, each := range t.setObservers {
+		if failed, status := each.testSet(items); failed != nil {
+			return failed, status
+		}
+	}
+	return nil, gosnmp.NoError
+}
+
+// endSet calls endSet of setObservers
+func (t *SubAgent) endSet() {
+	for _, each := range t.setObservers {
+		each.endSet()
+	}
+}
+
+// serveSetRequest for SetReqeust. See RFC 3416 section 4.2.5
 //
 //	will just Return  GetResponse for Fullily SUCCESS
-func (t *SubAgent) serveSetRequest(i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket, error) {
+//	All varbinds are resolved and tested by OnTestSet before any commit.
+//	If a commit fails, the committed ones are rolled back by OnUndoSet in reverse order.
+//	Varbinds of AgentX subagents are tested / committed / undone with TestSet / CommitSet / UndoSet.
+func (t *SubAgent) serveSetRequest(ctx context.Context, i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket, error) {
+	t.setMu.Lock()
+	defer t.setMu.Unlock()
+	// items created for this SET are removed by setObservers if they are not set
+	defer t.endSet()
+	view, err := t.getVACMView(i, VACMViewWrite)
+	if err != nil {
+		return t.getAuthorizationErrorPacket(i, err), nil
+	}
 	var ret gosnmp.SnmpPacket = copySnmpPacket(i)
 	ret.PDUType = gosnmp.GetResponse
 	ret.Variables = []gosnmp.SnmpPDU{}
+	toSet := []setRequestItem{}
+	resolver := t.newOIDResolver(i)
 	for id, varItem := range i.Variables {
-		item, _ := t.getForPDUValueControl(varItem.Name)
+		item := resolver.getForSet(varItem.Name)
+		if item == nil && view.contains(varItem.Name) {
+			var err error
+			if item, err = t.createForPDUValueControl(varItem.Name); err != nil {
+				t.Logger.Debugf("create oid %v meet %v", varItem.Name, err)
+			}
+		}
 		if item == nil {
 			if ret.Error == gosnmp.NoError {
 				ret.Error = gosnmp.NoSuchName
@@ -403,7 +803,7 @@ func (t *SubAgent) serveSetRequest(i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket, er
 			ret.Variables = append(ret.Variables, t.getPDUNoSuchInstance(varItem.Name))
 			continue
 		}
-		if t.checkPermission(item, i) != PermissionAllowanceAllowed {
+		if !view.contains(item.OID) || t.checkPermission(item, i) != PermissionAllowanceAllowed {
 			if ret.Error == gosnmp.NoError {
 				ret.Error = gosnmp.NoAccess
 				ret.ErrorIndex = uint8(id)
@@ -411,7 +811,7 @@ func (t *SubAgent) serveSetRequest(i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket, er
 			ret.Variables = append(ret.Variables, t.getPDUNil(varItem.Name))
 			continue
 		}
-		if item.OnSet == nil {
+		if !item.writable() {
 			if ret.Error == gosnmp.NoError {
 				ret.Error = gosnmp.ReadOnly
 				ret.ErrorIndex = uint8(id)
@@ -419,49 +819,182 @@ func (t *SubAgent) serveSetRequest(i *gosnmp.SnmpPacket) (*gosnmp.SnmpPacket, er
 			ret.Variables = append(ret.Variables, t.getPDUNil(varItem.Name))
 			continue
 		}
-		func() {
-			defer func() {
-				// panic in onset
-				if err := recover(); err != nil {
-					if t.UserErrorMarkPacket && ret.Error == gosnmp.NoError {
-						ret.Error = gosnmp.GenErr
-						ret.ErrorIndex = uint8(id)
-					}
-					ret.Variables = append(ret.Variables,
-						t.getPDUOctetString(varItem.Name, fmt.Sprintf("ERROR: %+v", err)))
-				}
-			}()
-			if err := item.OnSet(varItem.Value); err != nil {
-				if t.UserErrorMarkPacket && ret.Error == gosnmp.NoError {
-					ret.Error = gosnmp.GenErr
-					ret.ErrorIndex = uint8(id)
-				}
-				ret.Variables = append(ret.Variables,
-					t.getPDUOctetString(varItem.Name, fmt.Sprintf("ERROR: %+v", err)))
-				return
-			} else {
-				ret.Variables = append(ret.Variables, varItem)
-			}
-		}()
+		ret.Variables = append(ret.Variables, varItem)
+		toSet = append(toSet, setRequestItem{id: id, varItem: varItem, item: item})
+	}
+	if ret.Error != gosnmp.NoError {
+		// nothing will be set if any varbind is not writable
+		return &ret, nil
+	}
 
+	// test phase
+	if resolver.remote != nil {
+		defer resolver.remote.cleanupSet()
+		if failed, status := resolver.remote.testSet(toSet); failed != nil {
+			ret.Error = getSetErrorForVersion(i.Version, status)
+			ret.ErrorIndex = uint8(failed.id)
+			return &ret, nil
+		}
+	}
+	for _, each := range toSet {
+		if status, err := t.testSetItem(each); err != nil {
+			t.Logger.Debugf("test set %v meet %v", each.varItem.Name, err)
+			ret.Error = getSetErrorForVersion(i.Version, status)
+			ret.ErrorIndex = uint8(each.id)
+			return &ret, nil
+		}
+	}
+	if failed, status := t.testSetObservers(toSet); failed != nil {
+		ret.Error = getSetErrorForVersion(i.Version, status)
+		ret.ErrorIndex = uint8(failed.id)
+		return &ret, nil
+	}
+
+	// commit phase
+	for done, each := range toSet {
+		err := t.commitSetItem(ctx, each)
+		if err == nil {
+			continue
+		}
+		t.Logger.Debugf("commit set %v meet %v", each.varItem.Name, err)
+		if each.item.OnCommitSet == nil && !t.UserErrorMarkPacket {
+			// OnSet without UserErrorMarkPacket reports errors in the varbind only
+			ret.Variables[done] = t.getPDUOctetString(each.varItem.Name, fmt.Sprintf("ERROR: %+v", err))
+			continue
+		}
+		ret.Error = getSetErrorForVersion(i.Version, gosnmp.CommitFailed)
+		ret.ErrorIndex = uint8(each.id)
+		t.undoSetItems(&ret, toSet[:done])
+		ret.Variables = i.Variables
+		return &ret, nil
+	}
+	if resolver.remote != nil {
+		if failed := resolver.remote.commitSet(); failed != nil {
+			ret.Error = getSetErrorForVersion(i.Version, gosnmp.CommitFailed)
+			ret.ErrorIndex = uint8(failed.id)
+			if failed := resolver.remote.undoSet(); failed != nil {
+				ret.Error = getSetErrorForVersion(i.Version, gosnmp.UndoFailed)
+				ret.ErrorIndex = uint8(failed.id)
+			}
+			t.undoSetItems(&ret, toSet)
+			ret.Variables = i.Variables
+		}
 	}
 	return &ret, nil
 }
 
-func (t *SubAgent) getForPDUValueControl(oid string) (*PDUValueControlItem, int) {
-	toQuery := oidToByteString(oid)
-	i := sort.Search(len(t.OIDs), func(i int) bool {
-		thisOid := oidToByteString(t.OIDs[i].OID)
-		compareResult := compareByteString(thisOid, toQuery)
-		// thisOid >= toQuery
-		return compareResult == ByteStringCompareResultGreaterThen || compareResult == ByteStringCompareResultEqual
-	})
+// undoSetItems rolls back committed items in reverse order. failures are reported as undoFailed
+func (t *SubAgent) undoSetItems(ret *gosnmp.SnmpPacket, committed []setRequestItem) {
+	for undo := len(committed) - 1; undo >= 0; undo-- {
+		if err := t.undoSetItem(committed[undo]); err != nil {
+			t.Logger.Debugf("undo set %v meet %v", committed[undo].varItem.Name, err)
+			ret.Error = getSetErrorForVersion(ret.Version, gosnmp.UndoFailed)
+			ret.ErrorIndex = uint8(committed[undo].id)
+		}
+	}
+}
+
+// testSetItem checks the value by Type / Constraints, then by OnTestSet. returns the error status if fails
+func (t *SubAgent) testSetItem(each setRequestItem) (status gosnmp.SNMPError, err error) {
+	if status, err := each.item.checkSetValue(each.varItem); err != nil {
+		return status, err
+	}
+	if each.item.OnTestSet == nil {
+		return gosnmp.NoError, nil
+	}
+	defer func() {
+		if val := recover(); val != nil {
+			status, err = gosnmp.WrongValue, errors.Errorf("panic in OnTestSet: %+v", val)
+		}
+	}()
+	if err := each.item.OnTestSet(each.varItem.Value); err != nil {
+		if errors.Is(err, ErrInconsistentValue) {
+			return gosnmp.InconsistentValue, err
+		}
+		return gosnmp.WrongValue, err
+	}
+	return gosnmp.NoError, nil
+}
 
-	if i < len(t.OIDs) {
-		// t.OIDs[i].OID == toQuery
-		if compareByteString(oidToByteString(t.OIDs[i].OID), toQuery) == ByteStringCompareResultEqual {
-			return t.OIDs[i], i
+func (t *SubAgent) commitSetItem(ctx context.Context, each setRequestItem) (err error) {
+	defer func() {
+		if val := recover(); val != nil {
+			err = errors.Errorf("panic in set: %+v", val)
+		}
+	}()
+	if each.item.OnCommitSet != nil {
+		return each.item.OnCommitSet(each.varItem.Value)
+	}
+	if each.item.OnSetContext != nil {
+		return each.item.OnSetContext(ctx, each.varItem.Value)
+	}
+	return each.item.OnSet(each.varItem.Value)
+}
+
+func (t *SubAgent) undoSetItem(each setRequestItem) (err error) {
+	if each.item.OnUndoSet == nil {
+		return errors.Errorf("%v could not be undone", each.item.OID)
+	}
+	defer func() {
+		if val := recover(); val != nil {
+			err = errors.Errorf("panic in OnUndoSet: %+v", val)
 		}
+	}()
+	return each.item.OnUndoSet(each.varItem.Value)
+}
+
+// getSetErrorForVersion translates SNMPv2 error status of SET for SNMPv1. See RFC 3584 section 4.4
+func getSetErrorForVersion(version gosnmp.SnmpVersion, status gosnmp.SNMPError) gosnmp.SNMPError {
+	if version != gosnmp.Version1 {
+		return status
+	}
+	switch status {
+	case gosnmp.WrongValue, gosnmp.WrongEncoding, gosnmp.WrongType, gosnmp.WrongLength, gosnmp.InconsistentValue:
+		return gosnmp.BadValue
+	case gosnmp.NoAccess, gosnmp.NotWritable, gosnmp.NoCreation, gosnmp.InconsistentName, gosnmp.AuthorizationError:
+		return gosnmp.NoSuchName
+	case gosnmp.ResourceUnavailable, gosnmp.CommitFailed, gosnmp.UndoFailed:
+		return gosnmp.GenErr
+	}
+	return status
+}
+
+// getForPDUValueControl returns the item of oid in OIDs. nil if not exists
+func (t *SubAgent) getForPDUValueControl(oid string) *PDUValueControlItem {
+	arcs, err := parseOID(oid)
+	if err != nil {
+		return nil
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.oids == nil {
+		return nil
+	}
+	return t.oids.get(arcs)
+}
+
+// nextForPDUValueControl returns the first item of OIDs after oid. nil if not exists
+func (t *SubAgent) nextForPDUValueControl(oid string) *PDUValueControlItem {
+	arcs, err := parseOID(oid)
+	if err != nil {
+		return nil
+	}
+	return t.nextForArcs(arcs)
+}
+
+// nextForArcs returns the first item of OIDs after arcs. nil if not exists
+func (t *SubAgent) nextForArcs(arcs ByteString) *PDUValueControlItem {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.oids == nil {
+		return nil
 	}
-	return nil, i
+	return t.oids.next(arcs)
+}
+
+// searchOIDs returns the index of arcs in OIDs, or the index to insert it. t.mu shell be held
+func (t *SubAgent) searchOIDs(arcs ByteString) int {
+	return sort.Search(len(t.OIDs), func(i int) bool {
+		return compareByteString(t.OIDs[i].oidArcs(), arcs) != ByteStringCompareResultLessThen
+	})
 }
@@ -1,6 +1,8 @@
 package GoSNMPServer
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/gosnmp/gosnmp"
@@ -583,3 +585,114 @@ func (suite *ResponseForBufferTestSuite) snmpv3GetNextRequestEndofMib() []byte {
 func TestResponseForBufferTestSuite(t *testing.T) {
 	suite.Run(t, new(ResponseForBufferTestSuite))
 }
+
+type MaxMessageSizeTests struct {
+	suite.Suite
+
+	handle *MasterAgent
+}
+
+func (suite *MaxMessageSizeTests) SetupTest() {
+	items := []*PDUValueControlItem{
+		{
+			OID:   "1.3.6.1.4.1.9999.61.1",
+			Type:  gosnmp.OctetString,
+			OnGet: func() (interface{}, error) { return Asn1OctetStringWrap(strings.Repeat("x", 1000)), nil },
+		},
+	}
+	for id := 1; id <= 100; id++ {
+		items = append(items, &PDUValueControlItem{
+			OID:   fmt.Sprintf("1.3.6.1.4.1.9999.60.%d", id),
+			Type:  gosnmp.OctetString,
+			OnGet: func() (interface{}, error) { return Asn1OctetStringWrap("twenty bytes of data"), nil },
+		})
+	}
+	suite.handle = &MasterAgent{
+		Logger:         NewDiscardLogger(),
+		MaxMessageSize: 600,
+		SubAgents:      []*SubAgent{{CommunityIDs: []string{"public"}, OIDs: items}},
+	}
+	if err := suite.handle.ReadyForWork(); err != nil {
+		panic(err)
+	}
+}
+
+func (suite *MaxMessageSizeTests) request(pduType gosnmp.PDUType, maxRepetitions uint32, oids ...string) *gosnmp.SnmpPacket {
+	request := &gosnmp.SnmpPacket{
+		Version:        gosnmp.Version2c,
+		Community:      "public",
+		PDUType:        pduType,
+		RequestID:      1,
+		MaxRepetitions: maxRepetitions,
+	}
+	for _, each := range oids {
+		request.Variables = append(request.Variables, gosnmp.SnmpPDU{Name: each, Type: gosnmp.Null})
+	}
+	out, err := request.MarshalMsg()
+	if err != nil {
+		panic(err)
+	}
+	responseBytes, err := suite.handle.ResponseForBuffer(out)
+	if !assert.Nil(suite.T(), err) {
+		return nil
+	}
+	assert.LessOrEqual(suite.T(), len(responseBytes), 600)
+	handle := gosnmp.GoSNMP{Logger: gosnmp.NewLogger(&SnmpLoggerAdapter{suite.handle.Logger})}
+	response, err := handle.SnmpDecodePacket(responseBytes)
+	if !assert.Nil(suite.T(), err) {
+		return nil
+	}
+	return response
+}
+
+func (suite *MaxMessageSizeTests) TestGetBulk() {
+	response := suite.request(gosnmp.GetBulkRequest, 100, "1.3.6.1.4.1.9999.60")
+	if assert.NotNil(suite.T(), response) {
+		assert.Equal(suite.T(), gosnmp.NoError, response.Error)
+		assert.Greater(suite.T(), len(response.Variables), 5)
+		assert.Less(suite.T(), len(response.Variables), 100)
+		for id, each := range response.Variables {
+			assert.Equal(suite.T(), fmt.Sprintf(".1.3.6.1.4.1.9999.60.%d", id+1), each.Name)
+		}
+	}
+	// trimmed to no varbind
+	response = suite.request(gosnmp.GetBulkRequest, 10, "1.3.6.1.4.1.9999.60.100")
+	if assert.NotNil(suite.T(), response) {
+		assert.Equal(suite.T(), gosnmp.NoError, response.Error)
+		assert.Equal(suite.T(), 0, len(response.Variables))
+	}
+}
+
+func (suite *MaxMessageSizeTests) TestTooBig() {
+	response := suite.request(gosnmp.GetRequest, 0, "1.3.6.1.4.1.9999.60.1", "1.3.6.1.4.1.9999.61.1")
+	if assert.NotNil(suite.T(), response) {
+		assert.Equal(suite.T(), gosnmp.TooBig, response.Error)
+		assert.Equal(suite.T(), uint8(0), response.ErrorIndex)
+		assert.Equal(suite.T(), 0, len(response.Variables))
+	}
+	response = suite.request(gosnmp.GetNextRequest, 0, "1.3.6.1.4.1.9999.60.100")
+	if assert.NotNil(suite.T(), response) {
+		assert.Equal(suite.T(), gosnmp.TooBig, response.Error)
+	}
+	response = suite.request(gosnmp.GetRequest, 0, "1.3.6.1.4.1.9999.60.1")
+	if assert.NotNil(suite.T(), response) {
+		assert.Equal(suite.T(), gosnmp.NoError, response.Error)
+		assert.Equal(suite.T(), 1, len(response.Variables))
+	}
+}
+
+func (suite *MaxMessageSizeTests) TestMsgMaxSize() {
+	request := &gosnmp.SnmpPacket{Version: gosnmp.Version3, MsgMaxSize: 500}
+	assert.Equal(suite.T(), 500, suite.handle.getMaxMessageSize(request))
+	request.MsgMaxSize = 1000
+	assert.Equal(suite.T(), 600, suite.handle.getMaxMessageSize(request))
+	// less than 484 is not valid
+	request.MsgMaxSize = 100
+	assert.Equal(suite.T(), 600, suite.handle.getMaxMessageSize(request))
+	suite.handle.MaxMessageSize = 0
+	assert.Equal(suite.T(), DefaultMaxMessageSize, suite.handle.getMaxMessageSize(nil))
+}
+
+func TestMaxMessageSizeTestsSuite(t *testing.T) {
+	suite.Run(t, new(MaxMessageSizeTests))
+}
@@ -0,0 +1,889 @@
+package GoSNMPServer
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+)
+
+// DefaultAgentXTimeout is used for requests to AgentX subagents if neither the session nor the
+//
+//	registration sets a timeout. See RFC 2741 section 6.2.1
+const DefaultAgentXTimeout = 5 * time.Second
+
+// agentxMaster is the AgentX master agent of a MasterAgent. See RFC 2741
+//
+//	Registrations of all contexts are served by every SubAgent, with the contextName of SNMPv3
+//	requests. SNMPv1 / SNMPv2c requests are served in the default context.
+type agentxMaster struct {
+	// master is replaced on SNMPServer.Reload. See getMaster
+	master   *MasterAgent
+	masterMu sync.RWMutex
+	start    time.Time
+
+	mu            sync.Mutex
+	listeners     map[net.Listener]struct{}
+	conns         map[*agentxConn]struct{}
+	sessions      map[uint32]*agentxSession
+	lastSessionID uint32
+	registrations []*agentxRegistration
+	// regions are computed from registrations by context. reset on changes
+	regions map[string][]agentxRegion
+
+	lastTransactionID uint32
+}
+
+// agentxConn is a connection of a subagent, which could have many sessions
+type agentxConn struct {
+	agentx *agentxMaster
+	conn   net.Conn
+
+	writeMu sync.Mutex
+
+	mu           sync.Mutex
+	lastPacketID uint32
+	pending      map[uint32]chan *agentxPacket
+	sessions     map[uint32]*agentxSession
+	closed       chan struct{}
+}
+
+type agentxSession struct {
+	id      uint32
+	conn    *agentxConn
+	timeout time.Duration
+	oid     string
+	descr   string
+	// flags keeps the byte order of the subagent
+	flags     uint8
+	agentCaps map[string]struct{}
+}
+
+type agentxRegistration struct {
+	session    *agentxSession
+	context    string
+	subtree    string
+	priority   uint8
+	rangeSubID uint8
+	upperBound uint32
+	timeout    time.Duration
+	// spans of subtree with rangeSubID and upperBound. one span if rangeSubID is 0 or of the last sub-identifier
+	spans []agentxSpan
+}
+
+// agentxSpan is the subtrees from start to start with the last sub-identifier of upper, which are the range
+//
+//	[start, end) of OIDs. Ranges of the last sub-identifier are kept in one span however large they are.
+type agentxSpan struct {
+	start ByteString
+	upper int
+}
+
+// agentxMaxRangeSpans limits the spans of a registration with range_subid not of the last sub-identifier,
+//
+//	as each value is a subtree of its own.
+const agentxMaxRangeSpans = 1024
+
+// agentxRegion is a range of OIDs [start, end) served by a registration
+type agentxRegion struct {
+	start        ByteString
+	end          ByteString
+	registration *agentxRegistration
+}
+
+func newAgentXMaster(master *MasterAgent) *agentxMaster {
+	return &agentxMaster{
+		master:    master,
+		start:     time.Now(),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*agentxConn]struct{}),
+		sessions:  make(map[uint32]*agentxSession),
+	}
+}
+
+// getMaster returns the MasterAgent serving now
+func (t *agentxMaster) getMaster() *MasterAgent {
+	t.masterMu.RLock()
+	defer t.masterMu.RUnlock()
+	return t.master
+}
+
+// setMaster moves the sessions and registrations to master
+func (t *agentxMaster) setMaster(master *MasterAgent) {
+	t.masterMu.Lock()
+	defer t.masterMu.Unlock()
+	t.master = master
+}
+
+// ServeAgentX accepts AgentX subagents on listener until it is closed. See RFC 2741
+//
+//	Subagents register OID subtrees, which are served by forwarding Get / GetNext / GetBulk / Set
+//	of them to the subagents. Should be called after ReadyForWork, eg. by SNMPServer.ListenAgentX
+func (t *MasterAgent) ServeAgentX(listener net.Listener) error {
+	agentx := t.priv.agentx
+	if agentx == nil {
+		return errors.New("ServeAgentX: MasterAgent is not ready for work")
+	}
+	agentx.trackListener(listener)
+	defer func() {
+		agentx.mu.Lock()
+		delete(agentx.listeners, listener)
+		agentx.mu.Unlock()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return errors.Wrap(err, "AgentX Accept Error")
+		}
+		agentx.getMaster().Logger.Infof("AgentX connection from %v", conn.RemoteAddr())
+		c := &agentxConn{
+			agentx:   agentx,
+			conn:     conn,
+			pending:  make(map[uint32]chan *agentxPacket),
+			sessions: make(map[uint32]*agentxSession),
+			closed:   make(chan struct{}),
+		}
+		agentx.mu.Lock()
+		agentx.conns[c] = struct{}{}
+		agentx.mu.Unlock()
+		go c.serve()
+	}
+}
+
+// trackListener keeps listener to be closed on shutdown
+func (t *agentxMaster) trackListener(listener net.Listener) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.listeners[listener] = struct{}{}
+}
+
+// listenAgentX listens "unix" or "tcp". A socket file left by a former process is removed
+func listenAgentX(network, address string) (net.Listener, error) {
+	if network == "unix" {
+		if info, err := os.Stat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, errors.Wrap(err, "AgentX Listen Error")
+	}
+	return listener, nil
+}
+
+// shutdown closes all listeners and sessions
+func (t *agentxMaster) shutdown() {
+	t.mu.Lock()
+	conns := []*agentxConn{}
+	for listener := range t.listeners {
+		listener.Close()
+	}
+	for c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.mu.Unlock()
+	for _, c := range conns {
+		c.mu.Lock()
+		sessions := []*agentxSession{}
+		for _, session := range c.sessions {
+			sessions = append(sessions, session)
+		}
+		c.mu.Unlock()
+		for _, session := range sessions {
+			c.write(&agentxPacket{Type: agentxClose, Flags: session.flags, SessionID: session.id,
+				Reason: agentxCloseReasonShutdown})
+		}
+		c.conn.Close()
+	}
+}
+
+func (t *agentxMaster) sysUpTime() uint32 {
+	return uint32(time.Since(t.start) / (10 * time.Millisecond))
+}
+
+func (c *agentxConn) serve() {
+	logger := c.agentx.getMaster().Logger
+	defer c.close()
+	reader := bufio.NewReader(c.conn)
+	for {
+		p, err := readAgentXPacket(reader, agentxMaxPayloadSize)
+		if p == nil {
+			logger.Debugf("AgentX connection from %v closed: %v", c.conn.RemoteAddr(), err)
+			return
+		}
+		if err != nil {
+			logger.Warnf("AgentX PDU from %v: %v", c.conn.RemoteAddr(), err)
+			if p.Type != agentxResponse {
+				c.write(p.newResponse(AgentXParseError, 0))
+			}
+			continue
+		}
+		if p.Type == agentxResponse {
+			c.mu.Lock()
+			waiting := c.pending[p.PacketID]
+			delete(c.pending, p.PacketID)
+			c.mu.Unlock()
+			if waiting != nil {
+				waiting <- p
+			}
+			continue
+		}
+		response := c.handle(p)
+		response.SysUpTime = c.agentx.sysUpTime()
+		c.write(response)
+	}
+}
+
+// close removes the sessions of the connection
+func (c *agentxConn) close() {
+	c.conn.Close()
+	close(c.closed)
+	c.mu.Lock()
+	sessions := c.sessions
+	c.sessions = make(map[uint32]*agentxSession)
+	c.mu.Unlock()
+	agentx := c.agentx
+	agentx.mu.Lock()
+	defer agentx.mu.Unlock()
+	delete(agentx.conns, c)
+	for id := range sessions {
+		agentx.removeSessionLocked(id)
+	}
+}
+
+func (c *agentxConn) write(p *agentxPacket) error {
+	out, err := p.Marshal()
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.conn.Write(out); err != nil {
+		return errors.Wrap(err, "AgentX Write")
+	}
+	return nil
+}
+
+// handle serves PDUs sent by the subagent. returns the Response-PDU
+func (c *agentxConn) handle(p *agentxPacket) *agentxPacket {
+	agentx := c.agentx
+	logger := agentx.getMaster().Logger
+	if p.Type == agentxOpen {
+		session := &agentxSession{
+			conn:      c,
+			timeout:   time.Duration(p.Timeout) * time.Second,
+			oid:       p.ID,
+			descr:     p.Descr,
+			flags:     p.Flags & agentxFlagNetworkByteOrder,
+			agentCaps: make(map[string]struct{}),
+		}
+		agentx.mu.Lock()
+		agentx.lastSessionID++
+		session.id = agentx.lastSessionID
+		agentx.sessions[session.id] = session
+		agentx.mu.Unlock()
+		c.mu.Lock()
+		c.sessions[session.id] = session
+		c.mu.Unlock()
+		logger.Infof("AgentX session %v opened: %v (%v)", session.id, session.descr, session.oid)
+		response := p.newResponse(AgentXNoError, 0)
+		response.SessionID = session.id
+		return response
+	}
+
+	c.mu.Lock()
+	session := c.sessions[p.SessionID]
+	c.mu.Unlock()
+	if session == nil {
+		return p.newResponse(AgentXNotOpen, 0)
+	}
+	switch p.Type {
+	case agentxClose:
+		c.mu.Lock()
+		delete(c.sessions, session.id)
+		c.mu.Unlock()
+		agentx.mu.Lock()
+		agentx.removeSessionLocked(session.id)
+		agentx.mu.Unlock()
+		logger.Infof("AgentX session %v closed. reason=%v", session.id, p.Reason)
+		return p.newResponse(AgentXNoError, 0)
+	case agentxRegister:
+		return p.newResponse(agentx.register(session, p), 0)
+	case agentxUnregister:
+		return p.newResponse(agentx.unregister(session, p), 0)
+	case agentxPing:
+		return p.newResponse(AgentXNoError, 0)
+	case agentxNotify:
+		return p.newResponse(agentx.notify(p), 0)
+	case agentxAddAgentCaps:
+		agentx.mu.Lock()
+		session.agentCaps[p.ID] = struct{}{}
+		agentx.mu.Unlock()
+		return p.newResponse(AgentXNoError, 0)
+	case agentxRemoveAgentCaps:
+		agentx.mu.Lock()
+		defer agentx.mu.Unlock()
+		if _, ok := session.agentCaps[p.ID]; !ok {
+			return p.newResponse(AgentXUnknownAgentCaps, 0)
+		}
+		delete(session.agentCaps, p.ID)
+		return p.newResponse(AgentXNoError, 0)
+	}
+	// IndexAllocate / IndexDeallocate and the PDUs sent by masters
+	logger.Warnf("AgentX session %v: not supported PDU type %v", session.id, p.Type)
+	return p.newResponse(AgentXProcessingError, 0)
+}
+
+func (t *agentxMaster) removeSessionLocked(id uint32) {
+	delete(t.sessions, id)
+	registrations := t.registrations[:0]
+	for _, each := range t.registrations {
+		if each.session.id != id {
+			registrations = append(registrations, each)
+		}
+	}
+	t.registrations = registrations
+	t.regions = nil
+}
+
+func (t *agentxMaster) register(session *agentxSession, p *agentxPacket) AgentXError {
+	subIDs, err := agentxParseOID(p.Subtree)
+	if err != nil || len(subIDs) == 0 || int(p.RangeSubID) > len(subIDs) {
+		return AgentXParseError
+	}
+	registration := &agentxRegistration{
+		session:    session,
+		context:    p.Context,
+		subtree:    p.Subtree,
+		priority:   p.Priority,
+		rangeSubID: p.RangeSubID,
+		upperBound: p.UpperBound,
+		timeout:    time.Duration(p.Timeout) * time.Second,
+	}
+	subtree := make(ByteString, len(subIDs))
+	for id, each := range subIDs {
+		subtree[id] = int(each)
+	}
+	last := len(subtree) - 1
+	switch {
+	case p.RangeSubID == 0:
+		registration.spans = []agentxSpan{{start: subtree, upper: subtree[last]}}
+	case int(p.RangeSubID) == len(subtree):
+		if int(p.UpperBound) < subtree[last] {
+			return AgentXParseError
+		}
+		registration.spans = []agentxSpan{{start: subtree, upper: int(p.UpperBound)}}
+	default:
+		lower, upper := subtree[p.RangeSubID-1], int(p.UpperBound)
+		if upper < lower {
+			return AgentXParseError
+		}
+		if upper-lower >= agentxMaxRangeSpans {
+			return AgentXRequestDenied
+		}
+		for val := lower; val <= upper; val++ {
+			start := append(ByteString{}, subtree...)
+			start[p.RangeSubID-1] = val
+			registration.spans = append(registration.spans, agentxSpan{start: start, upper: start[last]})
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, each := range t.registrations {
+		if each.context != registration.context || each.priority != registration.priority {
+			continue
+		}
+		for _, exists := range each.spans {
+			for _, span := range registration.spans {
+				if exists.overlaps(span) {
+					return AgentXDuplicateRegistration
+				}
+			}
+		}
+	}
+	t.registrations = append(t.registrations, registration)
+	t.regions = nil
+	t.getMaster().Logger.Infof("AgentX session %v registered %v. context=%q priority=%v",
+		session.id, p.Subtree, p.Context, p.Priority)
+	return AgentXNoError
+}
+
+func (t *agentxMaster) unregister(session *agentxSession, p *agentxPacket) AgentXError {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, each := range t.registrations {
+		if each.session == session && each.context == p.Context && each.priority == p.Priority &&
+			strings.TrimPrefix(each.subtree, ".") == strings.TrimPrefix(p.Subtree, ".") &&
+			each.rangeSubID == p.RangeSubID && (p.RangeSubID == 0 || each.upperBound == p.UpperBound) {
+			t.registrations = append(t.registrations[:id], t.registrations[id+1:]...)
+			t.regions = nil
+			t.getMaster().Logger.Infof("AgentX session %v unregistered %v", session.id, p.Subtree)
+			return AgentXNoError
+		}
+	}
+	return AgentXUnknownRegistration
+}
+
+// notify sends the notification of a Notify-PDU with NotificationOriginator. See RFC 2741 section 7.1.10
+func (t *agentxMaster) notify(p *agentxPacket) AgentXError {
+	variables := p.Variables
+	if len(variables) != 0 && strings.TrimPrefix(variables[0].Name, ".") == "1.3.6.1.2.1.1.3.0" {
+		variables = variables[1:]
+	}
+	if len(variables) == 0 || strings.TrimPrefix(variables[0].Name, ".") != "1.3.6.1.6.3.1.1.4.1.0" ||
+		variables[0].Type != gosnmp.ObjectIdentifier {
+		return AgentXProcessingError
+	}
+	notification := Notification{
+		TrapOID:   strings.TrimPrefix(variables[0].Value.(string), "."),
+		Variables: variables[1:],
+	}
+	go t.getMaster().NotificationOriginator.Send(notification)
+	return AgentXNoError
+}
+
+// getRegions returns the regions of context sorted by OID
+func (t *agentxMaster) getRegions(context string) []agentxRegion {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.registrations) == 0 {
+		return nil
+	}
+	if t.regions == nil {
+		t.regions = make(map[string][]agentxRegion)
+	}
+	if regions, ok := t.regions[context]; ok {
+		return regions
+	}
+	regions := computeAgentXRegions(t.registrations, context)
+	t.regions[context] = regions
+	return regions
+}
+
+// computeAgentXRegions splits the OIDs by the subtrees registered. Each range is served by the most
+//
+//	specific subtree, then by the best (lowest) priority. See RFC 2741 section 7.1.5.1
+func computeAgentXRegions(registrations []*agentxRegistration, context string) []agentxRegion {
+	bounds := []ByteString{}
+	for _, each := range registrations {
+		if each.context != context {
+			continue
+		}
+		for _, span := range each.spans {
+			bounds = append(bounds, span.start, span.end())
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool {
+		return compareByteString(bounds[i], bounds[j]) == ByteStringCompareResultLessThen
+	})
+	regions := []agentxRegion{}
+	for id := 0; id+1 < len(bounds); id++ {
+		start, end := bounds[id], bounds[id+1]
+		if compareByteString(start, end) == ByteStringCompareResultEqual {
+			continue
+		}
+		var best *agentxRegistration
+		bestLength := 0
+		for _, each := range registrations {
+			if each.context != context {
+				continue
+			}
+			for _, span := range each.spans {
+				if compareByteString(span.start, start) == ByteStringCompareResultGreaterThen ||
+					compareByteString(span.end(), end) == ByteStringCompareResultLessThen {
+					continue
+				}
+				if best == nil || len(span.start) > bestLength || (len(span.start) == bestLength && each.priority < best.priority) {
+					best, bestLength = each, len(span.start)
+				}
+			}
+		}
+		if best == nil {
+			continue
+		}
+		if last := len(regions) - 1; last >= 0 && regions[last].registration == best &&
+			compareByteString(regions[last].end, start) == ByteStringCompareResultEqual {
+			regions[last].end = end
+			continue
+		}
+		regions = append(regions, agentxRegion{start: start, end: end, registration: best})
+	}
+	return regions
+}
+
+// agentxSubtreeEnd returns the first OID after the subtree
+func agentxSubtreeEnd(subtree ByteString) ByteString {
+	end := append(ByteString{}, subtree...)
+	end[len(end)-1]++
+	return end
+}
+
+// end returns the first OID after the span
+func (s agentxSpan) end() ByteString {
+	end := append(ByteString{}, s.start...)
+	end[len(end)-1] = s.upper + 1
+	return end
+}
+
+// overlaps returns if the spans have a subtree in common
+func (s agentxSpan) overlaps(other agentxSpan) bool {
+	last := len(s.start) - 1
+	if len(other.start) != len(s.start) ||
+		compareByteString(s.start[:last], other.start[:last]) != ByteStringCompareResultEqual {
+		return false
+	}
+	return s.start[last] <= other.upper && other.start[last] <= s.upper
+}
+
+func byteStringToOID(i ByteString) string {
+	parts := make([]string, len(i))
+	for id, each := range i {
+		parts[id] = strconv.Itoa(each)
+	}
+	return strings.Join(parts, ".")
+}
+
+func (r *agentxRegion) timeout() time.Duration {
+	if r.registration.timeout > 0 {
+		return r.registration.timeout
+	}
+	if r.registration.session.timeout > 0 {
+		return r.registration.session.timeout
+	}
+	return DefaultAgentXTimeout
+}
+
+// send sends p to the subagent without waiting for a response
+func (s *agentxSession) send(p *agentxPacket) error {
+	c := s.conn
+	c.mu.Lock()
+	c.lastPacketID++
+	p.PacketID = c.lastPacketID
+	c.mu.Unlock()
+	p.SessionID = s.id
+	p.Flags |= s.flags
+	return c.write(p)
+}
+
+// request sends p to the subagent and waits for its response
+func (s *agentxSession) request(p *agentxPacket, timeout time.Duration) (*agentxPacket, error) {
+	c := s.conn
+	waiting := make(chan *agentxPacket, 1)
+	c.mu.Lock()
+	c.lastPacketID++
+	p.PacketID = c.lastPacketID
+	c.pending[p.PacketID] = waiting
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, p.PacketID)
+		c.mu.Unlock()
+	}()
+	p.SessionID = s.id
+	p.Flags |= s.flags
+	if err := c.write(p); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case response := <-waiting:
+		return response, nil
+	case <-timer.C:
+		return nil, errors.Errorf("AgentX session %v: timeout", s.id)
+	case <-c.closed:
+		return nil, errors.Errorf("AgentX session %v: closed", s.id)
+	}
+}
+
+// agentxRequest forwards the varbinds of one SNMP request to subagents
+type agentxRequest struct {
+	agentx        *agentxMaster
+	logger        ILogger
+	context       string
+	transactionID uint32
+	regions       []agentxRegion
+	// maxRepetitions > 1 prefetches results of GetNext with GetBulk-PDUs
+	maxRepetitions int
+	nextCache      map[agentxSearchRange]gosnmp.SnmpPDU
+	// sets keeps the sessions of a SET by order. sets[:committed] are sent CommitSet
+	sets      []*agentxSetSession
+	committed int
+}
+
+type agentxSetSession struct {
+	session *agentxSession
+	items   []setRequestItem
+}
+
+// newRequest returns nil if nothing is registered
+func (t *agentxMaster) newRequest(i *gosnmp.SnmpPacket) *agentxRequest {
+	context := getPktVACMContextName(i)
+	regions := t.getRegions(context)
+	if len(regions) == 0 {
+		return nil
+	}
+	return &agentxRequest{
+		agentx:        t,
+		logger:        t.getMaster().Logger,
+		context:       context,
+		transactionID: atomic.AddUint32(&t.lastTransactionID, 1),
+		regions:       regions,
+		nextCache:     make(map[agentxSearchRange]gosnmp.SnmpPDU),
+	}
+}
+
+func (r *agentxRequest) newPacket(pduType agentxPDUType) *agentxPacket {
+	p := &agentxPacket{Type: pduType, TransactionID: r.transactionID}
+	if r.context != "" {
+		p.Flags |= agentxFlagNonDefaultContext
+		p.Context = r.context
+	}
+	return p
+}
+
+// region returns the region of oid. nil if not registered
+func (r *agentxRequest) region(oid ByteString) *agentxRegion {
+	id := sort.Search(len(r.regions), func(id int) bool {
+		return compareByteString(r.regions[id].end, oid) == ByteStringCompareResultGreaterThen
+	})
+	if id < len(r.regions) && compareByteString(r.regions[id].start, oid) != ByteStringCompareResultGreaterThen {
+		return &r.regions[id]
+	}
+	return nil
+}
+
+// get returns the item of oid from the subagent. nil if not registered or not exists
+func (r *agentxRequest) get(oid string) *PDUValueControlItem {
+	arcs, err := parseOID(oid)
+	if err != nil {
+		return nil
+	}
+	region := r.region(arcs)
+	if region == nil {
+		return nil
+	}
+	name := strings.TrimPrefix(oid, ".")
+	p := r.newPacket(agentxGet)
+	p.Ranges = []agentxSearchRange{{Start: name}}
+	response, err := region.registration.session.request(p, region.timeout())
+	if err == nil && response.Error != AgentXNoError {
+		err = errors.Errorf("AgentX Get %v: %v", name, response.Error)
+	}
+	if err == nil && len(response.Variables) != 1 {
+		err = errors.Errorf("AgentX Get %v: %v varbinds returned", name, len(response.Variables))
+	}
+	if err != nil {
+		r.logger.Warnf("%v", err)
+		return newRemoteErrorItem(name, err)
+	}
+	switch response.Variables[0].Type {
+	case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+		return nil
+	}
+	response.Variables[0].Name = name
+	return newRemoteItem(response.Variables[0])
+}
+
+// next returns the first item after oid from subagents. nil for endOfMibView
+func (r *agentxRequest) next(oid ByteString) *PDUValueControlItem {
+	for id := range r.regions {
+		region := &r.regions[id]
+		if compareByteString(region.end, oid) != ByteStringCompareResultGreaterThen {
+			continue
+		}
+		searchRange := agentxSearchRange{Start: byteStringToOID(oid), End: byteStringToOID(region.end)}
+		if compareByteString(region.start, oid) == ByteStringCompareResultGreaterThen {
+			searchRange.Start, searchRange.Include = byteStringToOID(region.start), true
+		}
+		varItem, err := r.searchNext(region, searchRange)
+		if err != nil {
+			// the region is skipped as empty
+			r.logger.Warnf("%v", err)
+			continue
+		}
+		if varItem.Type == gosnmp.EndOfMibView {
+			continue
+		}
+		found, err := parseOID(varItem.Name)
+		if err != nil || compareByteString(found, oid) != ByteStringCompareResultGreaterThen ||
+			compareByteString(found, region.end) != ByteStringCompareResultLessThen {
+			r.logger.Warnf("AgentX GetNext %v: %v out of range", searchRange.Start, varItem.Name)
+			continue
+		}
+		return newRemoteItem(varItem)
+	}
+	return nil
+}
+
+// searchNext sends GetNext / GetBulk for the first varbind in searchRange
+func (r *agentxRequest) searchNext(region *agentxRegion, searchRange agentxSearchRange) (gosnmp.SnmpPDU, error) {
+	if cached, ok := r.nextCache[searchRange]; ok {
+		return cached, nil
+	}
+	p := r.newPacket(agentxGetNext)
+	if r.maxRepetitions > 1 {
+		p.Type = agentxGetBulk
+		p.MaxRepetitions = uint16(r.maxRepetitions)
+		if r.maxRepetitions > 0xffff {
+			p.MaxRepetitions = 0xffff
+		}
+	}
+	p.Ranges = []agentxSearchRange{searchRange}
+	response, err := region.registration.session.request(p, region.timeout())
+	if err == nil && response.Error != AgentXNoError {
+		err = errors.Errorf("AgentX GetNext %v: %v", searchRange.Start, response.Error)
+	}
+	if err == nil && len(response.Variables) == 0 {
+		err = errors.Errorf("AgentX GetNext %v: no varbind returned", searchRange.Start)
+	}
+	if err != nil {
+		return gosnmp.SnmpPDU{}, err
+	}
+	// results of GetBulk are cached as the results of GetNext from the former ones
+	cursor := searchRange
+	for _, each := range response.Variables {
+		r.nextCache[cursor] = each
+		if each.Type == gosnmp.EndOfMibView {
+			break
+		}
+		cursor = agentxSearchRange{Start: each.Name, End: searchRange.End}
+	}
+	return response.Variables[0], nil
+}
+
+// setItem returns the item to set oid by the subagent. nil if not registered
+//
+//	The value is set by testSet / commitSet / undoSet / cleanupSet for all items of the request.
+func (r *agentxRequest) setItem(oid string) *PDUValueControlItem {
+	arcs, err := parseOID(oid)
+	if err != nil {
+		return nil
+	}
+	region := r.region(arcs)
+	if region == nil {
+		return nil
+	}
+	item := &PDUValueControlItem{
+		OID:         strings.TrimPrefix(oid, "."),
+		OnCommitSet: func(value interface{}) error { return nil },
+		OnUndoSet:   func(value interface{}) error { return nil },
+	}
+	session := region.registration.session
+	for _, each := range r.sets {
+		if each.session == session {
+			each.items = append(each.items, setRequestItem{item: item})
+			return item
+		}
+	}
+	r.sets = append(r.sets, &agentxSetSession{session: session, items: []setRequestItem{{item: item}}})
+	return item
+}
+
+// bindSetItems binds the varbinds of the request to the items of setItem
+func (r *agentxRequest) bindSetItems(toSet []setRequestItem) {
+	for _, set := range r.sets {
+		for id := range set.items {
+			for _, each := range toSet {
+				if each.item == set.items[id].item {
+					set.items[id] = each
+				}
+			}
+		}
+	}
+}
+
+// testSet sends TestSet-PDUs. returns the item failed with its error-status. See RFC 2741 section 7.2.4.1
+func (r *agentxRequest) testSet(toSet []setRequestItem) (*setRequestItem, gosnmp.SNMPError) {
+	r.bindSetItems(toSet)
+	for _, set := range r.sets {
+		p := r.newPacket(agentxTestSet)
+		for _, each := range set.items {
+			varItem := each.varItem
+			varItem.Name = each.item.OID
+			p.Variables = append(p.Variables, varItem)
+		}
+		response, err := set.session.request(p, r.sessionTimeout(set.session))
+		if err != nil {
+			r.logger.Warnf("AgentX TestSet: %v", err)
+			return &set.items[0], gosnmp.GenErr
+		}
+		if response.Error != AgentXNoError {
+			failed := &set.items[0]
+			if index := int(response.Index); index > 0 && index <= len(set.items) {
+				failed = &set.items[index-1]
+			}
+			return failed, agentxToSNMPError(response.Error)
+		}
+	}
+	return nil, gosnmp.NoError
+}
+
+// commitSet sends CommitSet-PDUs until one fails. returns the item of the session failed
+func (r *agentxRequest) commitSet() *setRequestItem {
+	for id, set := range r.sets {
+		r.committed = id + 1
+		if err := r.sendSet(set.session, agentxCommitSet); err != nil {
+			return &set.items[0]
+		}
+	}
+	return nil
+}
+
+// undoSet sends UndoSet-PDUs to the sessions sent CommitSet. returns the item of the first session failed
+func (r *agentxRequest) undoSet() *setRequestItem {
+	var failed *setRequestItem
+	for _, set := range r.sets[:r.committed] {
+		if err := r.sendSet(set.session, agentxUndoSet); err != nil && failed == nil {
+			failed = &set.items[0]
+		}
+	}
+	return failed
+}
+
+// cleanupSet ends the transaction. Should be called after testSet.
+//
+//	CleanupSet-PDUs are not responded. See RFC 2741 section 7.2.4.4
+func (r *agentxRequest) cleanupSet() {
+	for _, set := range r.sets {
+		if err := set.session.send(r.newPacket(agentxCleanupSet)); err != nil {
+			r.logger.Warnf("AgentX CleanupSet of session %v: %v", set.session.id, err)
+		}
+	}
+	r.sets, r.committed = nil, 0
+}
+
+func (r *agentxRequest) sendSet(session *agentxSession, pduType agentxPDUType) error {
+	response, err := session.request(r.newPacket(pduType), r.sessionTimeout(session))
+	if err == nil && response.Error != AgentXNoError {
+		err = response.Error
+	}
+	if err != nil {
+		r.logger.Warnf("AgentX PDU type %v of session %v: %v", pduType, session.id, err)
+	}
+	return err
+}
+
+func (r *agentxRequest) sessionTimeout(session *agentxSession) time.Duration {
+	if session.timeout > 0 {
+		return session.timeout
+	}
+	return DefaultAgentXTimeout
+}
+
+// agentxToSNMPError translates res.error of AgentX to error-status of SNMP
+func agentxToSNMPError(i AgentXError) gosnmp.SNMPError {
+	if i > AgentXError(gosnmp.InconsistentName) {
+		return gosnmp.GenErr
+	}
+	return gosnmp.SNMPError(i)
+}
@@ -0,0 +1,400 @@
+package GoSNMPServer
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+// testAgentX is a minimal AgentX subagent serving values from a map
+type testAgentX struct {
+	conn      net.Conn
+	sessionID uint32
+	packetID  uint32
+	responses chan *agentxPacket
+
+	mu         sync.Mutex
+	values     map[string]gosnmp.SnmpPDU
+	pending    []gosnmp.SnmpPDU
+	committed  []gosnmp.SnmpPDU
+	failCommit bool
+	undone     int
+	cleaned    int
+}
+
+func dialTestAgentX(network, address string, values ...gosnmp.SnmpPDU) *testAgentX {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		panic(err)
+	}
+	ret := &testAgentX{
+		conn:      conn,
+		responses: make(chan *agentxPacket, 1),
+		values:    make(map[string]gosnmp.SnmpPDU),
+	}
+	for _, each := range values {
+		ret.values[each.Name] = each
+	}
+	go ret.serve()
+	response := ret.call(&agentxPacket{Type: agentxOpen, Timeout: 1, ID: "1.3.6.1.4.1.9999", Descr: "test"})
+	ret.sessionID = response.SessionID
+	return ret
+}
+
+func (a *testAgentX) call(p *agentxPacket) *agentxPacket {
+	a.packetID++
+	p.SessionID, p.PacketID = a.sessionID, a.packetID
+	p.Flags |= agentxFlagNetworkByteOrder
+	out, err := p.Marshal()
+	if err != nil {
+		panic(err)
+	}
+	a.conn.Write(out)
+	select {
+	case response := <-a.responses:
+		return response
+	case <-time.After(time.Second):
+		panic("no AgentX response")
+	}
+}
+
+func (a *testAgentX) register(subtree string, priority uint8) AgentXError {
+	return a.call(&agentxPacket{Type: agentxRegister, Subtree: subtree, Priority: priority}).Error
+}
+
+func (a *testAgentX) serve() {
+	reader := bufio.NewReader(a.conn)
+	for {
+		p, err := readAgentXPacket(reader, agentxMaxPayloadSize)
+		if err != nil {
+			return
+		}
+		if p.Type == agentxResponse {
+			a.responses <- p
+			continue
+		}
+		response := a.handle(p)
+		if p.Type == agentxCleanupSet {
+			continue
+		}
+		out, _ := response.Marshal()
+		a.conn.Write(out)
+	}
+}
+
+func (a *testAgentX) handle(p *agentxPacket) *agentxPacket {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	response := p.newResponse(AgentXNoError, 0)
+	switch p.Type {
+	case agentxGet:
+		for _, each := range p.Ranges {
+			if val, ok := a.values[each.Start]; ok {
+				response.Variables = append(response.Variables, val)
+			} else {
+				response.Variables = append(response.Variables, gosnmp.SnmpPDU{Name: each.Start, Type: gosnmp.NoSuchObject})
+			}
+		}
+	case agentxGetNext, agentxGetBulk:
+		searchRange := p.Ranges[0]
+		repetitions := 1
+		if p.Type == agentxGetBulk {
+			repetitions = int(p.MaxRepetitions)
+		}
+		for ; repetitions > 0; repetitions-- {
+			next := a.next(searchRange)
+			response.Variables = append(response.Variables, next)
+			if next.Type == gosnmp.EndOfMibView {
+				break
+			}
+			searchRange = agentxSearchRange{Start: next.Name, End: searchRange.End}
+		}
+	case agentxTestSet:
+		for id, each := range p.Variables {
+			if _, ok := a.values[each.Name]; !ok {
+				response.Error, response.Index = AgentXError(gosnmp.NotWritable), uint16(id+1)
+				return response
+			}
+			if val, ok := each.Value.([]byte); ok && string(val) == "bad" {
+				response.Error, response.Index = AgentXError(gosnmp.WrongValue), uint16(id+1)
+				return response
+			}
+		}
+		a.pending = p.Variables
+	case agentxCommitSet:
+		if a.failCommit {
+			response.Error = AgentXError(gosnmp.CommitFailed)
+			return response
+		}
+		for _, each := range a.pending {
+			a.committed = append(a.committed, a.values[each.Name])
+			a.values[each.Name] = each
+		}
+	case agentxUndoSet:
+		for _, each := range a.committed {
+			a.values[each.Name] = each
+		}
+		a.undone++
+	case agentxCleanupSet:
+		a.pending, a.committed = nil, nil
+		a.cleaned++
+	}
+	return response
+}
+
+func (a *testAgentX) next(searchRange agentxSearchRange) gosnmp.SnmpPDU {
+	names := []string{}
+	for name := range a.values {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return compareByteString(oidToByteString(names[i]), oidToByteString(names[j])) == ByteStringCompareResultLessThen
+	})
+	start := oidToByteString(searchRange.Start)
+	for _, name := range names {
+		result := compareByteString(oidToByteString(name), start)
+		if result == ByteStringCompareResultLessThen || (result == ByteStringCompareResultEqual && !searchRange.Include) {
+			continue
+		}
+		if searchRange.End != "" && compareByteString(oidToByteString(name),
+			oidToByteString(searchRange.End)) != ByteStringCompareResultLessThen {
+			break
+		}
+		return a.values[name]
+	}
+	return gosnmp.SnmpPDU{Name: searchRange.Start, Type: gosnmp.EndOfMibView}
+}
+
+type AgentXMasterTests struct {
+	suite.Suite
+	Logger ILogger
+
+	shandle *SNMPServer
+	dir     string
+	socket  string
+	agent   *testAgentX
+}
+
+func (suite *AgentXMasterTests) SetupTest() {
+	logger := NewDefaultLogger()
+	logger.(*DefaultLogger).Level = logrus.InfoLevel
+	suite.Logger = logger
+
+	local := func(oid string) *PDUValueControlItem {
+		return &PDUValueControlItem{
+			OID:   oid,
+			Type:  gosnmp.OctetString,
+			OnGet: func() (value interface{}, err error) { return Asn1OctetStringWrap("local"), nil },
+		}
+	}
+	master := MasterAgent{
+		Logger: suite.Logger,
+		SubAgents: []*SubAgent{
+			{
+				CommunityIDs: []string{"public"},
+				OIDs:         []*PDUValueControlItem{local("1.3.6.1.4.1.9999.1.0"), local("1.3.6.1.4.1.9999.20.0")},
+			},
+		},
+	}
+	suite.shandle = NewSNMPServer(master)
+	if err := suite.shandle.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	dir, err := ioutil.TempDir("", "agentx")
+	if err != nil {
+		panic(err)
+	}
+	suite.dir = dir
+	suite.socket = filepath.Join(dir, "master")
+	if err := suite.shandle.ListenAgentX("unix", suite.socket); err != nil {
+		panic(err)
+	}
+	go suite.shandle.ServeForever()
+
+	suite.agent = dialTestAgentX("unix", suite.socket,
+		gosnmp.SnmpPDU{Name: "1.3.6.1.4.1.9999.10.1", Type: gosnmp.OctetString, Value: []byte("one")},
+		gosnmp.SnmpPDU{Name: "1.3.6.1.4.1.9999.10.2", Type: gosnmp.Integer, Value: 2},
+		gosnmp.SnmpPDU{Name: "1.3.6.1.4.1.9999.10.3", Type: gosnmp.Counter64, Value: uint64(3)},
+	)
+	if err := suite.agent.register("1.3.6.1.4.1.9999.10", 127); err != AgentXNoError {
+		panic(err)
+	}
+}
+
+func (suite *AgentXMasterTests) TearDownTest() {
+	suite.agent.conn.Close()
+	suite.shandle.Shutdown()
+	os.RemoveAll(suite.dir)
+}
+
+func (suite *AgentXMasterTests) getClient() *gosnmp.GoSNMP {
+	serverAddress := suite.shandle.Address().(*net.UDPAddr)
+	client := &gosnmp.GoSNMP{
+		Target:    serverAddress.IP.String(),
+		Port:      uint16(serverAddress.Port),
+		Version:   gosnmp.Version2c,
+		Community: "public",
+		Timeout:   2 * time.Second,
+	}
+	if err := client.Connect(); err != nil {
+		panic(err)
+	}
+	return client
+}
+
+func (suite *AgentXMasterTests) TestGet() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+	result, err := client.Get([]string{"1.3.6.1.4.1.9999.10.1", "1.3.6.1.4.1.9999.10.2", "1.3.6.1.4.1.9999.1.0"})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), "one", string(result.Variables[0].Value.([]byte)))
+		assert.Equal(suite.T(), 2, result.Variables[1].Value)
+		assert.Equal(suite.T(), "local", string(result.Variables[2].Value.([]byte)))
+	}
+	result, err = client.Get([]string{"1.3.6.1.4.1.9999.10.9"})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), gosnmp.NoSuchInstance, result.Variables[0].Type)
+	}
+}
+
+func (suite *AgentXMasterTests) TestWalk() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+	expected := []string{
+		".1.3.6.1.4.1.9999.1.0",
+		".1.3.6.1.4.1.9999.10.1",
+		".1.3.6.1.4.1.9999.10.2",
+		".1.3.6.1.4.1.9999.10.3",
+		".1.3.6.1.4.1.9999.20.0",
+	}
+	walked, err := client.WalkAll("1.3.6.1.4.1.9999")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), expected, getNames(walked))
+	walked, err = client.BulkWalkAll("1.3.6.1.4.1.9999")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), expected, getNames(walked))
+	assert.Equal(suite.T(), uint64(3), walked[3].Value)
+}
+
+func (suite *AgentXMasterTests) TestSet() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+	set := func(value string) *gosnmp.SnmpPacket {
+		result, err := client.Set([]gosnmp.SnmpPDU{
+			{Name: "1.3.6.1.4.1.9999.10.1", Type: gosnmp.OctetString, Value: value},
+		})
+		assert.Nil(suite.T(), err)
+		return result
+	}
+	assert.Equal(suite.T(), gosnmp.NoError, set("uno").Error)
+	suite.agent.mu.Lock()
+	assert.Equal(suite.T(), "uno", string(suite.agent.values["1.3.6.1.4.1.9999.10.1"].Value.([]byte)))
+	suite.agent.mu.Unlock()
+
+	assert.Equal(suite.T(), gosnmp.WrongValue, set("bad").Error)
+
+	suite.agent.mu.Lock()
+	suite.agent.failCommit = true
+	suite.agent.mu.Unlock()
+	assert.Equal(suite.T(), gosnmp.CommitFailed, set("dos").Error)
+	// CleanupSet-PDUs are not responded
+	assert.Eventually(suite.T(), func() bool {
+		suite.agent.mu.Lock()
+		defer suite.agent.mu.Unlock()
+		return suite.agent.cleaned == 3
+	}, time.Second, 10*time.Millisecond)
+	suite.agent.mu.Lock()
+	defer suite.agent.mu.Unlock()
+	assert.Equal(suite.T(), 1, suite.agent.undone)
+	assert.Equal(suite.T(), "uno", string(suite.agent.values["1.3.6.1.4.1.9999.10.1"].Value.([]byte)))
+}
+
+func (suite *AgentXMasterTests) TestPriority() {
+	other := dialTestAgentX("unix", suite.socket,
+		gosnmp.SnmpPDU{Name: "1.3.6.1.4.1.9999.10.1", Type: gosnmp.OctetString, Value: []byte("other")},
+	)
+	defer other.conn.Close()
+	assert.Equal(suite.T(), AgentXDuplicateRegistration, other.register("1.3.6.1.4.1.9999.10", 127))
+	assert.Equal(suite.T(), AgentXNoError, other.register("1.3.6.1.4.1.9999.10", 100))
+
+	client := suite.getClient()
+	defer client.Conn.Close()
+	get := func() string {
+		result, err := client.Get([]string{"1.3.6.1.4.1.9999.10.1"})
+		if !assert.Nil(suite.T(), err) || result.Variables[0].Type != gosnmp.OctetString {
+			return ""
+		}
+		return string(result.Variables[0].Value.([]byte))
+	}
+	assert.Equal(suite.T(), "other", get())
+
+	response := other.call(&agentxPacket{Type: agentxUnregister, Subtree: "1.3.6.1.4.1.9999.10", Priority: 100})
+	assert.Equal(suite.T(), AgentXNoError, response.Error)
+	assert.Equal(suite.T(), "one", get())
+
+	assert.Equal(suite.T(), AgentXNoError, other.register("1.3.6.1.4.1.9999.10.1", 200))
+	assert.Equal(suite.T(), "other", get())
+	response = other.call(&agentxPacket{Type: agentxClose, Reason: agentxCloseReasonShutdown})
+	assert.Equal(suite.T(), AgentXNoError, response.Error)
+	assert.Equal(suite.T(), "one", get())
+}
+
+func (suite *AgentXMasterTests) TestRegions() {
+	session := &agentxSession{id: 1}
+	other := &agentxSession{id: 2}
+	registrations := []*agentxRegistration{
+		{session: session, priority: 127, spans: []agentxSpan{{start: ByteString{1, 3, 6, 1, 4}, upper: 4}}},
+		{session: other, priority: 127, spans: []agentxSpan{{start: ByteString{1, 3, 6, 1, 4, 1, 2}, upper: 3}}},
+		{session: other, priority: 200, spans: []agentxSpan{{start: ByteString{1, 3, 6, 1, 5}, upper: 5}}},
+		{session: other, context: "other", spans: []agentxSpan{{start: ByteString{1, 3}, upper: 3}}},
+	}
+	regions := computeAgentXRegions(registrations, "")
+	actual := []string{}
+	for _, each := range regions {
+		actual = append(actual, byteStringToOID(each.start)+"-"+byteStringToOID(each.end)+":"+
+			string(rune('0'+each.registration.session.id)))
+	}
+	assert.Equal(suite.T(), []string{
+		"1.3.6.1.4-1.3.6.1.4.1.2:1",
+		"1.3.6.1.4.1.2-1.3.6.1.4.1.4:2",
+		"1.3.6.1.4.1.4-1.3.6.1.5:1",
+		"1.3.6.1.5-1.3.6.1.6:2",
+	}, actual)
+}
+
+func (suite *AgentXMasterTests) TestRangeRegistration() {
+	register := func(subtree string, rangeSubID uint8, upperBound uint32) AgentXError {
+		return suite.agent.call(&agentxPacket{Type: agentxRegister, Subtree: subtree, Priority: 127,
+			RangeSubID: rangeSubID, UpperBound: upperBound}).Error
+	}
+	// ranges of the last sub-identifier are one span however large
+	assert.Equal(suite.T(), AgentXNoError, register("1.3.6.1.4.1.9999.30.1", 9, 0xffffffff))
+	assert.Equal(suite.T(), AgentXDuplicateRegistration, register("1.3.6.1.4.1.9999.30.70000", 0, 0))
+	assert.Equal(suite.T(), AgentXNoError, register("1.3.6.1.4.1.9999.30.0", 0, 0))
+	assert.Equal(suite.T(), AgentXNoError, register("1.3.6.1.4.1.9999.30.70000.1", 0, 0))
+	assert.Equal(suite.T(), AgentXParseError, register("1.3.6.1.4.1.9999.31.5", 9, 4))
+
+	// a row of columns 1 to 22
+	assert.Equal(suite.T(), AgentXNoError, register("1.3.6.1.4.1.9999.32.1.7", 9, 22))
+	assert.Equal(suite.T(), AgentXDuplicateRegistration, register("1.3.6.1.4.1.9999.32.5.7", 0, 0))
+	assert.Equal(suite.T(), AgentXNoError, register("1.3.6.1.4.1.9999.32.5.8", 0, 0))
+	assert.Equal(suite.T(), AgentXRequestDenied, register("1.3.6.1.4.1.9999.33.1.7", 9, 0xffffffff))
+
+	regions := suite.shandle.getMaster().priv.agentx.getRegions("")
+	assert.True(suite.T(), len(regions) < 30, "%v regions", len(regions))
+}
+
+func TestAgentXMasterTestsSuite(t *testing.T) {
+	suite.Run(t, new(AgentXMasterTests))
+}
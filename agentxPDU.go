@@ -0,0 +1,595 @@
+package GoSNMPServer
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+)
+
+// agentxPDUType is h.type of AgentX PDUs. See RFC 2741 section 6.1
+type agentxPDUType uint8
+
+const (
+	agentxOpen            agentxPDUType = 1
+	agentxClose           agentxPDUType = 2
+	agentxRegister        agentxPDUType = 3
+	agentxUnregister      agentxPDUType = 4
+	agentxGet             agentxPDUType = 5
+	agentxGetNext         agentxPDUType = 6
+	agentxGetBulk         agentxPDUType = 7
+	agentxTestSet         agentxPDUType = 8
+	agentxCommitSet       agentxPDUType = 9
+	agentxUndoSet         agentxPDUType = 10
+	agentxCleanupSet      agentxPDUType = 11
+	agentxNotify          agentxPDUType = 12
+	agentxPing            agentxPDUType = 13
+	agentxIndexAllocate   agentxPDUType = 14
+	agentxIndexDeallocate agentxPDUType = 15
+	agentxAddAgentCaps    agentxPDUType = 16
+	agentxRemoveAgentCaps agentxPDUType = 17
+	agentxResponse        agentxPDUType = 18
+)
+
+// h.flags of AgentX PDUs
+const (
+	agentxFlagInstanceRegistration uint8 = 0x01
+	agentxFlagNewIndex             uint8 = 0x02
+	agentxFlagAnyIndex             uint8 = 0x04
+	agentxFlagNonDefaultContext    uint8 = 0x08
+	agentxFlagNetworkByteOrder     uint8 = 0x10
+)
+
+// AgentXError is res.error of AgentX Response-PDUs. See RFC 2741 section 6.2.16
+//
+//	Responses of Get / Set PDUs use the error-status of SNMP (gosnmp.SNMPError) as well.
+type AgentXError uint16
+
+const (
+	AgentXNoError               AgentXError = 0
+	AgentXOpenFailed            AgentXError = 256
+	AgentXNotOpen               AgentXError = 257
+	AgentXIndexWrongType        AgentXError = 258
+	AgentXIndexAlreadyAllocated AgentXError = 259
+	AgentXIndexNoneAvailable    AgentXError = 260
+	AgentXIndexNotAllocated     AgentXError = 261
+	AgentXUnsupportedContext    AgentXError = 262
+	AgentXDuplicateRegistration AgentXError = 263
+	AgentXUnknownRegistration   AgentXError = 264
+	AgentXUnknownAgentCaps      AgentXError = 265
+	AgentXParseError            AgentXError = 266
+	AgentXRequestDenied         AgentXError = 267
+	AgentXProcessingError       AgentXError = 268
+)
+
+const (
+	agentxVersion    = 1
+	agentxHeaderSize = 20
+	// agentxMaxPayloadSize limits PDUs read
+	agentxMaxPayloadSize = 1 << 20
+	// agentxInternetPrefix is the OID of h.prefix in Object Identifiers
+	agentxInternetPrefix = "1.3.6.1"
+)
+
+// reasons of Close-PDUs
+const (
+	agentxCloseReasonOther         uint8 = 1
+	agentxCloseReasonParseError    uint8 = 2
+	agentxCloseReasonProtocolError uint8 = 3
+	agentxCloseReasonTimeouts      uint8 = 4
+	agentxCloseReasonShutdown      uint8 = 5
+	agentxCloseReasonByManager     uint8 = 6
+)
+
+func (e AgentXError) Error() string {
+	switch e {
+	case AgentXNoError:
+		return "noAgentXError"
+	case AgentXOpenFailed:
+		return "openFailed"
+	case AgentXNotOpen:
+		return "notOpen"
+	case AgentXIndexWrongType:
+		return "indexWrongType"
+	case AgentXIndexAlreadyAllocated:
+		return "indexAlreadyAllocated"
+	case AgentXIndexNoneAvailable:
+		return "indexNoneAvailable"
+	case AgentXIndexNotAllocated:
+		return "indexNotAllocated"
+	case AgentXUnsupportedContext:
+		return "unsupportedContext"
+	case AgentXDuplicateRegistration:
+		return "duplicateRegistration"
+	case AgentXUnknownRegistration:
+		return "unknownRegistration"
+	case AgentXUnknownAgentCaps:
+		return "unknownAgentCaps"
+	case AgentXParseError:
+		return "parseError"
+	case AgentXRequestDenied:
+		return "requestDenied"
+	case AgentXProcessingError:
+		return "processingError"
+	}
+	return gosnmp.SNMPError(e).String()
+}
+
+// agentxSearchRange is a SearchRange of Get / GetNext / GetBulk PDUs. End is "" for no limit.
+type agentxSearchRange struct {
+	Start   string
+	Include bool
+	End     string
+}
+
+// agentxPacket is an AgentX PDU. Only the fields of its Type are used. See RFC 2741 section 6
+//
+//	OIDs are kept as "1.3.6.1..." without the leading dot.
+type agentxPacket struct {
+	Type          agentxPDUType
+	Flags         uint8
+	SessionID     uint32
+	TransactionID uint32
+	PacketID      uint32
+	// Context is used if Flags has agentxFlagNonDefaultContext
+	Context string
+
+	// Open-PDU. Timeout is also used by Register-PDU
+	Timeout uint8
+	ID      string
+	Descr   string
+	// Close-PDU
+	Reason uint8
+	// Register-PDU / Unregister-PDU
+	Priority   uint8
+	RangeSubID uint8
+	Subtree    string
+	UpperBound uint32
+	// Get-PDU / GetNext-PDU / GetBulk-PDU
+	NonRepeaters   uint16
+	MaxRepetitions uint16
+	Ranges         []agentxSearchRange
+	// Response-PDU
+	SysUpTime uint32
+	Error     AgentXError
+	Index     uint16
+	// TestSet-PDU / Notify-PDU / IndexAllocate-PDU / IndexDeallocate-PDU / Response-PDU
+	Variables []gosnmp.SnmpPDU
+}
+
+func (p *agentxPacket) byteOrder() binary.ByteOrder {
+	if p.Flags&agentxFlagNetworkByteOrder != 0 {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// hasContext reports if the PDU type carries the optional context
+func (p *agentxPacket) hasContext() bool {
+	switch p.Type {
+	case agentxOpen, agentxClose, agentxCommitSet, agentxUndoSet, agentxCleanupSet, agentxResponse:
+		return false
+	}
+	return p.Flags&agentxFlagNonDefaultContext != 0
+}
+
+// newResponse makes the Response-PDU of p
+func (p *agentxPacket) newResponse(err AgentXError, index uint16) *agentxPacket {
+	return &agentxPacket{
+		Type:          agentxResponse,
+		Flags:         p.Flags & agentxFlagNetworkByteOrder,
+		SessionID:     p.SessionID,
+		TransactionID: p.TransactionID,
+		PacketID:      p.PacketID,
+		Error:         err,
+		Index:         index,
+	}
+}
+
+// Marshal encodes the PDU with its header
+func (p *agentxPacket) Marshal() ([]byte, error) {
+	w := &agentxWriter{order: p.byteOrder()}
+	if p.hasContext() {
+		w.octetString([]byte(p.Context))
+	}
+	switch p.Type {
+	case agentxOpen:
+		w.bytes(p.Timeout, 0, 0, 0)
+		w.oid(p.ID, false)
+		w.octetString([]byte(p.Descr))
+	case agentxClose:
+		w.bytes(p.Reason, 0, 0, 0)
+	case agentxRegister, agentxUnregister:
+		if p.Type == agentxRegister {
+			w.bytes(p.Timeout, p.Priority, p.RangeSubID, 0)
+		} else {
+			w.bytes(0, p.Priority, p.RangeSubID, 0)
+		}
+		w.oid(p.Subtree, false)
+		if p.RangeSubID != 0 {
+			w.uint32(p.UpperBound)
+		}
+	case agentxGet, agentxGetNext, agentxGetBulk:
+		if p.Type == agentxGetBulk {
+			w.uint16(p.NonRepeaters)
+			w.uint16(p.MaxRepetitions)
+		}
+		for _, each := range p.Ranges {
+			w.oid(each.Start, each.Include)
+			w.oid(each.End, false)
+		}
+	case agentxTestSet, agentxNotify, agentxIndexAllocate, agentxIndexDeallocate:
+		w.varBinds(p.Variables)
+	case agentxAddAgentCaps:
+		w.oid(p.ID, false)
+		w.octetString([]byte(p.Descr))
+	case agentxRemoveAgentCaps:
+		w.oid(p.ID, false)
+	case agentxResponse:
+		w.uint32(p.SysUpTime)
+		w.uint16(uint16(p.Error))
+		w.uint16(p.Index)
+		w.varBinds(p.Variables)
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+	header := &agentxWriter{order: w.order}
+	header.bytes(agentxVersion, byte(p.Type), p.Flags, 0)
+	header.uint32(p.SessionID)
+	header.uint32(p.TransactionID)
+	header.uint32(p.PacketID)
+	header.uint32(uint32(len(w.data)))
+	return append(header.data, w.data...), nil
+}
+
+// readAgentXPacket reads a PDU. The stream could not be read any more if no packet is returned.
+//
+//	A packet with an error has a payload not valid, which could be answered with parseError.
+func readAgentXPacket(reader io.Reader, maxPayloadSize int) (*agentxPacket, error) {
+	header := make([]byte, agentxHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	if header[0] != agentxVersion {
+		return nil, errors.Errorf("not supported AgentX version %v", header[0])
+	}
+	p := &agentxPacket{Type: agentxPDUType(header[1]), Flags: header[2]}
+	order := p.byteOrder()
+	p.SessionID = order.Uint32(header[4:])
+	p.TransactionID = order.Uint32(header[8:])
+	p.PacketID = order.Uint32(header[12:])
+	length := order.Uint32(header[16:])
+	if length%4 != 0 || int64(length) > int64(maxPayloadSize) {
+		return nil, errors.Errorf("not valid AgentX payload length %v", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, errors.Wrap(err, "read AgentX payload")
+	}
+	return p, p.unmarshalPayload(payload)
+}
+
+func (p *agentxPacket) unmarshalPayload(payload []byte) error {
+	r := &agentxReader{order: p.byteOrder(), data: payload}
+	if p.hasContext() {
+		p.Context = string(r.octetString())
+	}
+	switch p.Type {
+	case agentxOpen:
+		p.Timeout = r.bytes(4)[0]
+		p.ID, _ = r.oid()
+		p.Descr = string(r.octetString())
+	case agentxClose:
+		p.Reason = r.bytes(4)[0]
+	case agentxRegister, agentxUnregister:
+		fields := r.bytes(4)
+		p.Timeout, p.Priority, p.RangeSubID = fields[0], fields[1], fields[2]
+		if p.Type == agentxUnregister {
+			p.Timeout = 0
+		}
+		p.Subtree, _ = r.oid()
+		if p.RangeSubID != 0 {
+			p.UpperBound = r.uint32()
+		}
+	case agentxGet, agentxGetNext, agentxGetBulk:
+		if p.Type == agentxGetBulk {
+			p.NonRepeaters = r.uint16()
+			p.MaxRepetitions = r.uint16()
+		}
+		for r.err == nil && len(r.data) != 0 {
+			each := agentxSearchRange{}
+			each.Start, each.Include = r.oid()
+			each.End, _ = r.oid()
+			p.Ranges = append(p.Ranges, each)
+		}
+	case agentxTestSet, agentxNotify, agentxIndexAllocate, agentxIndexDeallocate:
+		p.Variables = r.varBinds()
+	case agentxAddAgentCaps:
+		p.ID, _ = r.oid()
+		p.Descr = string(r.octetString())
+	case agentxRemoveAgentCaps:
+		p.ID, _ = r.oid()
+	case agentxResponse:
+		p.SysUpTime = r.uint32()
+		p.Error = AgentXError(r.uint16())
+		p.Index = r.uint16()
+		p.Variables = r.varBinds()
+	case agentxCommitSet, agentxUndoSet, agentxCleanupSet, agentxPing:
+	default:
+		return errors.Errorf("not supported AgentX PDU type %v", p.Type)
+	}
+	if r.err != nil {
+		return errors.Wrapf(r.err, "parse AgentX PDU type %v", p.Type)
+	}
+	return nil
+}
+
+type agentxWriter struct {
+	order binary.ByteOrder
+	data  []byte
+	err   error
+}
+
+func (w *agentxWriter) bytes(i ...byte) {
+	w.data = append(w.data, i...)
+}
+
+func (w *agentxWriter) uint16(i uint16) {
+	buf := make([]byte, 2)
+	w.order.PutUint16(buf, i)
+	w.data = append(w.data, buf...)
+}
+
+func (w *agentxWriter) uint32(i uint32) {
+	buf := make([]byte, 4)
+	w.order.PutUint32(buf, i)
+	w.data = append(w.data, buf...)
+}
+
+func (w *agentxWriter) uint64(i uint64) {
+	buf := make([]byte, 8)
+	w.order.PutUint64(buf, i)
+	w.data = append(w.data, buf...)
+}
+
+// octetString writes the length and the data padded to 4 bytes
+func (w *agentxWriter) octetString(i []byte) {
+	w.uint32(uint32(len(i)))
+	w.data = append(w.data, i...)
+	for len(w.data)%4 != 0 {
+		w.data = append(w.data, 0)
+	}
+}
+
+// oid writes an Object Identifier. 1.3.6.1.x. is written with prefix x
+func (w *agentxWriter) oid(oid string, include bool) {
+	subIDs, err := agentxParseOID(oid)
+	if err != nil {
+		w.err = err
+		return
+	}
+	prefix := byte(0)
+	if len(subIDs) > 5 && subIDs[0] == 1 && subIDs[1] == 3 && subIDs[2] == 6 && subIDs[3] == 1 &&
+		subIDs[4] > 0 && subIDs[4] < 256 {
+		prefix = byte(subIDs[4])
+		subIDs = subIDs[5:]
+	}
+	if len(subIDs) > 128 {
+		w.err = errors.Errorf("too many sub-identifiers in %v", oid)
+		return
+	}
+	includeByte := byte(0)
+	if include {
+		includeByte = 1
+	}
+	w.bytes(byte(len(subIDs)), prefix, includeByte, 0)
+	for _, each := range subIDs {
+		w.uint32(each)
+	}
+}
+
+func (w *agentxWriter) varBinds(i []gosnmp.SnmpPDU) {
+	for _, each := range i {
+		w.uint16(uint16(each.Type))
+		w.uint16(0)
+		w.oid(each.Name, false)
+		if err := w.value(each); err != nil {
+			w.err = errors.WithMessagef(err, "varbind %v", each.Name)
+		}
+	}
+}
+
+// value writes the data of a varbind. it accepts values in the forms of gosnmp and PDUValueControlItem.OnGet
+func (w *agentxWriter) value(i gosnmp.SnmpPDU) error {
+	switch i.Type {
+	case gosnmp.Integer:
+		val, err := agentxToUint64(i.Value)
+		w.uint32(uint32(val))
+		return err
+	case gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Uinteger32:
+		val, err := agentxToUint64(i.Value)
+		w.uint32(uint32(val))
+		return err
+	case gosnmp.Counter64:
+		val, err := agentxToUint64(i.Value)
+		w.uint64(val)
+		return err
+	case gosnmp.OctetString, gosnmp.Opaque, gosnmp.BitString:
+		switch val := i.Value.(type) {
+		case []byte:
+			w.octetString(val)
+		case string:
+			w.octetString([]byte(val))
+		default:
+			return errors.Errorf("not an OctetString %T", i.Value)
+		}
+	case gosnmp.IPAddress:
+		var ip net.IP
+		switch val := i.Value.(type) {
+		case string:
+			ip = net.ParseIP(val)
+		case net.IP:
+			ip = val
+		case []byte:
+			ip = net.IP(val)
+		}
+		if ip.To4() == nil {
+			return errors.Errorf("not an IPv4 address %v", i.Value)
+		}
+		w.octetString(ip.To4())
+	case gosnmp.ObjectIdentifier:
+		val, ok := i.Value.(string)
+		if !ok {
+			return errors.Errorf("not an ObjectIdentifier %T", i.Value)
+		}
+		w.oid(val, false)
+	case gosnmp.Null, gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+	default:
+		return errors.Errorf("not supported type %v", i.Type)
+	}
+	return nil
+}
+
+func agentxToUint64(i interface{}) (uint64, error) {
+	switch val := i.(type) {
+	case int:
+		return uint64(val), nil
+	case int8:
+		return uint64(val), nil
+	case int16:
+		return uint64(val), nil
+	case int32:
+		return uint64(val), nil
+	case int64:
+		return uint64(val), nil
+	case uint:
+		return uint64(val), nil
+	case uint8:
+		return uint64(val), nil
+	case uint16:
+		return uint64(val), nil
+	case uint32:
+		return uint64(val), nil
+	case uint64:
+		return val, nil
+	}
+	return 0, errors.Errorf("not an integer %T", i)
+}
+
+// agentxParseOID parses "1.3.6.1" or ".1.3.6.1". "" is the null OID.
+func agentxParseOID(oid string) ([]uint32, error) {
+	oid = strings.TrimPrefix(oid, ".")
+	if oid == "" {
+		return nil, nil
+	}
+	ret := []uint32{}
+	for _, each := range strings.Split(oid, ".") {
+		val, err := strconv.ParseUint(each, 10, 32)
+		if err != nil {
+			return nil, errors.Errorf("not valid oid %v", oid)
+		}
+		ret = append(ret, uint32(val))
+	}
+	return ret, nil
+}
+
+type agentxReader struct {
+	order binary.ByteOrder
+	data  []byte
+	err   error
+}
+
+func (r *agentxReader) bytes(count int) []byte {
+	if r.err != nil || len(r.data) < count {
+		if r.err == nil {
+			r.err = errors.New("truncated")
+		}
+		return make([]byte, count)
+	}
+	ret := r.data[:count]
+	r.data = r.data[count:]
+	return ret
+}
+
+func (r *agentxReader) uint16() uint16 {
+	return r.order.Uint16(r.bytes(2))
+}
+
+func (r *agentxReader) uint32() uint32 {
+	return r.order.Uint32(r.bytes(4))
+}
+
+func (r *agentxReader) uint64() uint64 {
+	return r.order.Uint64(r.bytes(8))
+}
+
+func (r *agentxReader) octetString() []byte {
+	length := int(r.uint32())
+	if r.err != nil || length > len(r.data) {
+		r.bytes(len(r.data) + 1)
+		return nil
+	}
+	ret := append([]byte{}, r.bytes(length)...)
+	r.bytes((4 - length%4) % 4)
+	return ret
+}
+
+func (r *agentxReader) oid() (string, bool) {
+	fields := r.bytes(4)
+	count, prefix, include := int(fields[0]), fields[1], fields[2] != 0
+	parts := []string{}
+	if prefix != 0 {
+		parts = append(parts, agentxInternetPrefix, strconv.Itoa(int(prefix)))
+	}
+	for i := 0; i < count && r.err == nil; i++ {
+		parts = append(parts, strconv.FormatUint(uint64(r.uint32()), 10))
+	}
+	return strings.Join(parts, "."), include
+}
+
+func (r *agentxReader) varBinds() []gosnmp.SnmpPDU {
+	ret := []gosnmp.SnmpPDU{}
+	for r.err == nil && len(r.data) != 0 {
+		each := gosnmp.SnmpPDU{Type: gosnmp.Asn1BER(r.uint16())}
+		r.uint16()
+		each.Name, _ = r.oid()
+		each.Value = r.value(each.Type)
+		ret = append(ret, each)
+	}
+	return ret
+}
+
+// value reads the data of a varbind in the forms gosnmp decodes
+func (r *agentxReader) value(i gosnmp.Asn1BER) interface{} {
+	switch i {
+	case gosnmp.Integer:
+		return int(int32(r.uint32()))
+	case gosnmp.Counter32, gosnmp.Gauge32:
+		return uint(r.uint32())
+	case gosnmp.TimeTicks, gosnmp.Uinteger32:
+		return r.uint32()
+	case gosnmp.Counter64:
+		return r.uint64()
+	case gosnmp.OctetString, gosnmp.Opaque, gosnmp.BitString:
+		return r.octetString()
+	case gosnmp.IPAddress:
+		ip := r.octetString()
+		if len(ip) != 4 {
+			r.err = errors.Errorf("not valid IpAddress %v", ip)
+			return nil
+		}
+		return net.IP(ip).String()
+	case gosnmp.ObjectIdentifier:
+		val, _ := r.oid()
+		return "." + val
+	case gosnmp.Null, gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+		return nil
+	}
+	r.err = errors.Errorf("not supported type %v", i)
+	return nil
+}
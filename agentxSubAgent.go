@@ -0,0 +1,576 @@
+package GoSNMPServer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+)
+
+// DefaultAgentXReconnectInterval is the interval between attempts of AgentXSubAgent to connect
+const DefaultAgentXReconnectInterval = 5 * time.Second
+
+// AgentXSubAgent serves the OIDs and Tables of a SubAgent through an AgentX master agent,
+//
+//	eg. snmpd of net-snmp configured with "master agentx". See RFC 2741
+//	It reconnects until Shutdown if the connection is lost or closed by the master agent.
+type AgentXSubAgent struct {
+	// Network and Address of the master agent. eg. "unix" "/var/agentx/master" or "tcp" "localhost:705"
+	Network string
+	Address string
+
+	// SubAgent serves the requests forwarded by the master agent
+	SubAgent *SubAgent
+
+	// ID and Descr of the session. ID is an OID identifying the subagent, "" for the null OID
+	ID    string
+	Descr string
+	// Timeout of the session. 0 for the default of the master agent
+	Timeout time.Duration
+	// Priority of the registrations. 0 for the default 127
+	Priority uint8
+	// Context to register in. "" for the default context
+	Context string
+	// Subtrees to register. nil registers each of SubAgent.OIDs as an instance and each of
+	//          SubAgent.Tables and SubAgent.Subtrees as a subtree, read on each connection.
+	Subtrees []string
+	// ReconnectInterval between attempts to connect. 0 for DefaultAgentXReconnectInterval
+	ReconnectInterval time.Duration
+
+	Logger ILogger
+
+	priv struct {
+		once     sync.Once
+		done     chan struct{}
+		doneOnce sync.Once
+
+		mu   sync.Mutex
+		conn *agentxSubAgentConn
+	}
+}
+
+// agentxSubAgentConn is a connection to the master agent with one session
+type agentxSubAgentConn struct {
+	agent     *AgentXSubAgent
+	conn      net.Conn
+	sessionID uint32
+
+	writeMu sync.Mutex
+
+	mu           sync.Mutex
+	lastPacketID uint32
+	pending      map[uint32]chan *agentxPacket
+	closed       chan struct{}
+	err          error
+
+	// set is the SET transaction in progress. Only used by the reading goroutine
+	set *agentxSubAgentSet
+}
+
+type agentxSubAgentSet struct {
+	transactionID uint32
+	items         []setRequestItem
+	// committed is the count of items committed
+	committed int
+}
+
+func (t *AgentXSubAgent) init() {
+	t.priv.once.Do(func() {
+		t.priv.done = make(chan struct{})
+	})
+}
+
+// ServeForever connects to the master agent and serves its requests until Shutdown
+func (t *AgentXSubAgent) ServeForever() error {
+	t.init()
+	if t.SubAgent == nil {
+		return errors.New("AgentXSubAgent: SubAgent is nil")
+	}
+	if t.Logger == nil {
+		t.Logger = NewDiscardLogger()
+	}
+	if t.SubAgent.Logger == nil {
+		t.SubAgent.Logger = t.Logger
+	}
+	if err := t.SubAgent.SyncConfig(); err != nil {
+		return err
+	}
+	interval := t.ReconnectInterval
+	if interval <= 0 {
+		interval = DefaultAgentXReconnectInterval
+	}
+	for {
+		err := t.serveConn()
+		select {
+		case <-t.priv.done:
+			return nil
+		default:
+		}
+		t.Logger.Warnf("AgentX subagent of %v %v: %v. reconnect in %v", t.Network, t.Address, err, interval)
+		select {
+		case <-t.priv.done:
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Shutdown closes the session and stops ServeForever
+func (t *AgentXSubAgent) Shutdown() {
+	t.init()
+	t.priv.doneOnce.Do(func() { close(t.priv.done) })
+	t.priv.mu.Lock()
+	c := t.priv.conn
+	t.priv.mu.Unlock()
+	if c != nil {
+		c.send(&agentxPacket{Type: agentxClose, Reason: agentxCloseReasonShutdown})
+		c.conn.Close()
+	}
+}
+
+// serveConn opens a session and serves it until the connection is closed
+func (t *AgentXSubAgent) serveConn() error {
+	dialer := net.Dialer{Timeout: DefaultAgentXTimeout}
+	conn, err := dialer.Dial(t.Network, t.Address)
+	if err != nil {
+		return errors.Wrap(err, "AgentX Dial")
+	}
+	c := &agentxSubAgentConn{
+		agent:   t,
+		conn:    conn,
+		pending: make(map[uint32]chan *agentxPacket),
+		closed:  make(chan struct{}),
+	}
+	t.priv.mu.Lock()
+	select {
+	case <-t.priv.done:
+		t.priv.mu.Unlock()
+		conn.Close()
+		return nil
+	default:
+	}
+	t.priv.conn = c
+	t.priv.mu.Unlock()
+	defer func() {
+		t.priv.mu.Lock()
+		t.priv.conn = nil
+		t.priv.mu.Unlock()
+		conn.Close()
+	}()
+	go c.serve()
+
+	if err := c.open(); err != nil {
+		return err
+	}
+	registered := 0
+	for _, p := range t.registrations() {
+		response, err := c.request(p)
+		if err != nil {
+			return err
+		}
+		if response.Error != AgentXNoError {
+			t.Logger.Warnf("AgentX Register %v: %v", p.Subtree, response.Error)
+			continue
+		}
+		registered++
+	}
+	t.Logger.Infof("AgentX session %v to %v %v: %v subtrees registered",
+		atomic.LoadUint32(&c.sessionID), t.Network, t.Address, registered)
+	<-c.closed
+	return c.err
+}
+
+// registrations returns the Register-PDUs of Subtrees or the OIDs and Tables of SubAgent
+func (t *AgentXSubAgent) registrations() []*agentxPacket {
+	ret := []*agentxPacket{}
+	add := func(subtree string, flags uint8) {
+		p := &agentxPacket{
+			Type:     agentxRegister,
+			Flags:    flags,
+			Priority: t.Priority,
+			Subtree:  strings.TrimPrefix(subtree, "."),
+		}
+		if p.Priority == 0 {
+			p.Priority = 127
+		}
+		if t.Context != "" {
+			p.Flags |= agentxFlagNonDefaultContext
+			p.Context = t.Context
+		}
+		ret = append(ret, p)
+	}
+	if t.Subtrees != nil {
+		for _, each := range t.Subtrees {
+			add(each, 0)
+		}
+		return ret
+	}
+	t.SubAgent.mu.RLock()
+	for _, each := range t.SubAgent.OIDs {
+		add(each.OID, agentxFlagInstanceRegistration)
+	}
+	t.SubAgent.mu.RUnlock()
+	for _, each := range t.SubAgent.Tables {
+		add(each.OID, 0)
+	}
+	for _, each := range t.SubAgent.Subtrees {
+		add(each.OID, 0)
+	}
+	return ret
+}
+
+// open sends the Open-PDU and keeps the session ID
+func (c *agentxSubAgentConn) open() error {
+	timeout := c.agent.Timeout / time.Second
+	if timeout > 255 {
+		timeout = 255
+	}
+	response, err := c.request(&agentxPacket{
+		Type:    agentxOpen,
+		Timeout: uint8(timeout),
+		ID:      c.agent.ID,
+		Descr:   c.agent.Descr,
+	})
+	if err != nil {
+		return err
+	}
+	if response.Error != AgentXNoError {
+		return errors.Wrap(response.Error, "AgentX Open")
+	}
+	atomic.StoreUint32(&c.sessionID, response.SessionID)
+	return nil
+}
+
+// send writes p of the session without waiting for a response
+func (c *agentxSubAgentConn) send(p *agentxPacket) error {
+	c.mu.Lock()
+	c.lastPacketID++
+	p.PacketID = c.lastPacketID
+	c.mu.Unlock()
+	p.SessionID = atomic.LoadUint32(&c.sessionID)
+	p.Flags |= agentxFlagNetworkByteOrder
+	return c.write(p)
+}
+
+// request sends p and waits for its response
+func (c *agentxSubAgentConn) request(p *agentxPacket) (*agentxPacket, error) {
+	waiting := make(chan *agentxPacket, 1)
+	c.mu.Lock()
+	c.lastPacketID++
+	p.PacketID = c.lastPacketID
+	c.pending[p.PacketID] = waiting
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, p.PacketID)
+		c.mu.Unlock()
+	}()
+	p.SessionID = atomic.LoadUint32(&c.sessionID)
+	p.Flags |= agentxFlagNetworkByteOrder
+	if err := c.write(p); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(DefaultAgentXTimeout)
+	defer timer.Stop()
+	select {
+	case response := <-waiting:
+		return response, nil
+	case <-timer.C:
+		return nil, errors.Errorf("AgentX PDU type %v: timeout", p.Type)
+	case <-c.closed:
+		return nil, errors.Errorf("AgentX PDU type %v: closed", p.Type)
+	}
+}
+
+func (c *agentxSubAgentConn) write(p *agentxPacket) error {
+	out, err := p.Marshal()
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.conn.Write(out); err != nil {
+		return errors.Wrap(err, "AgentX Write")
+	}
+	return nil
+}
+
+// serve reads PDUs until the connection is closed. Requests of the master agent are served in order
+func (c *agentxSubAgentConn) serve() {
+	logger := c.agent.Logger
+	defer close(c.closed)
+	defer c.conn.Close()
+	reader := bufio.NewReader(c.conn)
+	for {
+		p, err := readAgentXPacket(reader, agentxMaxPayloadSize)
+		if p == nil {
+			c.err = errors.Wrap(err, "AgentX connection closed")
+			return
+		}
+		if err != nil {
+			logger.Warnf("AgentX PDU from master: %v", err)
+			if p.Type != agentxResponse {
+				c.write(p.newResponse(AgentXParseError, 0))
+			}
+			continue
+		}
+		switch p.Type {
+		case agentxResponse:
+			c.mu.Lock()
+			waiting := c.pending[p.PacketID]
+			delete(c.pending, p.PacketID)
+			c.mu.Unlock()
+			if waiting != nil {
+				waiting <- p
+			}
+			continue
+		case agentxClose:
+			c.err = errors.Errorf("AgentX session closed by master. reason %v", p.Reason)
+			return
+		case agentxCleanupSet:
+			// not responded. See RFC 2741 section 7.2.4.4
+			c.set = nil
+			c.endSet()
+			continue
+		}
+		response := c.handle(p)
+		if err := c.write(response); err != nil {
+			logger.Warnf("AgentX response of PDU type %v: %v", p.Type, err)
+			c.write(p.newResponse(AgentXError(gosnmp.GenErr), 0))
+		}
+	}
+}
+
+// handle serves a request of the master agent. returns the Response-PDU
+func (c *agentxSubAgentConn) handle(p *agentxPacket) *agentxPacket {
+	if p.SessionID != atomic.LoadUint32(&c.sessionID) {
+		return p.newResponse(AgentXNotOpen, 0)
+	}
+	// the request is served as SNMPv2c with the context as community for OnCheckPermission
+	request := &gosnmp.SnmpPacket{Version: gosnmp.Version2c, Community: p.Context}
+	switch p.Type {
+	case agentxGet:
+		request.PDUType = gosnmp.GetRequest
+	case agentxGetNext, agentxGetBulk:
+		request.PDUType = gosnmp.GetNextRequest
+	case agentxTestSet, agentxCommitSet, agentxUndoSet:
+		request.PDUType = gosnmp.SetRequest
+	default:
+		return p.newResponse(AgentXProcessingError, 0)
+	}
+	ctx := newRequestContext(context.Background(), request)
+	// OIDs registered by AgentX subagents of the SubAgent's own MasterAgent are not resolved
+	resolver := &oidResolver{agent: c.agent.SubAgent}
+	switch p.Type {
+	case agentxGet:
+		return c.serveGet(ctx, p, request, resolver)
+	case agentxGetNext, agentxGetBulk:
+		return c.serveGetNext(ctx, p, request, resolver)
+	case agentxTestSet:
+		return c.testSet(p, request, resolver)
+	case agentxCommitSet:
+		return c.commitSet(ctx, p)
+	}
+	return c.undoSet(p)
+}
+
+// getValue appends the varbind of item to response. The first error is kept in response
+func (c *agentxSubAgentConn) getValue(ctx context.Context, response *agentxPacket, request *gosnmp.SnmpPacket, item *PDUValueControlItem) {
+	varItem, snmperr := c.agent.SubAgent.getForPDUValueControlResult(ctx, item, request)
+	varItem.Name = strings.TrimPrefix(varItem.Name, ".")
+	if snmperr != gosnmp.NoError && response.Error == AgentXNoError {
+		response.Error = AgentXError(snmperr)
+		response.Index = uint16(len(response.Variables) + 1)
+	}
+	response.Variables = append(response.Variables, varItem)
+}
+
+// serveGet serves Get-PDUs. See RFC 2741 section 7.2.3.1
+func (c *agentxSubAgentConn) serveGet(ctx context.Context, p *agentxPacket, request *gosnmp.SnmpPacket,
+	resolver *oidResolver) *agentxPacket {
+	response := p.newResponse(AgentXNoError, 0)
+	for _, each := range p.Ranges {
+		item := resolver.getLocal(each.Start)
+		if item == nil {
+			response.Variables = append(response.Variables, gosnmp.SnmpPDU{Name: each.Start, Type: gosnmp.NoSuchObject})
+			continue
+		}
+		c.getValue(ctx, response, request, item)
+	}
+	return response
+}
+
+// next returns the first walkable item in searchRange. nil for endOfMibView
+func (c *agentxSubAgentConn) next(resolver *oidResolver, searchRange agentxSearchRange) *PDUValueControlItem {
+	var item *PDUValueControlItem
+	if searchRange.Include {
+		item = resolver.getLocal(searchRange.Start)
+		if item != nil && (item.NonWalkable || !item.readable()) {
+			item = nil
+		}
+	}
+	if item == nil {
+		item = resolver.next(searchRange.Start, nil, true)
+	}
+	if item == nil || searchRange.End == "" {
+		return item
+	}
+	end, err := parseOID(searchRange.End)
+	if err != nil || compareByteString(item.oidArcs(), end) != ByteStringCompareResultLessThen {
+		return nil
+	}
+	return item
+}
+
+// serveGetNext serves GetNext-PDUs and GetBulk-PDUs. See RFC 2741 section 7.2.3.2 and 7.2.3.3
+//
+//	Repetitions of GetBulk stop when all repeaters reach endOfMibView.
+func (c *agentxSubAgentConn) serveGetNext(ctx context.Context, p *agentxPacket, request *gosnmp.SnmpPacket,
+	resolver *oidResolver) *agentxPacket {
+	response := p.newResponse(AgentXNoError, 0)
+	nonRepeaters, repetitions := len(p.Ranges), 0
+	if p.Type == agentxGetBulk {
+		nonRepeaters, repetitions = int(p.NonRepeaters), int(p.MaxRepetitions)
+		if nonRepeaters > len(p.Ranges) {
+			nonRepeaters = len(p.Ranges)
+		}
+	}
+	// nextOf appends the varbind after searchRange. returns false for endOfMibView
+	nextOf := func(searchRange agentxSearchRange) (string, bool) {
+		item := c.next(resolver, searchRange)
+		if item == nil {
+			response.Variables = append(response.Variables,
+				gosnmp.SnmpPDU{Name: searchRange.Start, Type: gosnmp.EndOfMibView})
+			return searchRange.Start, false
+		}
+		c.getValue(ctx, response, request, item)
+		return strings.TrimPrefix(item.OID, "."), true
+	}
+	for _, each := range p.Ranges[:nonRepeaters] {
+		nextOf(each)
+	}
+	repeaters := append([]agentxSearchRange{}, p.Ranges[nonRepeaters:]...)
+	for j := 0; j < repetitions && len(repeaters) != 0; j++ {
+		more := false
+		for k := range repeaters {
+			name, found := nextOf(repeaters[k])
+			repeaters[k] = agentxSearchRange{Start: name, End: repeaters[k].End}
+			more = more || found
+		}
+		if !more {
+			break
+		}
+	}
+	return response
+}
+
+// testSet serves TestSet-PDUs. See RFC 2741 section 7.2.4.1
+func (c *agentxSubAgentConn) testSet(p *agentxPacket, request *gosnmp.SnmpPacket,
+	resolver *oidResolver) *agentxPacket {
+	agent := c.agent.SubAgent
+	c.set = nil
+	toSet := []setRequestItem{}
+	for id, varItem := range p.Variables {
+		failed := func(status gosnmp.SNMPError) *agentxPacket {
+			return p.newResponse(AgentXError(status), uint16(id+1))
+		}
+		item := resolver.getLocal(varItem.Name)
+		if item == nil {
+			var err error
+			if item, err = agent.createForPDUValueControl(varItem.Name); err != nil {
+				agent.Logger.Debugf("create oid %v meet %v", varItem.Name, err)
+			}
+			if item == nil {
+				return failed(gosnmp.NoCreation)
+			}
+		}
+		if agent.checkPermission(item, request) != PermissionAllowanceAllowed {
+			return failed(gosnmp.NoAccess)
+		}
+		if !item.writable() {
+			return failed(gosnmp.NotWritable)
+		}
+		each := setRequestItem{id: id, varItem: varItem, item: item}
+		if status, err := agent.testSetItem(each); err != nil {
+			agent.Logger.Debugf("test set %v meet %v", varItem.Name, err)
+			return failed(status)
+		}
+		toSet = append(toSet, each)
+	}
+	if failed, status := agent.testSetObservers(toSet); failed != nil {
+		return p.newResponse(AgentXError(status), uint16(failed.id+1))
+	}
+	c.set = &agentxSubAgentSet{transactionID: p.TransactionID, items: toSet}
+	return p.newResponse(AgentXNoError, 0)
+}
+
+// commitSet serves CommitSet-PDUs. committed items are undone if one fails. See RFC 2741 section 7.2.4.2
+func (c *agentxSubAgentConn) commitSet(ctx context.Context, p *agentxPacket) *agentxPacket {
+	agent := c.agent.SubAgent
+	set := c.set
+	if set == nil || set.transactionID != p.TransactionID {
+		return p.newResponse(AgentXProcessingError, 0)
+	}
+	for done, each := range set.items {
+		err := agent.commitSetItem(ctx, each)
+		if err == nil {
+			set.committed = done + 1
+			continue
+		}
+		agent.Logger.Debugf("commit set %v meet %v", each.varItem.Name, err)
+		if each.item.OnCommitSet == nil && !agent.UserErrorMarkPacket {
+			// OnSet without UserErrorMarkPacket does not fail the request, as SNMP SET does
+			set.committed = done + 1
+			continue
+		}
+		if failed := c.undoItems(set.items[:done]); failed != nil {
+			return p.newResponse(AgentXError(gosnmp.UndoFailed), uint16(failed.id+1))
+		}
+		set.committed = 0
+		return p.newResponse(AgentXError(gosnmp.CommitFailed), uint16(each.id+1))
+	}
+	return p.newResponse(AgentXNoError, 0)
+}
+
+// undoSet serves UndoSet-PDUs. See RFC 2741 section 7.2.4.3
+func (c *agentxSubAgentConn) undoSet(p *agentxPacket) *agentxPacket {
+	set := c.set
+	if set == nil || set.transactionID != p.TransactionID {
+		return p.newResponse(AgentXProcessingError, 0)
+	}
+	failed := c.undoItems(set.items[:set.committed])
+	set.committed = 0
+	if failed != nil {
+		return p.newResponse(AgentXError(gosnmp.UndoFailed), uint16(failed.id+1))
+	}
+	return p.newResponse(AgentXNoError, 0)
+}
+
+// endSet ends the SET of the transaction, which is not interleaved with SNMP SET requests of the SubAgent
+func (c *agentxSubAgentConn) endSet() {
+	agent := c.agent.SubAgent
+	agent.setMu.Lock()
+	defer agent.setMu.Unlock()
+	agent.endSet()
+}
+
+// undoItems undoes committed items in reverse order. returns the first item failed
+func (c *agentxSubAgentConn) undoItems(committed []setRequestItem) *setRequestItem {
+	agent := c.agent.SubAgent
+	var failed *setRequestItem
+	for undo := len(committed) - 1; undo >= 0; undo-- {
+		if err := agent.undoSetItem(committed[undo]); err != nil {
+			agent.Logger.Debugf("undo set %v meet %v", committed[undo].varItem.Name, err)
+			if failed == nil {
+				failed = &committed[undo]
+			}
+		}
+	}
+	return failed
+}
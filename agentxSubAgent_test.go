@@ -0,0 +1,252 @@
+package GoSNMPServer
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type AgentXSubAgentTests struct {
+	suite.Suite
+	Logger ILogger
+
+	shandle *SNMPServer
+	dir     string
+	socket  string
+	agent   *AgentXSubAgent
+	stopped chan struct{}
+
+	mu         sync.Mutex
+	name       string
+	failCommit bool
+}
+
+func (suite *AgentXSubAgentTests) newMaster() *SNMPServer {
+	master := MasterAgent{
+		Logger: suite.Logger,
+		SubAgents: []*SubAgent{
+			{
+				CommunityIDs: []string{"public"},
+				OIDs: []*PDUValueControlItem{
+					{
+						OID:   "1.3.6.1.4.1.9999.1.0",
+						Type:  gosnmp.OctetString,
+						OnGet: func() (value interface{}, err error) { return Asn1OctetStringWrap("local"), nil },
+					},
+				},
+			},
+		},
+	}
+	shandle := NewSNMPServer(master)
+	if err := shandle.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	if err := shandle.ListenAgentX("unix", suite.socket); err != nil {
+		panic(err)
+	}
+	go shandle.ServeForever()
+	return shandle
+}
+
+func (suite *AgentXSubAgentTests) SetupTest() {
+	logger := NewDefaultLogger()
+	logger.(*DefaultLogger).Level = logrus.InfoLevel
+	suite.Logger = logger
+	suite.name = "gosnmp"
+	suite.failCommit = false
+
+	dir, err := ioutil.TempDir("", "agentx")
+	if err != nil {
+		panic(err)
+	}
+	suite.dir = dir
+	suite.socket = filepath.Join(dir, "master")
+	suite.shandle = suite.newMaster()
+
+	var undo string
+	suite.agent = &AgentXSubAgent{
+		Network:           "unix",
+		Address:           suite.socket,
+		Descr:             "test",
+		ReconnectInterval: 50 * time.Millisecond,
+		Logger:            suite.Logger,
+		SubAgent: &SubAgent{
+			OIDs: []*PDUValueControlItem{
+				{
+					OID:  "1.3.6.1.4.1.9999.30.1",
+					Type: gosnmp.OctetString,
+					OnGet: func() (value interface{}, err error) {
+						suite.mu.Lock()
+						defer suite.mu.Unlock()
+						return Asn1OctetStringWrap(suite.name), nil
+					},
+					OnTestSet: func(value interface{}) error {
+						if Asn1OctetStringUnwrap(value) == "bad" {
+							return errors.New("bad name")
+						}
+						return nil
+					},
+					OnCommitSet: func(value interface{}) error {
+						suite.mu.Lock()
+						defer suite.mu.Unlock()
+						undo, suite.name = suite.name, Asn1OctetStringUnwrap(value)
+						return nil
+					},
+					OnUndoSet: func(value interface{}) error {
+						suite.mu.Lock()
+						defer suite.mu.Unlock()
+						suite.name = undo
+						return nil
+					},
+				},
+				{
+					OID:   "1.3.6.1.4.1.9999.30.2",
+					Type:  gosnmp.Integer,
+					OnGet: func() (value interface{}, err error) { return Asn1IntegerWrap(2), nil },
+				},
+				{
+					OID:   "1.3.6.1.4.1.9999.30.3",
+					Type:  gosnmp.Gauge32,
+					OnGet: func() (value interface{}, err error) { return Asn1Gauge32Wrap(3), nil },
+					OnCommitSet: func(value interface{}) error {
+						suite.mu.Lock()
+						defer suite.mu.Unlock()
+						if suite.failCommit {
+							return errors.New("commit failed")
+						}
+						return nil
+					},
+				},
+			},
+		},
+	}
+	suite.stopped = make(chan struct{})
+	go func() {
+		defer close(suite.stopped)
+		suite.agent.ServeForever()
+	}()
+	suite.waitRegistered()
+}
+
+func (suite *AgentXSubAgentTests) TearDownTest() {
+	suite.agent.Shutdown()
+	<-suite.stopped
+	suite.shandle.Shutdown()
+	os.RemoveAll(suite.dir)
+}
+
+// waitRegistered waits until the OIDs of the subagent are served by the master agent
+func (suite *AgentXSubAgentTests) waitRegistered() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+	assert.Eventually(suite.T(), func() bool {
+		result, err := client.Get([]string{"1.3.6.1.4.1.9999.30.3"})
+		return err == nil && result.Variables[0].Type == gosnmp.Gauge32
+	}, 2*time.Second, 20*time.Millisecond)
+}
+
+func (suite *AgentXSubAgentTests) getClient() *gosnmp.GoSNMP {
+	serverAddress := suite.shandle.Address().(*net.UDPAddr)
+	client := &gosnmp.GoSNMP{
+		Target:    serverAddress.IP.String(),
+		Port:      uint16(serverAddress.Port),
+		Version:   gosnmp.Version2c,
+		Community: "public",
+		Timeout:   2 * time.Second,
+	}
+	if err := client.Connect(); err != nil {
+		panic(err)
+	}
+	return client
+}
+
+func (suite *AgentXSubAgentTests) TestGet() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+	result, err := client.Get([]string{"1.3.6.1.4.1.9999.30.1", "1.3.6.1.4.1.9999.30.2", "1.3.6.1.4.1.9999.1.0"})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), "gosnmp", string(result.Variables[0].Value.([]byte)))
+		assert.Equal(suite.T(), 2, result.Variables[1].Value)
+		assert.Equal(suite.T(), "local", string(result.Variables[2].Value.([]byte)))
+	}
+	result, err = client.Get([]string{"1.3.6.1.4.1.9999.30.9"})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), gosnmp.NoSuchInstance, result.Variables[0].Type)
+	}
+}
+
+func (suite *AgentXSubAgentTests) TestWalk() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+	expected := []string{
+		".1.3.6.1.4.1.9999.1.0",
+		".1.3.6.1.4.1.9999.30.1",
+		".1.3.6.1.4.1.9999.30.2",
+		".1.3.6.1.4.1.9999.30.3",
+	}
+	walked, err := client.WalkAll("1.3.6.1.4.1.9999")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), expected, getNames(walked))
+	walked, err = client.BulkWalkAll("1.3.6.1.4.1.9999")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), expected, getNames(walked))
+	assert.Equal(suite.T(), uint(3), walked[3].Value)
+}
+
+func (suite *AgentXSubAgentTests) TestSet() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+	set := func(variables ...gosnmp.SnmpPDU) gosnmp.SNMPError {
+		result, err := client.Set(variables)
+		if !assert.Nil(suite.T(), err) {
+			return gosnmp.GenErr
+		}
+		return result.Error
+	}
+	name := func(value string) gosnmp.SnmpPDU {
+		return gosnmp.SnmpPDU{Name: "1.3.6.1.4.1.9999.30.1", Type: gosnmp.OctetString, Value: value}
+	}
+	current := func() string {
+		suite.mu.Lock()
+		defer suite.mu.Unlock()
+		return suite.name
+	}
+	assert.Equal(suite.T(), gosnmp.NoError, set(name("agentx")))
+	assert.Equal(suite.T(), "agentx", current())
+	assert.Equal(suite.T(), gosnmp.WrongValue, set(name("bad")))
+	assert.Equal(suite.T(), gosnmp.NotWritable,
+		set(gosnmp.SnmpPDU{Name: "1.3.6.1.4.1.9999.30.2", Type: gosnmp.Integer, Value: 1}))
+
+	suite.mu.Lock()
+	suite.failCommit = true
+	suite.mu.Unlock()
+	assert.Equal(suite.T(), gosnmp.CommitFailed, set(name("undone"),
+		gosnmp.SnmpPDU{Name: "1.3.6.1.4.1.9999.30.3", Type: gosnmp.Gauge32, Value: uint(1)}))
+	assert.Equal(suite.T(), "agentx", current())
+}
+
+func (suite *AgentXSubAgentTests) TestReconnect() {
+	suite.shandle.Shutdown()
+	suite.shandle = suite.newMaster()
+	suite.waitRegistered()
+	client := suite.getClient()
+	defer client.Conn.Close()
+	result, err := client.Get([]string{"1.3.6.1.4.1.9999.30.1"})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), "gosnmp", string(result.Variables[0].Value.([]byte)))
+	}
+}
+
+func TestAgentXSubAgentTestsSuite(t *testing.T) {
+	suite.Run(t, new(AgentXSubAgentTests))
+}
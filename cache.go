@@ -0,0 +1,91 @@
+package GoSNMPServer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats counts reads of a Snapshot answered from the cache, or by calling OnRead
+type CacheStats struct {
+	Hits   uint32
+	Misses uint32
+}
+
+// Snapshot shares what OnRead returns for TTL among the items reading it.
+//
+//	Columns of a walk read the same Snapshot, so that the source, as /proc, is read once per walk
+//	and every column is answered from one consistent value. Errors of OnRead are not cached.
+//	Concurrent reads wait for the running OnRead instead of calling it again.
+type Snapshot struct {
+	// TTL is how long a value is served after read. 0 to call OnRead on every Get.
+	TTL    time.Duration
+	OnRead func() (value interface{}, err error)
+
+	mu     sync.Mutex
+	value  interface{}
+	readAt time.Time
+	valid  bool
+	hits   uint32
+	misses uint32
+
+	// now is time.Now. replaced by tests
+	now func() time.Time
+}
+
+// NewSnapshot creates a Snapshot of onRead cached for ttl
+func NewSnapshot(ttl time.Duration, onRead func() (value interface{}, err error)) *Snapshot {
+	return &Snapshot{TTL: ttl, OnRead: onRead}
+}
+
+// Get returns the cached value, or calls OnRead if it is older than TTL
+func (t *Snapshot) Get() (interface{}, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now
+	if t.now != nil {
+		now = t.now
+	}
+	if t.valid && now().Sub(t.readAt) < t.TTL {
+		atomic.AddUint32(&t.hits, 1)
+		return t.value, nil
+	}
+	atomic.AddUint32(&t.misses, 1)
+	value, err := t.OnRead()
+	if err != nil {
+		t.valid = false
+		return nil, err
+	}
+	t.value, t.readAt, t.valid = value, now(), true
+	return value, nil
+}
+
+// SetTTL changes TTL of a Snapshot in use
+func (t *Snapshot) SetTTL(ttl time.Duration) {
+	t.mu.Lock()
+	t.TTL = ttl
+	t.mu.Unlock()
+}
+
+// Invalidate drops the cached value, so that the next Get calls OnRead
+func (t *Snapshot) Invalidate() {
+	t.mu.Lock()
+	t.valid = false
+	t.mu.Unlock()
+}
+
+// Stats returns hits and misses of Get so far
+func (t *Snapshot) Stats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadUint32(&t.hits),
+		Misses: atomic.LoadUint32(&t.misses),
+	}
+}
+
+// CachedOnGet wraps onGet of a single item with a Snapshot of ttl.
+//
+//	The Snapshot is returned for its Stats. Items sharing a source should share a Snapshot instead.
+func CachedOnGet(ttl time.Duration, onGet FuncPDUControlGet) (FuncPDUControlGet, *Snapshot) {
+	snapshot := NewSnapshot(ttl, onGet)
+	return snapshot.Get, snapshot
+}
@@ -0,0 +1,153 @@
+package GoSNMPServer
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type CacheTests struct {
+	suite.Suite
+
+	now   time.Time
+	reads int
+	err   error
+}
+
+func (suite *CacheTests) SetupTest() {
+	suite.now = time.Now()
+	suite.reads = 0
+	suite.err = nil
+}
+
+func (suite *CacheTests) newSnapshot(ttl time.Duration) *Snapshot {
+	snapshot := NewSnapshot(ttl, func() (interface{}, error) {
+		suite.reads++
+		if suite.err != nil {
+			return nil, suite.err
+		}
+		return suite.reads, nil
+	})
+	snapshot.now = func() time.Time { return suite.now }
+	return snapshot
+}
+
+func (suite *CacheTests) TestTTL() {
+	snapshot := suite.newSnapshot(time.Second)
+	for i := 0; i < 3; i++ {
+		value, err := snapshot.Get()
+		assert.Nil(suite.T(), err)
+		assert.Equal(suite.T(), 1, value)
+	}
+	suite.now = suite.now.Add(time.Second)
+	value, _ := snapshot.Get()
+	assert.Equal(suite.T(), 2, value)
+	snapshot.Invalidate()
+	value, _ = snapshot.Get()
+	assert.Equal(suite.T(), 3, value)
+	assert.Equal(suite.T(), CacheStats{Hits: 2, Misses: 3}, snapshot.Stats())
+
+	snapshot.SetTTL(0)
+	snapshot.Get()
+	snapshot.Get()
+	assert.Equal(suite.T(), 5, suite.reads)
+}
+
+func (suite *CacheTests) TestError() {
+	snapshot := suite.newSnapshot(time.Second)
+	suite.err = errors.New("read failed")
+	_, err := snapshot.Get()
+	assert.NotNil(suite.T(), err)
+	suite.err = nil
+	value, err := snapshot.Get()
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 2, value)
+	assert.Equal(suite.T(), CacheStats{Hits: 0, Misses: 2}, snapshot.Stats())
+}
+
+func (suite *CacheTests) TestConcurrent() {
+	snapshot := suite.newSnapshot(time.Hour)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			snapshot.Get()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(suite.T(), 1, suite.reads)
+	assert.Equal(suite.T(), CacheStats{Hits: 19, Misses: 1}, snapshot.Stats())
+}
+
+// TestWalk walks columns sharing a Snapshot, which is read once
+func (suite *CacheTests) TestWalk() {
+	snapshot := NewSnapshot(time.Minute, func() (interface{}, error) {
+		suite.reads++
+		return []int{suite.reads * 10, suite.reads * 20, suite.reads * 30}, nil
+	})
+	column := func(oid string, id int) *PDUValueControlItem {
+		return &PDUValueControlItem{
+			OID:  oid,
+			Type: gosnmp.Integer,
+			OnGet: func() (value interface{}, err error) {
+				val, err := snapshot.Get()
+				if err != nil {
+					return nil, err
+				}
+				return Asn1IntegerWrap(val.([]int)[id]), nil
+			},
+		}
+	}
+	cached, single := CachedOnGet(time.Minute, func() (value interface{}, err error) { return Asn1IntegerWrap(4), nil })
+	master := MasterAgent{
+		Logger: NewDiscardLogger(),
+		SubAgents: []*SubAgent{
+			{
+				CommunityIDs: []string{"public"},
+				OIDs: []*PDUValueControlItem{
+					column("1.3.6.1.4.1.9999.95.1", 0),
+					column("1.3.6.1.4.1.9999.95.2", 1),
+					column("1.3.6.1.4.1.9999.95.3", 2),
+					{OID: "1.3.6.1.4.1.9999.95.4", Type: gosnmp.Integer, OnGet: cached},
+				},
+			},
+		},
+	}
+	shandle := NewSNMPServer(master)
+	if err := shandle.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	defer shandle.Shutdown()
+	go shandle.ServeForever()
+
+	serverAddress := shandle.Address().(*net.UDPAddr)
+	client := &gosnmp.GoSNMP{
+		Target:    serverAddress.IP.String(),
+		Port:      uint16(serverAddress.Port),
+		Version:   gosnmp.Version2c,
+		Community: "public",
+		Timeout:   time.Second,
+	}
+	if err := client.Connect(); err != nil {
+		panic(err)
+	}
+	defer client.Conn.Close()
+	walked, err := client.WalkAll("1.3.6.1.4.1.9999.95")
+	if assert.Nil(suite.T(), err) && assert.Equal(suite.T(), 4, len(walked)) {
+		assert.Equal(suite.T(), []interface{}{10, 20, 30, 4},
+			[]interface{}{walked[0].Value, walked[1].Value, walked[2].Value, walked[3].Value})
+	}
+	assert.Equal(suite.T(), CacheStats{Hits: 2, Misses: 1}, snapshot.Stats())
+	assert.Equal(suite.T(), uint32(1), single.Stats().Misses)
+}
+
+func TestCacheTestsSuite(t *testing.T) {
+	suite.Run(t, new(CacheTests))
+}
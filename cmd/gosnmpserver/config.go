@@ -0,0 +1,623 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+	"github.com/slayercat/GoSNMPServer"
+	"github.com/slayercat/GoSNMPServer/mibImps/dismanEventMib"
+	"github.com/slayercat/GoSNMPServer/mibImps/ifMib"
+	"github.com/slayercat/GoSNMPServer/mibImps/snmpNotificationMib"
+	"github.com/slayercat/GoSNMPServer/mibImps/ucdMib"
+	"gopkg.in/yaml.v3"
+)
+
+// config is the configuration file of run-server. See loadConfig
+//
+//	YAML and TOML files are decoded as JSON, so that the json names below are used in all formats.
+type config struct {
+	LogLevel string `json:"logLevel"`
+
+	Listeners []listenerConfig `json:"listeners"`
+
+	// EngineID is the data of the SNMPV3 AuthoritativeEngineID. empty for the default,
+	//          which is generated and kept in EngineStateFile if set
+	EngineID string `json:"engineID"`
+	// EngineIDFormat is one of ipv4, ipv6, mac, text, octets, 128 to 255 for enterprise specific formats,
+	//                and hex for a whole engine ID as "80001f8804...". See GoSNMPServer.EngineIDFormat
+	EngineIDFormat string `json:"engineIDFormat"`
+	// EnterpriseNumber of the engine ID. 0 for GoSNMPServer.DefaultEngineEnterpriseNumber
+	EnterpriseNumber uint32 `json:"enterpriseNumber"`
+	// EngineBoots is a static snmpEngineBoots. Use EngineStateFile instead to increase it on every start
+	EngineBoots uint32 `json:"engineBoots"`
+	// EngineStateFile keeps snmpEngineBoots. See GoSNMPServer.FileEngineStateStore
+	EngineStateFile string `json:"engineStateFile"`
+	V3Only          bool   `json:"v3Only"`
+	// ServeUSMStats serves the usmStats counters of RFC 3414 in all subAgents
+	ServeUSMStats bool `json:"serveUsmStats"`
+
+	Users     []userConfig     `json:"users"`
+	SubAgents []subAgentConfig `json:"subAgents"`
+	// CommunityContexts lets SNMPv3 requests reach subAgents without contexts by their communities, as of old versions
+	CommunityContexts bool `json:"communityContexts"`
+
+	MaxMessageSize int `json:"maxMessageSize"`
+	// Workers serves requests concurrently. See GoSNMPServer.WorkerPoolConfig
+	Workers        int      `json:"workers"`
+	QueueDepth     int      `json:"queueDepth"`
+	RequestTimeout duration `json:"requestTimeout"`
+	// UcdMibCacheTTL is how long ucdMib shares a read of the system among its OIDs, in all subAgents.
+	//      1s if not set, 0 for no cache
+	UcdMibCacheTTL *duration `json:"ucdMibCacheTTL"`
+}
+
+type listenerConfig struct {
+	// Transport is one of udp, udp4, udp6, tcp, tcp4, tcp6 and agentx
+	Transport string `json:"transport"`
+	Address   string `json:"address"`
+	// Network of agentx, unix or tcp. unix by default
+	Network string `json:"network"`
+}
+
+type userConfig struct {
+	Name string `json:"name"`
+	// AuthProtocol is one of none, MD5, SHA, SHA224, SHA256, SHA384 and SHA512
+	AuthProtocol   string `json:"authProtocol"`
+	AuthPassphrase string `json:"authPassphrase"`
+	// PrivProtocol is one of none, DES, AES, AES192, AES256, AES192C and AES256C
+	PrivProtocol   string `json:"privProtocol"`
+	PrivPassphrase string `json:"privPassphrase"`
+	// Networks restricts the user to source networks. See GoSNMPServer.SourceACL
+	Networks []string `json:"networks"`
+	ReadOnly bool     `json:"readOnly"`
+	// Contexts restricts the user to contexts. See GoSNMPServer.UserContexts
+	Contexts []string `json:"contexts"`
+}
+
+type subAgentConfig struct {
+	Communities []string `json:"communities"`
+	// Contexts are the SNMPv3 contexts of the subAgent, which communities never match
+	Contexts []string             `json:"contexts"`
+	ACLs     []communityACLConfig `json:"acls"`
+	MIBs     []mibConfig          `json:"mibs"`
+	OIDs     []oidConfig          `json:"oids"`
+}
+
+type communityACLConfig struct {
+	Community string   `json:"community"`
+	Networks  []string `json:"networks"`
+	ReadOnly  bool     `json:"readOnly"`
+}
+
+// mibConfig enables a module of mibImps
+type mibConfig struct {
+	// Name is one of dismanEventMib, ifMib, ucdMib and snmpNotificationMib.
+	//      Rows of snmpNotificationMib created by managers are kept in memory, and dropped on reload.
+	Name string `json:"name"`
+	// Tables serves ifTable / dskTable as tables following changes at runtime
+	Tables bool `json:"tables"`
+	// NameOverride lists the disks of ucdMib. empty for all
+	NameOverride []ucdMib.NameOverride `json:"nameOverride"`
+}
+
+// oidConfig is an OID of static value
+type oidConfig struct {
+	OID string `json:"oid"`
+	// Type is one of integer, octetString, objectIdentifier, ipAddress, counter32, gauge32, timeTicks and counter64
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+	// Writable keeps the value SET by managers in memory
+	Writable bool `json:"writable"`
+}
+
+// duration is a time.Duration of "2s" or seconds
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = duration(seconds * float64(time.Second))
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return errors.Errorf("duration %s: should be a string as \"2s\" or seconds", data)
+	}
+	val, err := time.ParseDuration(text)
+	if err != nil {
+		return errors.Wrapf(err, "duration %s", data)
+	}
+	*d = duration(val)
+	return nil
+}
+
+// loadConfig reads a YAML / JSON / TOML file by the extension of path
+func loadConfig(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read config")
+	}
+	ret, err := parseConfig(data, strings.ToLower(filepath.Ext(path)))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "config %v", path)
+	}
+	return ret, nil
+}
+
+// parseConfig decodes data of the format by extension. Unknown fields are errors
+func parseConfig(data []byte, ext string) (*config, error) {
+	var raw interface{}
+	switch ext {
+	case ".json":
+		raw = json.RawMessage(data)
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, errors.Wrap(err, "yaml")
+		}
+	case ".toml":
+		if _, err := toml.Decode(string(data), &raw); err != nil {
+			return nil, errors.Wrap(err, "toml")
+		}
+	default:
+		return nil, errors.Errorf("unknown format %q. should be .yaml, .yml, .json or .toml", ext)
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "json")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	ret := &config{}
+	if err := decoder.Decode(ret); err != nil {
+		return nil, errors.Wrap(err, "decode")
+	}
+	return ret, nil
+}
+
+var authProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"":       gosnmp.NoAuth,
+	"none":   gosnmp.NoAuth,
+	"md5":    gosnmp.MD5,
+	"sha":    gosnmp.SHA,
+	"sha224": gosnmp.SHA224,
+	"sha256": gosnmp.SHA256,
+	"sha384": gosnmp.SHA384,
+	"sha512": gosnmp.SHA512,
+}
+
+var privProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"":        gosnmp.NoPriv,
+	"none":    gosnmp.NoPriv,
+	"des":     gosnmp.DES,
+	"aes":     gosnmp.AES,
+	"aes192":  gosnmp.AES192,
+	"aes256":  gosnmp.AES256,
+	"aes192c": gosnmp.AES192C,
+	"aes256c": gosnmp.AES256C,
+}
+
+var engineIDFormats = map[string]GoSNMPServer.EngineIDFormat{
+	"":       0,
+	"ipv4":   GoSNMPServer.EngineIDFormatIPv4,
+	"ipv6":   GoSNMPServer.EngineIDFormatIPv6,
+	"mac":    GoSNMPServer.EngineIDFormatMAC,
+	"text":   GoSNMPServer.EngineIDFormatText,
+	"octets": GoSNMPServer.EngineIDFormatOctets,
+}
+
+// engineID returns the AuthoritativeEngineID of EngineID and EngineIDFormat
+func (c *config) engineID() (GoSNMPServer.SNMPEngineID, error) {
+	name := strings.ToLower(c.EngineIDFormat)
+	if name == "hex" {
+		if c.EnterpriseNumber != 0 {
+			return GoSNMPServer.SNMPEngineID{}, errors.New("enterpriseNumber: should not be set with a hex engineID")
+		}
+		return GoSNMPServer.ParseEngineID(c.EngineID)
+	}
+	format, ok := engineIDFormats[name]
+	if !ok {
+		number, err := strconv.ParseUint(name, 10, 8)
+		if err != nil || number < 128 {
+			return GoSNMPServer.SNMPEngineID{}, errors.Errorf("unknown engineIDFormat %v", c.EngineIDFormat)
+		}
+		format = GoSNMPServer.EngineIDFormat(number)
+	}
+	ret := GoSNMPServer.SNMPEngineID{
+		EngineIDData:     c.EngineID,
+		Format:           format,
+		EnterpriseNumber: c.EnterpriseNumber,
+	}
+	if ret.Marshal() == nil {
+		return ret, errors.Errorf("engineID %v: not valid of engineIDFormat %v", c.EngineID, c.EngineIDFormat)
+	}
+	return ret, nil
+}
+
+// newMasterAgent makes the MasterAgent of the config. It is not synced yet
+func (c *config) newMasterAgent(logger GoSNMPServer.ILogger) (*GoSNMPServer.MasterAgent, error) {
+	master := &GoSNMPServer.MasterAgent{
+		Logger:         logger,
+		MaxMessageSize: c.MaxMessageSize,
+		SecurityConfig: GoSNMPServer.SecurityConfig{
+			AuthoritativeEngineBoots: c.EngineBoots,
+			SnmpV3Only:               c.V3Only,
+			ServeUSMStats:            c.ServeUSMStats,
+			CommunityContexts:        c.CommunityContexts,
+		},
+	}
+	if c.EngineID != "" {
+		engineID, err := c.engineID()
+		if err != nil {
+			return nil, err
+		}
+		master.SecurityConfig.AuthoritativeEngineID = engineID
+	} else if c.EngineIDFormat != "" || c.EnterpriseNumber != 0 {
+		return nil, errors.New("engineIDFormat / enterpriseNumber: should be set with engineID")
+	}
+	if c.EngineStateFile != "" {
+		if c.EngineBoots != 0 {
+			return nil, errors.New("engineBoots: should not be set with engineStateFile")
+		}
+		master.SecurityConfig.EngineStateStore = GoSNMPServer.NewFileEngineStateStore(c.EngineStateFile)
+	}
+	for _, each := range c.Users {
+		if each.Name == "" {
+			return nil, errors.New("user: name is required")
+		}
+		auth, ok := authProtocols[strings.ToLower(each.AuthProtocol)]
+		if !ok {
+			return nil, errors.Errorf("user %v: unknown authProtocol %v", each.Name, each.AuthProtocol)
+		}
+		priv, ok := privProtocols[strings.ToLower(each.PrivProtocol)]
+		if !ok {
+			return nil, errors.Errorf("user %v: unknown privProtocol %v", each.Name, each.PrivProtocol)
+		}
+		if auth == gosnmp.NoAuth && priv != gosnmp.NoPriv {
+			return nil, errors.Errorf("user %v: privProtocol requires authProtocol", each.Name)
+		}
+		master.SecurityConfig.Users = append(master.SecurityConfig.Users, gosnmp.UsmSecurityParameters{
+			UserName:                 each.Name,
+			AuthenticationProtocol:   auth,
+			AuthenticationPassphrase: each.AuthPassphrase,
+			PrivacyProtocol:          priv,
+			PrivacyPassphrase:        each.PrivPassphrase,
+		})
+		if each.Networks != nil {
+			master.SecurityConfig.UserACLs = append(master.SecurityConfig.UserACLs, &GoSNMPServer.SourceACL{
+				Name:     each.Name,
+				Networks: each.Networks,
+				ReadOnly: each.ReadOnly,
+			})
+		}
+		if each.Contexts != nil {
+			master.SecurityConfig.UserContexts = append(master.SecurityConfig.UserContexts, &GoSNMPServer.UserContexts{
+				UserName:     each.Name,
+				ContextNames: each.Contexts,
+			})
+		}
+	}
+	if len(c.SubAgents) == 0 {
+		return nil, errors.New("subAgents: at least one is required")
+	}
+	for id, each := range c.SubAgents {
+		subAgent, err := each.newSubAgent(master)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "subAgents[%d]", id)
+		}
+		master.SubAgents = append(master.SubAgents, subAgent)
+	}
+	return master, nil
+}
+
+func (c *subAgentConfig) newSubAgent(master *GoSNMPServer.MasterAgent) (*GoSNMPServer.SubAgent, error) {
+	subAgent := &GoSNMPServer.SubAgent{
+		CommunityIDs: c.Communities,
+		ContextNames: c.Contexts,
+	}
+	for _, each := range c.ACLs {
+		subAgent.CommunityACLs = append(subAgent.CommunityACLs, &GoSNMPServer.SourceACL{
+			Name:     each.Community,
+			Networks: each.Networks,
+			ReadOnly: each.ReadOnly,
+		})
+	}
+	for _, each := range c.MIBs {
+		if err := each.attach(subAgent, master); err != nil {
+			return nil, err
+		}
+	}
+	for _, each := range c.OIDs {
+		item, err := each.newItem()
+		if err != nil {
+			return nil, err
+		}
+		subAgent.OIDs = append(subAgent.OIDs, item)
+	}
+	return subAgent, nil
+}
+
+// attach adds the OIDs / Tables of the mib into subAgent
+func (c *mibConfig) attach(subAgent *GoSNMPServer.SubAgent, master *GoSNMPServer.MasterAgent) error {
+	if c.NameOverride != nil && c.Name != "ucdMib" {
+		return errors.Errorf("mib %v: nameOverride is for ucdMib only", c.Name)
+	}
+	switch c.Name {
+	case "dismanEventMib":
+		subAgent.OIDs = append(subAgent.OIDs, dismanEventMib.All()...)
+	case "ifMib":
+		if c.Tables {
+			subAgent.Tables = append(subAgent.Tables, ifMib.NetworkTable())
+		} else {
+			subAgent.OIDs = append(subAgent.OIDs, ifMib.All()...)
+		}
+	case "ucdMib":
+		subAgent.OIDs = append(subAgent.OIDs, ucdMib.MemoryOIDs()...)
+		subAgent.OIDs = append(subAgent.OIDs, ucdMib.SystemStatsOIDs()...)
+		subAgent.OIDs = append(subAgent.OIDs, ucdMib.SystemLoadOIDs()...)
+		if c.Tables {
+			subAgent.Tables = append(subAgent.Tables, ucdMib.DiskUsageTable(c.NameOverride...))
+		} else {
+			subAgent.OIDs = append(subAgent.OIDs, ucdMib.DiskUsageOIDs(c.NameOverride...)...)
+		}
+	case "snmpNotificationMib":
+		return snmpNotificationMib.New().Attach(subAgent, &master.NotificationOriginator)
+	default:
+		return errors.Errorf("unknown mib %q", c.Name)
+	}
+	return nil
+}
+
+// newItem makes the item of static value
+func (c *oidConfig) newItem() (*GoSNMPServer.PDUValueControlItem, error) {
+	if err := GoSNMPServer.VerifyOid(c.OID); err != nil {
+		return nil, errors.WithMessagef(err, "oid %v", c.OID)
+	}
+	asnType, value, err := parseOIDValue(c.Type, c.Value)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "oid %v", c.OID)
+	}
+	var mu sync.Mutex
+	item := &GoSNMPServer.PDUValueControlItem{
+		OID:  c.OID,
+		Type: asnType,
+		OnGet: func() (interface{}, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return value, nil
+		},
+		Document: "static value of config",
+	}
+	if c.Writable {
+		item.OnSet = func(val interface{}) error {
+			mu.Lock()
+			defer mu.Unlock()
+			value = val
+			return nil
+		}
+	}
+	return item, nil
+}
+
+// parseOIDValue returns the type and the value in forms of PDUValueControlItem.OnGet
+func parseOIDValue(name string, raw json.RawMessage) (gosnmp.Asn1BER, interface{}, error) {
+	if raw == nil {
+		return 0, nil, errors.New("value is required")
+	}
+	var text string
+	var number uint64
+	var signed int64
+	switch strings.ToLower(name) {
+	case "integer":
+		if err := json.Unmarshal(raw, &signed); err != nil {
+			return 0, nil, errors.Errorf("integer value %s", raw)
+		}
+		return gosnmp.Integer, GoSNMPServer.Asn1IntegerWrap(int(signed)), nil
+	case "octetstring":
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return 0, nil, errors.Errorf("octetString value %s", raw)
+		}
+		return gosnmp.OctetString, GoSNMPServer.Asn1OctetStringWrap(text), nil
+	case "objectidentifier":
+		if err := json.Unmarshal(raw, &text); err != nil || GoSNMPServer.VerifyOid(text) != nil {
+			return 0, nil, errors.Errorf("objectIdentifier value %s", raw)
+		}
+		return gosnmp.ObjectIdentifier, GoSNMPServer.Asn1ObjectIdentifierWrap(text), nil
+	case "ipaddress":
+		if err := json.Unmarshal(raw, &text); err != nil || net.ParseIP(text).To4() == nil {
+			return 0, nil, errors.Errorf("ipAddress value %s", raw)
+		}
+		return gosnmp.IPAddress, GoSNMPServer.Asn1IPAddressWrap(net.ParseIP(text)), nil
+	}
+	if err := json.Unmarshal(raw, &number); err != nil {
+		return 0, nil, errors.Errorf("%v value %s", name, raw)
+	}
+	switch strings.ToLower(name) {
+	case "counter32":
+		if number > 0xffffffff {
+			return 0, nil, errors.Errorf("counter32 value %s", raw)
+		}
+		return gosnmp.Counter32, GoSNMPServer.Asn1Counter32Wrap(uint(number)), nil
+	case "gauge32":
+		if number > 0xffffffff {
+			return 0, nil, errors.Errorf("gauge32 value %s", raw)
+		}
+		return gosnmp.Gauge32, GoSNMPServer.Asn1Gauge32Wrap(uint(number)), nil
+	case "timeticks":
+		if number > 0xffffffff {
+			return 0, nil, errors.Errorf("timeTicks value %s", raw)
+		}
+		return gosnmp.TimeTicks, GoSNMPServer.Asn1TimeTicksWrap(uint32(number)), nil
+	case "counter64":
+		return gosnmp.Counter64, GoSNMPServer.Asn1Counter64Wrap(number), nil
+	}
+	return 0, nil, errors.Errorf("unknown type %q", name)
+}
+
+// checkListeners checks the listeners without listening
+func (c *config) checkListeners() error {
+	if len(c.Listeners) == 0 {
+		return errors.New("listeners: at least one is required")
+	}
+	for _, each := range c.Listeners {
+		var err error
+		switch each.Transport {
+		case "udp", "udp4", "udp6":
+			_, err = net.ResolveUDPAddr(each.Transport, each.Address)
+		case "tcp", "tcp4", "tcp6":
+			_, err = net.ResolveTCPAddr(each.Transport, each.Address)
+		case "agentx":
+			switch each.Network {
+			case "", "unix":
+				if each.Address == "" {
+					err = errors.New("address is required")
+				}
+			case "tcp":
+				_, err = net.ResolveTCPAddr(each.Network, each.Address)
+			default:
+				err = errors.Errorf("unknown network %q", each.Network)
+			}
+		default:
+			err = errors.Errorf("unknown transport %q", each.Transport)
+		}
+		if err != nil {
+			return errors.WithMessagef(err, "listener %v %v", each.Transport, each.Address)
+		}
+	}
+	return nil
+}
+
+// listen adds the listeners into server
+func (c *config) listen(server *GoSNMPServer.SNMPServer) error {
+	for _, each := range c.Listeners {
+		var err error
+		switch each.Transport {
+		case "udp", "udp4", "udp6":
+			err = server.ListenUDP(each.Transport, each.Address)
+		case "tcp", "tcp4", "tcp6":
+			err = server.ListenTCP(each.Transport, each.Address)
+		case "agentx":
+			network := each.Network
+			if network == "" {
+				network = "unix"
+			}
+			err = server.ListenAgentX(network, each.Address)
+		default:
+			err = errors.Errorf("unknown transport %q", each.Transport)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newServer makes the server of the config with the listeners
+func (c *config) newServer(logger GoSNMPServer.ILogger) (*GoSNMPServer.SNMPServer, error) {
+	master, err := c.newMasterAgent(logger)
+	if err != nil {
+		return nil, err
+	}
+	if err := master.ReadyForWork(); err != nil {
+		return nil, err
+	}
+	c.setUcdMibCacheTTL()
+	server := GoSNMPServer.NewSNMPServer(*master)
+	server.SetWorkerPool(GoSNMPServer.WorkerPoolConfig{
+		Workers:        c.Workers,
+		QueueDepth:     c.QueueDepth,
+		RequestTimeout: time.Duration(c.RequestTimeout),
+	})
+	if err := c.listen(server); err != nil {
+		server.Shutdown()
+		return nil, err
+	}
+	return server, nil
+}
+
+// validate checks the config as newServer without listening. engineStateFile is read but not written
+func (c *config) validate(logger GoSNMPServer.ILogger) error {
+	master, err := c.newMasterAgent(logger)
+	if err != nil {
+		return err
+	}
+	if store := master.SecurityConfig.EngineStateStore; store != nil {
+		if _, err := store.LoadEngineID(); err != nil {
+			return err
+		}
+		master.SecurityConfig.EngineStateStore = nil
+	}
+	if err := master.ReadyForWork(); err != nil {
+		return err
+	}
+	return c.checkListeners()
+}
+
+// reloadConfig applies the users, subAgents and mibs of the config file to server.
+//
+//	Listeners and workers are not changed. The running config is kept on errors.
+//	mibs are made again, so that rows of snmpNotificationMib created by managers are dropped.
+func reloadConfig(path string, server *GoSNMPServer.SNMPServer, logger GoSNMPServer.ILogger) error {
+	conf, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	master, err := conf.newMasterAgent(logger)
+	if err != nil {
+		return err
+	}
+	if err := server.Reload(*master); err != nil {
+		return err
+	}
+	conf.setUcdMibCacheTTL()
+	if conf.hasMIB("snmpNotificationMib") {
+		logger.Warnf("reload %v: rows of snmpNotificationMib created by managers are dropped", path)
+	}
+	return nil
+}
+
+// setUcdMibCacheTTL applies UcdMibCacheTTL to ucdMib, which shares one cache among all subAgents
+func (c *config) setUcdMibCacheTTL() {
+	ttl := ucdMib.DefaultCacheTTL
+	if c.UcdMibCacheTTL != nil {
+		ttl = time.Duration(*c.UcdMibCacheTTL)
+	}
+	ucdMib.SetCacheTTL(ttl)
+}
+
+// hasMIB returns if any subAgent enables the mib
+func (c *config) hasMIB(name string) bool {
+	for _, subAgent := range c.SubAgents {
+		for _, each := range subAgent.MIBs {
+			if each.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// reloadOnSignal calls reloadConfig on every SIGHUP
+func reloadOnSignal(path string, server *GoSNMPServer.SNMPServer, logger GoSNMPServer.ILogger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if err := reloadConfig(path, server, logger); err != nil {
+			logger.Errorf("reload %v: %v. keep serving the running config", path, err)
+			continue
+		}
+		logger.Infof("reload %v: done", path)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/slayercat/GoSNMPServer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type ConfigTests struct {
+	suite.Suite
+	Logger GoSNMPServer.ILogger
+}
+
+func (suite *ConfigTests) SetupTest() {
+	suite.Logger = GoSNMPServer.NewDiscardLogger()
+}
+
+func (suite *ConfigTests) TestExample() {
+	conf, err := loadConfig("gosnmpserver.example.yaml")
+	if !assert.Nil(suite.T(), err) {
+		return
+	}
+	assert.Equal(suite.T(), 2, len(conf.Listeners))
+	assert.Equal(suite.T(), 5*time.Second, time.Duration(conf.RequestTimeout))
+	if assert.NotNil(suite.T(), conf.UcdMibCacheTTL) {
+		assert.Equal(suite.T(), time.Second, time.Duration(*conf.UcdMibCacheTTL))
+	}
+	assert.Nil(suite.T(), conf.validate(suite.Logger))
+
+	master, err := conf.newMasterAgent(suite.Logger)
+	if !assert.Nil(suite.T(), err) {
+		return
+	}
+	assert.Equal(suite.T(), "gosnmpserver", master.SecurityConfig.AuthoritativeEngineID.EngineIDData)
+	if assert.Equal(suite.T(), 2, len(master.SecurityConfig.Users)) {
+		assert.Equal(suite.T(), gosnmp.SHA256, master.SecurityConfig.Users[0].AuthenticationProtocol)
+		assert.Equal(suite.T(), gosnmp.AES, master.SecurityConfig.Users[0].PrivacyProtocol)
+		assert.Equal(suite.T(), gosnmp.NoPriv, master.SecurityConfig.Users[1].PrivacyProtocol)
+	}
+	if assert.Equal(suite.T(), 1, len(master.SecurityConfig.UserACLs)) {
+		assert.Equal(suite.T(), "monitor", master.SecurityConfig.UserACLs[0].Name)
+	}
+}
+
+func (suite *ConfigTests) TestFormats() {
+	formats := map[string]string{
+		".json": `{"listeners": [{"transport": "udp", "address": "127.0.0.1:0"}],
+			"subAgents": [{"communities": ["public"], "oids": [{"oid": "1.3.6.1.4.1.9999.1.1", "type": "integer", "value": -1}]}],
+			"requestTimeout": 1.5}`,
+		".yaml": `
+listeners: [{transport: udp, address: "127.0.0.1:0"}]
+subAgents:
+  - communities: [public]
+    oids: [{oid: 1.3.6.1.4.1.9999.1.1, type: integer, value: -1}]
+requestTimeout: 1.5
+`,
+		".toml": `
+requestTimeout = 1.5
+[[listeners]]
+transport = "udp"
+address = "127.0.0.1:0"
+[[subAgents]]
+communities = ["public"]
+[[subAgents.oids]]
+oid = "1.3.6.1.4.1.9999.1.1"
+type = "integer"
+value = -1
+`,
+	}
+	for ext, data := range formats {
+		conf, err := parseConfig([]byte(data), ext)
+		if !assert.Nil(suite.T(), err, ext) {
+			continue
+		}
+		assert.Equal(suite.T(), 1500*time.Millisecond, time.Duration(conf.RequestTimeout), ext)
+		assert.Nil(suite.T(), conf.validate(suite.Logger), ext)
+	}
+	_, err := parseConfig([]byte(`{}`), ".ini")
+	assert.NotNil(suite.T(), err)
+	_, err = parseConfig([]byte(`{"listener": []}`), ".json")
+	assert.NotNil(suite.T(), err, "unknown field")
+	_, err = parseConfig([]byte(`{"subAgents": [{"mibs": [{"name": "ucdMib", "cacheTTL": "1s"}]}]}`), ".json")
+	assert.NotNil(suite.T(), err, "cacheTTL is global as ucdMibCacheTTL")
+}
+
+func (suite *ConfigTests) TestInvalid() {
+	invalid := []string{
+		`{"listeners": [{"transport": "udp", "address": "127.0.0.1:0"}]}`,
+		`{"subAgents": [{"communities": ["public"]}]}`,
+		`{"listeners": [{"transport": "sctp", "address": "127.0.0.1:0"}], "subAgents": [{}]}`,
+		`{"listeners": [{"transport": "udp"}], "subAgents": [{}], "users": [{"name": "u", "authProtocol": "SHA1024"}]}`,
+		`{"listeners": [{"transport": "udp"}], "subAgents": [{}], "users": [{"name": "u", "privProtocol": "AES"}]}`,
+		`{"listeners": [{"transport": "udp"}], "subAgents": [{"communities": ["public"], "acls": [{"community": "private", "networks": ["10.0.0.0/8"]}]}]}`,
+		`{"listeners": [{"transport": "udp"}], "subAgents": [{"mibs": [{"name": "hostMib"}]}]}`,
+		`{"listeners": [{"transport": "udp"}], "subAgents": [{"mibs": [{"name": "ifMib", "nameOverride": []}]}]}`,
+		`{"listeners": [{"transport": "udp"}], "subAgents": [{"oids": [{"oid": "1.3.6.1.4.1.9999.1.1", "type": "gauge32", "value": -1}]}]}`,
+		`{"listeners": [{"transport": "udp"}], "subAgents": [{"oids": [{"oid": "1.3.6.1.4.1.9999.1.1", "type": "ipAddress", "value": "::1"}]}]}`,
+		`{"listeners": [{"transport": "udp"}], "subAgents": [{"oids": [{"oid": "not an oid", "type": "integer", "value": 1}]}]}`,
+		`{"listeners": [{"transport": "udp"}], "subAgents": [{}], "engineID": "192.0.2.1", "engineIDFormat": "ipv6"}`,
+		`{"listeners": [{"transport": "udp"}], "subAgents": [{}], "engineID": "abc", "engineIDFormat": "6"}`,
+		`{"listeners": [{"transport": "udp"}], "subAgents": [{}], "engineID": "80001f88", "engineIDFormat": "hex"}`,
+		`{"listeners": [{"transport": "udp"}], "subAgents": [{}], "engineIDFormat": "text"}`,
+	}
+	for _, data := range invalid {
+		conf, err := parseConfig([]byte(data), ".json")
+		if !assert.Nil(suite.T(), err, data) {
+			continue
+		}
+		assert.NotNil(suite.T(), conf.validate(suite.Logger), data)
+	}
+}
+
+func (suite *ConfigTests) TestEngineID() {
+	formats := map[string]string{
+		`"engineID": "192.0.2.1", "engineIDFormat": "ipv4", "enterpriseNumber": 8072`: "80001f8801c0000201",
+		`"engineID": "0102ab", "engineIDFormat": "200"`:                               "80004fb8c80102ab",
+		`"engineID": "0x80001f8804616263", "engineIDFormat": "hex"`:                   "80001f8804616263",
+	}
+	for data, expected := range formats {
+		conf, err := parseConfig([]byte(`{`+data+`}`), ".json")
+		if !assert.Nil(suite.T(), err, data) {
+			continue
+		}
+		engineID, err := conf.engineID()
+		if assert.Nil(suite.T(), err, data) {
+			assert.Equal(suite.T(), expected, hex.EncodeToString(engineID.Marshal()), data)
+		}
+	}
+}
+
+func (suite *ConfigTests) TestStaticOID() {
+	conf := oidConfig{OID: "1.3.6.1.4.1.9999.1.1", Type: "octetString", Value: []byte(`"init"`)}
+	item, err := conf.newItem()
+	if !assert.Nil(suite.T(), err) {
+		return
+	}
+	assert.Nil(suite.T(), item.OnSet, "read only")
+
+	conf.Writable = true
+	item, err = conf.newItem()
+	if !assert.Nil(suite.T(), err) {
+		return
+	}
+	assert.Equal(suite.T(), gosnmp.OctetString, item.Type)
+	value, _ := item.OnGet()
+	assert.Equal(suite.T(), "init", value.(string))
+	assert.Nil(suite.T(), item.OnSet(GoSNMPServer.Asn1OctetStringWrap("set")))
+	value, _ = item.OnGet()
+	assert.Equal(suite.T(), "set", value.(string))
+}
+
+func (suite *ConfigTests) TestReload() {
+	path := filepath.Join(suite.T().TempDir(), "config.json")
+	write := func(community string) {
+		data := `{"listeners": [{"transport": "udp", "address": "127.0.0.1:0"}],
+			"subAgents": [{"communities": ["` + community + `"]}]}`
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			panic(err)
+		}
+	}
+	write("public")
+	conf, err := loadConfig(path)
+	if !assert.Nil(suite.T(), err) {
+		return
+	}
+	server, err := conf.newServer(suite.Logger)
+	if !assert.Nil(suite.T(), err) {
+		return
+	}
+	defer server.Shutdown()
+
+	write("private")
+	assert.Nil(suite.T(), reloadConfig(path, server, suite.Logger))
+	write(`public", "public`)
+	assert.NotNil(suite.T(), reloadConfig(path, server, suite.Logger))
+}
+
+func TestConfigTestsSuite(t *testing.T) {
+	suite.Run(t, new(ConfigTests))
+}
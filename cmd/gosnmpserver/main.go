@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"strings"
 
@@ -27,9 +28,32 @@ func makeApp() *cli.App {
 					&cli.StringFlag{Name: "v3AuthenticationPassphrase", Value: "testauth"},
 					&cli.StringFlag{Name: "v3PrivacyPassphrase", Value: "testpriv"},
 					&cli.BoolFlag{Name: "v3Only", Value: false},
+					&cli.StringFlag{Name: "engineStateFile", Usage: "keeps snmpEngineBoots, which increases on every start. empty for 1"},
+					&cli.StringFlag{Name: "config", Usage: "YAML / JSON / TOML config file, reloaded on SIGHUP. other flags but logLevel are ignored"},
 				},
 				Action: runServer,
 			},
+			{
+				Name:      "ValidateConfig",
+				Aliases:   []string{"validate-config"},
+				Usage:     "checks a config file of run-server without listening",
+				ArgsUsage: "<config file>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "logLevel", Value: "error"},
+				},
+				Action: validateConfig,
+			},
+			{
+				Name:    "RunSubAgent",
+				Aliases: []string{"run-subagent"},
+				Usage:   "serves the mibs through an AgentX master agent, eg. snmpd",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "logLevel", Value: "info"},
+					&cli.StringFlag{Name: "network", Value: "unix"},
+					&cli.StringFlag{Name: "address", Value: "/var/agentx/master"},
+				},
+				Action: runSubAgent,
+			},
 		},
 	}
 }
@@ -39,7 +63,7 @@ func main() {
 	app.Run(os.Args)
 }
 
-func runServer(c *cli.Context) error {
+func newLogger(c *cli.Context) GoSNMPServer.ILogger {
 	logger := GoSNMPServer.NewDefaultLogger()
 	switch strings.ToLower(c.String("logLevel")) {
 	case "fatal":
@@ -53,13 +77,71 @@ func runServer(c *cli.Context) error {
 	case "trace":
 		logger.(*GoSNMPServer.DefaultLogger).Level = logrus.TraceLevel
 	}
+	return logger
+}
+
+func runSubAgent(c *cli.Context) error {
+	logger := newLogger(c)
+	mibImps.SetupLogger(logger)
+	agent := &GoSNMPServer.AgentXSubAgent{
+		Network: c.String("network"),
+		Address: c.String("address"),
+		Descr:   "gosnmpserver",
+		Logger:  logger,
+		SubAgent: &GoSNMPServer.SubAgent{
+			OIDs: mibImps.All(),
+		},
+	}
+	return agent.ServeForever()
+}
+
+func validateConfig(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.Exit("usage: gosnmpserver validate-config <config file>", 2)
+	}
+	logger := newLogger(c)
+	mibImps.SetupLogger(logger)
+	conf, err := loadConfig(c.Args().First())
+	if err == nil {
+		err = conf.validate(logger)
+	}
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("%v: %v", c.Args().First(), err), 1)
+	}
+	fmt.Printf("%v: OK\n", c.Args().First())
+	return nil
+}
+
+func runServerOfConfig(c *cli.Context) error {
+	conf, err := loadConfig(c.String("config"))
+	if err != nil {
+		return err
+	}
+	if conf.LogLevel != "" && !c.IsSet("logLevel") {
+		c.Set("logLevel", conf.LogLevel)
+	}
+	logger := newLogger(c)
+	mibImps.SetupLogger(logger)
+	server, err := conf.newServer(logger)
+	if err != nil {
+		return err
+	}
+	go reloadOnSignal(c.String("config"), server, logger)
+	return server.ServeForever()
+}
+
+func runServer(c *cli.Context) error {
+	if c.IsSet("config") {
+		return runServerOfConfig(c)
+	}
+	logger := newLogger(c)
 	mibImps.SetupLogger(logger)
 
 	master := GoSNMPServer.MasterAgent{
 		Logger: logger,
 		SecurityConfig: GoSNMPServer.SecurityConfig{
 			AuthoritativeEngineBoots: 1,
-			SnmpV3Only:                   c.Bool("v3Only"),
+			SnmpV3Only:               c.Bool("v3Only"),
 			Users: []gosnmp.UsmSecurityParameters{
 				{
 					UserName:                 c.String("v3Username"),
@@ -73,15 +155,19 @@ func runServer(c *cli.Context) error {
 		SubAgents: []*GoSNMPServer.SubAgent{
 			{
 				CommunityIDs: []string{c.String("community")},
+				ContextNames: []string{c.String("community")},
 				OIDs:         mibImps.All(),
 			},
 		},
 	}
-
-
+	if c.String("engineStateFile") != "" {
+		master.SecurityConfig.AuthoritativeEngineBoots = 0
+		master.SecurityConfig.EngineStateStore = GoSNMPServer.NewFileEngineStateStore(c.String("engineStateFile"))
+	}
 
 	logger.Infof("V3 Users:")
-	for _, val := range master.SecurityConfig.Users {
+	for i := range master.SecurityConfig.Users {
+		val := &master.SecurityConfig.Users[i]
 		logger.Infof(
 			"\tUserName:%v\n\t -- AuthenticationProtocol:%v\n\t -- PrivacyProtocol:%v\n\t -- AuthenticationPassphrase:%v\n\t -- PrivacyPassphrase:%v",
 			val.UserName,
@@ -91,6 +177,9 @@ func runServer(c *cli.Context) error {
 			val.PrivacyPassphrase,
 		)
 	}
+	if err := master.ReadyForWork(); err != nil {
+		return err
+	}
 	server := GoSNMPServer.NewSNMPServer(master)
 	err := server.ListenUDP("udp", c.String("bindTo"))
 	if err != nil {
@@ -0,0 +1,172 @@
+package GoSNMPServer
+
+import (
+	"net"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+)
+
+// ErrInconsistentValue marks errors of OnTestSet for values which are valid alone but not with the
+// current state of the agent, eg createAndGo of an existing row. They are reported as inconsistentValue
+// instead of wrongValue.
+var ErrInconsistentValue = errors.New("ErrInconsistentValue")
+
+// ValueRange is a closed range of values or sizes, as (1..65535) or SIZE (0..255) in SMIv2
+type ValueRange struct {
+	Min int64
+	Max int64
+}
+
+// SetConstraints restricts values of SET, as the SYNTAX clause of an OBJECT-TYPE.
+//
+//	Values are checked before OnTestSet: wrongLength for Sizes, wrongValue for the others.
+type SetConstraints struct {
+	// Sizes of OctetString / Opaque / BitString values. empty for any size
+	Sizes []ValueRange
+	// Ranges of Integer / Gauge32 / TimeTicks / Uinteger32 / Counter64 values. empty for any value
+	Ranges []ValueRange
+	// Enums lists the valid values of Integer, as enumerations. empty for any value
+	Enums []int
+	// DisplayString limits OctetString values to NVT ASCII of at most 255 characters. See RFC 2579
+	DisplayString bool
+}
+
+// checkSetValue validates the type and the value of a SET varbind before OnTestSet.
+//
+//	Values are checked by their Go types as well, so that Asn1*Unwrap of OnSet never panics.
+//	Items with no Type are not checked.
+func (t *PDUValueControlItem) checkSetValue(varItem gosnmp.SnmpPDU) (gosnmp.SNMPError, error) {
+	if t.Type == gosnmp.UnknownType {
+		return gosnmp.NoError, nil
+	}
+	if varItem.Type != t.Type {
+		return gosnmp.WrongType, errors.Errorf("%v: %v for %v", t.OID, varItem.Type, t.Type)
+	}
+	switch t.Type {
+	case gosnmp.Integer:
+		val, ok := varItem.Value.(int)
+		if !ok {
+			break
+		}
+		if !t.Constraints.inEnums(val) || !t.Constraints.inRanges(int64(val)) {
+			return gosnmp.WrongValue, errors.Errorf("%v: %v out of range", t.OID, val)
+		}
+		return gosnmp.NoError, nil
+	case gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Uinteger32, gosnmp.Counter64:
+		val, ok := getUnsignedSetValue(t.Type, varItem.Value)
+		if !ok {
+			break
+		}
+		if val > uint64(1)<<63-1 || !t.Constraints.inRanges(int64(val)) {
+			return gosnmp.WrongValue, errors.Errorf("%v: %v out of range", t.OID, val)
+		}
+		return gosnmp.NoError, nil
+	case gosnmp.OctetString, gosnmp.Opaque, gosnmp.BitString:
+		var val []byte
+		switch each := varItem.Value.(type) {
+		case []byte:
+			val = each
+		case string:
+			val = []byte(each)
+		default:
+			if t.Type != gosnmp.Opaque {
+				return gosnmp.WrongType, errors.Errorf("%v: %T for %v", t.OID, varItem.Value, t.Type)
+			}
+			// floats of Opaque
+			return gosnmp.NoError, nil
+		}
+		if !t.Constraints.inSizes(len(val)) {
+			return gosnmp.WrongLength, errors.Errorf("%v: length %v out of range", t.OID, len(val))
+		}
+		if t.Constraints != nil && t.Constraints.DisplayString {
+			if len(val) > 255 {
+				return gosnmp.WrongLength, errors.Errorf("%v: DisplayString of length %v", t.OID, len(val))
+			}
+			if !isDisplayString(val) {
+				return gosnmp.WrongValue, errors.Errorf("%v: not a DisplayString %q", t.OID, val)
+			}
+		}
+		return gosnmp.NoError, nil
+	case gosnmp.ObjectIdentifier:
+		if val, ok := varItem.Value.(string); ok {
+			if _, err := parseOID(val); err != nil {
+				return gosnmp.WrongValue, errors.WithMessagef(err, "%v", t.OID)
+			}
+			return gosnmp.NoError, nil
+		}
+	case gosnmp.IPAddress:
+		if val, ok := varItem.Value.(string); ok {
+			if ip := net.ParseIP(val); ip == nil || ip.To4() == nil {
+				return gosnmp.WrongValue, errors.Errorf("%v: not valid ip %v", t.OID, val)
+			}
+			return gosnmp.NoError, nil
+		}
+	default:
+		return gosnmp.NoError, nil
+	}
+	return gosnmp.WrongType, errors.Errorf("%v: %T for %v", t.OID, varItem.Value, t.Type)
+}
+
+// getUnsignedSetValue returns the value of unsigned types, which are decoded as the Go types of Asn1*Unwrap
+func getUnsignedSetValue(asn1Type gosnmp.Asn1BER, value interface{}) (uint64, bool) {
+	switch asn1Type {
+	case gosnmp.Counter32, gosnmp.Gauge32:
+		val, ok := value.(uint)
+		return uint64(val), ok
+	case gosnmp.TimeTicks, gosnmp.Uinteger32:
+		val, ok := value.(uint32)
+		return uint64(val), ok
+	}
+	val, ok := value.(uint64)
+	return val, ok
+}
+
+func (t *SetConstraints) inRanges(val int64) bool {
+	if t == nil || len(t.Ranges) == 0 {
+		return true
+	}
+	for _, each := range t.Ranges {
+		if val >= each.Min && val <= each.Max {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *SetConstraints) inSizes(size int) bool {
+	if t == nil || len(t.Sizes) == 0 {
+		return true
+	}
+	for _, each := range t.Sizes {
+		if int64(size) >= each.Min && int64(size) <= each.Max {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *SetConstraints) inEnums(val int) bool {
+	if t == nil || len(t.Enums) == 0 {
+		return true
+	}
+	for _, each := range t.Enums {
+		if val == each {
+			return true
+		}
+	}
+	return false
+}
+
+// isDisplayString returns if val is NVT ASCII, where CR is followed by LF or NUL only
+func isDisplayString(val []byte) bool {
+	for i, each := range val {
+		if each > 127 {
+			return false
+		}
+		if each == '\r' && (i+1 == len(val) || (val[i+1] != '\n' && val[i+1] != 0)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+package GoSNMPServer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type ConstraintsTests struct {
+	suite.Suite
+
+	handle *MasterAgent
+	values map[string]interface{}
+}
+
+func (suite *ConstraintsTests) SetupTest() {
+	suite.values = map[string]interface{}{}
+	item := func(oid string, asn1Type gosnmp.Asn1BER, constraints *SetConstraints) *PDUValueControlItem {
+		return &PDUValueControlItem{
+			OID:         oid,
+			Type:        asn1Type,
+			Constraints: constraints,
+			OnSet: func(value interface{}) error {
+				suite.values[oid] = value
+				return nil
+			},
+		}
+	}
+	inconsistent := item("1.3.6.1.4.1.9999.96.6", gosnmp.Integer, nil)
+	inconsistent.OnTestSet = func(value interface{}) error {
+		return errors.WithMessage(ErrInconsistentValue, "in use")
+	}
+	suite.handle = &MasterAgent{
+		Logger: NewDiscardLogger(),
+		SubAgents: []*SubAgent{
+			{
+				CommunityIDs: []string{"private"},
+				OIDs: []*PDUValueControlItem{
+					item("1.3.6.1.4.1.9999.96.1", gosnmp.Integer, &SetConstraints{Ranges: []ValueRange{{1, 10}, {20, 20}}}),
+					item("1.3.6.1.4.1.9999.96.2", gosnmp.Integer, &SetConstraints{Enums: []int{1, 2, 4}}),
+					item("1.3.6.1.4.1.9999.96.3", gosnmp.OctetString, &SetConstraints{Sizes: []ValueRange{{0, 0}, {2, 4}}}),
+					item("1.3.6.1.4.1.9999.96.4", gosnmp.OctetString, &SetConstraints{DisplayString: true}),
+					item("1.3.6.1.4.1.9999.96.5", gosnmp.Gauge32, &SetConstraints{Ranges: []ValueRange{{0, 100}}}),
+					inconsistent,
+					item("1.3.6.1.4.1.9999.96.7", gosnmp.IPAddress, nil),
+					item("1.3.6.1.4.1.9999.96.8", gosnmp.ObjectIdentifier, nil),
+				},
+			},
+		},
+	}
+	if err := suite.handle.ReadyForWork(); err != nil {
+		panic(err)
+	}
+}
+
+// set serves a SetRequest of a varbind, and returns the error status
+func (suite *ConstraintsTests) set(version gosnmp.SnmpVersion, oid string, asn1Type gosnmp.Asn1BER, value interface{}) gosnmp.SNMPError {
+	request := &gosnmp.SnmpPacket{
+		Version:            version,
+		Community:          "private",
+		PDUType:            gosnmp.SetRequest,
+		SecurityParameters: &gosnmp.UsmSecurityParameters{},
+		Variables:          []gosnmp.SnmpPDU{{Name: oid, Type: asn1Type, Value: value}},
+	}
+	response, err := suite.handle.ResponseForPktContext(context.Background(), request)
+	if !assert.Nil(suite.T(), err) {
+		return gosnmp.GenErr
+	}
+	return response.Error
+}
+
+func (suite *ConstraintsTests) TestTypes() {
+	v2c := gosnmp.Version2c
+	assert.Equal(suite.T(), gosnmp.WrongType, suite.set(v2c, "1.3.6.1.4.1.9999.96.1", gosnmp.OctetString, []byte("1")))
+	assert.Equal(suite.T(), gosnmp.WrongType, suite.set(v2c, "1.3.6.1.4.1.9999.96.1", gosnmp.Integer, "1"))
+	assert.Equal(suite.T(), gosnmp.WrongType, suite.set(v2c, "1.3.6.1.4.1.9999.96.3", gosnmp.OctetString, 1))
+	assert.Equal(suite.T(), gosnmp.WrongType, suite.set(v2c, "1.3.6.1.4.1.9999.96.5", gosnmp.Gauge32, 1))
+	assert.Equal(suite.T(), gosnmp.WrongValue, suite.set(v2c, "1.3.6.1.4.1.9999.96.7", gosnmp.IPAddress, "::1"))
+	assert.Equal(suite.T(), gosnmp.WrongValue, suite.set(v2c, "1.3.6.1.4.1.9999.96.8", gosnmp.ObjectIdentifier, "1..3"))
+	assert.Equal(suite.T(), gosnmp.NoError, suite.set(v2c, "1.3.6.1.4.1.9999.96.7", gosnmp.IPAddress, "192.0.2.1"))
+	assert.Equal(suite.T(), gosnmp.NoError, suite.set(v2c, "1.3.6.1.4.1.9999.96.8", gosnmp.ObjectIdentifier, ".1.3.6"))
+	// SNMPv1 reports badValue. See RFC 3584 section 4.4
+	assert.Equal(suite.T(), gosnmp.BadValue, suite.set(gosnmp.Version1, "1.3.6.1.4.1.9999.96.1", gosnmp.OctetString, []byte("1")))
+	assert.Equal(suite.T(), 2, len(suite.values))
+}
+
+func (suite *ConstraintsTests) TestValues() {
+	v2c := gosnmp.Version2c
+	for _, each := range []struct {
+		oid      string
+		asn1Type gosnmp.Asn1BER
+		value    interface{}
+		status   gosnmp.SNMPError
+	}{
+		{"1.3.6.1.4.1.9999.96.1", gosnmp.Integer, 0, gosnmp.WrongValue},
+		{"1.3.6.1.4.1.9999.96.1", gosnmp.Integer, 10, gosnmp.NoError},
+		{"1.3.6.1.4.1.9999.96.1", gosnmp.Integer, 15, gosnmp.WrongValue},
+		{"1.3.6.1.4.1.9999.96.1", gosnmp.Integer, 20, gosnmp.NoError},
+		{"1.3.6.1.4.1.9999.96.2", gosnmp.Integer, 3, gosnmp.WrongValue},
+		{"1.3.6.1.4.1.9999.96.2", gosnmp.Integer, 4, gosnmp.NoError},
+		{"1.3.6.1.4.1.9999.96.3", gosnmp.OctetString, []byte{}, gosnmp.NoError},
+		{"1.3.6.1.4.1.9999.96.3", gosnmp.OctetString, []byte("a"), gosnmp.WrongLength},
+		{"1.3.6.1.4.1.9999.96.3", gosnmp.OctetString, "abcd", gosnmp.NoError},
+		{"1.3.6.1.4.1.9999.96.3", gosnmp.OctetString, []byte("abcde"), gosnmp.WrongLength},
+		{"1.3.6.1.4.1.9999.96.4", gosnmp.OctetString, []byte("line\r\n"), gosnmp.NoError},
+		{"1.3.6.1.4.1.9999.96.4", gosnmp.OctetString, []byte("line\r"), gosnmp.WrongValue},
+		{"1.3.6.1.4.1.9999.96.4", gosnmp.OctetString, []byte("caf\xc3\xa9"), gosnmp.WrongValue},
+		{"1.3.6.1.4.1.9999.96.4", gosnmp.OctetString, make([]byte, 256), gosnmp.WrongLength},
+		{"1.3.6.1.4.1.9999.96.5", gosnmp.Gauge32, uint(100), gosnmp.NoError},
+		{"1.3.6.1.4.1.9999.96.5", gosnmp.Gauge32, uint(101), gosnmp.WrongValue},
+		{"1.3.6.1.4.1.9999.96.6", gosnmp.Integer, 1, gosnmp.InconsistentValue},
+	} {
+		assert.Equal(suite.T(), each.status, suite.set(v2c, each.oid, each.asn1Type, each.value), "%v %v", each.oid, each.value)
+	}
+	assert.Equal(suite.T(), 20, suite.values["1.3.6.1.4.1.9999.96.1"])
+	assert.Equal(suite.T(), "abcd", suite.values["1.3.6.1.4.1.9999.96.3"])
+	_, ok := suite.values["1.3.6.1.4.1.9999.96.6"]
+	assert.False(suite.T(), ok)
+}
+
+func TestConstraintsTestsSuite(t *testing.T) {
+	suite.Run(t, new(ConstraintsTests))
+}
@@ -0,0 +1,95 @@
+package GoSNMPServer
+
+import (
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+)
+
+// UserContexts restricts an SNMPV3 user to contexts. See SecurityConfig.UserContexts
+//
+//	A user with no UserContexts could reach any context, as of old versions.
+type UserContexts struct {
+	// UserName is the USM user or the TSM securityName
+	UserName string
+	// ContextNames the user is allowed to. "" for the default context
+	ContextNames []string
+}
+
+// contextKey selects the SubAgent of an SNMPV3 request. See RFC 3411 section 3.3.1
+type contextKey struct {
+	engineID string
+	name     string
+}
+
+// syncContexts maps SubAgent.ContextNames of the MasterAgent
+func (t *MasterAgent) syncContexts() error {
+	t.priv.contextToSubAgent = make(map[contextKey]*SubAgent)
+	for _, current := range t.SubAgents {
+		engineID := t.SecurityConfig.AuthoritativeEngineID.Marshal()
+		if !current.ContextEngineID.isZero() {
+			var err error
+			if engineID, err = current.ContextEngineID.marshal(); err != nil {
+				return errors.WithMessagef(err, "SyncConfig: ContextEngineID of %v", current.ContextNames)
+			}
+		}
+		for _, name := range current.ContextNames {
+			key := contextKey{engineID: string(engineID), name: name}
+			if _, exists := t.priv.contextToSubAgent[key]; exists {
+				return errors.Errorf("SyncConfig: Config Error: duplicate context %q of engine ID %x", name, engineID)
+			}
+			t.priv.contextToSubAgent[key] = current
+		}
+	}
+	users := make(map[string]bool)
+	for _, each := range t.SecurityConfig.UserContexts {
+		if users[each.UserName] {
+			return errors.Errorf("SyncConfig: Config Error: duplicate UserContexts of %q", each.UserName)
+		}
+		users[each.UserName] = true
+	}
+	return nil
+}
+
+// findForContext returns the SubAgent of the context of an SNMPV3 request. nil if not exists
+//
+//	contextEngineIDs of other engines are served only by SubAgents of that ContextEngineID, or by
+//	the default SubAgent of NoSecurity. With SecurityConfig.CommunityContexts, SubAgents without
+//	ContextNames are found by CommunityIDs of the contextName, as of old versions.
+func (t *MasterAgent) findForContext(i *gosnmp.SnmpPacket) *SubAgent {
+	local := string(t.SecurityConfig.AuthoritativeEngineID.Marshal())
+	engineID := i.ContextEngineID
+	// contextEngineID of notifications is the one of the originator. See RFC 3413 section 3.2
+	if engineID == "" || i.PDUType == gosnmp.SNMPv2Trap || i.PDUType == gosnmp.InformRequest {
+		engineID = local
+	}
+	if val, ok := t.priv.contextToSubAgent[contextKey{engineID: engineID, name: i.ContextName}]; ok {
+		return val
+	}
+	if t.SecurityConfig.NoSecurity {
+		return t.priv.defaultSubAgent
+	}
+	if engineID != local || !t.SecurityConfig.CommunityContexts {
+		return nil
+	}
+	if val := t.findForSubAgent(i.ContextName); val != nil && len(val.ContextNames) == 0 {
+		return val
+	}
+	return nil
+}
+
+// checkUserContext checks the context of an SNMPV3 request is allowed for the user of it
+func (t *MasterAgent) checkUserContext(request *gosnmp.SnmpPacket) error {
+	name := getUserNameOfPacket(request)
+	if request.Version != gosnmp.Version3 || name == "" {
+		return nil
+	}
+	for _, each := range t.SecurityConfig.UserContexts {
+		if each.UserName == name {
+			if !stringInSlice(request.ContextName, each.ContextNames) {
+				return errors.WithMessagef(ErrNoPermission, "user %v: context %q not allowed", name, request.ContextName)
+			}
+			return nil
+		}
+	}
+	return nil
+}
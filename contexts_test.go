@@ -0,0 +1,140 @@
+package GoSNMPServer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type ContextTests struct {
+	suite.Suite
+	Logger ILogger
+
+	handle *MasterAgent
+}
+
+func (suite *ContextTests) newSubAgent(value string) *SubAgent {
+	return &SubAgent{
+		OIDs: []*PDUValueControlItem{
+			{
+				OID:   "1.3.6.1.4.1.9999.92.1",
+				Type:  gosnmp.OctetString,
+				OnGet: func() (interface{}, error) { return Asn1OctetStringWrap(value), nil },
+			},
+		},
+	}
+}
+
+func (suite *ContextTests) SetupTest() {
+	suite.Logger = NewDiscardLogger()
+	community := suite.newSubAgent("community")
+	community.CommunityIDs = []string{"shared", "legacy"}
+	local := suite.newSubAgent("local")
+	local.ContextNames = []string{"shared", "other"}
+	remote := suite.newSubAgent("remote")
+	remote.ContextNames = []string{"shared"}
+	remote.ContextEngineID = SNMPEngineID{EngineIDData: "remote", Format: EngineIDFormatText}
+	suite.handle = &MasterAgent{
+		Logger: suite.Logger,
+		SecurityConfig: SecurityConfig{
+			AuthoritativeEngineID: SNMPEngineID{EngineIDData: "local", Format: EngineIDFormatText},
+			Users: []gosnmp.UsmSecurityParameters{
+				{UserName: "alice", AuthenticationProtocol: gosnmp.NoAuth, PrivacyProtocol: gosnmp.NoPriv},
+				{UserName: "bob", AuthenticationProtocol: gosnmp.NoAuth, PrivacyProtocol: gosnmp.NoPriv},
+			},
+			UserContexts: []*UserContexts{{UserName: "alice", ContextNames: []string{"shared", "legacy"}}},
+		},
+		SubAgents: []*SubAgent{community, local, remote},
+	}
+	if err := suite.handle.ReadyForWork(); err != nil {
+		panic(err)
+	}
+}
+
+// get serves a GetRequest. "" if no SubAgent is found
+func (suite *ContextTests) get(request *gosnmp.SnmpPacket) (string, gosnmp.SNMPError) {
+	request.PDUType = gosnmp.GetRequest
+	if request.SecurityParameters == nil {
+		// as decoded SNMPV1 / V2c requests
+		request.SecurityParameters = &gosnmp.UsmSecurityParameters{}
+	}
+	request.Variables = []gosnmp.SnmpPDU{{Name: "1.3.6.1.4.1.9999.92.1", Type: gosnmp.Null}}
+	response, err := suite.handle.ResponseForPktContext(context.Background(), request)
+	if err != nil {
+		assert.True(suite.T(), errors.Is(err, ErrNoSNMPInstance))
+		return "", gosnmp.NoError
+	}
+	if response.Error != gosnmp.NoError {
+		return "", response.Error
+	}
+	return Asn1OctetStringUnwrap(response.Variables[0].Value), gosnmp.NoError
+}
+
+func (suite *ContextTests) v3(user, engineID, contextName string) *gosnmp.SnmpPacket {
+	return &gosnmp.SnmpPacket{
+		Version:            gosnmp.Version3,
+		SecurityModel:      gosnmp.UserSecurityModel,
+		SecurityParameters: &gosnmp.UsmSecurityParameters{UserName: user},
+		ContextEngineID:    engineID,
+		ContextName:        contextName,
+	}
+}
+
+func (suite *ContextTests) TestRouting() {
+	value, _ := suite.get(&gosnmp.SnmpPacket{Version: gosnmp.Version2c, Community: "shared"})
+	assert.Equal(suite.T(), "community", value)
+	value, _ = suite.get(&gosnmp.SnmpPacket{Version: gosnmp.Version2c, Community: "other"})
+	assert.Equal(suite.T(), "", value)
+
+	local := string(suite.handle.SecurityConfig.AuthoritativeEngineID.Marshal())
+	value, _ = suite.get(suite.v3("bob", "", "shared"))
+	assert.Equal(suite.T(), "local", value)
+	value, _ = suite.get(suite.v3("bob", local, "other"))
+	assert.Equal(suite.T(), "local", value)
+	remote := SNMPEngineID{EngineIDData: "remote", Format: EngineIDFormatText}
+	value, _ = suite.get(suite.v3("bob", string(remote.Marshal()), "shared"))
+	assert.Equal(suite.T(), "remote", value)
+	value, _ = suite.get(suite.v3("bob", string(remote.Marshal()), "other"))
+	assert.Equal(suite.T(), "", value)
+
+	// communities never open contexts of the same name
+	value, _ = suite.get(suite.v3("bob", "", "legacy"))
+	assert.Equal(suite.T(), "", value)
+	// SubAgents without ContextNames are found by CommunityIDs with CommunityContexts, for the local engine only
+	suite.handle.SecurityConfig.CommunityContexts = true
+	value, _ = suite.get(suite.v3("bob", "", "legacy"))
+	assert.Equal(suite.T(), "community", value)
+	value, _ = suite.get(suite.v3("bob", string(remote.Marshal()), "legacy"))
+	assert.Equal(suite.T(), "", value)
+}
+
+func (suite *ContextTests) TestUserContexts() {
+	suite.handle.SecurityConfig.CommunityContexts = true
+	value, _ := suite.get(suite.v3("alice", "", "shared"))
+	assert.Equal(suite.T(), "local", value)
+	value, _ = suite.get(suite.v3("alice", "", "legacy"))
+	assert.Equal(suite.T(), "community", value)
+	_, status := suite.get(suite.v3("alice", "", "other"))
+	assert.Equal(suite.T(), gosnmp.AuthorizationError, status)
+}
+
+func (suite *ContextTests) TestSyncConfig() {
+	suite.handle.SubAgents[2].ContextEngineID = SNMPEngineID{}
+	assert.NotNil(suite.T(), suite.handle.SyncConfig())
+	suite.handle.SubAgents[2].ContextEngineID = SNMPEngineID{Raw: []byte{1}}
+	assert.NotNil(suite.T(), suite.handle.SyncConfig())
+	suite.handle.SubAgents[2].ContextNames = []string{"remote"}
+	suite.handle.SubAgents[2].ContextEngineID = SNMPEngineID{}
+	assert.Nil(suite.T(), suite.handle.SyncConfig())
+	suite.handle.SecurityConfig.UserContexts = append(suite.handle.SecurityConfig.UserContexts,
+		&UserContexts{UserName: "alice"})
+	assert.NotNil(suite.T(), suite.handle.SyncConfig())
+}
+
+func TestContextTestsSuite(t *testing.T) {
+	suite.Run(t, new(ContextTests))
+}
@@ -0,0 +1,170 @@
+package GoSNMPServer
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+// EngineIDFormat is the format of SNMPEngineID.EngineIDData. See RFC 3411 section 5
+//
+//	Formats 128 to 255 are enterprise specific, whose data are given in hex.
+type EngineIDFormat uint8
+
+const (
+	// EngineIDFormatIPv4 data is an address as "192.0.2.1"
+	EngineIDFormatIPv4 EngineIDFormat = 1
+	// EngineIDFormatIPv6 data is an address as "2001:db8::1"
+	EngineIDFormatIPv6 EngineIDFormat = 2
+	// EngineIDFormatMAC data is an address as "00:00:5e:00:53:01"
+	EngineIDFormatMAC EngineIDFormat = 3
+	// EngineIDFormatText data is administratively assigned text
+	EngineIDFormatText EngineIDFormat = 4
+	// EngineIDFormatOctets data is administratively assigned octets in hex as "0102ab"
+	EngineIDFormatOctets EngineIDFormat = 5
+)
+
+// DefaultEngineEnterpriseNumber is the enterprise number of engine IDs. pysnmp (20408)
+const DefaultEngineEnterpriseNumber = 20408
+
+// maxEngineIDDataSize is the max size of data after the enterprise number and the format
+const maxEngineIDDataSize = 32 - 5
+
+type SNMPEngineID struct {
+	// See https://tools.ietf.org/html/rfc3411#section-5
+	// 			SnmpEngineID ::= TEXTUAL-CONVENTION
+	//      SYNTAX       OCTET STRING (SIZE(5..32))
+	EngineIDData string
+
+	// Format of EngineIDData. 0 for the bytes of EngineIDData in format octets(5), as of old versions.
+	Format EngineIDFormat
+	// EnterpriseNumber is the IANA private enterprise number. 0 for DefaultEngineEnterpriseNumber
+	EnterpriseNumber uint32
+
+	// Raw is the whole engine ID, which overrides the fields above. See ParseEngineID
+	Raw []byte
+}
+
+// ParseEngineID parses a whole engine ID in hex, as "80001f8804..." or "0x80001f8804..."
+func ParseEngineID(text string) (SNMPEngineID, error) {
+	text = strings.TrimPrefix(strings.TrimPrefix(text, "0x"), "0X")
+	raw, err := hex.DecodeString(strings.Replace(text, ":", "", -1))
+	if err != nil {
+		return SNMPEngineID{}, errors.Wrapf(err, "engine ID %v", text)
+	}
+	ret := SNMPEngineID{Raw: raw}
+	if _, err := ret.marshal(); err != nil {
+		return SNMPEngineID{}, err
+	}
+	return ret, nil
+}
+
+// Marshal returns the engine ID. nil if it is not valid, which is rejected by MasterAgent.ReadyForWork
+func (t *SNMPEngineID) Marshal() []byte {
+	ret, _ := t.marshal()
+	return ret
+}
+
+// isZero is true if nothing is configured
+func (t *SNMPEngineID) isZero() bool {
+	return t.EngineIDData == "" && t.Raw == nil
+}
+
+func (t *SNMPEngineID) marshal() ([]byte, error) {
+	if t.Raw != nil {
+		if len(t.Raw) < 5 || len(t.Raw) > 32 {
+			return nil, errors.Errorf("engine ID %x: size should be 5 to 32", t.Raw)
+		}
+		return t.Raw, nil
+	}
+
+	// msgAuthoritativeEngineID: 80004fb8054445534b544f502d4a3732533245343ab63bc8
+	// 1... .... = Engine ID Conformance: RFC3411 (SNMPv3)
+	// Engine Enterprise ID: pysnmp (20408)
+	// Engine ID Format: Octets, administratively assigned (5)
+	// Engine ID Data: 4445534b544f502d4a3732533245343ab63bc8
+	enterprise := t.EnterpriseNumber
+	if enterprise == 0 {
+		enterprise = DefaultEngineEnterpriseNumber
+	}
+	if enterprise >= 0x80000000 {
+		return nil, errors.Errorf("engine ID: enterprise number %v too large", enterprise)
+	}
+	format := t.Format
+	if format == 0 {
+		format = EngineIDFormatOctets
+	}
+	data, err := t.marshalData()
+	if err != nil {
+		return nil, errors.WithMessagef(err, "engine ID %q of format %v", t.EngineIDData, t.Format)
+	}
+	if len(data) == 0 || len(data) > maxEngineIDDataSize {
+		return nil, errors.Errorf("engine ID %q: size of data should be 1 to %v", t.EngineIDData, maxEngineIDDataSize)
+	}
+	ret := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(ret, enterprise|0x80000000)
+	ret[4] = byte(format)
+	return append(ret, data...), nil
+}
+
+func (t *SNMPEngineID) marshalData() ([]byte, error) {
+	switch t.Format {
+	case 0, EngineIDFormatText:
+		return []byte(t.EngineIDData), nil
+	case EngineIDFormatIPv4:
+		if ip := net.ParseIP(t.EngineIDData).To4(); ip != nil {
+			return ip, nil
+		}
+		return nil, errors.New("not an IPv4 address")
+	case EngineIDFormatIPv6:
+		ip := net.ParseIP(t.EngineIDData)
+		if ip == nil || ip.To4() != nil {
+			return nil, errors.New("not an IPv6 address")
+		}
+		return ip, nil
+	case EngineIDFormatMAC:
+		mac, err := net.ParseMAC(t.EngineIDData)
+		if err != nil || len(mac) != 6 {
+			return nil, errors.New("not a MAC address")
+		}
+		return mac, nil
+	}
+	if t.Format == EngineIDFormatOctets || t.Format >= 128 {
+		data, err := hex.DecodeString(t.EngineIDData)
+		if err != nil {
+			return nil, errors.Wrap(err, "not hex")
+		}
+		return data, nil
+	}
+	return nil, errors.New("reserved format")
+}
+
+// DefaultAuthoritativeEngineID returns an engine ID of the host ID, which is stable without storage.
+//
+//	MasterAgent uses RandomAuthoritativeEngineID instead if SecurityConfig.EngineStateStore is set,
+//	which is stored to be stable across restarts.
+func DefaultAuthoritativeEngineID() SNMPEngineID {
+	val, _ := host.Info()
+	data := strings.Replace(val.HostID, "-", "", -1)
+	if raw, err := hex.DecodeString(data); err == nil && len(raw) != 0 && len(raw) <= maxEngineIDDataSize {
+		return SNMPEngineID{EngineIDData: data, Format: EngineIDFormatOctets}
+	}
+	if len(data) == 0 || len(data) > maxEngineIDDataSize {
+		return RandomAuthoritativeEngineID()
+	}
+	return SNMPEngineID{EngineIDData: data, Format: EngineIDFormatText}
+}
+
+// RandomAuthoritativeEngineID returns an engine ID of 12 random octets
+func RandomAuthoritativeEngineID() SNMPEngineID {
+	data := make([]byte, 12)
+	if _, err := rand.Read(data); err != nil {
+		panic(errors.Wrap(err, "RandomAuthoritativeEngineID"))
+	}
+	return SNMPEngineID{EngineIDData: hex.EncodeToString(data), Format: EngineIDFormatOctets}
+}
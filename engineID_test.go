@@ -0,0 +1,96 @@
+package GoSNMPServer
+
+import (
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type EngineIDTests struct {
+	suite.Suite
+}
+
+func (suite *EngineIDTests) TestFormats() {
+	cases := []struct {
+		engineID SNMPEngineID
+		expected string
+	}{
+		{SNMPEngineID{EngineIDData: "abc"}, "80004fb805616263"},
+		{SNMPEngineID{EngineIDData: "192.0.2.1", Format: EngineIDFormatIPv4, EnterpriseNumber: 8072}, "80001f8801c0000201"},
+		{SNMPEngineID{EngineIDData: "2001:db8::1", Format: EngineIDFormatIPv6}, "80004fb80220010db8000000000000000000000001"},
+		{SNMPEngineID{EngineIDData: "00:00:5e:00:53:01", Format: EngineIDFormatMAC}, "80004fb80300005e005301"},
+		{SNMPEngineID{EngineIDData: "abc", Format: EngineIDFormatText}, "80004fb804616263"},
+		{SNMPEngineID{EngineIDData: "0102ab", Format: EngineIDFormatOctets}, "80004fb8050102ab"},
+		{SNMPEngineID{EngineIDData: "0102ab", Format: 200, EnterpriseNumber: 8072}, "80001f88c80102ab"},
+		{SNMPEngineID{Raw: []byte{0, 0, 0, 9, 1, 2}}, "000000090102"},
+	}
+	for _, each := range cases {
+		assert.Equal(suite.T(), each.expected, hex.EncodeToString(each.engineID.Marshal()), each.engineID.EngineIDData)
+	}
+
+	invalid := []SNMPEngineID{
+		{EngineIDData: "a text longer than twenty seven bytes"},
+		{EngineIDData: "2001:db8::1", Format: EngineIDFormatIPv4},
+		{EngineIDData: "192.0.2.1", Format: EngineIDFormatIPv6},
+		{EngineIDData: "00:00:5e:00:53", Format: EngineIDFormatMAC},
+		{EngineIDData: "not hex", Format: EngineIDFormatOctets},
+		{EngineIDData: "abc", Format: 6},
+		{EngineIDData: "abc", EnterpriseNumber: 0x80000000},
+		{Raw: []byte{0x80, 0, 0, 0}},
+	}
+	for _, each := range invalid {
+		assert.Nil(suite.T(), each.Marshal(), each.EngineIDData)
+	}
+	master := &MasterAgent{
+		SecurityConfig: SecurityConfig{AuthoritativeEngineID: invalid[0]},
+		SubAgents:      []*SubAgent{{}},
+	}
+	assert.NotNil(suite.T(), master.ReadyForWork())
+}
+
+func (suite *EngineIDTests) TestParse() {
+	engineID, err := ParseEngineID("0x80001f8804616263")
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), "80001f8804616263", hex.EncodeToString(engineID.Marshal()))
+	}
+	_, err = ParseEngineID("80:00:1f:88:04:61")
+	assert.Nil(suite.T(), err)
+	_, err = ParseEngineID("80001f88")
+	assert.NotNil(suite.T(), err, "too short")
+	_, err = ParseEngineID("not hex")
+	assert.NotNil(suite.T(), err)
+}
+
+func (suite *EngineIDTests) TestDefault() {
+	engineID := DefaultAuthoritativeEngineID()
+	assert.NotNil(suite.T(), engineID.Marshal())
+	random := RandomAuthoritativeEngineID()
+	assert.NotNil(suite.T(), random.Marshal())
+	another := RandomAuthoritativeEngineID()
+	assert.NotEqual(suite.T(), random.Marshal(), another.Marshal())
+}
+
+func (suite *EngineIDTests) TestStored() {
+	store := NewFileEngineStateStore(filepath.Join(suite.T().TempDir(), "engine.conf"))
+	start := func() *MasterAgent {
+		master := &MasterAgent{
+			SecurityConfig: SecurityConfig{EngineStateStore: store},
+			SubAgents:      []*SubAgent{{}},
+		}
+		if err := master.ReadyForWork(); err != nil {
+			panic(err)
+		}
+		return master
+	}
+	first := start()
+	second := start()
+	assert.Equal(suite.T(), first.SecurityConfig.AuthoritativeEngineID.Marshal(), second.SecurityConfig.AuthoritativeEngineID.Marshal())
+	assert.Equal(suite.T(), uint32(2), second.SecurityConfig.AuthoritativeEngineBoots)
+}
+
+func TestEngineIDTestsSuite(t *testing.T) {
+	suite.Run(t, new(EngineIDTests))
+}
@@ -0,0 +1,189 @@
+package GoSNMPServer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxEngineValue is the max of snmpEngineBoots and snmpEngineTime. See RFC 3414 section 2.2
+const maxEngineValue = 2147483647
+
+// EngineStateStore keeps snmpEngineBoots across restarts. See SecurityConfig.EngineStateStore
+//
+//	RFC 3414 section 2.2 requires snmpEngineBoots to increase on every start of the engine,
+//	otherwise messages of the last run could be replayed.
+type EngineStateStore interface {
+	// LoadEngineID returns the engineID stored with boots. nil if never stored
+	//
+	//	It is used when SecurityConfig.AuthoritativeEngineID is not set, so that a generated engineID is stable.
+	LoadEngineID() ([]byte, error)
+	// LoadEngineBoots returns the boots stored for engineID. 0 if never stored
+	LoadEngineBoots(engineID []byte) (uint32, error)
+	// SaveEngineBoots stores the boots of engineID
+	SaveEngineBoots(engineID []byte, boots uint32) error
+}
+
+// FileEngineStateStore stores snmpEngineBoots in a file, as the "engineBoots" of net-snmp persistent files
+//
+//	The file is written as "engineID 80004fb805..." and "engineBoots 3" lines. Boots of another engineID
+//	are not taken, so that the boots restart from 1 when the engineID changes.
+type FileEngineStateStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// NewFileEngineStateStore returns a FileEngineStateStore of path
+func NewFileEngineStateStore(path string) *FileEngineStateStore {
+	return &FileEngineStateStore{Path: path}
+}
+
+func (t *FileEngineStateStore) LoadEngineID() ([]byte, error) {
+	engineID, _, err := t.load()
+	return engineID, err
+}
+
+func (t *FileEngineStateStore) LoadEngineBoots(engineID []byte) (uint32, error) {
+	storedID, boots, err := t.load()
+	if err != nil || !bytes.Equal(storedID, engineID) {
+		return 0, err
+	}
+	return boots, nil
+}
+
+// load reads the engineID and boots of the file. nil and 0 if the file does not exist
+func (t *FileEngineStateStore) load() ([]byte, uint32, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	data, err := ioutil.ReadFile(t.Path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "FileEngineStateStore")
+	}
+	var engineID []byte
+	var boots uint32
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "engineID":
+			if engineID, err = hex.DecodeString(fields[1]); err != nil {
+				return nil, 0, errors.Wrapf(err, "FileEngineStateStore %v: engineID", t.Path)
+			}
+		case "engineBoots":
+			val, err := strconv.ParseUint(fields[1], 10, 32)
+			if err != nil {
+				return nil, 0, errors.Wrapf(err, "FileEngineStateStore %v: engineBoots", t.Path)
+			}
+			boots = uint32(val)
+		}
+	}
+	return engineID, boots, nil
+}
+
+func (t *FileEngineStateStore) SaveEngineBoots(engineID []byte, boots uint32) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	data := "engineID " + hex.EncodeToString(engineID) + "\nengineBoots " + strconv.FormatUint(uint64(boots), 10) + "\n"
+	// write and rename, so that a crash never leaves a broken file
+	tmp, err := ioutil.TempFile(filepath.Dir(t.Path), filepath.Base(t.Path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "FileEngineStateStore")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "FileEngineStateStore")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "FileEngineStateStore")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "FileEngineStateStore")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), t.Path), "FileEngineStateStore")
+}
+
+// engineClock keeps snmpEngineBoots and snmpEngineTime of a MasterAgent. See RFC 3414 section 2.2.2
+//
+//	snmpEngineTime counts seconds since the engine starts. When it reaches maxEngineValue,
+//	snmpEngineBoots increases and snmpEngineTime restarts from 0, as if the engine restarts.
+//	snmpEngineBoots stays at maxEngineValue once it reaches it.
+type engineClock struct {
+	engineID []byte
+	store    EngineStateStore
+	logger   ILogger
+	// now is time.Now. replaced by tests
+	now func() time.Time
+
+	mu    sync.Mutex
+	boots uint32
+	start time.Time
+}
+
+// newEngineClock starts the clock of engineID. boots is the static boots used without a store
+func newEngineClock(engineID []byte, store EngineStateStore, boots uint32, logger ILogger,
+	now func() time.Time) (*engineClock, error) {
+	ret := &engineClock{
+		engineID: engineID,
+		store:    store,
+		logger:   logger,
+		now:      now,
+		boots:    boots,
+		start:    now(),
+	}
+	if store == nil {
+		return ret, nil
+	}
+	stored, err := store.LoadEngineBoots(engineID)
+	if err != nil {
+		return nil, err
+	}
+	ret.boots = stored
+	if err := ret.incrementBoots(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// incrementBoots increases and saves boots. Should be called with mu held or before being shared
+func (t *engineClock) incrementBoots() error {
+	if t.boots < maxEngineValue {
+		t.boots++
+	}
+	if t.store == nil {
+		return nil
+	}
+	return t.store.SaveEngineBoots(t.engineID, t.boots)
+}
+
+// bootsAndTime returns snmpEngineBoots and snmpEngineTime
+func (t *engineClock) bootsAndTime() (uint32, uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	elapsed := t.now().Sub(t.start) / time.Second
+	if elapsed >= maxEngineValue {
+		t.start = t.start.Add(maxEngineValue * time.Second)
+		elapsed -= maxEngineValue
+		if err := t.incrementBoots(); err != nil {
+			t.logger.Errorf("snmpEngineBoots %v is not saved: %v", t.boots, err)
+		}
+	}
+	return t.boots, uint32(elapsed)
+}
@@ -0,0 +1,120 @@
+package GoSNMPServer
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type EngineStateTests struct {
+	suite.Suite
+	Logger ILogger
+
+	path string
+}
+
+func (suite *EngineStateTests) SetupTest() {
+	suite.Logger = NewDiscardLogger()
+	suite.path = filepath.Join(suite.T().TempDir(), "engine.conf")
+}
+
+func (suite *EngineStateTests) newMaster(engineID string) *MasterAgent {
+	return &MasterAgent{
+		Logger: suite.Logger,
+		SecurityConfig: SecurityConfig{
+			AuthoritativeEngineID: SNMPEngineID{EngineIDData: engineID},
+			EngineStateStore:      NewFileEngineStateStore(suite.path),
+			Users: []gosnmp.UsmSecurityParameters{
+				{UserName: "user", AuthenticationProtocol: gosnmp.NoAuth, PrivacyProtocol: gosnmp.NoPriv},
+			},
+		},
+		SubAgents: []*SubAgent{{CommunityIDs: []string{"public"}}},
+	}
+}
+
+func (suite *EngineStateTests) TestFileStore() {
+	store := NewFileEngineStateStore(suite.path)
+	boots, err := store.LoadEngineBoots([]byte("engine"))
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), uint32(0), boots)
+
+	assert.Nil(suite.T(), store.SaveEngineBoots([]byte("engine"), 3))
+	boots, err = store.LoadEngineBoots([]byte("engine"))
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), uint32(3), boots)
+	boots, err = store.LoadEngineBoots([]byte("another"))
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), uint32(0), boots, "boots of another engineID")
+
+	if err := ioutil.WriteFile(suite.path, []byte("engineBoots three\n"), 0600); err != nil {
+		panic(err)
+	}
+	_, err = store.LoadEngineBoots([]byte("engine"))
+	assert.NotNil(suite.T(), err)
+}
+
+func (suite *EngineStateTests) TestBoots() {
+	master := suite.newMaster("gosnmpserver")
+	assert.Nil(suite.T(), master.ReadyForWork())
+	assert.Equal(suite.T(), uint32(1), master.SecurityConfig.AuthoritativeEngineBoots)
+	assert.Nil(suite.T(), master.ReadyForWork())
+	assert.Equal(suite.T(), uint32(1), master.SecurityConfig.AuthoritativeEngineBoots, "not a restart")
+
+	// restart
+	master = suite.newMaster("gosnmpserver")
+	assert.Nil(suite.T(), master.ReadyForWork())
+	assert.Equal(suite.T(), uint32(2), master.SecurityConfig.AuthoritativeEngineBoots)
+	usm, err := master.getUsmSecurityParametersFromUser("user")
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), uint32(2), usm.AuthoritativeEngineBoots)
+		assert.True(suite.T(), usm.AuthoritativeEngineTime < 10, "seconds since the engine starts")
+	}
+
+	// engineID changed
+	master = suite.newMaster("another")
+	assert.Nil(suite.T(), master.ReadyForWork())
+	assert.Equal(suite.T(), uint32(1), master.SecurityConfig.AuthoritativeEngineBoots)
+}
+
+func (suite *EngineStateTests) TestReload() {
+	shandle := NewSNMPServer(*suite.newMaster("gosnmpserver"))
+	assert.Nil(suite.T(), shandle.Reload(*suite.newMaster("gosnmpserver")))
+	assert.Equal(suite.T(), uint32(1), shandle.getMaster().SecurityConfig.AuthoritativeEngineBoots)
+	assert.Nil(suite.T(), shandle.Reload(*suite.newMaster("another")))
+	assert.Equal(suite.T(), uint32(1), shandle.getMaster().SecurityConfig.AuthoritativeEngineBoots)
+}
+
+func (suite *EngineStateTests) TestWrap() {
+	now := time.Now()
+	clock, err := newEngineClock([]byte("engine"), NewFileEngineStateStore(suite.path), 0, suite.Logger,
+		func() time.Time { return now })
+	if !assert.Nil(suite.T(), err) {
+		return
+	}
+	now = now.Add(10 * time.Second)
+	boots, engineTime := clock.bootsAndTime()
+	assert.Equal(suite.T(), uint32(1), boots)
+	assert.Equal(suite.T(), uint32(10), engineTime)
+
+	now = now.Add(maxEngineValue * time.Second)
+	boots, engineTime = clock.bootsAndTime()
+	assert.Equal(suite.T(), uint32(2), boots)
+	assert.Equal(suite.T(), uint32(10), engineTime)
+	stored, _ := NewFileEngineStateStore(suite.path).LoadEngineBoots([]byte("engine"))
+	assert.Equal(suite.T(), uint32(2), stored)
+
+	// latched
+	clock.boots = maxEngineValue
+	now = now.Add(maxEngineValue * time.Second)
+	boots, _ = clock.bootsAndTime()
+	assert.Equal(suite.T(), uint32(maxEngineValue), boots)
+}
+
+func TestEngineStateTestsSuite(t *testing.T) {
+	suite.Run(t, new(EngineStateTests))
+}
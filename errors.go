@@ -7,3 +7,9 @@ var ErrNoSNMPInstance = errors.New("ErrNoSNMPInstance")
 var ErrUnsupportedOperation = errors.New("ErrUnsupportedOperation")
 var ErrNoPermission = errors.New("ErrNoPermission")
 var ErrUnsupportedPacketData = errors.New("ErrUnsupportedPacketData")
+
+// ErrSourceNotAllowed marks requests from sources not allowed by SourceACLs. They are dropped with no response.
+var ErrSourceNotAllowed = errors.New("ErrSourceNotAllowed")
+
+// errServerShutdown is the error of reading requests after SNMPServer.Shutdown
+var errServerShutdown = errors.New("server is shut down")
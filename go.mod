@@ -3,12 +3,17 @@ module github.com/slayercat/GoSNMPServer
 go 1.13
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/gosnmp/gosnmp v1.36.2-0.20231009064202-d306ed5aa998
+	github.com/pion/dtls/v2 v2.2.7
+	github.com/pion/transport/v2 v2.2.1
 	github.com/pkg/errors v0.9.1
 	github.com/prometheus/procfs v0.0.8
 	github.com/shirou/gopsutil/v3 v3.23.11
 	github.com/sirupsen/logrus v1.4.2
 	github.com/stretchr/testify v1.8.4
 	github.com/urfave/cli/v2 v2.1.1
+	golang.org/x/crypto v0.14.0 // indirect
+	golang.org/x/net v0.17.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
-
@@ -114,3 +114,111 @@ func VerifyOid(oid string) error {
 	}
 	return nil
 }
+
+// IsOIDInSubtree checks if an oid is in the subtree under a mask. See RFC 3415 section 5
+//
+//	mask marks which sub-identifiers of subtree must match. The most significant bit
+//	of the first byte is the first sub-identifier. 1 for exact, 0 for wildcard.
+//	Missing bits are treated as 1.
+func IsOIDInSubtree(oid, subtree string, mask []byte) bool {
+	oidArcs, err := parseOID(oid)
+	if err != nil {
+		return false
+	}
+	subtreeArcs, err := parseOID(subtree)
+	if err != nil {
+		return false
+	}
+	return isByteStringInSubtree(oidArcs, subtreeArcs, mask)
+}
+
+func isByteStringInSubtree(target, subtree ByteString, mask []byte) bool {
+	if len(target) < len(subtree) {
+		return false
+	}
+	for i, val := range subtree {
+		if i/8 < len(mask) && mask[i/8]&(0x80>>uint(i%8)) == 0 {
+			continue // wildcard
+		}
+		if target[i] != val {
+			return false
+		}
+	}
+	return true
+}
+
+// newRemoteItem wraps a varbind returned by another agent
+func newRemoteItem(varItem gosnmp.SnmpPDU) *PDUValueControlItem {
+	return &PDUValueControlItem{
+		OID:   varItem.Name,
+		Type:  varItem.Type,
+		OnGet: func() (value interface{}, err error) { return varItem.Value, nil },
+	}
+}
+
+// newRemoteErrorItem returns an item failing on get, for errors of another agent
+func newRemoteErrorItem(oid string, err error) *PDUValueControlItem {
+	return &PDUValueControlItem{
+		OID:   oid,
+		Type:  gosnmp.Null,
+		OnGet: func() (value interface{}, e error) { return nil, err },
+	}
+}
+
+// berTLVSize returns the size of a BER TLV with content of n bytes
+func berTLVSize(n int) int {
+	switch {
+	case n < 0x80:
+		return 2 + n
+	case n < 0x100:
+		return 3 + n
+	case n < 0x10000:
+		return 4 + n
+	case n < 0x1000000:
+		return 5 + n
+	}
+	return 6 + n
+}
+
+// berOIDSize returns the size of the content of an OID encoded with BER
+func berOIDSize(oid string) int {
+	subIDs, err := agentxParseOID(oid)
+	if err != nil {
+		return len(oid)
+	}
+	size := 1
+	for id, each := range subIDs {
+		if id < 2 {
+			continue
+		}
+		for size++; each >= 0x80; each >>= 7 {
+			size++
+		}
+	}
+	return size
+}
+
+// estimateVarBindSize returns about the size of a varbind encoded with BER. Integers are counted as the largest
+func estimateVarBindSize(pdu gosnmp.SnmpPDU) int {
+	value := 0
+	switch pdu.Type {
+	case gosnmp.OctetString, gosnmp.Opaque, gosnmp.BitString:
+		switch val := pdu.Value.(type) {
+		case string:
+			value = len(val)
+		case []byte:
+			value = len(val)
+		}
+	case gosnmp.ObjectIdentifier:
+		if val, ok := pdu.Value.(string); ok {
+			value = berOIDSize(val)
+		}
+	case gosnmp.IPAddress:
+		value = 4
+	case gosnmp.Integer, gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Uinteger32:
+		value = 5
+	case gosnmp.Counter64:
+		value = 9
+	}
+	return berTLVSize(berTLVSize(berOIDSize(pdu.Name)) + berTLVSize(value))
+}
@@ -1,7 +1,11 @@
 package GoSNMPServer
 
-import "net"
-import "github.com/pkg/errors"
+import (
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+)
 
 type ISnmpServerListener interface {
 	SetupLogger(ILogger)
@@ -15,6 +19,14 @@ type IReplyer interface {
 	Shutdown()
 }
 
+// IRemoteAddressReplyer is implemented by replyers knowing where the request comes from.
+//
+//	The address is passed to callbacks in RequestInfo.
+type IRemoteAddressReplyer interface {
+	IReplyer
+	RemoteAddress() net.Addr
+}
+
 type UDPListener struct {
 	conn   *net.UDPConn
 	logger ILogger
@@ -42,23 +54,34 @@ func (udp *UDPListener) Address() net.Addr {
 	return udp.conn.LocalAddr()
 }
 
+// maxUDPMessageSize is the largest payload of a UDP datagram
+const maxUDPMessageSize = 65535
+
+// udpReadBuffers are reused by NextSnmp. Requests are copied out, so that queued ones keep only their size
+var udpReadBuffers = sync.Pool{New: func() interface{} { return new([maxUDPMessageSize]byte) }}
+
 func (udp *UDPListener) NextSnmp() ([]byte, IReplyer, error) {
-	var msg [4096]byte
 	if udp.conn == nil {
 		return nil, nil, errors.New("Connection Not Listen")
 	}
-	counts, udpAddr, err := udp.conn.ReadFromUDP(msg[:])
+	buf := udpReadBuffers.Get().(*[maxUDPMessageSize]byte)
+	defer udpReadBuffers.Put(buf)
+	counts, udpAddr, err := udp.conn.ReadFromUDP(buf[:])
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "UDP Read Error")
 	}
 	udp.logger.Infof("udp request from %v. size=%v", udpAddr, counts)
-	return msg[:counts], &UDPReplyer{udpAddr, udp.conn}, nil
+	msg := make([]byte, counts)
+	copy(msg, buf[:counts])
+	return msg, &UDPReplyer{udpAddr, udp.conn}, nil
 }
 
+// Shutdown closes the connection, which fails the running NextSnmp.
+//
+//	conn is kept, as NextSnmp may be reading it on the serving goroutine.
 func (udp *UDPListener) Shutdown() {
 	if udp.conn != nil {
 		udp.conn.Close()
-		udp.conn = nil
 	}
 }
 
@@ -77,3 +100,7 @@ func (r *UDPReplyer) ReplyPDU(i []byte) error {
 }
 
 func (r *UDPReplyer) Shutdown() {}
+
+func (r *UDPReplyer) RemoteAddress() net.Addr {
+	return r.target
+}
@@ -0,0 +1,126 @@
+package GoSNMPServer
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"time"
+
+	"github.com/pion/dtls/v2"
+	"github.com/pion/dtls/v2/pkg/protocol"
+	"github.com/pion/dtls/v2/pkg/protocol/recordlayer"
+	"github.com/pion/transport/v2/udp"
+	"github.com/pkg/errors"
+)
+
+// DTLSListener serves SNMPv3 over DTLS with the Transport Security Model. See RFC 6353
+//
+//	Each datagram carries one message. Sessions share the limits of TCPListener.
+type DTLSListener struct {
+	connListener
+
+	config     *dtls.Config
+	certToName []tlsCertToName
+}
+
+// NewDTLSListener listens address with default limits. config must have the server certificate.
+//
+//	Client certificates are verified with config.ClientCAs if it is set. Otherwise self-signed
+//	client certificates are accepted and checked with certToName only.
+func NewDTLSListener(l3proto, address string, config *dtls.Config, certToName []TLSCertToName) (*DTLSListener, error) {
+	table, err := newTLSCertToNameTable(certToName)
+	if err != nil {
+		return nil, err
+	}
+	copied := *config
+	if copied.ClientCAs != nil {
+		copied.ClientAuth = dtls.RequireAndVerifyClientCert
+	} else if copied.ClientAuth < dtls.RequireAnyClientCert {
+		copied.ClientAuth = dtls.RequireAnyClientCert
+	}
+	udpaddr, err := net.ResolveUDPAddr(l3proto, address)
+	if err != nil {
+		return nil, errors.Wrap(err, "ResolveUDPAddr Error")
+	}
+	lc := udp.ListenConfig{
+		// new sessions start with a handshake record
+		AcceptFilter: func(packet []byte) bool {
+			pkts, err := recordlayer.UnpackDatagram(packet)
+			if err != nil || len(pkts) < 1 {
+				return false
+			}
+			h := &recordlayer.Header{}
+			if err := h.Unmarshal(pkts[0]); err != nil {
+				return false
+			}
+			return h.ContentType == protocol.ContentTypeHandshake
+		},
+	}
+	listener, err := lc.Listen(l3proto, udpaddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "DTLS Listen Error")
+	}
+	ret := &DTLSListener{
+		connListener: newConnListener("dtls", listener),
+		config:       &copied,
+		certToName:   table,
+	}
+	ret.datagram = true
+	ret.onAccept = ret.handshake
+	return ret, nil
+}
+
+func (l *DTLSListener) handshake(conn net.Conn) (net.Conn, IReplyer, error) {
+	timeout := l.IdleTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	dtlsConn, err := dtls.ServerWithContext(ctx, conn, l.config)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "DTLS Handshake")
+	}
+	certs, chains, err := l.peerCertificates(dtlsConn.ConnectionState().PeerCertificates)
+	if err == nil {
+		var name string
+		name, err = securityNameForCerts(l.certToName, certs, chains)
+		if err == nil {
+			l.logger.Debugf("dtls connection from %v as %v", conn.RemoteAddr(), name)
+			return dtlsConn, &TLSReplyer{
+				TCPReplyer: TCPReplyer{conn: dtlsConn},
+				security:   TransportSecurity{Transport: "dtls", SecurityName: name},
+			}, nil
+		}
+	}
+	dtlsConn.Close()
+	return nil, nil, err
+}
+
+// peerCertificates parses the certificates of the peer. chains are built with ClientCAs as crypto/tls does.
+func (l *DTLSListener) peerCertificates(raw [][]byte) ([]*x509.Certificate, [][]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for _, each := range raw {
+		cert, err := x509.ParseCertificate(each)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "ParseCertificate")
+		}
+		certs = append(certs, cert)
+	}
+	if l.config.ClientCAs == nil || len(certs) == 0 {
+		return certs, nil, nil
+	}
+	intermediates := x509.NewCertPool()
+	for _, each := range certs[1:] {
+		intermediates.AddCert(each)
+	}
+	chains, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         l.config.ClientCAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Verify client certificate")
+	}
+	return certs, chains, nil
+}
@@ -0,0 +1,289 @@
+package GoSNMPServer
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Default limits of TCPListener
+const (
+	DefaultTCPMaxConnections = 100
+	DefaultTCPIdleTimeout    = 5 * time.Minute
+	DefaultTCPMaxMessageSize = 1 << 20
+)
+
+// connListener serves SNMP messages on the connections of listener. It is shared by TCP / TLS / DTLS listeners.
+type connListener struct {
+	// MaxConnections limits the connections served at the same time. 0 for no limit.
+	//                new connections over the limit are closed at once.
+	MaxConnections int
+	// IdleTimeout closes connections without any message in the duration. 0 for no timeout.
+	//             it also limits the handshake of TLS / DTLS.
+	IdleTimeout time.Duration
+	// MaxMessageSize closes connections sending larger messages. 0 for DefaultTCPMaxMessageSize
+	MaxMessageSize int
+
+	// name of the transport for logs
+	name     string
+	listener net.Listener
+	logger   ILogger
+	// onAccept prepares a new connection, as the handshake of TLS.
+	//          returns the connection to read messages from and the replyer of it.
+	onAccept func(conn net.Conn) (net.Conn, IReplyer, error)
+	// datagram reads one message with each Read instead of BER framing
+	datagram bool
+
+	startOnce sync.Once
+	requests  chan tcpRequest
+	done      chan struct{}
+	// acceptErr is set before done is closed
+	acceptErr error
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+type tcpRequest struct {
+	msg     []byte
+	replyer IReplyer
+}
+
+func newConnListener(name string, listener net.Listener) connListener {
+	return connListener{
+		MaxConnections: DefaultTCPMaxConnections,
+		IdleTimeout:    DefaultTCPIdleTimeout,
+		MaxMessageSize: DefaultTCPMaxMessageSize,
+		name:           name,
+		listener:       listener,
+		logger:         NewDiscardLogger(),
+		onAccept: func(conn net.Conn) (net.Conn, IReplyer, error) {
+			return conn, &TCPReplyer{conn: conn}, nil
+		},
+		requests: make(chan tcpRequest),
+		done:     make(chan struct{}),
+		conns:    make(map[net.Conn]struct{}),
+	}
+}
+
+// TCPListener serves SNMP over TCP. Each message is a BER encoded SEQUENCE
+//
+//	without any other framing. See RFC 3430
+type TCPListener struct {
+	connListener
+}
+
+// NewTCPListener listens address with default limits. Limits could be changed before serving.
+func NewTCPListener(l3proto, address string) (*TCPListener, error) {
+	tcpaddr, err := net.ResolveTCPAddr(l3proto, address)
+	if err != nil {
+		return nil, errors.Wrap(err, "ResolveTCPAddr Error")
+	}
+	listener, err := net.ListenTCP(l3proto, tcpaddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "TCP Listen Error")
+	}
+	return &TCPListener{newConnListener("tcp", listener)}, nil
+}
+
+func (l *connListener) SetupLogger(i ILogger) {
+	l.logger = i
+}
+
+func (l *connListener) Address() net.Addr {
+	return l.listener.Addr()
+}
+
+func (l *connListener) NextSnmp() ([]byte, IReplyer, error) {
+	l.startOnce.Do(func() { go l.acceptLoop() })
+	select {
+	case request := <-l.requests:
+		return request.msg, request.replyer, nil
+	case <-l.done:
+		return nil, nil, errors.Wrapf(l.acceptErr, "%v Accept Error", l.name)
+	}
+}
+
+func (l *connListener) Shutdown() {
+	l.listener.Close()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for conn := range l.conns {
+		conn.Close()
+	}
+}
+
+func (l *connListener) acceptLoop() {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			l.acceptErr = err
+			close(l.done)
+			return
+		}
+		l.mu.Lock()
+		if l.MaxConnections > 0 && len(l.conns) >= l.MaxConnections {
+			l.mu.Unlock()
+			l.logger.Warnf("%v connection from %v closed. over MaxConnections %v", l.name, conn.RemoteAddr(), l.MaxConnections)
+			conn.Close()
+			continue
+		}
+		l.conns[conn] = struct{}{}
+		l.mu.Unlock()
+		go l.serveConn(conn)
+	}
+}
+
+func (l *connListener) serveConn(rawConn net.Conn) {
+	defer func() {
+		l.mu.Lock()
+		delete(l.conns, rawConn)
+		l.mu.Unlock()
+		rawConn.Close()
+	}()
+	if l.IdleTimeout > 0 {
+		rawConn.SetDeadline(time.Now().Add(l.IdleTimeout))
+	}
+	conn, replyer, err := l.onAccept(rawConn)
+	if err != nil {
+		l.logger.Warnf("%v connection from %v refused: %v", l.name, rawConn.RemoteAddr(), err)
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Time{})
+	if each, ok := replyer.(interface{ setWriteTimeout(time.Duration) }); ok {
+		each.setWriteTimeout(l.IdleTimeout)
+	}
+	maxSize := l.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = DefaultTCPMaxMessageSize
+	}
+	reader := bufio.NewReader(conn)
+	var buf []byte
+	if l.datagram {
+		buf = make([]byte, maxSize)
+	}
+	for {
+		if l.IdleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(l.IdleTimeout))
+		}
+		var msg []byte
+		if l.datagram {
+			msg, err = readDatagram(conn, buf)
+		} else {
+			msg, err = readBERMessage(reader, maxSize)
+		}
+		if err != nil {
+			if err != io.EOF {
+				l.logger.Debugf("%v connection from %v closed: %v", l.name, conn.RemoteAddr(), err)
+			}
+			return
+		}
+		l.logger.Infof("%v request from %v. size=%v", l.name, conn.RemoteAddr(), len(msg))
+		select {
+		case l.requests <- tcpRequest{msg, replyer}:
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// readDatagram reads a message with one Read into buf, which is reused. The message is copied out
+func readDatagram(conn net.Conn, buf []byte) ([]byte, error) {
+	counts, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, counts)
+	copy(msg, buf[:counts])
+	return msg, nil
+}
+
+// readBERMessage reads a BER encoded SEQUENCE with its header
+func readBERMessage(reader *bufio.Reader, maxSize int) ([]byte, error) {
+	tag, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if tag != 0x30 {
+		return nil, errors.Errorf("not a SEQUENCE. tag=%#x", tag)
+	}
+	header := []byte{tag}
+	first, err := reader.ReadByte()
+	if err != nil {
+		return nil, errors.Wrap(err, "read length")
+	}
+	header = append(header, first)
+	length := int(first)
+	if first&0x80 != 0 {
+		count := int(first & 0x7f)
+		if count == 0 || count > 4 {
+			// indefinite length is not allowed
+			return nil, errors.Errorf("not supported length of %v bytes", count)
+		}
+		length = 0
+		for i := 0; i < count; i++ {
+			each, err := reader.ReadByte()
+			if err != nil {
+				return nil, errors.Wrap(err, "read length")
+			}
+			header = append(header, each)
+			length = length<<8 | int(each)
+		}
+	}
+	if length < 0 || len(header)+length > maxSize {
+		return nil, errors.Errorf("message size %v over %v", len(header)+length, maxSize)
+	}
+	msg := make([]byte, len(header)+length)
+	copy(msg, header)
+	if _, err := io.ReadFull(reader, msg[len(header):]); err != nil {
+		return nil, errors.Wrap(err, "read message")
+	}
+	return msg, nil
+}
+
+// TCPReplyer replies on the connection of the request
+type TCPReplyer struct {
+	mu   sync.Mutex
+	conn net.Conn
+	// writeTimeout closes the connection if a reply is not written in time. 0 for no timeout
+	writeTimeout time.Duration
+}
+
+func (r *TCPReplyer) setWriteTimeout(timeout time.Duration) {
+	r.mu.Lock()
+	r.writeTimeout = timeout
+	r.mu.Unlock()
+}
+
+// ReplyPDU writes a reply. The connection is closed if the peer does not read it in IdleTimeout,
+//
+//	so that a peer which stops reading never blocks the server.
+func (r *TCPReplyer) ReplyPDU(i []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.writeTimeout > 0 {
+		r.conn.SetWriteDeadline(time.Now().Add(r.writeTimeout))
+	}
+	if _, err := r.conn.Write(i); err != nil {
+		var netError net.Error
+		if errors.As(err, &netError) && netError.Timeout() {
+			r.conn.Close()
+		}
+		return errors.Wrap(err, "TCP Write")
+	}
+	return nil
+}
+
+// Shutdown closes the connection
+func (r *TCPReplyer) Shutdown() {
+	r.conn.Close()
+}
+
+func (r *TCPReplyer) RemoteAddress() net.Addr {
+	return r.conn.RemoteAddr()
+}
@@ -0,0 +1,295 @@
+package GoSNMPServer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type TCPListenerTests struct {
+	suite.Suite
+	Logger ILogger
+
+	shandle *SNMPServer
+	tcp     *TCPListener
+}
+
+func (suite *TCPListenerTests) SetupTest() {
+	logger := NewDefaultLogger()
+	logger.(*DefaultLogger).Level = logrus.InfoLevel
+	suite.Logger = logger
+
+	items := []*PDUValueControlItem{}
+	for id := 1; id <= 500; id++ {
+		items = append(items, &PDUValueControlItem{
+			OID:   fmt.Sprintf("1.3.6.1.4.1.9999.6.%d", id),
+			Type:  gosnmp.OctetString,
+			OnGet: func() (value interface{}, err error) { return Asn1OctetStringWrap("0123456789abcdef"), nil },
+		})
+	}
+	master := MasterAgent{
+		Logger: suite.Logger,
+		SubAgents: []*SubAgent{
+			{
+				CommunityIDs: []string{"public"},
+				OIDs:         items,
+			},
+			{
+				CommunityIDs:  []string{"denied"},
+				CommunityACLs: []*SourceACL{{Name: "denied", Networks: []string{"192.0.2.0/24"}}},
+			},
+		},
+	}
+	suite.shandle = NewSNMPServer(master)
+	if err := suite.shandle.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	tcp, err := NewTCPListener("tcp4", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	tcp.MaxConnections = 2
+	tcp.IdleTimeout = 500 * time.Millisecond
+	suite.tcp = tcp
+	suite.shandle.Listen(tcp)
+	go suite.shandle.ServeForever()
+}
+
+func (suite *TCPListenerTests) TearDownTest() {
+	suite.shandle.Shutdown()
+}
+
+func (suite *TCPListenerTests) getClient(transport string) *gosnmp.GoSNMP {
+	var address net.Addr
+	for _, each := range suite.shandle.Addresses() {
+		if each.Network() == transport {
+			address = each
+		}
+	}
+	host, port, _ := net.SplitHostPort(address.String())
+	client := &gosnmp.GoSNMP{
+		Target:    host,
+		Transport: transport,
+		Version:   gosnmp.Version2c,
+		Community: "public",
+		Timeout:   time.Second,
+	}
+	fmt.Sscan(port, &client.Port)
+	if err := client.Connect(); err != nil {
+		panic(err)
+	}
+	return client
+}
+
+func (suite *TCPListenerTests) TestUDPAndTCP() {
+	for _, transport := range []string{"udp", "tcp"} {
+		client := suite.getClient(transport)
+		result, err := client.Get([]string{"1.3.6.1.4.1.9999.6.1"})
+		if assert.Nil(suite.T(), err, transport) {
+			assert.Equal(suite.T(), "0123456789abcdef", string(result.Variables[0].Value.([]byte)))
+		}
+		client.Conn.Close()
+	}
+}
+
+func (suite *TCPListenerTests) TestLargeBulk() {
+	client := suite.getClient("tcp")
+	defer client.Conn.Close()
+
+	// larger than the former 4096 bytes buffer
+	result, err := client.GetBulk([]string{"1.3.6.1.4.1.9999.6"}, 0, 200)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 200, len(result.Variables))
+
+	// many requests on the same connection
+	walked, err := client.BulkWalkAll("1.3.6.1.4.1.9999.6")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 500, len(walked))
+}
+
+func (suite *TCPListenerTests) TestFraming() {
+	client := suite.getClient("tcp")
+	defer client.Conn.Close()
+	request := &gosnmp.SnmpPacket{
+		Version:   gosnmp.Version2c,
+		Community: "public",
+		PDUType:   gosnmp.GetRequest,
+		RequestID: 1,
+		Variables: []gosnmp.SnmpPDU{{Name: "1.3.6.1.4.1.9999.6.1", Type: gosnmp.Null}},
+	}
+	msg, err := request.MarshalMsg()
+	assert.Nil(suite.T(), err)
+
+	conn, err := net.Dial("tcp", suite.tcp.Address().String())
+	assert.Nil(suite.T(), err)
+	defer conn.Close()
+	// two messages written in three parts
+	all := append(append([]byte{}, msg...), msg...)
+	for _, part := range [][]byte{all[:3], all[3 : len(msg)+5], all[len(msg)+5:]} {
+		_, err := conn.Write(part)
+		assert.Nil(suite.T(), err)
+		time.Sleep(20 * time.Millisecond)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	reader := bufio.NewReader(conn)
+	for i := 0; i < 2; i++ {
+		response, err := readBERMessage(reader, DefaultTCPMaxMessageSize)
+		if assert.Nil(suite.T(), err) {
+			packet, err := client.SnmpDecodePacket(response)
+			assert.Nil(suite.T(), err)
+			assert.Equal(suite.T(), gosnmp.GetResponse, packet.PDUType)
+		}
+	}
+
+	// not a SEQUENCE closes the connection
+	_, err = conn.Write([]byte{0x02, 0x01, 0x00})
+	assert.Nil(suite.T(), err)
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(suite.T(), io.EOF, err)
+}
+
+func (suite *TCPListenerTests) TestLimits() {
+	conns := []net.Conn{}
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", suite.tcp.Address().String())
+		assert.Nil(suite.T(), err)
+		defer conn.Close()
+		conns = append(conns, conn)
+		time.Sleep(20 * time.Millisecond)
+	}
+	// over MaxConnections
+	conns[2].SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, err := conns[2].Read(make([]byte, 1))
+	assert.Equal(suite.T(), io.EOF, err)
+
+	// IdleTimeout
+	conns[0].SetReadDeadline(time.Now().Add(2 * time.Second))
+	start := time.Now()
+	_, err = conns[0].Read(make([]byte, 1))
+	assert.Equal(suite.T(), io.EOF, err)
+	assert.True(suite.T(), time.Since(start) < 1500*time.Millisecond)
+}
+
+// TestRequestError checks a failed request does not close the connection of the others
+func (suite *TCPListenerTests) TestRequestError() {
+	client := suite.getClient("tcp")
+	defer client.Conn.Close()
+	conn, err := net.Dial("tcp", suite.tcp.Address().String())
+	assert.Nil(suite.T(), err)
+	defer conn.Close()
+	// the request of a community not allowed from here is dropped
+	for id, community := range []string{"denied", "public"} {
+		request := &gosnmp.SnmpPacket{
+			Version:   gosnmp.Version2c,
+			Community: community,
+			PDUType:   gosnmp.GetRequest,
+			RequestID: uint32(id),
+			Variables: []gosnmp.SnmpPDU{{Name: "1.3.6.1.4.1.9999.6.1", Type: gosnmp.Null}},
+		}
+		msg, err := request.MarshalMsg()
+		assert.Nil(suite.T(), err)
+		_, err = conn.Write(msg)
+		assert.Nil(suite.T(), err)
+		time.Sleep(50 * time.Millisecond)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	reader := bufio.NewReader(conn)
+	for {
+		response, err := readBERMessage(reader, DefaultTCPMaxMessageSize)
+		if !assert.Nil(suite.T(), err) {
+			return
+		}
+		packet, err := client.SnmpDecodePacket(response)
+		assert.Nil(suite.T(), err)
+		if packet.Community == "public" {
+			assert.Equal(suite.T(), gosnmp.GetResponse, packet.PDUType)
+			return
+		}
+	}
+}
+
+// TestUDPMessages checks requests of UDP are copied out of the read buffer, as they may be queued
+func (suite *TCPListenerTests) TestUDPMessages() {
+	listener, err := NewUDPListener("udp4", "127.0.0.1:0")
+	assert.Nil(suite.T(), err)
+	defer listener.Shutdown()
+	conn, err := net.Dial("udp4", listener.Address().String())
+	assert.Nil(suite.T(), err)
+	defer conn.Close()
+
+	messages := [][]byte{}
+	for _, each := range []string{"first", "second"} {
+		_, err := conn.Write([]byte(each))
+		assert.Nil(suite.T(), err)
+		msg, _, err := listener.NextSnmp()
+		assert.Nil(suite.T(), err)
+		assert.Equal(suite.T(), len(msg), cap(msg))
+		messages = append(messages, msg)
+	}
+	assert.Equal(suite.T(), []byte("first"), messages[0])
+	assert.Equal(suite.T(), []byte("second"), messages[1])
+}
+
+// TestStalledReader checks a peer which never reads its responses does not block the server
+func (suite *TCPListenerTests) TestStalledReader() {
+	request := &gosnmp.SnmpPacket{
+		Version:        gosnmp.Version2c,
+		Community:      "public",
+		PDUType:        gosnmp.GetBulkRequest,
+		RequestID:      1,
+		MaxRepetitions: 500,
+		Variables:      []gosnmp.SnmpPDU{{Name: "1.3.6.1.4.1.9999.6", Type: gosnmp.Null}},
+	}
+	msg, err := request.MarshalMsg()
+	assert.Nil(suite.T(), err)
+	conn, err := net.Dial("tcp", suite.tcp.Address().String())
+	assert.Nil(suite.T(), err)
+	defer conn.Close()
+	go func() {
+		// about 40MB of responses, over the buffers of both ends
+		for i := 0; i < 2000; i++ {
+			if _, err := conn.Write(msg); err != nil {
+				return
+			}
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	client := suite.getClient("udp")
+	defer client.Conn.Close()
+	client.Timeout = 3 * time.Second
+	client.Retries = 0
+	_, err = client.Get([]string{"1.3.6.1.4.1.9999.6.1"})
+	assert.Nil(suite.T(), err)
+}
+
+// TestShutdown checks ServeForever of many listeners returns after Shutdown
+func (suite *TCPListenerTests) TestShutdown() {
+	for i := 0; i < 20; i++ {
+		shandle := NewSNMPServer(MasterAgent{Logger: NewDiscardLogger(), SubAgents: []*SubAgent{{}}})
+		assert.Nil(suite.T(), shandle.ListenUDP("udp4", "127.0.0.1:0"))
+		assert.Nil(suite.T(), shandle.ListenTCP("tcp4", "127.0.0.1:0"))
+		served := make(chan error)
+		go func() { served <- shandle.ServeForever() }()
+		time.Sleep(time.Millisecond)
+		shandle.Shutdown()
+		select {
+		case err := <-served:
+			assert.Nil(suite.T(), err)
+		case <-time.After(2 * time.Second):
+			suite.T().Fatal("ServeForever is not returned after Shutdown")
+		}
+	}
+}
+
+func TestTCPListenerTestsSuite(t *testing.T) {
+	suite.Run(t, new(TCPListenerTests))
+}
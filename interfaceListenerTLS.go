@@ -0,0 +1,270 @@
+package GoSNMPServer
+
+import (
+	"crypto"
+	_ "crypto/md5"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TLSCertMapType selects how TLSCertToName maps a certificate to a securityName.
+//
+//	See RFC 6353 section 5.3.2 and SNMP-TLS-TM-MIB
+type TLSCertMapType int
+
+const (
+	// TLSCertMapSpecified maps to TLSCertToName.SecurityName
+	TLSCertMapSpecified TLSCertMapType = 1
+	// TLSCertMapSANRFC822Name maps the first rfc822Name of subjectAltName. the host part is lower cased.
+	TLSCertMapSANRFC822Name TLSCertMapType = 2
+	// TLSCertMapSANDNSName maps the first dNSName of subjectAltName lower cased.
+	TLSCertMapSANDNSName TLSCertMapType = 3
+	// TLSCertMapSANIPAddress maps the first iPAddress of subjectAltName.
+	//                        IPv4 as "192.0.2.1", IPv6 as 32 lower cased hex digits.
+	TLSCertMapSANIPAddress TLSCertMapType = 4
+	// TLSCertMapSANAny maps the first of rfc822Name, dNSName and iPAddress of subjectAltName.
+	TLSCertMapSANAny TLSCertMapType = 5
+	// TLSCertMapCommonName maps the CommonName of the subject.
+	TLSCertMapCommonName TLSCertMapType = 6
+)
+
+// TLSCertToName is an entry of the certificate to securityName table (snmpTlstmCertToTSNTable).
+//
+//	Entries are tried in order. See RFC 6353 section 5.3.2
+type TLSCertToName struct {
+	// Fingerprint of the client certificate, or of a CA certificate in its verified chain.
+	//             as "SHA-256:AB:CD:..." See TLSFingerprint.
+	//             supported hashes are MD5, SHA-1, SHA-224, SHA-256, SHA-384, SHA-512.
+	Fingerprint string
+	MapType     TLSCertMapType
+	// SecurityName is used for TLSCertMapSpecified
+	SecurityName string
+}
+
+type tlsCertToName struct {
+	TLSCertToName
+	hash crypto.Hash
+	sum  []byte
+}
+
+var tlsFingerprintHashes = map[string]crypto.Hash{
+	"MD5":     crypto.MD5,
+	"SHA-1":   crypto.SHA1,
+	"SHA-224": crypto.SHA224,
+	"SHA-256": crypto.SHA256,
+	"SHA-384": crypto.SHA384,
+	"SHA-512": crypto.SHA512,
+}
+
+// TLSFingerprint returns the SHA-256 fingerprint of cert for TLSCertToName.Fingerprint
+func TLSFingerprint(cert *x509.Certificate) string {
+	sum := crypto.SHA256.New()
+	sum.Write(cert.Raw)
+	parts := []string{}
+	for _, each := range sum.Sum(nil) {
+		parts = append(parts, fmt.Sprintf("%02X", each))
+	}
+	return "SHA-256:" + strings.Join(parts, ":")
+}
+
+func parseTLSFingerprint(fingerprint string) (crypto.Hash, []byte, error) {
+	parts := strings.SplitN(fingerprint, ":", 2)
+	if len(parts) != 2 {
+		return 0, nil, errors.Errorf("no hash algorithm in fingerprint %v", fingerprint)
+	}
+	name := strings.ToUpper(parts[0])
+	if !strings.Contains(name, "-") && strings.HasPrefix(name, "SHA") {
+		// SHA256 as SHA-256
+		name = "SHA-" + strings.TrimPrefix(name, "SHA")
+	}
+	hash, ok := tlsFingerprintHashes[name]
+	if !ok || !hash.Available() {
+		return 0, nil, errors.Errorf("not supported hash algorithm in fingerprint %v", fingerprint)
+	}
+	sum, err := hex.DecodeString(strings.Replace(parts[1], ":", "", -1))
+	if err != nil || len(sum) != hash.Size() {
+		return 0, nil, errors.Errorf("not valid fingerprint %v", fingerprint)
+	}
+	return hash, sum, nil
+}
+
+func newTLSCertToNameTable(entries []TLSCertToName) ([]tlsCertToName, error) {
+	ret := []tlsCertToName{}
+	for _, each := range entries {
+		hash, sum, err := parseTLSFingerprint(each.Fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		if each.MapType < TLSCertMapSpecified || each.MapType > TLSCertMapCommonName {
+			return nil, errors.Errorf("not valid MapType %v for %v", each.MapType, each.Fingerprint)
+		}
+		if each.MapType == TLSCertMapSpecified && (each.SecurityName == "" || len(each.SecurityName) > 32) {
+			return nil, errors.Errorf("not valid SecurityName %q for %v", each.SecurityName, each.Fingerprint)
+		}
+		ret = append(ret, tlsCertToName{each, hash, sum})
+	}
+	return ret, nil
+}
+
+func (t *tlsCertToName) match(cert *x509.Certificate) bool {
+	sum := t.hash.New()
+	sum.Write(cert.Raw)
+	return string(sum.Sum(nil)) == string(t.sum)
+}
+
+// securityName maps the peer certificate. certs are the certificates sent by the peer, chains are the verified chains.
+//
+//	Certificates in chains match fingerprints only if verified.
+func securityNameForCerts(table []tlsCertToName, certs []*x509.Certificate, chains [][]*x509.Certificate) (string, error) {
+	if len(certs) == 0 {
+		return "", errors.WithMessagef(ErrNoPermission, "no client certificate")
+	}
+	candidates := []*x509.Certificate{certs[0]}
+	for _, chain := range chains {
+		candidates = append(candidates, chain...)
+	}
+	for id := range table {
+		each := &table[id]
+		matched := false
+		for _, cert := range candidates {
+			matched = matched || each.match(cert)
+		}
+		if !matched {
+			continue
+		}
+		if name := mapCertToName(each, certs[0]); name != "" && len(name) <= 32 {
+			return name, nil
+		}
+	}
+	return "", errors.WithMessagef(ErrNoPermission, "no securityName for %v", TLSFingerprint(certs[0]))
+}
+
+// mapCertToName returns "" if the certificate could not be mapped
+func mapCertToName(entry *tlsCertToName, cert *x509.Certificate) string {
+	rfc822Name := func() string {
+		if len(cert.EmailAddresses) == 0 {
+			return ""
+		}
+		parts := strings.SplitN(cert.EmailAddresses[0], "@", 2)
+		if len(parts) != 2 {
+			return ""
+		}
+		return parts[0] + "@" + strings.ToLower(parts[1])
+	}
+	dnsName := func() string {
+		if len(cert.DNSNames) == 0 {
+			return ""
+		}
+		return strings.ToLower(cert.DNSNames[0])
+	}
+	ipAddress := func() string {
+		if len(cert.IPAddresses) == 0 {
+			return ""
+		}
+		if ip4 := cert.IPAddresses[0].To4(); ip4 != nil {
+			return ip4.String()
+		}
+		return hex.EncodeToString(cert.IPAddresses[0].To16())
+	}
+	switch entry.MapType {
+	case TLSCertMapSpecified:
+		return entry.SecurityName
+	case TLSCertMapSANRFC822Name:
+		return rfc822Name()
+	case TLSCertMapSANDNSName:
+		return dnsName()
+	case TLSCertMapSANIPAddress:
+		return ipAddress()
+	case TLSCertMapSANAny:
+		for _, each := range []func() string{rfc822Name, dnsName, ipAddress} {
+			if name := each(); name != "" {
+				return name
+			}
+		}
+	case TLSCertMapCommonName:
+		return cert.Subject.CommonName
+	}
+	return ""
+}
+
+// TLSListener serves SNMPv3 over TLS with the Transport Security Model. See RFC 6353
+//
+//	Clients must send certificates, which are mapped to securityNames by the certificate to name table.
+type TLSListener struct {
+	connListener
+
+	config     *tls.Config
+	certToName []tlsCertToName
+}
+
+// NewTLSListener listens address with default limits. config must have the server certificate.
+//
+//	Client certificates are verified with config.ClientCAs if it is set. Otherwise self-signed
+//	client certificates are accepted and checked with certToName only.
+func NewTLSListener(l3proto, address string, config *tls.Config, certToName []TLSCertToName) (*TLSListener, error) {
+	table, err := newTLSCertToNameTable(certToName)
+	if err != nil {
+		return nil, err
+	}
+	config = config.Clone()
+	if config.ClientCAs != nil {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	} else if config.ClientAuth < tls.RequireAnyClientCert {
+		config.ClientAuth = tls.RequireAnyClientCert
+	}
+	if config.MinVersion < tls.VersionTLS12 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	tcpaddr, err := net.ResolveTCPAddr(l3proto, address)
+	if err != nil {
+		return nil, errors.Wrap(err, "ResolveTCPAddr Error")
+	}
+	listener, err := net.ListenTCP(l3proto, tcpaddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "TLS Listen Error")
+	}
+	ret := &TLSListener{
+		connListener: newConnListener("tls", listener),
+		config:       config,
+		certToName:   table,
+	}
+	ret.onAccept = ret.handshake
+	return ret, nil
+}
+
+func (l *TLSListener) handshake(conn net.Conn) (net.Conn, IReplyer, error) {
+	tlsConn := tls.Server(conn, l.config)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, nil, errors.Wrap(err, "TLS Handshake")
+	}
+	state := tlsConn.ConnectionState()
+	name, err := securityNameForCerts(l.certToName, state.PeerCertificates, state.VerifiedChains)
+	if err != nil {
+		tlsConn.Close()
+		return nil, nil, err
+	}
+	l.logger.Debugf("tls connection from %v as %v", conn.RemoteAddr(), name)
+	return tlsConn, &TLSReplyer{
+		TCPReplyer: TCPReplyer{conn: tlsConn},
+		security:   TransportSecurity{Transport: "tls", SecurityName: name},
+	}, nil
+}
+
+// TLSReplyer replies on a TLS / DTLS connection with its securityName
+type TLSReplyer struct {
+	TCPReplyer
+	security TransportSecurity
+}
+
+func (r *TLSReplyer) TransportSecurity() TransportSecurity {
+	return r.security
+}
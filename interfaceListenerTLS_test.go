@@ -0,0 +1,286 @@
+package GoSNMPServer
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pion/dtls/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type TLSListenerTests struct {
+	suite.Suite
+	Logger ILogger
+
+	shandle *SNMPServer
+	tls     *TLSListener
+	dtls    *DTLSListener
+
+	serverCert tls.Certificate
+	adminCert  tls.Certificate
+	guestCert  tls.Certificate
+	otherCert  tls.Certificate
+}
+
+// newTestCertificate creates a self-signed certificate
+func newTestCertificate(commonName string, dnsNames ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func (suite *TLSListenerTests) SetupTest() {
+	logger := NewDefaultLogger()
+	logger.(*DefaultLogger).Level = logrus.InfoLevel
+	suite.Logger = logger
+
+	suite.serverCert = newTestCertificate("agent", "localhost")
+	suite.adminCert = newTestCertificate("admin")
+	suite.guestCert = newTestCertificate("Guest", "Guest.Example.com")
+	suite.otherCert = newTestCertificate("other")
+	certToName := []TLSCertToName{
+		{Fingerprint: TLSFingerprint(suite.adminCert.Leaf), MapType: TLSCertMapSpecified, SecurityName: "tlsadmin"},
+		{Fingerprint: TLSFingerprint(suite.guestCert.Leaf), MapType: TLSCertMapSANDNSName},
+	}
+
+	master := MasterAgent{
+		Logger: suite.Logger,
+		VACM: &VACMConfig{
+			Groups: []VACMGroup{
+				{SecurityModel: VACMSecurityModelTSM, SecurityName: "tlsadmin", GroupName: "admins"},
+			},
+			Accesses: []VACMAccess{
+				{
+					GroupName:     "admins",
+					ContextPrefix: "public",
+					SecurityModel: VACMSecurityModelTSM,
+					SecurityLevel: gosnmp.AuthPriv,
+					ReadView:      "all",
+				},
+			},
+			Views: []VACMViewFamily{{ViewName: "all", Subtree: "1.3.6.1"}},
+		},
+		SubAgents: []*SubAgent{
+			{
+				CommunityIDs: []string{"public"},
+				ContextNames: []string{"public"},
+				OIDs: []*PDUValueControlItem{
+					{
+						OID:   "1.3.6.1.4.1.9999.8.1",
+						Type:  gosnmp.OctetString,
+						OnGet: func() (value interface{}, err error) { return Asn1OctetStringWrap("secure"), nil },
+					},
+				},
+			},
+		},
+	}
+	suite.shandle = NewSNMPServer(master)
+	var err error
+	suite.tls, err = NewTLSListener("tcp4", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{suite.serverCert},
+	}, certToName)
+	if err != nil {
+		panic(err)
+	}
+	suite.dtls, err = NewDTLSListener("udp4", "127.0.0.1:0", &dtls.Config{
+		Certificates: []tls.Certificate{suite.serverCert},
+	}, certToName)
+	if err != nil {
+		panic(err)
+	}
+	suite.shandle.Listen(suite.tls)
+	suite.shandle.Listen(suite.dtls)
+	go suite.shandle.ServeForever()
+}
+
+func (suite *TLSListenerTests) TearDownTest() {
+	suite.shandle.Shutdown()
+}
+
+// tsmGetRequest marshals a GetRequest of the Transport Security Model
+func (suite *TLSListenerTests) tsmGetRequest(contextName, oid string) []byte {
+	request := &gosnmp.SnmpPacket{
+		Version:       gosnmp.Version3,
+		MsgFlags:      gosnmp.NoAuthNoPriv | gosnmp.Reportable,
+		SecurityModel: gosnmp.UserSecurityModel,
+		SecurityParameters: &gosnmp.UsmSecurityParameters{
+			Logger: gosnmp.NewLogger(&SnmpLoggerAdapter{suite.Logger}),
+		},
+		MsgID:       1,
+		MsgMaxSize:  65507,
+		ContextName: contextName,
+		PDUType:     gosnmp.GetRequest,
+		RequestID:   1,
+		Variables:   []gosnmp.SnmpPDU{{Name: oid, Type: gosnmp.Null}},
+	}
+	out, err := request.MarshalMsg()
+	if err != nil {
+		panic(err)
+	}
+	msg, err := parseV3Message(out)
+	if err != nil {
+		panic(err)
+	}
+	msg.flags = gosnmp.AuthPriv | gosnmp.Reportable
+	msg.securityModel = TSMSecurityModel
+	msg.securityParameters = nil
+	return msg.marshal()
+}
+
+// decodeTSMResponse decodes a response of the Transport Security Model
+func (suite *TLSListenerTests) decodeTSMResponse(i []byte) *gosnmp.SnmpPacket {
+	msg, err := parseV3Message(i)
+	if !assert.Nil(suite.T(), err) {
+		return nil
+	}
+	assert.Equal(suite.T(), TSMSecurityModel, msg.securityModel)
+	assert.Equal(suite.T(), gosnmp.AuthPriv, msg.flags)
+	msg.flags = gosnmp.NoAuthNoPriv
+	msg.securityModel = gosnmp.UserSecurityModel
+	msg.securityParameters = marshalUsmSecurityName("")
+	client := gosnmp.GoSNMP{Logger: gosnmp.NewLogger(&SnmpLoggerAdapter{suite.Logger})}
+	client.SecurityParameters = &gosnmp.UsmSecurityParameters{Logger: client.Logger}
+	response, err := client.SnmpDecodePacket(msg.marshal())
+	if !assert.Nil(suite.T(), err) {
+		return nil
+	}
+	return response
+}
+
+func (suite *TLSListenerTests) dialTLS(cert tls.Certificate) net.Conn {
+	conn, err := tls.Dial("tcp", suite.tls.Address().String(), &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	return conn
+}
+
+func (suite *TLSListenerTests) dialDTLS(cert tls.Certificate) net.Conn {
+	conn, err := dtls.Dial("udp", suite.dtls.Address().(*net.UDPAddr), &dtls.Config{
+		Certificates:       []tls.Certificate{cert},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	return conn
+}
+
+func (suite *TLSListenerTests) getOverTLS(cert tls.Certificate, contextName string) *gosnmp.SnmpPacket {
+	conn := suite.dialTLS(cert)
+	defer conn.Close()
+	if _, err := conn.Write(suite.tsmGetRequest(contextName, "1.3.6.1.4.1.9999.8.1")); !assert.Nil(suite.T(), err) {
+		return nil
+	}
+	response, err := readBERMessage(bufio.NewReader(conn), DefaultTCPMaxMessageSize)
+	if !assert.Nil(suite.T(), err) {
+		return nil
+	}
+	return suite.decodeTSMResponse(response)
+}
+
+func (suite *TLSListenerTests) TestTLS() {
+	response := suite.getOverTLS(suite.adminCert, "public")
+	if assert.NotNil(suite.T(), response) {
+		assert.Equal(suite.T(), gosnmp.NoError, response.Error)
+		assert.Equal(suite.T(), "secure", string(response.Variables[0].Value.([]byte)))
+	}
+	// mapped as guest.example.com without VACM group
+	response = suite.getOverTLS(suite.guestCert, "public")
+	if assert.NotNil(suite.T(), response) {
+		assert.Equal(suite.T(), gosnmp.AuthorizationError, response.Error)
+	}
+}
+
+func (suite *TLSListenerTests) TestDTLS() {
+	conn := suite.dialDTLS(suite.adminCert)
+	defer conn.Close()
+	for i := 0; i < 2; i++ {
+		_, err := conn.Write(suite.tsmGetRequest("public", "1.3.6.1.4.1.9999.8.1"))
+		assert.Nil(suite.T(), err)
+		buffer := make([]byte, 65535)
+		counts, err := conn.Read(buffer)
+		if assert.Nil(suite.T(), err) {
+			response := suite.decodeTSMResponse(buffer[:counts])
+			if assert.NotNil(suite.T(), response) {
+				assert.Equal(suite.T(), "secure", string(response.Variables[0].Value.([]byte)))
+			}
+		}
+	}
+}
+
+func (suite *TLSListenerTests) TestNotMapped() {
+	conn := suite.dialTLS(suite.otherCert)
+	defer conn.Close()
+	conn.Write(suite.tsmGetRequest("public", "1.3.6.1.4.1.9999.8.1"))
+	_, err := conn.Read(make([]byte, 1))
+	assert.NotNil(suite.T(), err)
+
+	// without a client certificate
+	_, err = tls.Dial("tcp", suite.tls.Address().String(), &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12})
+	assert.NotNil(suite.T(), err)
+}
+
+func (suite *TLSListenerTests) TestCertToName() {
+	_, err := newTLSCertToNameTable([]TLSCertToName{{Fingerprint: "SHA-256:00", MapType: TLSCertMapSANAny}})
+	assert.NotNil(suite.T(), err)
+	_, err = newTLSCertToNameTable([]TLSCertToName{{Fingerprint: TLSFingerprint(suite.adminCert.Leaf), MapType: TLSCertMapSpecified}})
+	assert.NotNil(suite.T(), err)
+
+	for _, each := range []struct {
+		mapType TLSCertMapType
+		name    string
+	}{
+		{TLSCertMapSANDNSName, "guest.example.com"},
+		{TLSCertMapSANIPAddress, "127.0.0.1"},
+		{TLSCertMapSANAny, "guest.example.com"},
+		{TLSCertMapCommonName, "Guest"},
+		{TLSCertMapSANRFC822Name, ""},
+	} {
+		table, err := newTLSCertToNameTable([]TLSCertToName{
+			{Fingerprint: "sha256" + TLSFingerprint(suite.guestCert.Leaf)[len("SHA-256"):], MapType: each.mapType},
+		})
+		assert.Nil(suite.T(), err)
+		name, err := securityNameForCerts(table, []*x509.Certificate{suite.guestCert.Leaf}, nil)
+		assert.Equal(suite.T(), each.name, name, "map type %v", each.mapType)
+		assert.Equal(suite.T(), each.name == "", err != nil)
+	}
+}
+
+func TestTLSListenerTestsSuite(t *testing.T) {
+	suite.Run(t, new(TLSListenerTests))
+}
@@ -0,0 +1,164 @@
+package ifMib
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strings"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/slayercat/GoSNMPServer"
+)
+
+// networkRow is the data of a row in ifTable
+type networkRow struct {
+	ifIndex int
+	stat    net.IOCountersStat
+	hwAddr  string
+}
+
+// NetworkTable Returns ifTable which reads the interfaces on each request.
+//
+//	Interfaces added or removed at runtime are visible without restart.
+//	It serves the same OIDs as NetworkOIDs, so use one of them only.
+//	see http://www.net-snmp.org/docs/mibs/interfaces.html
+func NetworkTable() *GoSNMPServer.Table {
+	counter32Column := func(id int, document string, get func(stat net.IOCountersStat) uint64) *GoSNMPServer.TableColumn {
+		return &GoSNMPServer.TableColumn{
+			ID:   id,
+			Type: gosnmp.Counter32,
+			OnGet: func(row GoSNMPServer.TableRow) (value interface{}, err error) {
+				return GoSNMPServer.Asn1Counter32Wrap(uint(get(row.Data.(*networkRow).stat))), nil
+			},
+			Document: document,
+		}
+	}
+	columns := []*GoSNMPServer.TableColumn{
+		{
+			ID:   1,
+			Type: gosnmp.Integer,
+			OnGet: func(row GoSNMPServer.TableRow) (value interface{}, err error) {
+				return GoSNMPServer.Asn1IntegerWrap(row.Data.(*networkRow).ifIndex), nil
+			},
+			Document: "ifIndex",
+		},
+		{
+			ID:   2,
+			Type: gosnmp.OctetString,
+			OnGet: func(row GoSNMPServer.TableRow) (value interface{}, err error) {
+				return GoSNMPServer.Asn1OctetStringWrap(row.Data.(*networkRow).stat.Name), nil
+			},
+			Document: "ifDescr",
+		},
+		{
+			ID:   3,
+			Type: gosnmp.Integer,
+			OnGet: func(row GoSNMPServer.TableRow) (value interface{}, err error) {
+				var gigabitEthernet = 117 // see  http://www.net-snmp.org/docs/mibs/interfaces.html#IANAifType
+				//XXX: Let's assume all item is gigabitEthernet. /sys/class/net/eth0/type
+				return GoSNMPServer.Asn1IntegerWrap(gigabitEthernet), nil
+			},
+			Document: "ifType",
+		},
+		{
+			ID:   6,
+			Type: gosnmp.OctetString,
+			OnGet: func(row GoSNMPServer.TableRow) (value interface{}, err error) {
+				targetStr := strings.Replace(row.Data.(*networkRow).hwAddr, ":", "", -1)
+				decoded, err := hex.DecodeString(targetStr)
+				if err != nil {
+					return nil, err
+				}
+				return GoSNMPServer.Asn1OctetStringWrap(string(decoded)), nil
+			},
+			Document: "ifPhysAddress",
+		},
+		counter32Column(10, "ifInOctets", func(stat net.IOCountersStat) uint64 { return stat.BytesRecv }),
+		counter32Column(11, "ifInUcastPkts", func(stat net.IOCountersStat) uint64 { return stat.PacketsRecv }),
+		counter32Column(13, "ifInDiscards", func(stat net.IOCountersStat) uint64 { return stat.Dropin }),
+		counter32Column(14, "ifInErrors", func(stat net.IOCountersStat) uint64 { return stat.Errin }),
+		counter32Column(16, "ifOutOctets", func(stat net.IOCountersStat) uint64 { return stat.BytesSent }),
+		counter32Column(17, "ifOutUcastPkts", func(stat net.IOCountersStat) uint64 { return stat.PacketsSent }),
+		counter32Column(19, "ifOutDisCards", func(stat net.IOCountersStat) uint64 { return stat.Dropout }),
+		counter32Column(20, "ifOutErrors", func(stat net.IOCountersStat) uint64 { return stat.Errout }),
+	}
+	if runtime.GOOS == "linux" {
+		columns = append(columns, linuxPlatformNetworkColumns()...)
+	}
+	return &GoSNMPServer.Table{
+		OID:       "1.3.6.1.2.1.2.2.1",
+		Columns:   columns,
+		OnGetRows: getNetworkRows,
+		Document:  "ifTable",
+	}
+}
+
+func getNetworkRows() ([]GoSNMPServer.TableRow, error) {
+	valInterfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	hwAddrs := make(map[string]string)
+	for _, val := range valInterfaces {
+		hwAddrs[val.Name] = val.HardwareAddr
+	}
+	vcounters, err := net.IOCounters(true)
+	if err != nil {
+		return nil, err
+	}
+	toRet := []GoSNMPServer.TableRow{}
+	for ifIndex, val := range vcounters {
+		toRet = append(toRet, GoSNMPServer.TableRow{
+			Index: GoSNMPServer.TableIndexInteger(ifIndex),
+			Data:  &networkRow{ifIndex: ifIndex, stat: val, hwAddr: hwAddrs[val.Name]},
+		})
+	}
+	return toRet, nil
+}
+
+func linuxPlatformNetworkColumns() []*GoSNMPServer.TableColumn {
+	return []*GoSNMPServer.TableColumn{
+		{
+			ID:   7,
+			Type: gosnmp.Integer,
+			OnGet: func(row GoSNMPServer.TableRow) (value interface{}, err error) {
+				adminstatus_up := 1
+				adminstatus_down := 2
+				_, err = ioutil.ReadFile(fmt.Sprintf("/sys/class/net/%s/carrier", row.Data.(*networkRow).stat.Name))
+				if err != nil {
+					return GoSNMPServer.Asn1IntegerWrap(int(adminstatus_down)), nil
+				}
+				return GoSNMPServer.Asn1IntegerWrap(adminstatus_up), nil
+			},
+			Document: "ifAdminStatus",
+		},
+		{
+			ID:   8,
+			Type: gosnmp.Integer,
+			OnGet: func(row GoSNMPServer.TableRow) (value interface{}, err error) {
+				str_num := map[string]int{
+					"up":             1,
+					"down":           2,
+					"testing":        3,
+					"unknown":        4,
+					"dormant":        5,
+					"notPresent":     6,
+					"lowerLayerDown": 7,
+				}
+				bTs, err := ioutil.ReadFile(fmt.Sprintf("/sys/class/net/%s/operstate", row.Data.(*networkRow).stat.Name))
+				if err != nil {
+					return nil, err
+				}
+				bTString := strings.TrimSpace(string(bTs))
+				if val, ok := str_num[bTString]; ok {
+					return GoSNMPServer.Asn1IntegerWrap(int(val)), nil
+				}
+				g_Logger.Errorf("get ifOperStatus: unknown operstate %v", bTString)
+				return GoSNMPServer.Asn1IntegerWrap(int(4)), nil
+			},
+			Document: "ifOperStatus",
+		},
+	}
+}
@@ -4,6 +4,7 @@ import "github.com/slayercat/GoSNMPServer"
 
 import "github.com/slayercat/GoSNMPServer/mibImps/dismanEventMib"
 import "github.com/slayercat/GoSNMPServer/mibImps/ifMib"
+import "github.com/slayercat/GoSNMPServer/mibImps/snmpNotificationMib"
 import "github.com/slayercat/GoSNMPServer/mibImps/ucdMib"
 
 func init() {
@@ -17,6 +18,7 @@ func SetupLogger(i GoSNMPServer.ILogger) {
 	g_Logger = i
 	dismanEventMib.SetupLogger(i)
 	ifMib.SetupLogger(i)
+	snmpNotificationMib.SetupLogger(i)
 	ucdMib.SetupLogger(i)
 }
 
@@ -29,3 +31,15 @@ func All() []*GoSNMPServer.PDUValueControlItem {
 	toRet = append(toRet, ucdMib.All()...)
 	return toRet
 }
+
+// AllWithTables function provides the same OIDs as All, but ifTable and dskTable
+//
+//	are served as Tables to follow interfaces and disks changed at runtime.
+func AllWithTables() ([]*GoSNMPServer.PDUValueControlItem, []*GoSNMPServer.Table) {
+	toRet := []*GoSNMPServer.PDUValueControlItem{}
+	toRet = append(toRet, dismanEventMib.All()...)
+	toRet = append(toRet, ucdMib.MemoryOIDs()...)
+	toRet = append(toRet, ucdMib.SystemStatsOIDs()...)
+	toRet = append(toRet, ucdMib.SystemLoadOIDs()...)
+	return toRet, []*GoSNMPServer.Table{ifMib.NetworkTable(), ucdMib.DiskUsageTable()}
+}
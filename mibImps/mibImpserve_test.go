@@ -5,6 +5,7 @@ import (
 	"net"
 	"os/exec"
 	"testing"
+	"time"
 
 	"github.com/gosnmp/gosnmp"
 	"github.com/sirupsen/logrus"
@@ -44,6 +45,7 @@ func (suite *SnmpServerTestSuite) SetupTest() {
 		SubAgents: []*GoSNMPServer.SubAgent{
 			{
 				CommunityIDs: []string{"public"},
+				ContextNames: []string{"public"},
 				OIDs:         All(),
 			},
 		},
@@ -126,3 +128,41 @@ func (suite *SnmpServerTestSuite) TearDownSuite() {
 func TestSnmpServerTestSuiteSuite(t *testing.T) {
 	suite.Run(t, new(SnmpServerTestSuite))
 }
+
+func TestAllWithTables(t *testing.T) {
+	walk := func(oids []*GoSNMPServer.PDUValueControlItem, tables []*GoSNMPServer.Table) []string {
+		master := GoSNMPServer.MasterAgent{
+			Logger: GoSNMPServer.NewDiscardLogger(),
+			SubAgents: []*GoSNMPServer.SubAgent{
+				{CommunityIDs: []string{"public"}, OIDs: oids, Tables: tables},
+			},
+		}
+		server := GoSNMPServer.NewSNMPServer(master)
+		if err := server.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		defer server.Shutdown()
+		go server.ServeForever()
+		serverAddress := server.Address().(*net.UDPAddr)
+		client := &gosnmp.GoSNMP{
+			Target:    serverAddress.IP.String(),
+			Port:      uint16(serverAddress.Port),
+			Version:   gosnmp.Version2c,
+			Community: "public",
+			Timeout:   time.Second,
+		}
+		if err := client.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		defer client.Conn.Close()
+		walked, err := client.WalkAll("1.3")
+		assert.Nil(t, err)
+		names := []string{}
+		for _, each := range walked {
+			names = append(names, each.Name)
+		}
+		return names
+	}
+	oids, tables := AllWithTables()
+	assert.Equal(t, walk(All(), nil), walk(oids, tables))
+}
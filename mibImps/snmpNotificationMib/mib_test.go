@@ -0,0 +1,207 @@
+package snmpNotificationMib
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/slayercat/GoSNMPServer"
+	"github.com/stretchr/testify/assert"
+)
+
+func startServer(t *testing.T, mib *MIB) (*GoSNMPServer.SNMPServer, *GoSNMPServer.SubAgent) {
+	subAgent := &GoSNMPServer.SubAgent{CommunityIDs: []string{"private"}, UserErrorMarkPacket: true}
+	master := GoSNMPServer.MasterAgent{
+		Logger:    GoSNMPServer.NewDiscardLogger(),
+		SubAgents: []*GoSNMPServer.SubAgent{subAgent},
+	}
+	server := GoSNMPServer.NewSNMPServer(master)
+	if err := mib.Attach(subAgent, server.NotificationOriginator()); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeForever()
+	return server, subAgent
+}
+
+func getClient(t *testing.T, server *GoSNMPServer.SNMPServer) *gosnmp.GoSNMP {
+	serverAddress := server.Address().(*net.UDPAddr)
+	client := &gosnmp.GoSNMP{
+		Target:    serverAddress.IP.String(),
+		Port:      uint16(serverAddress.Port),
+		Version:   gosnmp.Version2c,
+		Community: "private",
+		Timeout:   time.Second,
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestCreateRowsBySet(t *testing.T) {
+	receiver, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+	receiverAddress := receiver.LocalAddr().(*net.UDPAddr)
+
+	mib := New()
+	server, _ := startServer(t, mib)
+	defer server.Shutdown()
+	client := getClient(t, server)
+	defer client.Conn.Close()
+
+	name := "." + GoSNMPServer.TableIndexImpliedString("t1")
+	tAddress := string(append(receiverAddress.IP.To4(), byte(receiverAddress.Port>>8), byte(receiverAddress.Port)))
+	for _, pdus := range [][]gosnmp.SnmpPDU{
+		{
+			{Name: oidSnmpTargetParamsEntry + ".2" + name, Type: gosnmp.Integer, Value: 1},
+			{Name: oidSnmpTargetParamsEntry + ".3" + name, Type: gosnmp.Integer, Value: 2},
+			{Name: oidSnmpTargetParamsEntry + ".4" + name, Type: gosnmp.OctetString, Value: "public"},
+			{Name: oidSnmpTargetParamsEntry + ".5" + name, Type: gosnmp.Integer, Value: 1},
+			{Name: oidSnmpTargetParamsEntry + ".7" + name, Type: gosnmp.Integer, Value: int(GoSNMPServer.RowStatusCreateAndGo)},
+		},
+		{
+			{Name: oidSnmpTargetAddrEntry + ".2" + name, Type: gosnmp.ObjectIdentifier, Value: OIDSnmpUDPDomain},
+			{Name: oidSnmpTargetAddrEntry + ".3" + name, Type: gosnmp.OctetString, Value: tAddress},
+			{Name: oidSnmpTargetAddrEntry + ".6" + name, Type: gosnmp.OctetString, Value: "tag1 tag2"},
+			{Name: oidSnmpTargetAddrEntry + ".7" + name, Type: gosnmp.OctetString, Value: "t1"},
+			{Name: oidSnmpTargetAddrEntry + ".9" + name, Type: gosnmp.Integer, Value: int(GoSNMPServer.RowStatusCreateAndWait)},
+		},
+		{
+			{Name: oidSnmpNotifyEntry + ".2" + name, Type: gosnmp.OctetString, Value: "tag2"},
+			{Name: oidSnmpNotifyEntry + ".5" + name, Type: gosnmp.Integer, Value: int(GoSNMPServer.RowStatusCreateAndGo)},
+		},
+	} {
+		result, err := client.Set(pdus)
+		assert.Nil(t, err)
+		assert.Equal(t, gosnmp.NoError, result.Error)
+	}
+	// the address row is notInService
+	assert.Equal(t, 0, len(mib.Targets()))
+	result, err := client.Get([]string{oidSnmpTargetAddrEntry + ".9" + name})
+	assert.Nil(t, err)
+	assert.Equal(t, int(GoSNMPServer.RowStatusNotInService), result.Variables[0].Value)
+
+	result, err = client.Set([]gosnmp.SnmpPDU{
+		{Name: oidSnmpTargetAddrEntry + ".9" + name, Type: gosnmp.Integer, Value: int(GoSNMPServer.RowStatusActive)},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, gosnmp.NoError, result.Error)
+	targets := mib.Targets()
+	if assert.Equal(t, 1, len(targets)) {
+		assert.Equal(t, "t1", targets[0].Name)
+		assert.Equal(t, receiverAddress.String(), targets[0].Address)
+		assert.Equal(t, gosnmp.Version2c, targets[0].Version)
+		assert.Equal(t, "public", targets[0].Community)
+		assert.Equal(t, 15*time.Second, targets[0].Timeout)
+		assert.False(t, targets[0].Inform)
+	}
+
+	results := server.NotificationOriginator().Send(GoSNMPServer.Notification{TrapOID: "1.3.6.1.6.3.1.1.5.1"})
+	if assert.Equal(t, 1, len(results)) {
+		assert.Nil(t, results[0].Err)
+	}
+	buf := make([]byte, 4096)
+	receiver.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = receiver.Read(buf)
+	assert.Nil(t, err)
+
+	walked, err := client.WalkAll("1.3.6.1.6.3")
+	assert.Nil(t, err)
+	assert.Equal(t, 6+8+4, len(walked))
+
+	result, err = client.Set([]gosnmp.SnmpPDU{
+		{Name: oidSnmpNotifyEntry + ".5" + name, Type: gosnmp.Integer, Value: int(GoSNMPServer.RowStatusDestroy)},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, gosnmp.NoError, result.Error)
+	assert.Equal(t, 0, len(mib.Targets()))
+	result, err = client.Get([]string{oidSnmpNotifyEntry + ".2" + name})
+	assert.Nil(t, err)
+	assert.Equal(t, gosnmp.NoSuchInstance, result.Variables[0].Type)
+}
+
+func TestCreateAndGoNotReady(t *testing.T) {
+	mib := New()
+	server, subAgent := startServer(t, mib)
+	defer server.Shutdown()
+	client := getClient(t, server)
+	defer client.Conn.Close()
+
+	name := "." + GoSNMPServer.TableIndexImpliedString("p")
+	result, err := client.Set([]gosnmp.SnmpPDU{
+		{Name: oidSnmpTargetParamsEntry + ".2" + name, Type: gosnmp.Integer, Value: 1},
+		{Name: oidSnmpTargetParamsEntry + ".7" + name, Type: gosnmp.Integer, Value: int(GoSNMPServer.RowStatusCreateAndGo)},
+	})
+	assert.Nil(t, err)
+	assert.NotEqual(t, gosnmp.NoError, result.Error)
+	assert.Equal(t, 0, len(mib.targetParamsTable.Rows()))
+	assert.Equal(t, 0, len(subAgent.OIDs))
+}
+
+func TestTargetParamsSecurity(t *testing.T) {
+	mib := New()
+	assert.NotNil(t, mib.AddTargetParams(TargetParams{Name: "v2c", Version: gosnmp.Version2c, SecurityLevel: gosnmp.AuthNoPriv}))
+	assert.Nil(t, mib.AddTargetParams(TargetParams{Name: "v3", Version: gosnmp.Version3, SecurityName: "user", SecurityLevel: gosnmp.AuthPriv}))
+	for _, each := range []struct {
+		name                                  string
+		mpModel, securityModel, securityLevel int
+	}{
+		{"mismatch", 1, 3, 1},
+		{"v1auth", 0, 1, 2},
+	} {
+		assert.Nil(t, mib.targetParamsTable.AddRow(GoSNMPServer.TableIndexImpliedString(each.name), map[int]interface{}{
+			targetParamsMPModel:       each.mpModel,
+			targetParamsSecurityModel: each.securityModel,
+			targetParamsSecurityName:  "public",
+			targetParamsSecurityLevel: each.securityLevel,
+		}))
+	}
+	for _, each := range []string{"v3", "mismatch", "v1auth"} {
+		assert.Nil(t, mib.AddTargetAddr(TargetAddr{Name: each, Address: "127.0.0.1:162", TagList: []string{"x"}, Params: each}))
+	}
+	assert.Nil(t, mib.AddNotify(Notify{Name: "n", Tag: "x"}))
+
+	targets := mib.Targets()
+	if assert.Equal(t, 1, len(targets)) {
+		assert.Equal(t, "v3", targets[0].Name)
+		assert.Equal(t, gosnmp.Version3, targets[0].Version)
+		assert.Equal(t, "user", targets[0].UserName)
+		assert.Equal(t, gosnmp.AuthPriv, targets[0].SecurityLevel)
+	}
+}
+
+func TestNotifyFilter(t *testing.T) {
+	mib := New()
+	assert.Nil(t, mib.AddTargetParams(TargetParams{Name: "p", Version: gosnmp.Version2c, SecurityName: "public"}))
+	assert.Nil(t, mib.AddTargetAddr(TargetAddr{Name: "a", Address: "[::1]:1162", Transport: "tcp", TagList: []string{"x"}, Params: "p"}))
+	assert.Nil(t, mib.AddNotify(Notify{Name: "n", Tag: "x", Inform: true}))
+	assert.Nil(t, mib.AddNotifyFilterProfile("p", "profile"))
+	assert.Nil(t, mib.AddNotifyFilter(NotifyFilter{ProfileName: "profile", Subtree: "1.3.6.1.6.3.1.1.5"}))
+	assert.Nil(t, mib.AddNotifyFilter(NotifyFilter{ProfileName: "profile", Subtree: "1.3.6.1.6.3.1.1.5.4", Excluded: true}))
+	assert.NotNil(t, mib.AddNotify(Notify{Name: "n"}))
+	assert.NotNil(t, mib.AddTargetAddr(TargetAddr{Name: "b", Address: "localhost:162", Params: "p"}))
+
+	targets := mib.Targets()
+	if !assert.Equal(t, 1, len(targets)) {
+		return
+	}
+	assert.Equal(t, "[::1]:1162", targets[0].Address)
+	assert.Equal(t, "tcp", targets[0].Transport)
+	assert.True(t, targets[0].Inform)
+	if assert.NotNil(t, targets[0].Filter) {
+		assert.True(t, targets[0].Filter(GoSNMPServer.Notification{TrapOID: "1.3.6.1.6.3.1.1.5.3"}))
+		assert.False(t, targets[0].Filter(GoSNMPServer.Notification{TrapOID: "1.3.6.1.6.3.1.1.5.4"}))
+		assert.False(t, targets[0].Filter(GoSNMPServer.Notification{TrapOID: "1.3.6.1.4.1.9999.0.1"}))
+		assert.False(t, targets[0].Filter(GoSNMPServer.Notification{
+			TrapOID:   "1.3.6.1.6.3.1.1.5.3",
+			Variables: []gosnmp.SnmpPDU{{Name: "1.3.6.1.2.1.2.2.1.1.1"}},
+		}))
+	}
+}
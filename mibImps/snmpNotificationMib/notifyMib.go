@@ -0,0 +1,232 @@
+package snmpNotificationMib
+
+import (
+	"strings"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+	"github.com/slayercat/GoSNMPServer"
+)
+
+const (
+	oidSnmpNotifyEntry              = "1.3.6.1.6.3.13.1.1.1"
+	oidSnmpNotifyFilterProfileEntry = "1.3.6.1.6.3.13.1.2.1"
+	oidSnmpNotifyFilterEntry        = "1.3.6.1.6.3.13.1.3.1"
+)
+
+// columns of snmpNotifyEntry
+const (
+	notifyName        = 1
+	notifyTag         = 2
+	notifyType        = 3
+	notifyStorageType = 4
+	notifyRowStatus   = 5
+)
+
+// values of snmpNotifyType
+const (
+	notifyTypeTrap   = 1
+	notifyTypeInform = 2
+)
+
+// columns of snmpNotifyFilterProfileEntry
+const (
+	notifyFilterProfileName        = 1
+	notifyFilterProfileStorageType = 2
+	notifyFilterProfileRowStatus   = 3
+)
+
+// columns of snmpNotifyFilterEntry
+const (
+	notifyFilterSubtree     = 1
+	notifyFilterMask        = 2
+	notifyFilterType        = 3
+	notifyFilterStorageType = 4
+	notifyFilterRowStatus   = 5
+)
+
+// values of snmpNotifyFilterType
+const (
+	notifyFilterTypeIncluded = 1
+	notifyFilterTypeExcluded = 2
+)
+
+// Notify is a row of snmpNotifyTable
+type Notify struct {
+	Name string
+	// Tag selects TargetAddr rows with the tag in TagList
+	Tag string
+	// Inform sends InformRequest instead of trap
+	Inform bool
+}
+
+// NotifyFilter is a row of snmpNotifyFilterTable
+type NotifyFilter struct {
+	ProfileName string
+	Subtree     string
+	// Mask is the same as VACMViewFamily.Mask
+	Mask     []byte
+	Excluded bool
+}
+
+func newNotifyTable() *GoSNMPServer.RowStatusTable {
+	return &GoSNMPServer.RowStatusTable{
+		OID:             oidSnmpNotifyEntry,
+		Document:        "snmpNotifyTable",
+		RowStatusColumn: notifyRowStatus,
+		Columns: []*GoSNMPServer.RowStatusColumn{
+			{ID: notifyName, Document: "snmpNotifyName", Type: gosnmp.OctetString, NotAccessible: true},
+			{ID: notifyTag, Document: "snmpNotifyTag", Type: gosnmp.OctetString,
+				DefVal: "", OnTestSet: checkLength(0, 255)},
+			{ID: notifyType, Document: "snmpNotifyType", Type: gosnmp.Integer,
+				DefVal: notifyTypeTrap, OnTestSet: checkRange(notifyTypeTrap, notifyTypeInform)},
+			{ID: notifyStorageType, Document: "snmpNotifyStorageType", Type: gosnmp.Integer,
+				DefVal: StorageTypeNonVolatile, OnTestSet: checkRange(StorageTypeOther, StorageTypeReadOnly)},
+			{ID: notifyRowStatus, Document: "snmpNotifyRowStatus", Type: gosnmp.Integer},
+		},
+		OnParseIndex: parseNameIndex(notifyName),
+	}
+}
+
+// newNotifyFilterProfileTable makes snmpNotifyFilterProfileTable. indexed by snmpTargetParamsName
+func newNotifyFilterProfileTable() *GoSNMPServer.RowStatusTable {
+	return &GoSNMPServer.RowStatusTable{
+		OID:             oidSnmpNotifyFilterProfileEntry,
+		Document:        "snmpNotifyFilterProfileTable",
+		RowStatusColumn: notifyFilterProfileRowStatus,
+		Columns: []*GoSNMPServer.RowStatusColumn{
+			{ID: notifyFilterProfileName, Document: "snmpNotifyFilterProfileName", Type: gosnmp.OctetString,
+				OnTestSet: checkLength(1, 32)},
+			{ID: notifyFilterProfileStorageType, Document: "snmpNotifyFilterProfileStorType", Type: gosnmp.Integer,
+				DefVal: StorageTypeNonVolatile, OnTestSet: checkRange(StorageTypeOther, StorageTypeReadOnly)},
+			{ID: notifyFilterProfileRowStatus, Document: "snmpNotifyFilterProfileRowStatus", Type: gosnmp.Integer},
+		},
+		OnParseIndex: func(index string) (map[int]interface{}, error) {
+			_, err := parseName(index)
+			return map[int]interface{}{}, err
+		},
+	}
+}
+
+// newNotifyFilterTable makes snmpNotifyFilterTable. indexed by snmpNotifyFilterProfileName, IMPLIED snmpNotifyFilterSubtree
+func newNotifyFilterTable() *GoSNMPServer.RowStatusTable {
+	return &GoSNMPServer.RowStatusTable{
+		OID:             oidSnmpNotifyFilterEntry,
+		Document:        "snmpNotifyFilterTable",
+		RowStatusColumn: notifyFilterRowStatus,
+		Columns: []*GoSNMPServer.RowStatusColumn{
+			{ID: notifyFilterSubtree, Document: "snmpNotifyFilterSubtree", Type: gosnmp.ObjectIdentifier, NotAccessible: true},
+			{ID: notifyFilterMask, Document: "snmpNotifyFilterMask", Type: gosnmp.OctetString,
+				DefVal: "", OnTestSet: checkLength(0, 16)},
+			{ID: notifyFilterType, Document: "snmpNotifyFilterType", Type: gosnmp.Integer,
+				DefVal: notifyFilterTypeIncluded, OnTestSet: checkRange(notifyFilterTypeIncluded, notifyFilterTypeExcluded)},
+			{ID: notifyFilterStorageType, Document: "snmpNotifyFilterStorageType", Type: gosnmp.Integer,
+				DefVal: StorageTypeNonVolatile, OnTestSet: checkRange(StorageTypeOther, StorageTypeReadOnly)},
+			{ID: notifyFilterRowStatus, Document: "snmpNotifyFilterRowStatus", Type: gosnmp.Integer},
+		},
+		OnParseIndex: func(index string) (map[int]interface{}, error) {
+			name, subtree, err := GoSNMPServer.TableIndexParseString(index, false)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkLength(1, 32)(name); err != nil {
+				return nil, err
+			}
+			if err := GoSNMPServer.VerifyOid(subtree); err != nil {
+				return nil, err
+			}
+			return map[int]interface{}{notifyFilterSubtree: subtree}, nil
+		},
+	}
+}
+
+// AddNotify adds an active row into snmpNotifyTable
+func (t *MIB) AddNotify(notify Notify) error {
+	typ := notifyTypeTrap
+	if notify.Inform {
+		typ = notifyTypeInform
+	}
+	return t.notifyTable.AddRow(GoSNMPServer.TableIndexImpliedString(notify.Name), map[int]interface{}{
+		notifyTag:  notify.Tag,
+		notifyType: typ,
+	})
+}
+
+// AddNotifyFilterProfile selects the filter profile for the TargetParams row
+func (t *MIB) AddNotifyFilterProfile(paramsName, profileName string) error {
+	return t.notifyFilterProfileTable.AddRow(GoSNMPServer.TableIndexImpliedString(paramsName), map[int]interface{}{
+		notifyFilterProfileName: profileName,
+	})
+}
+
+// AddNotifyFilter adds an active row into snmpNotifyFilterTable
+func (t *MIB) AddNotifyFilter(filter NotifyFilter) error {
+	if err := GoSNMPServer.VerifyOid(filter.Subtree); err != nil {
+		return errors.WithMessagef(err, "NotifyFilter %v", filter.ProfileName)
+	}
+	typ := notifyFilterTypeIncluded
+	if filter.Excluded {
+		typ = notifyFilterTypeExcluded
+	}
+	subtree := strings.TrimPrefix(filter.Subtree, ".")
+	return t.notifyFilterTable.AddRow(GoSNMPServer.TableIndexString(filter.ProfileName)+"."+subtree, map[int]interface{}{
+		notifyFilterMask: string(filter.Mask),
+		notifyFilterType: typ,
+	})
+}
+
+type notifyFilter struct {
+	subtree  string
+	mask     []byte
+	excluded bool
+}
+
+// filterForParams returns the filter of the profile for the TargetParams row. nil if no profile.
+//
+//	See RFC 3413 section 6
+func (t *MIB) filterForParams(paramsName string) GoSNMPServer.FuncNotificationFilter {
+	profile, ok := t.notifyFilterProfileTable.ActiveRow(GoSNMPServer.TableIndexImpliedString(paramsName))
+	if !ok {
+		return nil
+	}
+	prefix := GoSNMPServer.TableIndexString(profile.Values[notifyFilterProfileName].(string)) + "."
+	filters := []notifyFilter{}
+	for _, each := range t.notifyFilterTable.Rows() {
+		if each.Status != GoSNMPServer.RowStatusActive || !strings.HasPrefix(each.Index, prefix) {
+			continue
+		}
+		filters = append(filters, notifyFilter{
+			subtree:  each.Values[notifyFilterSubtree].(string),
+			mask:     []byte(each.Values[notifyFilterMask].(string)),
+			excluded: each.Values[notifyFilterType].(int) == notifyFilterTypeExcluded,
+		})
+	}
+	return func(notification GoSNMPServer.Notification) bool {
+		if !isNotifyIncluded(filters, notification.TrapOID) {
+			return false
+		}
+		for _, each := range notification.Variables {
+			if !isNotifyIncluded(filters, each.Name) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// isNotifyIncluded checks oid with the most specific filter.
+func isNotifyIncluded(filters []notifyFilter, oid string) bool {
+	var matched *notifyFilter
+	matchedLen := 0
+	for id := range filters {
+		each := &filters[id]
+		if !GoSNMPServer.IsOIDInSubtree(oid, each.subtree, each.mask) {
+			continue
+		}
+		length := len(strings.Split(each.subtree, "."))
+		if matched == nil || length > matchedLen || (length == matchedLen && each.subtree > matched.subtree) {
+			matched, matchedLen = each, length
+		}
+	}
+	return matched != nil && !matched.excluded
+}
@@ -0,0 +1,79 @@
+package snmpNotificationMib
+
+import (
+	"github.com/slayercat/GoSNMPServer"
+)
+
+func init() {
+	g_Logger = GoSNMPServer.NewDiscardLogger()
+}
+
+var g_Logger GoSNMPServer.ILogger
+
+// SetupLogger Setups Logger for this mib
+func SetupLogger(i GoSNMPServer.ILogger) {
+	g_Logger = i
+}
+
+// MIB keeps the notification targets as SNMP-TARGET-MIB and SNMP-NOTIFICATION-MIB. See RFC 3413
+//
+//	snmpTargetAddrTable          1.3.6.1.6.3.12.1.2
+//	snmpTargetParamsTable        1.3.6.1.6.3.12.1.3
+//	snmpNotifyTable              1.3.6.1.6.3.13.1.1
+//	snmpNotifyFilterProfileTable 1.3.6.1.6.3.13.1.2
+//	snmpNotifyFilterTable        1.3.6.1.6.3.13.1.3
+//
+//	Rows could be added by code, or by managers with SET / RowStatus after Attach.
+type MIB struct {
+	targetAddrTable          *GoSNMPServer.RowStatusTable
+	targetParamsTable        *GoSNMPServer.RowStatusTable
+	notifyTable              *GoSNMPServer.RowStatusTable
+	notifyFilterProfileTable *GoSNMPServer.RowStatusTable
+	notifyFilterTable        *GoSNMPServer.RowStatusTable
+}
+
+// New makes an empty MIB
+func New() *MIB {
+	return &MIB{
+		targetAddrTable:          newTargetAddrTable(),
+		targetParamsTable:        newTargetParamsTable(),
+		notifyTable:              newNotifyTable(),
+		notifyFilterProfileTable: newNotifyFilterProfileTable(),
+		notifyFilterTable:        newNotifyFilterTable(),
+	}
+}
+
+func (t *MIB) tables() []*GoSNMPServer.RowStatusTable {
+	return []*GoSNMPServer.RowStatusTable{
+		t.targetAddrTable,
+		t.targetParamsTable,
+		t.notifyTable,
+		t.notifyFilterProfileTable,
+		t.notifyFilterTable,
+	}
+}
+
+// Attach serves the tables in subAgent and sends notifications of originator to the targets.
+//
+//	Should be called before MasterAgent.ReadyForWork.
+//	Set subAgent.UserErrorMarkPacket to report failed SET to managers.
+func (t *MIB) Attach(subAgent *GoSNMPServer.SubAgent, originator *GoSNMPServer.NotificationOriginator) error {
+	for _, each := range t.tables() {
+		if err := each.Attach(subAgent); err != nil {
+			return err
+		}
+	}
+	if originator != nil {
+		originator.OnGetTargets = t.Targets
+	}
+	return nil
+}
+
+// All returns the OIDs of all rows
+func (t *MIB) All() []*GoSNMPServer.PDUValueControlItem {
+	toRet := []*GoSNMPServer.PDUValueControlItem{}
+	for _, each := range t.tables() {
+		toRet = append(toRet, each.All()...)
+	}
+	return toRet
+}
@@ -0,0 +1,53 @@
+package snmpNotificationMib
+
+import (
+	"github.com/pkg/errors"
+	"github.com/slayercat/GoSNMPServer"
+)
+
+// StorageType values. See RFC 2579
+const (
+	StorageTypeOther       = 1
+	StorageTypeVolatile    = 2
+	StorageTypeNonVolatile = 3
+	StorageTypePermanent   = 4
+	StorageTypeReadOnly    = 5
+)
+
+// parseNameIndex parses an IMPLIED SnmpAdminString index into the column
+func parseNameIndex(columnID int) GoSNMPServer.FuncRowStatusParseIndex {
+	return func(index string) (map[int]interface{}, error) {
+		name, err := parseName(index)
+		if err != nil {
+			return nil, err
+		}
+		return map[int]interface{}{columnID: name}, nil
+	}
+}
+
+// parseName parses an IMPLIED SnmpAdminString (SIZE(1..32)) index
+func parseName(index string) (string, error) {
+	name, _, err := GoSNMPServer.TableIndexParseString(index, true)
+	if err != nil {
+		return "", err
+	}
+	return name, checkLength(1, 32)(name)
+}
+
+func checkRange(min, max int) GoSNMPServer.FuncPDUControlTestSet {
+	return func(value interface{}) error {
+		if val := value.(int); val < min || val > max {
+			return errors.Errorf("value %v out of range %v..%v", val, min, max)
+		}
+		return nil
+	}
+}
+
+func checkLength(min, max int) GoSNMPServer.FuncPDUControlTestSet {
+	return func(value interface{}) error {
+		if val := value.(string); len(val) < min || len(val) > max {
+			return errors.Errorf("length %v out of range %v..%v", len(val), min, max)
+		}
+		return nil
+	}
+}
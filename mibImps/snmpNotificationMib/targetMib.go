@@ -0,0 +1,341 @@
+package snmpNotificationMib
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+	"github.com/slayercat/GoSNMPServer"
+)
+
+// Transport domains of snmpTargetAddrTDomain. See RFC 3417, RFC 3430 and RFC 3419
+const (
+	OIDSnmpUDPDomain          = "1.3.6.1.6.1.1"
+	OIDSnmpTCPDomain          = "1.3.6.1.6.1.5"
+	OIDTransportDomainUDPIPv6 = "1.3.6.1.2.1.100.1.2"
+	OIDTransportDomainTCPIPv6 = "1.3.6.1.2.1.100.1.6"
+)
+
+const (
+	oidSnmpTargetAddrEntry   = "1.3.6.1.6.3.12.1.2.1"
+	oidSnmpTargetParamsEntry = "1.3.6.1.6.3.12.1.3.1"
+)
+
+// columns of snmpTargetAddrEntry
+const (
+	targetAddrName        = 1
+	targetAddrTDomain     = 2
+	targetAddrTAddress    = 3
+	targetAddrTimeout     = 4
+	targetAddrRetryCount  = 5
+	targetAddrTagList     = 6
+	targetAddrParams      = 7
+	targetAddrStorageType = 8
+	targetAddrRowStatus   = 9
+)
+
+// columns of snmpTargetParamsEntry
+const (
+	targetParamsName          = 1
+	targetParamsMPModel       = 2
+	targetParamsSecurityModel = 3
+	targetParamsSecurityName  = 4
+	targetParamsSecurityLevel = 5
+	targetParamsStorageType   = 6
+	targetParamsRowStatus     = 7
+)
+
+// TargetAddr is a row of snmpTargetAddrTable
+type TargetAddr struct {
+	Name string
+	// Address is "ip:port" of the receiver
+	Address string
+	// Transport is "udp" or "tcp". default "udp"
+	Transport string
+	// Timeout in 0.01 seconds. default 1500
+	Timeout int
+	// RetryCount default 3
+	RetryCount int
+	// TagList selects this target from snmpNotifyTag
+	TagList []string
+	// Params is the name of the TargetParams row
+	Params string
+}
+
+// TargetParams is a row of snmpTargetParamsTable
+type TargetParams struct {
+	Name    string
+	Version gosnmp.SnmpVersion
+	// SecurityName is the community for SNMPV1 / SNMPV2c and the user name for SNMPV3
+	SecurityName  string
+	SecurityLevel gosnmp.SnmpV3MsgFlags
+}
+
+func newTargetAddrTable() *GoSNMPServer.RowStatusTable {
+	return &GoSNMPServer.RowStatusTable{
+		OID:             oidSnmpTargetAddrEntry,
+		Document:        "snmpTargetAddrTable",
+		RowStatusColumn: targetAddrRowStatus,
+		Columns: []*GoSNMPServer.RowStatusColumn{
+			{ID: targetAddrName, Document: "snmpTargetAddrName", Type: gosnmp.OctetString, NotAccessible: true},
+			{ID: targetAddrTDomain, Document: "snmpTargetAddrTDomain", Type: gosnmp.ObjectIdentifier,
+				OnTestSet: checkTDomain},
+			{ID: targetAddrTAddress, Document: "snmpTargetAddrTAddress", Type: gosnmp.OctetString,
+				OnTestSet: checkLength(1, 255)},
+			{ID: targetAddrTimeout, Document: "snmpTargetAddrTimeout", Type: gosnmp.Integer,
+				DefVal: 1500, OnTestSet: checkRange(0, 2147483647)},
+			{ID: targetAddrRetryCount, Document: "snmpTargetAddrRetryCount", Type: gosnmp.Integer,
+				DefVal: 3, OnTestSet: checkRange(0, 255)},
+			{ID: targetAddrTagList, Document: "snmpTargetAddrTagList", Type: gosnmp.OctetString,
+				DefVal: "", OnTestSet: checkLength(0, 255)},
+			{ID: targetAddrParams, Document: "snmpTargetAddrParams", Type: gosnmp.OctetString,
+				OnTestSet: checkLength(1, 32)},
+			{ID: targetAddrStorageType, Document: "snmpTargetAddrStorageType", Type: gosnmp.Integer,
+				DefVal: StorageTypeNonVolatile, OnTestSet: checkRange(StorageTypeOther, StorageTypeReadOnly)},
+			{ID: targetAddrRowStatus, Document: "snmpTargetAddrRowStatus", Type: gosnmp.Integer},
+		},
+		OnParseIndex: parseNameIndex(targetAddrName),
+	}
+}
+
+func newTargetParamsTable() *GoSNMPServer.RowStatusTable {
+	return &GoSNMPServer.RowStatusTable{
+		OID:             oidSnmpTargetParamsEntry,
+		Document:        "snmpTargetParamsTable",
+		RowStatusColumn: targetParamsRowStatus,
+		Columns: []*GoSNMPServer.RowStatusColumn{
+			{ID: targetParamsName, Document: "snmpTargetParamsName", Type: gosnmp.OctetString, NotAccessible: true},
+			{ID: targetParamsMPModel, Document: "snmpTargetParamsMPModel", Type: gosnmp.Integer,
+				OnTestSet: checkRange(0, 3)},
+			{ID: targetParamsSecurityModel, Document: "snmpTargetParamsSecurityModel", Type: gosnmp.Integer,
+				OnTestSet: checkRange(1, 3)},
+			{ID: targetParamsSecurityName, Document: "snmpTargetParamsSecurityName", Type: gosnmp.OctetString,
+				OnTestSet: checkLength(0, 255)},
+			{ID: targetParamsSecurityLevel, Document: "snmpTargetParamsSecurityLevel", Type: gosnmp.Integer,
+				OnTestSet: checkRange(1, 3)},
+			{ID: targetParamsStorageType, Document: "snmpTargetParamsStorageType", Type: gosnmp.Integer,
+				DefVal: StorageTypeNonVolatile, OnTestSet: checkRange(StorageTypeOther, StorageTypeReadOnly)},
+			{ID: targetParamsRowStatus, Document: "snmpTargetParamsRowStatus", Type: gosnmp.Integer},
+		},
+		OnParseIndex: parseNameIndex(targetParamsName),
+	}
+}
+
+// AddTargetAddr adds an active row into snmpTargetAddrTable
+func (t *MIB) AddTargetAddr(addr TargetAddr) error {
+	tDomain, tAddress, err := encodeTAddress(addr.Transport, addr.Address)
+	if err != nil {
+		return errors.WithMessagef(err, "TargetAddr %v", addr.Name)
+	}
+	values := map[int]interface{}{
+		targetAddrTDomain:  tDomain,
+		targetAddrTAddress: tAddress,
+		targetAddrTagList:  strings.Join(addr.TagList, " "),
+		targetAddrParams:   addr.Params,
+	}
+	if addr.Timeout != 0 {
+		values[targetAddrTimeout] = addr.Timeout
+	}
+	if addr.RetryCount != 0 {
+		values[targetAddrRetryCount] = addr.RetryCount
+	}
+	return t.targetAddrTable.AddRow(GoSNMPServer.TableIndexImpliedString(addr.Name), values)
+}
+
+// AddTargetParams adds an active row into snmpTargetParamsTable
+func (t *MIB) AddTargetParams(params TargetParams) error {
+	var mpModel, securityModel int
+	switch params.Version {
+	case gosnmp.Version1:
+		mpModel, securityModel = 0, 1
+	case gosnmp.Version2c:
+		mpModel, securityModel = 1, 2
+	case gosnmp.Version3:
+		mpModel, securityModel = 3, 3
+	default:
+		return errors.WithMessagef(GoSNMPServer.ErrUnsupportedProtoVersion, "TargetParams %v", params.Name)
+	}
+	if params.Version != gosnmp.Version3 && params.SecurityLevel&gosnmp.AuthPriv != gosnmp.NoAuthNoPriv {
+		return errors.Errorf("TargetParams %v: %v supports noAuthNoPriv only", params.Name, params.Version)
+	}
+	return t.targetParamsTable.AddRow(GoSNMPServer.TableIndexImpliedString(params.Name), map[int]interface{}{
+		targetParamsMPModel:       mpModel,
+		targetParamsSecurityModel: securityModel,
+		targetParamsSecurityName:  params.SecurityName,
+		targetParamsSecurityLevel: securityLevelToMIB(params.SecurityLevel),
+	})
+}
+
+// Targets resolves the active rows as snmpNotifyTable -> snmpTargetAddrTable -> snmpTargetParamsTable.
+//
+//	See RFC 3413 section 3.3
+func (t *MIB) Targets() []*GoSNMPServer.NotificationTarget {
+	toRet := []*GoSNMPServer.NotificationTarget{}
+	// each target is selected only once
+	selected := make(map[string]bool)
+	addrs := t.targetAddrTable.Rows()
+	for _, notify := range t.notifyTable.Rows() {
+		if notify.Status != GoSNMPServer.RowStatusActive {
+			continue
+		}
+		tag := notify.Values[notifyTag].(string)
+		inform := notify.Values[notifyType].(int) == notifyTypeInform
+		for _, addr := range addrs {
+			if addr.Status != GoSNMPServer.RowStatusActive || selected[addr.Index] ||
+				!hasTag(addr.Values[targetAddrTagList].(string), tag) {
+				continue
+			}
+			selected[addr.Index] = true
+			target, err := t.makeTarget(addr, inform)
+			if err != nil {
+				g_Logger.Debugf("%v", err)
+				continue
+			}
+			toRet = append(toRet, target)
+		}
+	}
+	return toRet
+}
+
+func (t *MIB) makeTarget(addr GoSNMPServer.RowStatusRow, inform bool) (*GoSNMPServer.NotificationTarget, error) {
+	name := addr.Values[targetAddrName].(string)
+	paramsName := addr.Values[targetAddrParams].(string)
+	params, ok := t.targetParamsTable.ActiveRow(GoSNMPServer.TableIndexImpliedString(paramsName))
+	if !ok {
+		return nil, errors.Errorf("snmpTargetAddrTable %v: no active params %v", name, paramsName)
+	}
+	transport, address, err := decodeTAddress(addr.Values[targetAddrTDomain].(string), addr.Values[targetAddrTAddress].(string))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "snmpTargetAddrTable %v", name)
+	}
+	target := &GoSNMPServer.NotificationTarget{
+		Name:      name,
+		Address:   address,
+		Transport: transport,
+		Inform:    inform,
+		Timeout:   time.Duration(addr.Values[targetAddrTimeout].(int)) * 10 * time.Millisecond,
+		Retries:   addr.Values[targetAddrRetryCount].(int),
+		Filter:    t.filterForParams(paramsName),
+	}
+	securityName := params.Values[targetParamsSecurityName].(string)
+	mpModel, securityModel := params.Values[targetParamsMPModel].(int), params.Values[targetParamsSecurityModel].(int)
+	switch {
+	case mpModel == 0 && securityModel == 1:
+		target.Version, target.Community = gosnmp.Version1, securityName
+	case mpModel == 1 && securityModel == 2:
+		target.Version, target.Community = gosnmp.Version2c, securityName
+	case mpModel == 3 && securityModel == 3:
+		target.Version, target.UserName = gosnmp.Version3, securityName
+	default:
+		return nil, errors.Errorf("snmpTargetParamsTable %v: not supported mpModel %v with securityModel %v",
+			paramsName, mpModel, securityModel)
+	}
+	target.SecurityLevel = securityLevelFromMIB(params.Values[targetParamsSecurityLevel].(int))
+	if target.Version != gosnmp.Version3 && target.SecurityLevel != gosnmp.NoAuthNoPriv {
+		return nil, errors.Errorf("snmpTargetParamsTable %v: %v supports noAuthNoPriv only", paramsName, target.Version)
+	}
+	return target, nil
+}
+
+func hasTag(tagList, tag string) bool {
+	for _, each := range strings.Fields(tagList) {
+		if each == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func checkTDomain(value interface{}) error {
+	switch value.(string) {
+	case OIDSnmpUDPDomain, OIDSnmpTCPDomain, OIDTransportDomainUDPIPv6, OIDTransportDomainTCPIPv6:
+		return nil
+	}
+	return errors.Errorf("not supported transport domain %v", value)
+}
+
+// encodeTAddress encodes "ip:port" as TAddress. See RFC 3417 and RFC 3419
+func encodeTAddress(transport, address string) (string, string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "not valid address %v", address)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", "", errors.Errorf("not valid ip %v", host)
+	}
+	portVal, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "not valid port of %v", address)
+	}
+	var tDomain string
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		tDomain = OIDSnmpUDPDomain
+		if transport == "tcp" {
+			tDomain = OIDSnmpTCPDomain
+		}
+	} else {
+		tDomain = OIDTransportDomainUDPIPv6
+		if transport == "tcp" {
+			tDomain = OIDTransportDomainTCPIPv6
+		}
+	}
+	if transport != "" && transport != "udp" && transport != "tcp" {
+		return "", "", errors.Errorf("not supported transport %v", transport)
+	}
+	out := make([]byte, len(ip)+2)
+	copy(out, ip)
+	binary.BigEndian.PutUint16(out[len(ip):], uint16(portVal))
+	return tDomain, string(out), nil
+}
+
+// decodeTAddress decodes TAddress as transport and "ip:port"
+func decodeTAddress(tDomain, tAddress string) (string, string, error) {
+	var transport string
+	var length int
+	switch tDomain {
+	case OIDSnmpUDPDomain:
+		transport, length = "udp", net.IPv4len
+	case OIDSnmpTCPDomain:
+		transport, length = "tcp", net.IPv4len
+	case OIDTransportDomainUDPIPv6:
+		transport, length = "udp", net.IPv6len
+	case OIDTransportDomainTCPIPv6:
+		transport, length = "tcp", net.IPv6len
+	default:
+		return "", "", errors.Errorf("not supported transport domain %v", tDomain)
+	}
+	if len(tAddress) != length+2 {
+		return "", "", errors.Errorf("not valid TAddress length %v for %v", len(tAddress), tDomain)
+	}
+	ip := net.IP([]byte(tAddress[:length]))
+	port := binary.BigEndian.Uint16([]byte(tAddress[length:]))
+	return transport, net.JoinHostPort(ip.String(), strconv.Itoa(int(port))), nil
+}
+
+func securityLevelToMIB(level gosnmp.SnmpV3MsgFlags) int {
+	switch level & gosnmp.AuthPriv {
+	case gosnmp.AuthPriv:
+		return 3
+	case gosnmp.AuthNoPriv:
+		return 2
+	default:
+		return 1
+	}
+}
+
+func securityLevelFromMIB(level int) gosnmp.SnmpV3MsgFlags {
+	switch level {
+	case 3:
+		return gosnmp.AuthPriv
+	case 2:
+		return gosnmp.AuthNoPriv
+	default:
+		return gosnmp.NoAuthNoPriv
+	}
+}
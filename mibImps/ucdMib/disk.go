@@ -69,7 +69,7 @@ func DiskUsageOIDs(showTheseNameOnly ...NameOverride) []*GoSNMPServer.PDUValueCo
 				OID:  fmt.Sprintf("1.3.6.1.4.1.2021.9.1.6.%d", cid),
 				Type: gosnmp.Integer,
 				OnGet: func() (value interface{}, err error) {
-					data, err := disk.Usage(currentDiskItem.RealPath)
+					data, err := diskUsage(currentDiskItem.RealPath)
 					if err != nil {
 						return nil, err
 					}
@@ -81,7 +81,7 @@ func DiskUsageOIDs(showTheseNameOnly ...NameOverride) []*GoSNMPServer.PDUValueCo
 				OID:  fmt.Sprintf("1.3.6.1.4.1.2021.9.1.7.%d", cid),
 				Type: gosnmp.Integer,
 				OnGet: func() (value interface{}, err error) {
-					data, err := disk.Usage(currentDiskItem.RealPath)
+					data, err := diskUsage(currentDiskItem.RealPath)
 					if err != nil {
 						return nil, err
 					}
@@ -93,7 +93,7 @@ func DiskUsageOIDs(showTheseNameOnly ...NameOverride) []*GoSNMPServer.PDUValueCo
 				OID:  fmt.Sprintf("1.3.6.1.4.1.2021.9.1.8.%d", cid),
 				Type: gosnmp.Integer,
 				OnGet: func() (value interface{}, err error) {
-					data, err := disk.Usage(currentDiskItem.RealPath)
+					data, err := diskUsage(currentDiskItem.RealPath)
 					if err != nil {
 						return nil, err
 					}
@@ -105,7 +105,7 @@ func DiskUsageOIDs(showTheseNameOnly ...NameOverride) []*GoSNMPServer.PDUValueCo
 				OID:  fmt.Sprintf("1.3.6.1.4.1.2021.9.1.9.%d", cid),
 				Type: gosnmp.Integer,
 				OnGet: func() (value interface{}, err error) {
-					data, err := disk.Usage(currentDiskItem.RealPath)
+					data, err := diskUsage(currentDiskItem.RealPath)
 					if err != nil {
 						return nil, err
 					}
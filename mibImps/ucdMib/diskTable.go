@@ -0,0 +1,90 @@
+package ucdMib
+
+import (
+	"github.com/gosnmp/gosnmp"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/slayercat/GoSNMPServer"
+)
+
+// diskRow is the data of a row in dskTable
+type diskRow struct {
+	NameOverride
+	index int
+}
+
+// DiskUsageTable Returns dskTable which reads the disks on each request.
+//
+//	Disks mounted or unmounted at runtime are visible without restart.
+//	It serves the same OIDs as DiskUsageOIDs, so use one of them only.
+//	Args:
+//	    showTheseNameOnly:  what path whill this table returns. empty means all mounted.
+//	see http://www.net-snmp.org/docs/mibs/ucdavis.html#DisplayString
+func DiskUsageTable(showTheseNameOnly ...NameOverride) *GoSNMPServer.Table {
+	usageColumn := func(id int, document string, get func(data *disk.UsageStat) int) *GoSNMPServer.TableColumn {
+		return &GoSNMPServer.TableColumn{
+			ID:   id,
+			Type: gosnmp.Integer,
+			OnGet: func(row GoSNMPServer.TableRow) (value interface{}, err error) {
+				data, err := diskUsage(row.Data.(*diskRow).RealPath)
+				if err != nil {
+					return nil, err
+				}
+				return GoSNMPServer.Asn1IntegerWrap(get(data)), nil
+			},
+			Document: document,
+		}
+	}
+	showNameColumn := func(id int, document string) *GoSNMPServer.TableColumn {
+		return &GoSNMPServer.TableColumn{
+			ID:   id,
+			Type: gosnmp.OctetString,
+			OnGet: func(row GoSNMPServer.TableRow) (value interface{}, err error) {
+				return GoSNMPServer.Asn1OctetStringWrap(row.Data.(*diskRow).ShowName), nil
+			},
+			Document: document,
+		}
+	}
+	return &GoSNMPServer.Table{
+		OID: "1.3.6.1.4.1.2021.9.1",
+		Columns: []*GoSNMPServer.TableColumn{
+			{
+				ID:   1,
+				Type: gosnmp.Integer,
+				OnGet: func(row GoSNMPServer.TableRow) (value interface{}, err error) {
+					return GoSNMPServer.Asn1IntegerWrap(row.Data.(*diskRow).index), nil
+				},
+				Document: "dskIndex",
+			},
+			showNameColumn(2, "currentDskPath"),
+			showNameColumn(3, "currentDskDevice"),
+			usageColumn(6, "currentDskTotal", func(data *disk.UsageStat) int { return int(data.Total / 1024 / 1024) }),
+			usageColumn(7, "currentDskAvail", func(data *disk.UsageStat) int { return int(data.Free / 1024 / 1024) }),
+			usageColumn(8, "currentDskUsed", func(data *disk.UsageStat) int { return int(data.Used / 1024 / 1024) }),
+			usageColumn(9, "currentDskPercent", func(data *disk.UsageStat) int { return int(data.UsedPercent) }),
+		},
+		OnGetRows: func() ([]GoSNMPServer.TableRow, error) {
+			disks := showTheseNameOnly
+			if len(disks) == 0 {
+				partitionStats, err := disk.Partitions(false)
+				if err != nil {
+					return nil, err
+				}
+				for _, val := range partitionStats {
+					disks = append(disks, NameOverride{
+						RealPath: val.Mountpoint,
+						ShowName: val.Mountpoint,
+					})
+				}
+			}
+			toRet := []GoSNMPServer.TableRow{}
+			for id, each := range disks {
+				toRet = append(toRet, GoSNMPServer.TableRow{
+					Index: GoSNMPServer.TableIndexInteger(id + 1),
+					Data:  &diskRow{NameOverride: each, index: id + 1},
+				})
+			}
+			return toRet, nil
+		},
+		Document: "dskTable",
+	}
+}
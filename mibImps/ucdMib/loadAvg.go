@@ -4,7 +4,6 @@ import (
 	"fmt"
 
 	"github.com/gosnmp/gosnmp"
-	"github.com/shirou/gopsutil/v3/load"
 	"github.com/slayercat/GoSNMPServer"
 )
 
@@ -29,7 +28,7 @@ func SystemLoadOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.10.1.3.1",
 			Type: gosnmp.OctetString,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := load.Avg(); err != nil {
+				if val, err := loadAvg(); err != nil {
 					return nil, err
 				} else {
 					return GoSNMPServer.Asn1OctetStringWrap(fmt.Sprintf("%v", val.Load1)), nil
@@ -41,7 +40,7 @@ func SystemLoadOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.10.1.5.1",
 			Type: gosnmp.Integer,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := load.Avg(); err != nil {
+				if val, err := loadAvg(); err != nil {
 					return nil, err
 				} else {
 					return GoSNMPServer.Asn1IntegerWrap(int(val.Load1 * 100)), nil
@@ -66,7 +65,7 @@ func SystemLoadOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.10.1.3.2",
 			Type: gosnmp.OctetString,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := load.Avg(); err != nil {
+				if val, err := loadAvg(); err != nil {
 					return nil, err
 				} else {
 					return GoSNMPServer.Asn1OctetStringWrap(fmt.Sprintf("%v", val.Load5)), nil
@@ -78,7 +77,7 @@ func SystemLoadOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.10.1.5.2",
 			Type: gosnmp.Integer,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := load.Avg(); err != nil {
+				if val, err := loadAvg(); err != nil {
 					return nil, err
 				} else {
 					return GoSNMPServer.Asn1IntegerWrap(int(val.Load5 * 100)), nil
@@ -103,7 +102,7 @@ func SystemLoadOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.10.1.3.3",
 			Type: gosnmp.OctetString,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := load.Avg(); err != nil {
+				if val, err := loadAvg(); err != nil {
 					return nil, err
 				} else {
 					return GoSNMPServer.Asn1OctetStringWrap(fmt.Sprintf("%v", val.Load15)), nil
@@ -115,7 +114,7 @@ func SystemLoadOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.10.1.5.3",
 			Type: gosnmp.Integer,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := load.Avg(); err != nil {
+				if val, err := loadAvg(); err != nil {
 					return nil, err
 				} else {
 					return GoSNMPServer.Asn1IntegerWrap(int(val.Load15 * 100)), nil
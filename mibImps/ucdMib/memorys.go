@@ -2,7 +2,6 @@ package ucdMib
 
 import (
 	"github.com/gosnmp/gosnmp"
-	"github.com/shirou/gopsutil/v3/mem"
 	"github.com/slayercat/GoSNMPServer"
 )
 
@@ -27,7 +26,7 @@ func MemoryOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.4.3",
 			Type: gosnmp.Integer,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := mem.SwapMemory(); err == nil {
+				if val, err := swapMemory(); err == nil {
 					return GoSNMPServer.Asn1IntegerWrap(int(val.Total / 1024)), nil
 				} else {
 					return nil, err
@@ -39,7 +38,7 @@ func MemoryOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.4.4",
 			Type: gosnmp.Integer,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := mem.SwapMemory(); err == nil {
+				if val, err := swapMemory(); err == nil {
 					return GoSNMPServer.Asn1IntegerWrap(int(val.Free / 1024)), nil
 				} else {
 					return nil, err
@@ -51,7 +50,7 @@ func MemoryOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.4.5",
 			Type: gosnmp.Integer,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := mem.VirtualMemory(); err == nil {
+				if val, err := virtualMemory(); err == nil {
 					return GoSNMPServer.Asn1IntegerWrap(int(val.Total / 1024)), nil
 				} else {
 					return nil, err
@@ -63,7 +62,7 @@ func MemoryOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.4.6",
 			Type: gosnmp.Integer,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := mem.VirtualMemory(); err == nil {
+				if val, err := virtualMemory(); err == nil {
 					return GoSNMPServer.Asn1IntegerWrap(int(val.Available / 1024)), nil
 				} else {
 					return nil, err
@@ -75,8 +74,8 @@ func MemoryOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.4.11",
 			Type: gosnmp.Integer,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := mem.VirtualMemory(); err == nil {
-					if valSwap, errSwap := mem.SwapMemory(); errSwap == nil {
+				if val, err := virtualMemory(); err == nil {
+					if valSwap, errSwap := swapMemory(); errSwap == nil {
 						return GoSNMPServer.Asn1IntegerWrap(int((val.Available + valSwap.Free) / 1024)), nil
 					} else {
 						return nil, errSwap
@@ -97,7 +96,7 @@ func MemoryOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.4.14",
 			Type: gosnmp.Integer,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := mem.VirtualMemory(); err == nil {
+				if val, err := virtualMemory(); err == nil {
 					return GoSNMPServer.Asn1IntegerWrap(int(val.Buffers / 1024)), nil
 				} else {
 					return nil, err
@@ -109,7 +108,7 @@ func MemoryOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.4.15",
 			Type: gosnmp.Integer,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := mem.VirtualMemory(); err == nil {
+				if val, err := virtualMemory(); err == nil {
 					return GoSNMPServer.Asn1IntegerWrap(int(val.Cached / 1024)), nil
 				} else {
 					return nil, err
@@ -0,0 +1,132 @@
+package ucdMib
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/procfs"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/slayercat/GoSNMPServer"
+)
+
+// DefaultCacheTTL is how long a read of the system is shared by the columns of this mib
+const DefaultCacheTTL = time.Second
+
+// snapshots of the system shared by all the items of this mib, so that a walk reads each source once
+var (
+	virtualMemorySnapshot = GoSNMPServer.NewSnapshot(DefaultCacheTTL, func() (interface{}, error) { return mem.VirtualMemory() })
+	swapMemorySnapshot    = GoSNMPServer.NewSnapshot(DefaultCacheTTL, func() (interface{}, error) { return mem.SwapMemory() })
+	loadAvgSnapshot       = GoSNMPServer.NewSnapshot(DefaultCacheTTL, func() (interface{}, error) { return load.Avg() })
+	cpuTimesSnapshot      = GoSNMPServer.NewSnapshot(DefaultCacheTTL, func() (interface{}, error) { return cpu.Times(false) })
+	ioCountersSnapshot    = GoSNMPServer.NewSnapshot(DefaultCacheTTL, func() (interface{}, error) { return disk.IOCounters() })
+	procStatSnapshot      = GoSNMPServer.NewSnapshot(DefaultCacheTTL, func() (interface{}, error) {
+		fs, err := procfs.NewDefaultFS()
+		if err != nil {
+			return nil, err
+		}
+		return fs.NewStat()
+	})
+
+	g_CacheTTL           = DefaultCacheTTL
+	g_DiskUsageSnapshots = map[string]*GoSNMPServer.Snapshot{}
+	g_SnapshotsMutex     sync.Mutex
+)
+
+func allSnapshots() []*GoSNMPServer.Snapshot {
+	g_SnapshotsMutex.Lock()
+	defer g_SnapshotsMutex.Unlock()
+	ret := []*GoSNMPServer.Snapshot{virtualMemorySnapshot, swapMemorySnapshot, loadAvgSnapshot,
+		cpuTimesSnapshot, ioCountersSnapshot, procStatSnapshot}
+	for _, each := range g_DiskUsageSnapshots {
+		ret = append(ret, each)
+	}
+	return ret
+}
+
+// SetCacheTTL Setups how long a read of the system is shared. 0 to read on every get
+func SetCacheTTL(ttl time.Duration) {
+	g_SnapshotsMutex.Lock()
+	g_CacheTTL = ttl
+	g_SnapshotsMutex.Unlock()
+	for _, each := range allSnapshots() {
+		each.SetTTL(ttl)
+	}
+}
+
+// CacheStats returns the sum of hits and misses of reads of the system by this mib
+func CacheStats() GoSNMPServer.CacheStats {
+	var ret GoSNMPServer.CacheStats
+	for _, each := range allSnapshots() {
+		stats := each.Stats()
+		ret.Hits += stats.Hits
+		ret.Misses += stats.Misses
+	}
+	return ret
+}
+
+func virtualMemory() (*mem.VirtualMemoryStat, error) {
+	val, err := virtualMemorySnapshot.Get()
+	if err != nil {
+		return nil, err
+	}
+	return val.(*mem.VirtualMemoryStat), nil
+}
+
+func swapMemory() (*mem.SwapMemoryStat, error) {
+	val, err := swapMemorySnapshot.Get()
+	if err != nil {
+		return nil, err
+	}
+	return val.(*mem.SwapMemoryStat), nil
+}
+
+func loadAvg() (*load.AvgStat, error) {
+	val, err := loadAvgSnapshot.Get()
+	if err != nil {
+		return nil, err
+	}
+	return val.(*load.AvgStat), nil
+}
+
+func cpuTimes() ([]cpu.TimesStat, error) {
+	val, err := cpuTimesSnapshot.Get()
+	if err != nil {
+		return nil, err
+	}
+	return val.([]cpu.TimesStat), nil
+}
+
+func ioCounters() (map[string]disk.IOCountersStat, error) {
+	val, err := ioCountersSnapshot.Get()
+	if err != nil {
+		return nil, err
+	}
+	return val.(map[string]disk.IOCountersStat), nil
+}
+
+func procStat() (procfs.Stat, error) {
+	val, err := procStatSnapshot.Get()
+	if err != nil {
+		return procfs.Stat{}, err
+	}
+	return val.(procfs.Stat), nil
+}
+
+// diskUsage returns the usage of path, shared by the columns of its row
+func diskUsage(path string) (*disk.UsageStat, error) {
+	g_SnapshotsMutex.Lock()
+	snapshot, ok := g_DiskUsageSnapshots[path]
+	if !ok {
+		snapshot = GoSNMPServer.NewSnapshot(g_CacheTTL, func() (interface{}, error) { return disk.Usage(path) })
+		g_DiskUsageSnapshots[path] = snapshot
+	}
+	g_SnapshotsMutex.Unlock()
+	val, err := snapshot.Get()
+	if err != nil {
+		return nil, err
+	}
+	return val.(*disk.UsageStat), nil
+}
@@ -3,8 +3,6 @@ package ucdMib
 import (
 	"github.com/gosnmp/gosnmp"
 	"github.com/prometheus/procfs"
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/disk"
 	"github.com/slayercat/GoSNMPServer"
 )
 
@@ -29,7 +27,7 @@ func SystemStatsOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.11.50",
 			Type: gosnmp.Counter32,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := cpu.Times(false); err == nil {
+				if val, err := cpuTimes(); err == nil {
 					return GoSNMPServer.Asn1Counter32Wrap(uint(val[0].User)), nil
 				} else {
 					return nil, err
@@ -41,7 +39,7 @@ func SystemStatsOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.11.51",
 			Type: gosnmp.Counter32,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := cpu.Times(false); err == nil {
+				if val, err := cpuTimes(); err == nil {
 					return GoSNMPServer.Asn1Counter32Wrap(uint(val[0].Nice)), nil
 				} else {
 					return nil, err
@@ -53,7 +51,7 @@ func SystemStatsOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.11.52",
 			Type: gosnmp.Counter32,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := cpu.Times(false); err == nil {
+				if val, err := cpuTimes(); err == nil {
 					return GoSNMPServer.Asn1Counter32Wrap(uint(val[0].System)), nil
 				} else {
 					return nil, err
@@ -65,7 +63,7 @@ func SystemStatsOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.11.53",
 			Type: gosnmp.Counter32,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := cpu.Times(false); err == nil {
+				if val, err := cpuTimes(); err == nil {
 					return GoSNMPServer.Asn1Counter32Wrap(uint(val[0].Idle)), nil
 				} else {
 					return nil, err
@@ -77,7 +75,7 @@ func SystemStatsOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.11.54",
 			Type: gosnmp.Counter32,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := cpu.Times(false); err == nil {
+				if val, err := cpuTimes(); err == nil {
 					return GoSNMPServer.Asn1Counter32Wrap(uint(val[0].Iowait)), nil
 				} else {
 					return nil, err
@@ -89,7 +87,7 @@ func SystemStatsOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.11.56",
 			Type: gosnmp.Counter32,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := cpu.Times(false); err == nil {
+				if val, err := cpuTimes(); err == nil {
 					return GoSNMPServer.Asn1Counter32Wrap(uint(val[0].Irq)), nil
 				} else {
 					return nil, err
@@ -101,7 +99,7 @@ func SystemStatsOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.11.57",
 			Type: gosnmp.Counter32,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := disk.IOCounters(); err == nil {
+				if val, err := ioCounters(); err == nil {
 					var sum uint64
 					for _, value := range val {
 						sum += value.WriteCount
@@ -117,7 +115,7 @@ func SystemStatsOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.11.58",
 			Type: gosnmp.Counter32,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := disk.IOCounters(); err == nil {
+				if val, err := ioCounters(); err == nil {
 					var sum uint64
 					for _, value := range val {
 						sum += value.ReadCount
@@ -133,7 +131,7 @@ func SystemStatsOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.11.61",
 			Type: gosnmp.Counter32,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := cpu.Times(false); err == nil {
+				if val, err := cpuTimes(); err == nil {
 					return GoSNMPServer.Asn1Counter32Wrap(uint(val[0].Softirq)), nil
 				} else {
 					return nil, err
@@ -145,7 +143,7 @@ func SystemStatsOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.11.64",
 			Type: gosnmp.Counter32,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := cpu.Times(false); err == nil {
+				if val, err := cpuTimes(); err == nil {
 					return GoSNMPServer.Asn1Counter32Wrap(uint(val[0].Steal)), nil
 				} else {
 					return nil, err
@@ -157,7 +155,7 @@ func SystemStatsOIDs() []*GoSNMPServer.PDUValueControlItem {
 			OID:  "1.3.6.1.4.1.2021.11.65",
 			Type: gosnmp.Counter32,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := cpu.Times(false); err == nil {
+				if val, err := cpuTimes(); err == nil {
 					return GoSNMPServer.Asn1Counter32Wrap(uint(val[0].Guest)), nil
 				} else {
 					return nil, err
@@ -171,8 +169,7 @@ func SystemStatsOIDs() []*GoSNMPServer.PDUValueControlItem {
 	return toRet
 }
 func appendLinuxPlatformSystemStats(io *[]*GoSNMPServer.PDUValueControlItem) {
-	procfsInt, err := procfs.NewDefaultFS()
-	if err != nil {
+	if _, err := procfs.NewDefaultFS(); err != nil {
 		return
 	}
 	toAppend := []*GoSNMPServer.PDUValueControlItem{
@@ -180,7 +177,7 @@ func appendLinuxPlatformSystemStats(io *[]*GoSNMPServer.PDUValueControlItem) {
 			OID:  "1.3.6.1.4.1.2021.11.59",
 			Type: gosnmp.Counter32,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := procfsInt.NewStat(); err == nil {
+				if val, err := procStat(); err == nil {
 					return GoSNMPServer.Asn1Counter32Wrap(uint(val.IRQTotal)), nil
 				} else {
 					return nil, err
@@ -192,7 +189,7 @@ func appendLinuxPlatformSystemStats(io *[]*GoSNMPServer.PDUValueControlItem) {
 			OID:  "1.3.6.1.4.1.2021.11.60",
 			Type: gosnmp.Counter32,
 			OnGet: func() (value interface{}, err error) {
-				if val, err := procfsInt.NewStat(); err == nil {
+				if val, err := procStat(); err == nil {
 					return GoSNMPServer.Asn1Counter32Wrap(uint(val.ContextSwitches)), nil
 				} else {
 					return nil, err
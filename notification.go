@@ -0,0 +1,376 @@
+package GoSNMPServer
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+)
+
+// OID of sysUpTime.0 and snmpTrapOID.0. See RFC 3416 section 4.2.6
+const (
+	OIDSysUpTime   = "1.3.6.1.2.1.1.3.0"
+	OIDSnmpTrapOID = "1.3.6.1.6.3.1.1.4.1.0"
+	// oidSnmpTraps is the prefix of generic traps, coldStart(1) .. egpNeighborLoss(6)
+	oidSnmpTraps = "1.3.6.1.6.3.1.1.5"
+)
+
+const defaultNotificationPort = 162
+const defaultNotificationTimeout = time.Second
+
+// NotificationTarget describes where and how to send a notification.
+type NotificationTarget struct {
+	// Name identifys this target in logs and results.
+	Name string
+	// Address is the "host:port" of the notification receiver. port 162 is used if missing.
+	Address string
+	// Transport is "udp" or "tcp". default "udp"
+	Transport string
+
+	// Version is the SNMP version to send with.
+	Version gosnmp.SnmpVersion
+	// Community is used for SNMPV1 / SNMPV2c
+	Community string
+	// UserName selects the USM user in SecurityConfig.Users for SNMPV3
+	UserName string
+	// ContextName is the SNMPV3 contextName
+	ContextName string
+	// SecurityLevel of SNMPV3 messages: gosnmp.NoAuthNoPriv / AuthNoPriv / AuthPriv.
+	//               It should not be above the level of the user. NoAuthNoPriv only for SNMPV1 / SNMPV2c
+	SecurityLevel gosnmp.SnmpV3MsgFlags
+
+	// Inform sends InformRequest instead of trap. (SNMPV2c / SNMPV3 only)
+	Inform bool
+	// Timeout for each try of an inform. default 1 second
+	Timeout time.Duration
+	// Retries of an unacknowledged inform
+	Retries int
+	// NoBackoff disables doubling the timeout on each retry
+	NoBackoff bool
+
+	// Filter decides if a notification should be sent to this target. set to nil to send all.
+	Filter FuncNotificationFilter
+}
+
+// FuncNotificationFilter returns true if the notification should be sent
+type FuncNotificationFilter func(notification Notification) bool
+
+// FuncGetNotificationTargets returns targets in addition to NotificationOriginator.Targets
+type FuncGetNotificationTargets func() []*NotificationTarget
+
+// Notification is a SNMPV2 style notification.
+//
+//	sysUpTime.0 and snmpTrapOID.0 will be prepended automaticly.
+//	For SNMPV1 targets it will be translated as RFC 3584 section 3.2.
+type Notification struct {
+	// TrapOID is the value of snmpTrapOID.0. eg 1.3.6.1.6.3.1.1.5.3 for linkDown
+	TrapOID string
+	// Variables to send with.
+	Variables []gosnmp.SnmpPDU
+	// Enterprise overrides the enterprise of SNMPV1 traps.
+	Enterprise string
+}
+
+// NotificationResult is the result of sending to a single target.
+type NotificationResult struct {
+	Target *NotificationTarget
+	// Acknowledged is true if the inform receives its response.
+	//      Traps will never be acknowledged.
+	Acknowledged bool
+	Err          error
+}
+
+// NotificationOriginator sends notifications to targets, RFC 3413 section 3.2
+type NotificationOriginator struct {
+	Targets []*NotificationTarget
+
+	// OnGetTargets will be called on every Send for more targets. eg. from SNMP-TARGET-MIB
+	OnGetTargets FuncGetNotificationTargets
+
+	master *MasterAgent
+}
+
+func (t *NotificationOriginator) syncConfig(master *MasterAgent) error {
+	t.master = master
+	for _, each := range t.Targets {
+		if err := t.checkTarget(each); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *NotificationOriginator) checkTarget(target *NotificationTarget) error {
+	if target == nil {
+		return errors.New("NotificationTarget is nil")
+	}
+	if _, _, err := splitNotificationAddress(target.Address); err != nil {
+		return errors.WithMessagef(err, "NotificationTarget %v", target.Name)
+	}
+	if target.Version != gosnmp.Version3 && target.SecurityLevel&gosnmp.AuthPriv != gosnmp.NoAuthNoPriv {
+		return errors.Errorf("NotificationTarget %v: %v supports noAuthNoPriv only", target.Name, target.Version)
+	}
+	switch target.Version {
+	case gosnmp.Version1:
+		if target.Inform {
+			return errors.Errorf("NotificationTarget %v: SNMPV1 does not support inform", target.Name)
+		}
+	case gosnmp.Version2c:
+	case gosnmp.Version3:
+		if t.master == nil {
+			break
+		}
+		usm := t.master.SecurityConfig.FindForUser(target.UserName)
+		if usm == nil {
+			return errors.Errorf("NotificationTarget %v: unknown user %v", target.Name, target.UserName)
+		}
+		if getUserSecurityLevel(usm) < target.SecurityLevel&gosnmp.AuthPriv {
+			return errors.Errorf("NotificationTarget %v: security level of user %v is below %v",
+				target.Name, target.UserName, target.SecurityLevel&gosnmp.AuthPriv)
+		}
+	default:
+		return errors.WithMessagef(ErrUnsupportedProtoVersion, "NotificationTarget %v", target.Name)
+	}
+	return nil
+}
+
+// Send sends the notification to all targets at the same time
+//
+//	and waits for all informs to be acknowledged or timeout.
+//	Targets whose Filter rejects the notification are skipped.
+func (t *NotificationOriginator) Send(notification Notification) []NotificationResult {
+	targets := []*NotificationTarget{}
+	allTargets := t.Targets
+	if t.OnGetTargets != nil {
+		allTargets = append(append([]*NotificationTarget{}, t.Targets...), t.OnGetTargets()...)
+	}
+	for _, each := range allTargets {
+		if each.Filter != nil && !each.Filter(notification) {
+			continue
+		}
+		targets = append(targets, each)
+	}
+	results := make([]NotificationResult, len(targets))
+	var wg sync.WaitGroup
+	for id, each := range targets {
+		wg.Add(1)
+		go func(id int, target *NotificationTarget) {
+			defer wg.Done()
+			results[id] = t.SendTo(target, notification)
+		}(id, each)
+	}
+	wg.Wait()
+	return results
+}
+
+// SendTo sends the notification to one target
+func (t *NotificationOriginator) SendTo(target *NotificationTarget, notification Notification) (result NotificationResult) {
+	result.Target = target
+	if t.master == nil {
+		result.Err = errors.New("NotificationOriginator is not ready. call MasterAgent.ReadyForWork first")
+		return
+	}
+	if err := t.checkTarget(target); err != nil {
+		result.Err = err
+		return
+	}
+	trapArcs, err := parseOID(notification.TrapOID)
+	if err == nil && len(trapArcs) < 2 {
+		// makeTrap splits the last arc of SNMPV1 traps
+		err = errors.Errorf("%q: at least two arcs are required", notification.TrapOID)
+	}
+	if err != nil {
+		result.Err = errors.WithMessagef(err, "Notification TrapOID")
+		return
+	}
+	if err := t.checkNotifyView(target, notification); err != nil {
+		result.Err = err
+		return
+	}
+	client, err := t.newClient(target)
+	if err != nil {
+		result.Err = err
+		return
+	}
+	defer client.Conn.Close()
+
+	trap := t.makeTrap(client, target, notification)
+	t.master.Logger.Debugf("NotificationOriginator: send %v to %v(%v) inform=%v",
+		notification.TrapOID, target.Name, target.Address, trap.IsInform)
+	response, err := client.SendTrap(trap)
+	if err != nil {
+		result.Err = errors.Wrapf(err, "send notification to %v", target.Address)
+		return
+	}
+	if trap.IsInform {
+		if response == nil || response.PDUType != gosnmp.GetResponse {
+			result.Err = errors.Errorf("inform to %v got no response", target.Address)
+			return
+		}
+		if response.Error != gosnmp.NoError {
+			result.Err = errors.Errorf("inform to %v got error %v", target.Address, response.Error)
+			return
+		}
+		result.Acknowledged = true
+	}
+	return
+}
+
+// checkNotifyView checks the notification against the VACM notify view of the target
+func (t *NotificationOriginator) checkNotifyView(target *NotificationTarget, notification Notification) error {
+	if t.master.VACM == nil {
+		return nil
+	}
+	securityModel := VACMSecurityModelUSM
+	securityName := target.UserName
+	securityLevel := gosnmp.NoAuthNoPriv
+	switch target.Version {
+	case gosnmp.Version1:
+		securityModel, securityName = VACMSecurityModelSNMPv1, target.Community
+	case gosnmp.Version2c:
+		securityModel, securityName = VACMSecurityModelSNMPv2c, target.Community
+	default:
+		securityLevel = target.SecurityLevel & gosnmp.AuthPriv
+	}
+	view, err := t.master.VACM.view(securityModel, securityName, securityLevel, target.ContextName, VACMViewNotify)
+	if err != nil {
+		return err
+	}
+	if !view.contains(notification.TrapOID) {
+		return errors.WithMessagef(ErrNoPermission, "VACM: notInView %v", notification.TrapOID)
+	}
+	for _, each := range notification.Variables {
+		if !view.contains(each.Name) {
+			return errors.WithMessagef(ErrNoPermission, "VACM: notInView %v", each.Name)
+		}
+	}
+	return nil
+}
+
+func (t *NotificationOriginator) newClient(target *NotificationTarget) (*gosnmp.GoSNMP, error) {
+	host, port, err := splitNotificationAddress(target.Address)
+	if err != nil {
+		return nil, err
+	}
+	timeout := target.Timeout
+	if timeout == 0 {
+		timeout = defaultNotificationTimeout
+	}
+	client := &gosnmp.GoSNMP{
+		Target:             host,
+		Port:               port,
+		Transport:          target.Transport,
+		Version:            target.Version,
+		Community:          target.Community,
+		ContextName:        target.ContextName,
+		Timeout:            timeout,
+		Retries:            target.Retries,
+		ExponentialTimeout: !target.NoBackoff,
+		Logger:             gosnmp.NewLogger(&SnmpLoggerAdapter{t.master.Logger}),
+	}
+	if target.Version == gosnmp.Version3 {
+		usm, err := t.master.getUsmSecurityParametersFromUser(target.UserName)
+		if err != nil {
+			return nil, err
+		}
+		if target.Inform {
+			// The receiver of an inform is authoritative. Leave it empty for discovery.
+			usm.AuthoritativeEngineID = ""
+			usm.AuthoritativeEngineBoots = 0
+			usm.AuthoritativeEngineTime = 0
+		}
+		client.SecurityModel = gosnmp.UserSecurityModel
+		client.MsgFlags = target.SecurityLevel & gosnmp.AuthPriv
+		// keys above the level are dropped, as the level of the user is checked by checkTarget
+		if client.MsgFlags != gosnmp.AuthPriv {
+			usm.PrivacyProtocol, usm.PrivacyPassphrase, usm.PrivacyKey = gosnmp.NoPriv, "", nil
+		}
+		if client.MsgFlags == gosnmp.NoAuthNoPriv {
+			usm.AuthenticationProtocol, usm.AuthenticationPassphrase, usm.SecretKey = gosnmp.NoAuth, "", nil
+		}
+		client.SecurityParameters = usm
+	}
+	if err := client.Connect(); err != nil {
+		return nil, errors.Wrapf(err, "connect to %v", target.Address)
+	}
+	return client, nil
+}
+
+func (t *NotificationOriginator) makeTrap(client *gosnmp.GoSNMP, target *NotificationTarget,
+	notification Notification) gosnmp.SnmpTrap {
+	_, engineTime := t.master.engineBootsAndTime()
+	sysUpTime := engineTime * 100
+	if target.Version == gosnmp.Version1 {
+		trap := gosnmp.SnmpTrap{
+			Variables:  notification.Variables,
+			Enterprise: notification.Enterprise,
+			Timestamp:  uint(sysUpTime),
+		}
+		trapOID := strings.TrimPrefix(notification.TrapOID, ".")
+		lastDot := strings.LastIndex(trapOID, ".")
+		prefix, last := trapOID[:lastDot], trapOID[lastDot+1:]
+		specific, _ := strconv.Atoi(last)
+		if prefix == oidSnmpTraps && specific >= 1 && specific <= 6 {
+			trap.GenericTrap = specific - 1
+			if trap.Enterprise == "" {
+				trap.Enterprise = oidSnmpTraps
+			}
+		} else {
+			trap.GenericTrap = 6 // enterpriseSpecific
+			trap.SpecificTrap = specific
+			if trap.Enterprise == "" {
+				trap.Enterprise = strings.TrimSuffix(prefix, ".0")
+			}
+		}
+		trap.AgentAddress = "0.0.0.0"
+		if local, ok := client.Conn.LocalAddr().(*net.UDPAddr); ok && local.IP.To4() != nil {
+			trap.AgentAddress = local.IP.String()
+		}
+		return trap
+	}
+	variables := []gosnmp.SnmpPDU{
+		{Name: OIDSysUpTime, Type: gosnmp.TimeTicks, Value: sysUpTime},
+		{Name: OIDSnmpTrapOID, Type: gosnmp.ObjectIdentifier, Value: notification.TrapOID},
+	}
+	variables = append(variables, notification.Variables...)
+	return gosnmp.SnmpTrap{
+		Variables: variables,
+		IsInform:  target.Inform,
+	}
+}
+
+func splitNotificationAddress(address string) (string, uint16, error) {
+	return splitAddress(address, defaultNotificationPort)
+}
+
+// splitAddress splits "host:port". defaultPort is used if missing
+func splitAddress(address string, defaultPort uint16) (string, uint16, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		// no port. use the default
+		if strings.Contains(err.Error(), "missing port") {
+			return strings.Trim(address, "[]"), defaultPort, nil
+		}
+		return "", 0, errors.Wrapf(err, "not valid address %v", address)
+	}
+	val, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "not valid port of %v", address)
+	}
+	return host, uint16(val), nil
+}
+
+// getUserSecurityLevel returns the security level configured for a USM user
+func getUserSecurityLevel(usm *gosnmp.UsmSecurityParameters) gosnmp.SnmpV3MsgFlags {
+	if usm == nil || usm.AuthenticationProtocol <= gosnmp.NoAuth {
+		return gosnmp.NoAuthNoPriv
+	}
+	if usm.PrivacyProtocol <= gosnmp.NoPriv {
+		return gosnmp.AuthNoPriv
+	}
+	return gosnmp.AuthPriv
+}
@@ -0,0 +1,253 @@
+package GoSNMPServer
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type NotificationTests struct {
+	suite.Suite
+	Logger ILogger
+
+	receiver *SNMPServer
+	received chan gosnmp.SnmpPDU
+}
+
+func (suite *NotificationTests) getUsers() []gosnmp.UsmSecurityParameters {
+	return []gosnmp.UsmSecurityParameters{
+		{
+			UserName:                 "testuser",
+			AuthenticationProtocol:   gosnmp.MD5,
+			PrivacyProtocol:          gosnmp.DES,
+			AuthenticationPassphrase: "testauth",
+			PrivacyPassphrase:        "testpriv",
+		},
+	}
+}
+
+func (suite *NotificationTests) SetupTest() {
+	logger := NewDefaultLogger()
+	logger.(*DefaultLogger).Level = logrus.InfoLevel
+	suite.Logger = logger
+	suite.received = make(chan gosnmp.SnmpPDU, 16)
+
+	onTrap := func(isInform bool, trapdata gosnmp.SnmpPDU) (dataret interface{}, err error) {
+		suite.received <- trapdata
+		return trapdata.Value, nil
+	}
+	master := MasterAgent{
+		Logger: suite.Logger,
+		SecurityConfig: SecurityConfig{
+			AuthoritativeEngineBoots: 1,
+			AuthoritativeEngineID:    SNMPEngineID{EngineIDData: "receiver"},
+			Users:                    suite.getUsers(),
+		},
+		SubAgents: []*SubAgent{
+			{
+				CommunityIDs: []string{"public"},
+				ContextNames: []string{"public"},
+				OIDs: []*PDUValueControlItem{
+					{OID: OIDSysUpTime, Type: gosnmp.TimeTicks, OnTrap: onTrap},
+					{OID: OIDSnmpTrapOID, Type: gosnmp.ObjectIdentifier, OnTrap: onTrap},
+					{OID: "1.3.6.1.4.1.9999.1.0", Type: gosnmp.OctetString, OnTrap: onTrap},
+				},
+			},
+		},
+	}
+	suite.receiver = NewSNMPServer(master)
+	if err := suite.receiver.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	go suite.receiver.ServeForever()
+}
+
+func (suite *NotificationTests) TearDownTest() {
+	suite.receiver.Shutdown()
+}
+
+func (suite *NotificationTests) getOriginator(targets ...*NotificationTarget) *NotificationOriginator {
+	master := MasterAgent{
+		Logger: suite.Logger,
+		SecurityConfig: SecurityConfig{
+			AuthoritativeEngineBoots: 1,
+			AuthoritativeEngineID:    SNMPEngineID{EngineIDData: "originator"},
+			Users:                    suite.getUsers(),
+		},
+		NotificationOriginator: NotificationOriginator{Targets: targets},
+		SubAgents:              []*SubAgent{{}},
+	}
+	if err := master.ReadyForWork(); err != nil {
+		panic(err)
+	}
+	return &master.NotificationOriginator
+}
+
+// waitFor waits for a varbind with name received by the receiver
+func (suite *NotificationTests) waitFor(name string) *gosnmp.SnmpPDU {
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case val := <-suite.received:
+			if val.Name == "."+name {
+				return &val
+			}
+		case <-timeout:
+			return nil
+		}
+	}
+}
+
+func (suite *NotificationTests) getNotification() Notification {
+	return Notification{
+		TrapOID: "1.3.6.1.4.1.9999.0.1",
+		Variables: []gosnmp.SnmpPDU{
+			{Name: "1.3.6.1.4.1.9999.1.0", Type: gosnmp.OctetString, Value: "hello"},
+		},
+	}
+}
+
+func (suite *NotificationTests) TestSendTraps() {
+	address := suite.receiver.Address().String()
+	for _, target := range []*NotificationTarget{
+		{Name: "v1", Address: address, Version: gosnmp.Version1, Community: "public"},
+		{Name: "v2c", Address: address, Version: gosnmp.Version2c, Community: "public"},
+		{Name: "v3", Address: address, Version: gosnmp.Version3, UserName: "testuser", ContextName: "public",
+			SecurityLevel: gosnmp.AuthPriv},
+	} {
+		suite.Run(target.Name, func() {
+			originator := suite.getOriginator(target)
+			results := originator.Send(suite.getNotification())
+			assert.Equal(suite.T(), 1, len(results))
+			assert.Nil(suite.T(), results[0].Err)
+			assert.False(suite.T(), results[0].Acknowledged)
+			if target.Version != gosnmp.Version1 {
+				trapOID := suite.waitFor(OIDSnmpTrapOID)
+				if assert.NotNil(suite.T(), trapOID) {
+					assert.Equal(suite.T(), ".1.3.6.1.4.1.9999.0.1", trapOID.Value)
+				}
+			}
+			val := suite.waitFor("1.3.6.1.4.1.9999.1.0")
+			if assert.NotNil(suite.T(), val) {
+				assert.Equal(suite.T(), "hello", Asn1OctetStringUnwrap(val.Value))
+			}
+		})
+	}
+}
+
+func (suite *NotificationTests) TestSendInforms() {
+	address := suite.receiver.Address().String()
+	for _, target := range []*NotificationTarget{
+		{Name: "v2c", Address: address, Version: gosnmp.Version2c, Community: "public", Inform: true},
+		{Name: "v3", Address: address, Version: gosnmp.Version3, UserName: "testuser", ContextName: "public",
+			SecurityLevel: gosnmp.AuthPriv, Inform: true},
+	} {
+		suite.Run(target.Name, func() {
+			originator := suite.getOriginator(target)
+			results := originator.Send(suite.getNotification())
+			assert.Equal(suite.T(), 1, len(results))
+			assert.Nil(suite.T(), results[0].Err)
+			assert.True(suite.T(), results[0].Acknowledged)
+			assert.NotNil(suite.T(), suite.waitFor("1.3.6.1.4.1.9999.1.0"))
+		})
+	}
+}
+
+func (suite *NotificationTests) TestInformTimeout() {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+	originator := suite.getOriginator(&NotificationTarget{
+		Address:   conn.LocalAddr().String(),
+		Version:   gosnmp.Version2c,
+		Community: "public",
+		Inform:    true,
+		Timeout:   50 * time.Millisecond,
+		Retries:   2,
+	})
+	start := time.Now()
+	results := originator.Send(suite.getNotification())
+	assert.NotNil(suite.T(), results[0].Err)
+	assert.False(suite.T(), results[0].Acknowledged)
+	// 50ms + 100ms + 200ms with backoff
+	assert.True(suite.T(), time.Since(start) >= 350*time.Millisecond)
+}
+
+// TestSecurityLevel sends SNMPV3 traps with the level of the target, below the level of the user
+func (suite *NotificationTests) TestSecurityLevel() {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+	for _, level := range []gosnmp.SnmpV3MsgFlags{gosnmp.NoAuthNoPriv, gosnmp.AuthNoPriv, gosnmp.AuthPriv} {
+		originator := suite.getOriginator(&NotificationTarget{
+			Address:       conn.LocalAddr().String(),
+			Version:       gosnmp.Version3,
+			UserName:      "testuser",
+			SecurityLevel: level,
+		})
+		results := originator.Send(suite.getNotification())
+		assert.Nil(suite.T(), results[0].Err, "%v", level)
+		buf := make([]byte, 4096)
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		count, err := conn.Read(buf)
+		if !assert.Nil(suite.T(), err) {
+			return
+		}
+		msg, err := parseV3Message(buf[:count])
+		if assert.Nil(suite.T(), err) {
+			assert.Equal(suite.T(), level, msg.flags&gosnmp.AuthPriv)
+		}
+	}
+}
+
+// TestBadTrapOID rejects TrapOIDs which SNMPV1 traps could not split into enterprise and specific-trap
+func (suite *NotificationTests) TestBadTrapOID() {
+	originator := suite.getOriginator(&NotificationTarget{
+		Address:   "127.0.0.1:162",
+		Version:   gosnmp.Version1,
+		Community: "public",
+	})
+	for _, trapOID := range []string{"", "1", ".1", "1..2"} {
+		results := originator.Send(Notification{TrapOID: trapOID})
+		assert.NotNil(suite.T(), results[0].Err, trapOID)
+	}
+}
+
+func (suite *NotificationTests) TestBadTargets() {
+	master := MasterAgent{
+		SecurityConfig: SecurityConfig{Users: suite.getUsers()},
+		NotificationOriginator: NotificationOriginator{Targets: []*NotificationTarget{
+			{Address: "127.0.0.1", Version: gosnmp.Version1, Inform: true},
+		}},
+		SubAgents: []*SubAgent{{}},
+	}
+	assert.NotNil(suite.T(), master.ReadyForWork())
+	master.NotificationOriginator.Targets = []*NotificationTarget{
+		{Address: "127.0.0.1", Version: gosnmp.Version3, UserName: "nobody"},
+	}
+	assert.NotNil(suite.T(), master.ReadyForWork())
+	master.NotificationOriginator.Targets = []*NotificationTarget{
+		{Address: "127.0.0.1", Version: gosnmp.Version2c, SecurityLevel: gosnmp.AuthNoPriv},
+	}
+	assert.NotNil(suite.T(), master.ReadyForWork())
+	master.SecurityConfig.Users[0].PrivacyProtocol = gosnmp.NoPriv
+	master.NotificationOriginator.Targets = []*NotificationTarget{
+		{Address: "127.0.0.1", Version: gosnmp.Version3, UserName: "testuser", SecurityLevel: gosnmp.AuthPriv},
+	}
+	assert.NotNil(suite.T(), master.ReadyForWork())
+	master.NotificationOriginator.Targets[0].SecurityLevel = gosnmp.AuthNoPriv
+	assert.Nil(suite.T(), master.ReadyForWork())
+}
+
+func TestNotificationTestsSuite(t *testing.T) {
+	suite.Run(t, new(NotificationTests))
+}
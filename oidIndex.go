@@ -0,0 +1,194 @@
+package GoSNMPServer
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// oidIndex is a trie of items by the arcs of their OIDs, which are parsed once when added.
+//
+//	Lookups of exact, next and subtree OIDs cost O(depth * log(fanout)) without parsing items again.
+//	Nodes are pruned on remove, so that every node but the root has an item in its subtree.
+type oidIndex struct {
+	root oidNode
+	size int
+}
+
+type oidNode struct {
+	arc  int
+	item *PDUValueControlItem
+	// children are sorted by arc
+	children []*oidNode
+}
+
+// parseOID returns the arcs of oid as "1.3.6.1" or ".1.3.6.1". No arcs for ""
+//
+//	Unlike oidToByteString it returns an error instead of panicking, as oid could come from requests.
+func parseOID(oid string) (ByteString, error) {
+	ret := make(ByteString, 0, 16)
+	if oid == "" {
+		return ret, nil
+	}
+	if oid[0] == '.' {
+		oid = oid[1:]
+	}
+	var arc uint64
+	digits := 0
+	for i := 0; i <= len(oid); i++ {
+		if i == len(oid) || oid[i] == '.' {
+			if digits == 0 {
+				return nil, errors.Errorf("oid %v: empty arc", oid)
+			}
+			ret = append(ret, int(arc))
+			arc, digits = 0, 0
+			continue
+		}
+		if oid[i] < '0' || oid[i] > '9' {
+			return nil, errors.Errorf("oid %v: not a number", oid)
+		}
+		arc = arc*10 + uint64(oid[i]-'0')
+		digits++
+		if arc > 0xffffffff {
+			return nil, errors.Errorf("oid %v: arc too large", oid)
+		}
+	}
+	return ret, nil
+}
+
+// search returns the position of arc in children, and if it exists
+func (n *oidNode) search(arc int) (int, bool) {
+	id := sort.Search(len(n.children), func(i int) bool { return n.children[i].arc >= arc })
+	return id, id < len(n.children) && n.children[id].arc == arc
+}
+
+// find returns the node of arcs. nil if not exists
+func (t *oidIndex) find(arcs ByteString) *oidNode {
+	node := &t.root
+	for _, arc := range arcs {
+		id, ok := node.search(arc)
+		if !ok {
+			return nil
+		}
+		node = node.children[id]
+	}
+	return node
+}
+
+// get returns the item of arcs. nil if not exists
+func (t *oidIndex) get(arcs ByteString) *PDUValueControlItem {
+	if node := t.find(arcs); node != nil {
+		return node.item
+	}
+	return nil
+}
+
+// insert adds item of arcs. false if arcs exists
+func (t *oidIndex) insert(arcs ByteString, item *PDUValueControlItem) bool {
+	node := &t.root
+	for _, arc := range arcs {
+		id, ok := node.search(arc)
+		if !ok {
+			node.children = append(node.children, nil)
+			copy(node.children[id+1:], node.children[id:])
+			node.children[id] = &oidNode{arc: arc}
+		}
+		node = node.children[id]
+	}
+	if node.item != nil {
+		return false
+	}
+	node.item = item
+	t.size++
+	return true
+}
+
+// remove removes and returns the item of arcs. nil if not exists
+func (t *oidIndex) remove(arcs ByteString) *PDUValueControlItem {
+	path := make([]*oidNode, 0, len(arcs)+1)
+	node := &t.root
+	path = append(path, node)
+	for _, arc := range arcs {
+		id, ok := node.search(arc)
+		if !ok {
+			return nil
+		}
+		node = node.children[id]
+		path = append(path, node)
+	}
+	item := node.item
+	if item == nil {
+		return nil
+	}
+	node.item = nil
+	t.size--
+	// prune nodes left empty
+	for depth := len(path) - 1; depth > 0; depth-- {
+		if path[depth].item != nil || len(path[depth].children) != 0 {
+			break
+		}
+		parent := path[depth-1]
+		id, _ := parent.search(path[depth].arc)
+		parent.children = append(parent.children[:id], parent.children[id+1:]...)
+	}
+	return item
+}
+
+// first returns the first item in the subtree of n, n itself included
+func (n *oidNode) first() *PDUValueControlItem {
+	for node := n; node != nil; {
+		if node.item != nil {
+			return node.item
+		}
+		if len(node.children) == 0 {
+			return nil
+		}
+		node = node.children[0]
+	}
+	return nil
+}
+
+// next returns the first item after arcs. nil if not exists
+func (t *oidIndex) next(arcs ByteString) *PDUValueControlItem {
+	return t.root.next(arcs)
+}
+
+func (n *oidNode) next(arcs ByteString) *PDUValueControlItem {
+	if len(arcs) == 0 {
+		// items below n are after n itself
+		if len(n.children) == 0 {
+			return nil
+		}
+		return n.children[0].first()
+	}
+	id, ok := n.search(arcs[0])
+	if ok {
+		if item := n.children[id].next(arcs[1:]); item != nil {
+			return item
+		}
+		id++
+	}
+	if id < len(n.children) {
+		return n.children[id].first()
+	}
+	return nil
+}
+
+// walk calls fn for items in the subtree of prefix by order, prefix itself included, until fn returns false
+func (t *oidIndex) walk(prefix ByteString, fn func(item *PDUValueControlItem) bool) {
+	if node := t.find(prefix); node != nil {
+		node.walk(fn)
+	}
+}
+
+func (n *oidNode) walk(fn func(item *PDUValueControlItem) bool) bool {
+	if n.item != nil && !fn(n.item) {
+		return false
+	}
+	for _, child := range n.children {
+		if !child.walk(fn) {
+			return false
+		}
+	}
+	return true
+}
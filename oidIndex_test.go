@@ -0,0 +1,237 @@
+package GoSNMPServer
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type OIDIndexTests struct {
+	suite.Suite
+}
+
+// sortedOIDs looks up items as SubAgent did before oidIndex, parsing OIDs on every comparison
+type sortedOIDs []*PDUValueControlItem
+
+func (t sortedOIDs) search(oid string) (*PDUValueControlItem, int) {
+	toQuery := oidToByteString(oid)
+	i := sort.Search(len(t), func(i int) bool {
+		return compareByteString(oidToByteString(t[i].OID), toQuery) != ByteStringCompareResultLessThen
+	})
+	if i < len(t) && compareByteString(oidToByteString(t[i].OID), toQuery) == ByteStringCompareResultEqual {
+		return t[i], i
+	}
+	return nil, i
+}
+
+func (t sortedOIDs) get(oid string) *PDUValueControlItem {
+	item, _ := t.search(oid)
+	return item
+}
+
+func (t sortedOIDs) next(oid string) *PDUValueControlItem {
+	item, id := t.search(oid)
+	if item != nil {
+		id++
+	}
+	if id < len(t) {
+		return t[id]
+	}
+	return nil
+}
+
+// newIndexedOIDs returns items of columns x rows cells, as a table
+func newIndexedOIDs(columns, rows int) []*PDUValueControlItem {
+	ret := make([]*PDUValueControlItem, 0, columns*rows)
+	for column := 1; column <= columns; column++ {
+		for row := 1; row <= rows; row++ {
+			ret = append(ret, &PDUValueControlItem{
+				OID:   fmt.Sprintf("1.3.6.1.4.1.9999.93.1.%d.%d", column, row),
+				Type:  gosnmp.Integer,
+				OnGet: func() (value interface{}, err error) { return Asn1IntegerWrap(1), nil },
+			})
+		}
+	}
+	return ret
+}
+
+func (suite *OIDIndexTests) TestParse() {
+	arcs, err := parseOID(".1.3.6.4294967295")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), ByteString{1, 3, 6, 4294967295}, arcs)
+	arcs, err = parseOID("")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 0, len(arcs))
+	for _, each := range []string{"1..3", "1.3.", "1.a", "1.4294967296", "."} {
+		_, err = parseOID(each)
+		assert.NotNil(suite.T(), err, each)
+	}
+}
+
+func (suite *OIDIndexTests) TestIndex() {
+	random := rand.New(rand.NewSource(1))
+	index := &oidIndex{}
+	reference := sortedOIDs{}
+	oids := []string{"1.3", "1.3.6", "1.3.6.1.2.1.1.1.0"}
+	for i := 0; i < 500; i++ {
+		oids = append(oids, fmt.Sprintf("1.3.6.%d.%d.%d", random.Intn(4), random.Intn(20), random.Intn(4)))
+	}
+	check := func() {
+		assert.Equal(suite.T(), len(reference), index.size)
+		for _, oid := range append(oids, "1", "1.3.6.3.20", "2") {
+			arcs := oidToByteString(oid)
+			assert.Equal(suite.T(), reference.get(oid), index.get(arcs), oid)
+			assert.Equal(suite.T(), reference.next(oid), index.next(arcs), oid)
+		}
+		walked := sortedOIDs{}
+		index.walk(nil, func(item *PDUValueControlItem) bool {
+			walked = append(walked, item)
+			return true
+		})
+		assert.Equal(suite.T(), reference, walked)
+	}
+	for _, oid := range oids {
+		item := &PDUValueControlItem{OID: oid}
+		exists, id := reference.search(oid)
+		assert.Equal(suite.T(), exists == nil, index.insert(oidToByteString(oid), item))
+		if exists == nil {
+			reference = append(reference, nil)
+			copy(reference[id+1:], reference[id:])
+			reference[id] = item
+		}
+	}
+	check()
+	for _, oid := range oids[:300] {
+		exists, id := reference.search(oid)
+		assert.Equal(suite.T(), exists, index.remove(oidToByteString(oid)))
+		if exists != nil {
+			reference = append(reference[:id], reference[id+1:]...)
+		}
+	}
+	check()
+}
+
+func (suite *OIDIndexTests) TestSubtree() {
+	index := &oidIndex{}
+	for _, each := range newIndexedOIDs(3, 3) {
+		index.insert(oidToByteString(each.OID), each)
+	}
+	walked := []string{}
+	index.walk(oidToByteString("1.3.6.1.4.1.9999.93.1.2"), func(item *PDUValueControlItem) bool {
+		walked = append(walked, item.OID)
+		return len(walked) < 2
+	})
+	assert.Equal(suite.T(), []string{"1.3.6.1.4.1.9999.93.1.2.1", "1.3.6.1.4.1.9999.93.1.2.2"}, walked)
+	index.walk(oidToByteString("1.3.6.1.4.1.9999.93.1.4"), func(item *PDUValueControlItem) bool {
+		suite.T().Errorf("walked %v", item.OID)
+		return true
+	})
+}
+
+func (suite *OIDIndexTests) TestSubAgent() {
+	items := newIndexedOIDs(2, 12)
+	rand.New(rand.NewSource(1)).Shuffle(len(items), func(i, j int) { items[i], items[j] = items[j], items[i] })
+	agent := &SubAgent{Logger: NewDiscardLogger(), OIDs: items[:10]}
+	assert.Nil(suite.T(), agent.AddOIDs(items[10:15]...))
+	assert.Nil(suite.T(), agent.SyncConfig())
+	assert.Nil(suite.T(), agent.AddOIDs(items[15:]...))
+	assert.NotNil(suite.T(), agent.AddOIDs(&PDUValueControlItem{OID: ".1.3.6.1.4.1.9999.93.1.1.1"}))
+	assert.NotNil(suite.T(), agent.AddOIDs(&PDUValueControlItem{OID: "1.3.a"}))
+	agent.RemoveOIDs("1.3.6.1.4.1.9999.93.1.1.12", "1.3.6.1.4.1.9999.93.1.2.1", "1.3.6.1.4.1.9999.93.1.3.1", "not an oid")
+	assert.Equal(suite.T(), 22, len(agent.OIDs))
+	assert.True(suite.T(), sort.IsSorted(byOID(agent.OIDs)))
+
+	assert.Nil(suite.T(), agent.getForPDUValueControl("1.3.6.1.4.1.9999.93.1.2.1"))
+	assert.Equal(suite.T(), "1.3.6.1.4.1.9999.93.1.2.2", agent.getForPDUValueControl(".1.3.6.1.4.1.9999.93.1.2.2").OID)
+	assert.Equal(suite.T(), "1.3.6.1.4.1.9999.93.1.2.2", agent.nextForPDUValueControl("1.3.6.1.4.1.9999.93.1.1.11").OID)
+	assert.Equal(suite.T(), "1.3.6.1.4.1.9999.93.1.1.1", agent.nextForPDUValueControl("").OID)
+	assert.Nil(suite.T(), agent.nextForPDUValueControl("1.3.6.1.4.1.9999.93.1.2.12"))
+	assert.Nil(suite.T(), agent.getForPDUValueControl("1..3"))
+
+	agent.OIDs = append(agent.OIDs, &PDUValueControlItem{OID: "1.3.6.1.4.1.9999.93.1.1.01"})
+	assert.NotNil(suite.T(), agent.SyncConfig())
+}
+
+func TestOIDIndexTestsSuite(t *testing.T) {
+	suite.Run(t, new(OIDIndexTests))
+}
+
+// benchmarkOIDs are 50k items of 10 columns x 5000 rows
+var benchmarkOIDs = newIndexedOIDs(10, 5000)
+
+// oidLookup is the lookup of the sorted slice or of oidIndex through SubAgent
+type oidLookup struct {
+	get  func(oid string) *PDUValueControlItem
+	next func(oid string) *PDUValueControlItem
+}
+
+func benchmarkLookups(b *testing.B) map[string]oidLookup {
+	sorted := append(sortedOIDs{}, benchmarkOIDs...)
+	sort.Sort(byOID(sorted))
+	agent := &SubAgent{Logger: NewDiscardLogger(), OIDs: append([]*PDUValueControlItem{}, benchmarkOIDs...)}
+	if err := agent.SyncConfig(); err != nil {
+		b.Fatal(err)
+	}
+	return map[string]oidLookup{
+		"sorted": {get: sorted.get, next: sorted.next},
+		"index":  {get: agent.getForPDUValueControl, next: agent.nextForPDUValueControl},
+	}
+}
+
+func runOIDBenchmark(b *testing.B, fn func(b *testing.B, lookup oidLookup)) {
+	lookups := benchmarkLookups(b)
+	for _, name := range []string{"sorted", "index"} {
+		lookup := lookups[name]
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			fn(b, lookup)
+		})
+	}
+}
+
+// BenchmarkOIDGet gets random OIDs of 50k items
+func BenchmarkOIDGet(b *testing.B) {
+	runOIDBenchmark(b, func(b *testing.B, lookup oidLookup) {
+		random := rand.New(rand.NewSource(1))
+		for i := 0; i < b.N; i++ {
+			if lookup.get(benchmarkOIDs[random.Intn(len(benchmarkOIDs))].OID) == nil {
+				b.Fatal("not found")
+			}
+		}
+	})
+}
+
+// BenchmarkOIDGetNext gets the next of random OIDs of 50k items
+func BenchmarkOIDGetNext(b *testing.B) {
+	runOIDBenchmark(b, func(b *testing.B, lookup oidLookup) {
+		random := rand.New(rand.NewSource(1))
+		for i := 0; i < b.N; i++ {
+			lookup.next(benchmarkOIDs[random.Intn(len(benchmarkOIDs))].OID)
+		}
+	})
+}
+
+// BenchmarkOIDGetBulkWalk walks all of 50k items one after another, as GetBulk / BulkWalkAll do
+func BenchmarkOIDGetBulkWalk(b *testing.B) {
+	runOIDBenchmark(b, func(b *testing.B, lookup oidLookup) {
+		for i := 0; i < b.N; i++ {
+			walked, oid := 0, "1.3.6.1.4.1.9999.93"
+			for {
+				item := lookup.next(oid)
+				if item == nil {
+					break
+				}
+				walked++
+				oid = item.OID
+			}
+			if walked != len(benchmarkOIDs) {
+				b.Fatalf("walked %v", walked)
+			}
+		}
+	})
+}
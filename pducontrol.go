@@ -1,6 +1,7 @@
 package GoSNMPServer
 
 import (
+	"context"
 	"net"
 
 	"github.com/gosnmp/gosnmp"
@@ -39,6 +40,30 @@ type FuncPDUControlGet func() (value interface{}, err error)
 // FuncPDUControlSet will be called on set value
 type FuncPDUControlSet func(value interface{}) error
 
+// FuncPDUControlGetContext will be called on get value with the context of the request.
+//
+//	ctx carries the RequestInfo (see RequestInfoFromContext) and is done when the request times out.
+type FuncPDUControlGetContext func(ctx context.Context) (value interface{}, err error)
+
+// FuncPDUControlSetContext will be called on set value with the context of the request. See FuncPDUControlGetContext
+type FuncPDUControlSetContext func(ctx context.Context, value interface{}) error
+
+// FuncPDUControlTestSet will be called on the test phase of set. return error to reject the value.
+//
+//	Nothing should be changed in it.
+type FuncPDUControlTestSet func(value interface{}) error
+
+// FuncPDUControlUndoSet will be called to roll back a committed value when a later commit fails.
+//
+//	value is the value committed.
+type FuncPDUControlUndoSet func(value interface{}) error
+
+// FuncPDUControlCreate will be called on SET of an OID which is not in SubAgent.OIDs.
+//
+//	returns the items to add into SubAgent.OIDs, which should include the OID asked for.
+//	return nil for not creatable.
+type FuncPDUControlCreate func(oid string) ([]*PDUValueControlItem, error)
+
 // PDUValueControlItem describe the action of get / set / walk in pdu tree
 type PDUValueControlItem struct {
 	// OID controls which OID does this PDUValue works
@@ -50,6 +75,8 @@ type PDUValueControlItem struct {
 	//             in direct get.
 	//             All write only item will be NonWalkable
 	NonWalkable bool
+	// Constraints restricts values of SET, checked before OnTestSet. set to nil for any value of Type.
+	Constraints *SetConstraints
 
 	/////////// Callbacks
 
@@ -62,12 +89,58 @@ type PDUValueControlItem struct {
 	OnGet FuncPDUControlGet
 	// OnSet will be called on any Set option. set to nil for mark as a read-only item.
 	OnSet FuncPDUControlSet
+
+	// OnGetContext will be called instead of OnGet if not nil.
+	OnGetContext FuncPDUControlGetContext
+	// OnSetContext will be called instead of OnSet if not nil.
+	OnSetContext FuncPDUControlSetContext
+
+	// OnTestSet will be called for every varbind of a Set before any commit. set to nil to accept all values.
+	OnTestSet FuncPDUControlTestSet
+	// OnCommitSet will be called instead of OnSet if not nil. errors are reported as commitFailed.
+	OnCommitSet FuncPDUControlSet
+	// OnUndoSet rolls back OnCommitSet / OnSet when a later varbind fails to commit.
+	//           set to nil for not undoable, which results undoFailed.
+	OnUndoSet FuncPDUControlUndoSet
 	// OnTrap will be called on TRAP.
 	OnTrap FuncPDUControlTrap
 	//////////// For human document
 
 	//Document for this PDU Item. ignored by the program.
 	Document string
+
+	// arcs of OID, parsed when the item is indexed by a SubAgent
+	arcs ByteString
+}
+
+// oidArcs returns the arcs of OID, which are parsed if not indexed. nil if OID is not valid
+func (t *PDUValueControlItem) oidArcs() ByteString {
+	if t.arcs != nil {
+		return t.arcs
+	}
+	arcs, err := parseOID(t.OID)
+	if err != nil {
+		return nil
+	}
+	return arcs
+}
+
+// readable returns if the item has OnGet or OnGetContext
+func (t *PDUValueControlItem) readable() bool {
+	return t.OnGet != nil || t.OnGetContext != nil
+}
+
+// writable returns if the item has OnSet, OnSetContext or OnCommitSet
+func (t *PDUValueControlItem) writable() bool {
+	return t.OnSet != nil || t.OnSetContext != nil || t.OnCommitSet != nil
+}
+
+// get calls OnGetContext or OnGet
+func (t *PDUValueControlItem) get(ctx context.Context) (interface{}, error) {
+	if t.OnGetContext != nil {
+		return t.OnGetContext(ctx)
+	}
+	return t.OnGet()
 }
 
 func Asn1IntegerUnwrap(i interface{}) int { return i.(int) }
@@ -125,11 +198,7 @@ func (x byOID) Len() int {
 }
 
 func (x byOID) Less(i, j int) bool {
-	bsi := oidToByteString(x[i].OID)
-	bsj := oidToByteString(x[j].OID)
-	//  oidToByteString(x[i].OID) < oidToByteString(x[j].OID)
-	return compareByteString(bsi, bsj) == ByteStringCompareResultLessThen
-
+	return compareByteString(x[i].oidArcs(), x[j].oidArcs()) == ByteStringCompareResultLessThen
 }
 
 func (x byOID) Swap(i, j int) {
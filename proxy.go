@@ -0,0 +1,448 @@
+package GoSNMPServer
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+)
+
+const defaultProxyPort = 161
+const defaultProxyTimeout = 2 * time.Second
+
+// ProxyTarget is another SNMP agent which requests of a SubAgent are forwarded to,
+//
+//	as the proxy forwarder application of RFC 3413 section 3.5.
+//	Requests are translated to the Version and security of the target, and the responses are
+//	relayed back. eg. SNMPV3 in front and SNMPV2c behind.
+//	Varbinds of a SET are sent to the target in one SetRequest on commit, which could not be undone.
+type ProxyTarget struct {
+	// Name identifys this target in logs.
+	Name string
+	// Address is the "host:port" of the agent. port 161 is used if missing.
+	Address string
+	// Transport is "udp" or "tcp". default "udp"
+	Transport string
+
+	// Version is the SNMP version to forward with.
+	Version gosnmp.SnmpVersion
+	// Community is used for SNMPV1 / SNMPV2c
+	Community string
+	// UserName selects the USM user in SecurityConfig.Users for SNMPV3
+	UserName string
+	// ContextName is the SNMPV3 contextName
+	ContextName string
+
+	// Timeout for each try of a request. default 2 seconds
+	Timeout time.Duration
+	// Retries of an unanswered request
+	Retries int
+
+	// Subtrees to forward. nil forwards all OIDs not in OIDs / Tables of the SubAgent.
+	//          Subtrees of the targets of a SubAgent should not overlap.
+	Subtrees []string
+
+	priv struct {
+		// mu serializes requests of client
+		mu     sync.Mutex
+		client *gosnmp.GoSNMP
+	}
+}
+
+// proxyRegion is a subtree forwarded to target. end is nil for the whole OID tree
+type proxyRegion struct {
+	start  ByteString
+	end    ByteString
+	target *ProxyTarget
+}
+
+func (r *proxyRegion) contains(oid ByteString) bool {
+	return compareByteString(r.start, oid) != ByteStringCompareResultGreaterThen &&
+		(r.end == nil || compareByteString(oid, r.end) == ByteStringCompareResultLessThen)
+}
+
+// syncProxies checks Proxies and computes the regions of their subtrees
+func (t *SubAgent) syncProxies() error {
+	t.proxyRegions = nil
+	for _, target := range t.Proxies {
+		if err := t.checkProxyTarget(target); err != nil {
+			return err
+		}
+		subtrees := target.Subtrees
+		if len(subtrees) == 0 {
+			subtrees = []string{""}
+		}
+		for _, each := range subtrees {
+			start, err := parseOID(each)
+			if err != nil {
+				return errors.WithMessagef(err, "ProxyTarget %v", target.Name)
+			}
+			region := proxyRegion{start: start, target: target}
+			if len(region.start) != 0 {
+				region.end = agentxSubtreeEnd(region.start)
+			}
+			t.proxyRegions = append(t.proxyRegions, region)
+		}
+	}
+	sort.Slice(t.proxyRegions, func(i, j int) bool {
+		return compareByteString(t.proxyRegions[i].start, t.proxyRegions[j].start) == ByteStringCompareResultLessThen
+	})
+	for id := 1; id < len(t.proxyRegions); id++ {
+		if t.proxyRegions[id-1].contains(t.proxyRegions[id].start) {
+			return errors.Errorf("community %v: overlapped proxy subtree %v of %v", t.CommunityIDs,
+				byteStringToOID(t.proxyRegions[id].start), t.proxyRegions[id].target.Name)
+		}
+	}
+	return nil
+}
+
+func (t *SubAgent) checkProxyTarget(target *ProxyTarget) error {
+	if target == nil {
+		return errors.New("ProxyTarget is nil")
+	}
+	if _, _, err := splitAddress(target.Address, defaultProxyPort); err != nil {
+		return errors.WithMessagef(err, "ProxyTarget %v", target.Name)
+	}
+	switch target.Version {
+	case gosnmp.Version1, gosnmp.Version2c:
+	case gosnmp.Version3:
+		if t.master == nil || t.master.SecurityConfig.FindForUser(target.UserName) == nil {
+			return errors.Errorf("ProxyTarget %v: unknown user %v", target.Name, target.UserName)
+		}
+	default:
+		return errors.WithMessagef(ErrUnsupportedProtoVersion, "ProxyTarget %v", target.Name)
+	}
+	return nil
+}
+
+// newProxyClient connects to target with its version and security
+func (t *SubAgent) newProxyClient(target *ProxyTarget) (*gosnmp.GoSNMP, error) {
+	host, port, err := splitAddress(target.Address, defaultProxyPort)
+	if err != nil {
+		return nil, err
+	}
+	timeout := target.Timeout
+	if timeout == 0 {
+		timeout = defaultProxyTimeout
+	}
+	client := &gosnmp.GoSNMP{
+		Target:      host,
+		Port:        port,
+		Transport:   target.Transport,
+		Version:     target.Version,
+		Community:   target.Community,
+		ContextName: target.ContextName,
+		Timeout:     timeout,
+		Retries:     target.Retries,
+		MaxOids:     gosnmp.MaxOids,
+		Logger:      gosnmp.NewLogger(&SnmpLoggerAdapter{t.Logger}),
+	}
+	if target.Version == gosnmp.Version3 {
+		usm, err := t.master.getUsmSecurityParametersFromUser(target.UserName)
+		if err != nil {
+			return nil, err
+		}
+		// the target is authoritative. Leave it empty for discovery.
+		usm.AuthoritativeEngineID = ""
+		usm.AuthoritativeEngineBoots = 0
+		usm.AuthoritativeEngineTime = 0
+		usm.SecretKey, usm.PrivacyKey = nil, nil
+		client.SecurityModel = gosnmp.UserSecurityModel
+		client.MsgFlags = getUserSecurityLevel(usm)
+		client.SecurityParameters = usm
+	}
+	if err := client.Connect(); err != nil {
+		return nil, errors.Wrapf(err, "connect to %v", target.Address)
+	}
+	return client, nil
+}
+
+// proxyCall sends a request to target. The client is kept for later requests, and dropped on errors.
+func (t *SubAgent) proxyCall(target *ProxyTarget,
+	call func(client *gosnmp.GoSNMP) (*gosnmp.SnmpPacket, error)) (*gosnmp.SnmpPacket, error) {
+	target.priv.mu.Lock()
+	defer target.priv.mu.Unlock()
+	if target.priv.client == nil {
+		client, err := t.newProxyClient(target)
+		if err != nil {
+			return nil, err
+		}
+		target.priv.client = client
+	}
+	response, err := call(target.priv.client)
+	if err != nil {
+		target.priv.client.Conn.Close()
+		target.priv.client = nil
+		return nil, errors.Wrapf(err, "ProxyTarget %v", target.Name)
+	}
+	return response, nil
+}
+
+// proxyRequest forwards the varbinds of one SNMP request to ProxyTargets
+type proxyRequest struct {
+	agent   *SubAgent
+	request *gosnmp.SnmpPacket
+	// maxRepetitions > 1 prefetches results of GetNext with GetBulkRequests
+	maxRepetitions int
+	getCache       map[proxyCacheKey]gosnmp.SnmpPDU
+	nextCache      map[proxyCacheKey]gosnmp.SnmpPDU
+	sets           []*proxySet
+}
+
+type proxyCacheKey struct {
+	target *ProxyTarget
+	oid    string
+}
+
+// proxySet is the varbinds of a SET forwarded to a target
+type proxySet struct {
+	target    *ProxyTarget
+	variables []gosnmp.SnmpPDU
+	sent      bool
+	err       error
+}
+
+// newProxyRequest returns nil if there is no ProxyTarget
+func (t *SubAgent) newProxyRequest(request *gosnmp.SnmpPacket) *proxyRequest {
+	if len(t.proxyRegions) == 0 {
+		return nil
+	}
+	return &proxyRequest{
+		agent:     t,
+		request:   request,
+		getCache:  make(map[proxyCacheKey]gosnmp.SnmpPDU),
+		nextCache: make(map[proxyCacheKey]gosnmp.SnmpPDU),
+	}
+}
+
+// region returns the region of oid. nil if not forwarded
+func (r *proxyRequest) region(oid ByteString) *proxyRegion {
+	regions := r.agent.proxyRegions
+	id := sort.Search(len(regions), func(id int) bool {
+		return compareByteString(regions[id].start, oid) == ByteStringCompareResultGreaterThen
+	})
+	if id > 0 && regions[id-1].contains(oid) {
+		return &regions[id-1]
+	}
+	return nil
+}
+
+// prefetch gets the varbinds forwarded with one GetRequest for each target
+func (r *proxyRequest) prefetch(variables []gosnmp.SnmpPDU) {
+	names := make(map[*ProxyTarget][]string)
+	targets := []*ProxyTarget{}
+	for _, each := range variables {
+		arcs, err := parseOID(each.Name)
+		if err != nil {
+			continue
+		}
+		region := r.region(arcs)
+		if region == nil {
+			continue
+		}
+		if _, ok := names[region.target]; !ok {
+			targets = append(targets, region.target)
+		}
+		names[region.target] = append(names[region.target], strings.TrimPrefix(each.Name, "."))
+	}
+	for _, target := range targets {
+		oids := names[target]
+		if len(oids) < 2 {
+			continue
+		}
+		response, err := r.agent.proxyCall(target, func(client *gosnmp.GoSNMP) (*gosnmp.SnmpPacket, error) {
+			return client.Get(oids)
+		})
+		if err != nil || response.Error != gosnmp.NoError || len(response.Variables) != len(oids) {
+			// fetched one by one
+			continue
+		}
+		for id, each := range response.Variables {
+			r.getCache[proxyCacheKey{target, oids[id]}] = each
+		}
+	}
+}
+
+// get returns the item of oid from the target. nil if not forwarded or not exists
+func (r *proxyRequest) get(oid string) *PDUValueControlItem {
+	arcs, err := parseOID(oid)
+	if err != nil {
+		return nil
+	}
+	region := r.region(arcs)
+	if region == nil {
+		return nil
+	}
+	name := strings.TrimPrefix(oid, ".")
+	varItem, err := r.fetch(region.target, name)
+	if err != nil {
+		r.agent.Logger.Warnf("%v", err)
+		return newRemoteErrorItem(name, err)
+	}
+	switch varItem.Type {
+	case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+		return nil
+	}
+	varItem.Name = name
+	return newRemoteItem(varItem)
+}
+
+// fetch gets name from target. SNMPV1 noSuchName is returned as noSuchObject
+func (r *proxyRequest) fetch(target *ProxyTarget, name string) (gosnmp.SnmpPDU, error) {
+	if cached, ok := r.getCache[proxyCacheKey{target, name}]; ok {
+		return cached, nil
+	}
+	response, err := r.agent.proxyCall(target, func(client *gosnmp.GoSNMP) (*gosnmp.SnmpPacket, error) {
+		return client.Get([]string{name})
+	})
+	if err == nil && response.Error == gosnmp.NoSuchName {
+		return gosnmp.SnmpPDU{Name: name, Type: gosnmp.NoSuchObject}, nil
+	}
+	if err == nil && response.Error != gosnmp.NoError {
+		err = errors.Errorf("ProxyTarget %v: Get %v: %v", target.Name, name, response.Error)
+	}
+	if err == nil && len(response.Variables) != 1 {
+		err = errors.Errorf("ProxyTarget %v: Get %v: %v varbinds returned", target.Name, name, len(response.Variables))
+	}
+	if err != nil {
+		return gosnmp.SnmpPDU{}, err
+	}
+	return response.Variables[0], nil
+}
+
+// next returns the first item after oid from targets. nil for endOfMibView
+func (r *proxyRequest) next(oid ByteString) *PDUValueControlItem {
+	for id := range r.agent.proxyRegions {
+		region := &r.agent.proxyRegions[id]
+		if region.end != nil && compareByteString(region.end, oid) != ByteStringCompareResultGreaterThen {
+			continue
+		}
+		cursor := oid
+		if compareByteString(region.start, oid) == ByteStringCompareResultGreaterThen {
+			// the subtree itself could be an instance
+			start := byteStringToOID(region.start)
+			if varItem, err := r.fetch(region.target, start); err == nil && varItem.Type != gosnmp.NoSuchObject &&
+				varItem.Type != gosnmp.NoSuchInstance && varItem.Type != gosnmp.EndOfMibView {
+				varItem.Name = start
+				return newRemoteItem(varItem)
+			}
+			cursor = region.start
+		}
+		varItem, err := r.searchNext(region.target, byteStringToOID(cursor))
+		if err != nil {
+			// the region is skipped as empty
+			r.agent.Logger.Warnf("%v", err)
+			continue
+		}
+		if varItem.Type == gosnmp.EndOfMibView {
+			continue
+		}
+		found, err := parseOID(varItem.Name)
+		if err != nil || compareByteString(found, oid) != ByteStringCompareResultGreaterThen {
+			r.agent.Logger.Warnf("ProxyTarget %v: GetNext %v: %v not increasing", region.target.Name,
+				byteStringToOID(cursor), varItem.Name)
+			continue
+		}
+		if !region.contains(found) {
+			continue
+		}
+		return newRemoteItem(varItem)
+	}
+	return nil
+}
+
+// searchNext sends GetNextRequest / GetBulkRequest for the varbind after name
+func (r *proxyRequest) searchNext(target *ProxyTarget, name string) (gosnmp.SnmpPDU, error) {
+	key := proxyCacheKey{target, name}
+	if cached, ok := r.nextCache[key]; ok {
+		return cached, nil
+	}
+	bulk := r.maxRepetitions > 1 && target.Version != gosnmp.Version1
+	response, err := r.agent.proxyCall(target, func(client *gosnmp.GoSNMP) (*gosnmp.SnmpPacket, error) {
+		if bulk {
+			return client.GetBulk([]string{name}, 0, uint32(r.maxRepetitions))
+		}
+		return client.GetNext([]string{name})
+	})
+	if err == nil && response.Error == gosnmp.NoSuchName {
+		// SNMPV1 end of MIB. See RFC 3584 section 4.2.2.1
+		return gosnmp.SnmpPDU{Name: name, Type: gosnmp.EndOfMibView}, nil
+	}
+	if err == nil && response.Error != gosnmp.NoError {
+		err = errors.Errorf("ProxyTarget %v: GetNext %v: %v", target.Name, name, response.Error)
+	}
+	if err == nil && len(response.Variables) == 0 {
+		err = errors.Errorf("ProxyTarget %v: GetNext %v: no varbind returned", target.Name, name)
+	}
+	if err != nil {
+		return gosnmp.SnmpPDU{}, err
+	}
+	// results of GetBulk are cached as the results of GetNext from the former ones
+	for id := range response.Variables {
+		each := &response.Variables[id]
+		each.Name = strings.TrimPrefix(each.Name, ".")
+		r.nextCache[key] = *each
+		if each.Type == gosnmp.EndOfMibView {
+			break
+		}
+		key = proxyCacheKey{target, each.Name}
+	}
+	return response.Variables[0], nil
+}
+
+// setItem returns the item to set oid by the target. nil if not forwarded
+//
+//	All varbinds of a target are sent on the commit of the first one.
+func (r *proxyRequest) setItem(oid string) *PDUValueControlItem {
+	arcs, err := parseOID(oid)
+	if err != nil {
+		return nil
+	}
+	region := r.region(arcs)
+	if region == nil {
+		return nil
+	}
+	name := strings.TrimPrefix(oid, ".")
+	var set *proxySet
+	for _, each := range r.sets {
+		if each.target == region.target {
+			set = each
+		}
+	}
+	if set == nil {
+		set = &proxySet{target: region.target}
+		r.sets = append(r.sets, set)
+	}
+	varItem := gosnmp.SnmpPDU{Name: name}
+	for _, each := range r.request.Variables {
+		if strings.TrimPrefix(each.Name, ".") == name {
+			varItem = each
+			varItem.Name = name
+		}
+	}
+	set.variables = append(set.variables, varItem)
+	return &PDUValueControlItem{
+		OID:         name,
+		Type:        varItem.Type,
+		OnCommitSet: func(value interface{}) error { return r.commitSet(set) },
+	}
+}
+
+// commitSet sends the varbinds of set once. returns the error of the target
+func (r *proxyRequest) commitSet(set *proxySet) error {
+	if set.sent {
+		return set.err
+	}
+	set.sent = true
+	response, err := r.agent.proxyCall(set.target, func(client *gosnmp.GoSNMP) (*gosnmp.SnmpPacket, error) {
+		return client.Set(set.variables)
+	})
+	if err == nil && response.Error != gosnmp.NoError {
+		err = errors.Errorf("ProxyTarget %v: Set: %v at %v", set.target.Name, response.Error, response.ErrorIndex)
+	}
+	set.err = err
+	return err
+}
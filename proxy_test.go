@@ -0,0 +1,278 @@
+package GoSNMPServer
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type ProxyTests struct {
+	suite.Suite
+	Logger ILogger
+
+	// downstream is the legacy agent behind the proxy
+	downstream *SNMPServer
+	shandle    *SNMPServer
+
+	mu       sync.Mutex
+	location string
+}
+
+func (suite *ProxyTests) SetupTest() {
+	logger := NewDefaultLogger()
+	logger.(*DefaultLogger).Level = logrus.InfoLevel
+	suite.Logger = logger
+	suite.location = "rack 1"
+
+	downstream := MasterAgent{
+		Logger: suite.Logger,
+		SubAgents: []*SubAgent{
+			{
+				CommunityIDs: []string{"legacy"},
+				OIDs: []*PDUValueControlItem{
+					{
+						OID:   "1.3.6.1.4.1.9999.40.1",
+						Type:  gosnmp.OctetString,
+						OnGet: func() (value interface{}, err error) { return Asn1OctetStringWrap("legacy"), nil },
+					},
+					{
+						OID:  "1.3.6.1.4.1.9999.40.2",
+						Type: gosnmp.OctetString,
+						OnGet: func() (value interface{}, err error) {
+							suite.mu.Lock()
+							defer suite.mu.Unlock()
+							return Asn1OctetStringWrap(suite.location), nil
+						},
+						OnSet: func(value interface{}) error {
+							suite.mu.Lock()
+							defer suite.mu.Unlock()
+							suite.location = Asn1OctetStringUnwrap(value)
+							return nil
+						},
+					},
+					{
+						OID:   "1.3.6.1.4.1.9999.40.3",
+						Type:  gosnmp.Counter32,
+						OnGet: func() (value interface{}, err error) { return Asn1Counter32Wrap(3), nil },
+					},
+					{
+						OID:   "1.3.6.1.4.1.9999.50.1",
+						Type:  gosnmp.Integer,
+						OnGet: func() (value interface{}, err error) { return Asn1IntegerWrap(50), nil },
+					},
+				},
+			},
+		},
+	}
+	suite.downstream = NewSNMPServer(downstream)
+	if err := suite.downstream.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	go suite.downstream.ServeForever()
+	address := suite.downstream.Address().String()
+
+	master := MasterAgent{
+		Logger: suite.Logger,
+		SecurityConfig: SecurityConfig{
+			AuthoritativeEngineBoots: 1,
+			Users: []gosnmp.UsmSecurityParameters{
+				{
+					UserName:                 "proxyuser",
+					AuthenticationProtocol:   gosnmp.SHA,
+					PrivacyProtocol:          gosnmp.AES,
+					AuthenticationPassphrase: "proxyauth",
+					PrivacyPassphrase:        "proxypriv",
+				},
+			},
+		},
+		SubAgents: []*SubAgent{
+			{
+				CommunityIDs: []string{"public"},
+				OIDs: []*PDUValueControlItem{
+					{
+						OID:   "1.3.6.1.4.1.9999.30.1",
+						Type:  gosnmp.OctetString,
+						OnGet: func() (value interface{}, err error) { return Asn1OctetStringWrap("local"), nil },
+					},
+					{
+						OID:   "1.3.6.1.4.1.9999.45.1",
+						Type:  gosnmp.OctetString,
+						OnGet: func() (value interface{}, err error) { return Asn1OctetStringWrap("local"), nil },
+					},
+				},
+				Proxies: []*ProxyTarget{
+					{
+						Name:      "legacy-v2c",
+						Address:   address,
+						Version:   gosnmp.Version2c,
+						Community: "legacy",
+						Subtrees:  []string{"1.3.6.1.4.1.9999.40"},
+					},
+				},
+			},
+			{
+				// the whole SubAgent forwarded with SNMPV1
+				CommunityIDs: []string{"legacy"},
+				ContextNames: []string{"legacy"},
+				Proxies: []*ProxyTarget{
+					{
+						Name:      "legacy-v1",
+						Address:   address,
+						Version:   gosnmp.Version1,
+						Community: "legacy",
+					},
+				},
+			},
+		},
+	}
+	suite.shandle = NewSNMPServer(master)
+	if err := suite.shandle.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	go suite.shandle.ServeForever()
+}
+
+func (suite *ProxyTests) TearDownTest() {
+	suite.shandle.Shutdown()
+	suite.downstream.Shutdown()
+}
+
+func (suite *ProxyTests) getClient(community string) *gosnmp.GoSNMP {
+	serverAddress := suite.shandle.Address().(*net.UDPAddr)
+	client := &gosnmp.GoSNMP{
+		Target:    serverAddress.IP.String(),
+		Port:      uint16(serverAddress.Port),
+		Version:   gosnmp.Version2c,
+		Community: community,
+		Timeout:   2 * time.Second,
+	}
+	if err := client.Connect(); err != nil {
+		panic(err)
+	}
+	return client
+}
+
+func (suite *ProxyTests) TestGet() {
+	client := suite.getClient("public")
+	defer client.Conn.Close()
+	result, err := client.Get([]string{"1.3.6.1.4.1.9999.40.1", "1.3.6.1.4.1.9999.30.1", "1.3.6.1.4.1.9999.40.3"})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), "legacy", string(result.Variables[0].Value.([]byte)))
+		assert.Equal(suite.T(), "local", string(result.Variables[1].Value.([]byte)))
+		assert.Equal(suite.T(), uint(3), result.Variables[2].Value)
+	}
+	// 9999.50 is not in the subtree forwarded
+	result, err = client.Get([]string{"1.3.6.1.4.1.9999.40.9", "1.3.6.1.4.1.9999.50.1"})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), gosnmp.NoSuchInstance, result.Variables[0].Type)
+		assert.Equal(suite.T(), gosnmp.NoSuchInstance, result.Variables[1].Type)
+	}
+}
+
+func (suite *ProxyTests) TestWalk() {
+	client := suite.getClient("public")
+	defer client.Conn.Close()
+	expected := []string{
+		".1.3.6.1.4.1.9999.30.1",
+		".1.3.6.1.4.1.9999.40.1",
+		".1.3.6.1.4.1.9999.40.2",
+		".1.3.6.1.4.1.9999.40.3",
+		".1.3.6.1.4.1.9999.45.1",
+	}
+	walked, err := client.WalkAll("1.3.6.1.4.1.9999")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), expected, getNames(walked))
+	walked, err = client.BulkWalkAll("1.3.6.1.4.1.9999")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), expected, getNames(walked))
+}
+
+func (suite *ProxyTests) TestSet() {
+	client := suite.getClient("public")
+	defer client.Conn.Close()
+	result, err := client.Set([]gosnmp.SnmpPDU{
+		{Name: "1.3.6.1.4.1.9999.40.2", Type: gosnmp.OctetString, Value: "rack 2"},
+	})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), gosnmp.NoError, result.Error)
+	}
+	suite.mu.Lock()
+	assert.Equal(suite.T(), "rack 2", suite.location)
+	suite.mu.Unlock()
+
+	result, err = client.Set([]gosnmp.SnmpPDU{
+		{Name: "1.3.6.1.4.1.9999.40.1", Type: gosnmp.OctetString, Value: "read only"},
+	})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), gosnmp.CommitFailed, result.Error)
+	}
+}
+
+// TestV3ToV1 forwards SNMPV3 requests of context legacy with SNMPV1
+func (suite *ProxyTests) TestV3ToV1() {
+	serverAddress := suite.shandle.Address().(*net.UDPAddr)
+	client := &gosnmp.GoSNMP{
+		Target:        serverAddress.IP.String(),
+		Port:          uint16(serverAddress.Port),
+		Version:       gosnmp.Version3,
+		Timeout:       2 * time.Second,
+		SecurityModel: gosnmp.UserSecurityModel,
+		MsgFlags:      gosnmp.AuthPriv,
+		ContextName:   "legacy",
+		SecurityParameters: &gosnmp.UsmSecurityParameters{
+			UserName:                 "proxyuser",
+			AuthenticationProtocol:   gosnmp.SHA,
+			PrivacyProtocol:          gosnmp.AES,
+			AuthenticationPassphrase: "proxyauth",
+			PrivacyPassphrase:        "proxypriv",
+		},
+	}
+	if err := client.Connect(); err != nil {
+		panic(err)
+	}
+	defer client.Conn.Close()
+	result, err := client.Get([]string{"1.3.6.1.4.1.9999.50.1", "1.3.6.1.4.1.9999.40.9"})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), 50, result.Variables[0].Value)
+		assert.Equal(suite.T(), gosnmp.NoSuchInstance, result.Variables[1].Type)
+	}
+	walked, err := client.BulkWalkAll("1.3.6.1.4.1.9999")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), []string{
+		".1.3.6.1.4.1.9999.40.1",
+		".1.3.6.1.4.1.9999.40.2",
+		".1.3.6.1.4.1.9999.40.3",
+		".1.3.6.1.4.1.9999.50.1",
+	}, getNames(walked))
+}
+
+func (suite *ProxyTests) TestSyncConfig() {
+	agent := &SubAgent{
+		Logger: suite.Logger,
+		Proxies: []*ProxyTarget{
+			{Name: "a", Address: "127.0.0.1", Version: gosnmp.Version2c, Subtrees: []string{"1.3.6.1.4.1.9999"}},
+			{Name: "b", Address: "127.0.0.1", Version: gosnmp.Version2c, Subtrees: []string{"1.3.6.1.4.1.9999.1"}},
+		},
+	}
+	assert.NotNil(suite.T(), agent.SyncConfig())
+	agent.Proxies[1].Subtrees = []string{"1.3.6.1.4.1.99990"}
+	assert.Nil(suite.T(), agent.SyncConfig())
+	// arcs above 2^31-1 are valid sub-identifiers
+	agent.Proxies[1].Subtrees = []string{"1.3.6.1.4.1.3000000000"}
+	assert.Nil(suite.T(), agent.SyncConfig())
+	agent.Proxies[1].Version = gosnmp.Version3
+	err := agent.SyncConfig()
+	assert.NotNil(suite.T(), err)
+	assert.False(suite.T(), errors.Is(err, ErrUnsupportedProtoVersion))
+}
+
+func TestProxyTestsSuite(t *testing.T) {
+	suite.Run(t, new(ProxyTests))
+}
@@ -0,0 +1,58 @@
+package GoSNMPServer
+
+import (
+	"context"
+	"net"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// RequestInfo describes the request being served. See RequestInfoFromContext
+type RequestInfo struct {
+	// SourceAddress is where the request comes from. nil if unknown
+	SourceAddress net.Addr
+
+	Version gosnmp.SnmpVersion
+	PDUType gosnmp.PDUType
+
+	// Community of SNMPV1 / SNMPV2c requests
+	Community string
+	// UserName is the USM user or the TSM securityName of SNMPV3 requests
+	UserName string
+	// SecurityLevel of SNMPV3 requests. NoAuthNoPriv for SNMPV1 / SNMPV2c
+	SecurityLevel gosnmp.SnmpV3MsgFlags
+	// ContextName of SNMPV3 requests
+	ContextName string
+}
+
+type requestInfoKey struct{}
+
+// WithSourceAddress returns a context for MasterAgent.ResponseForBufferContext of a request from addr
+func WithSourceAddress(ctx context.Context, addr net.Addr) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, &RequestInfo{SourceAddress: addr})
+}
+
+// RequestInfoFromContext returns the RequestInfo of ctx passed to callbacks. nil if ctx is not of a request
+func RequestInfoFromContext(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return info
+}
+
+// newRequestContext returns a context carrying the RequestInfo of request
+func newRequestContext(ctx context.Context, request *gosnmp.SnmpPacket) context.Context {
+	info := &RequestInfo{
+		Version: request.Version,
+		PDUType: request.PDUType,
+	}
+	if parent := RequestInfoFromContext(ctx); parent != nil {
+		info.SourceAddress = parent.SourceAddress
+	}
+	if request.Version == gosnmp.Version3 {
+		info.UserName = getUserNameOfPacket(request)
+		info.SecurityLevel = request.MsgFlags & gosnmp.AuthPriv
+		info.ContextName = request.ContextName
+	} else {
+		info.Community = request.Community
+	}
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
@@ -0,0 +1,191 @@
+package GoSNMPServer
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type RequestContextTests struct {
+	suite.Suite
+	Logger ILogger
+
+	shandle *SNMPServer
+
+	mu       sync.Mutex
+	lastSet  *RequestInfo
+	deadline bool
+}
+
+func (suite *RequestContextTests) SetupTest() {
+	suite.Logger = NewDiscardLogger()
+	suite.lastSet = nil
+	suite.deadline = false
+	master := MasterAgent{
+		Logger: suite.Logger,
+		SecurityConfig: SecurityConfig{
+			AuthoritativeEngineBoots: 1,
+			Users: []gosnmp.UsmSecurityParameters{
+				{
+					UserName:                 "ctxuser",
+					AuthenticationProtocol:   gosnmp.SHA,
+					PrivacyProtocol:          gosnmp.AES,
+					AuthenticationPassphrase: "ctxauthpass",
+					PrivacyPassphrase:        "ctxprivpass",
+				},
+			},
+		},
+		SubAgents: []*SubAgent{
+			{
+				CommunityIDs: []string{"public", "ctx"},
+				ContextNames: []string{"ctx"},
+				OIDs: []*PDUValueControlItem{
+					{
+						// returns who is asking
+						OID:  "1.3.6.1.4.1.9999.80.1",
+						Type: gosnmp.OctetString,
+						OnGetContext: func(ctx context.Context) (value interface{}, err error) {
+							info := RequestInfoFromContext(ctx)
+							_, ok := ctx.Deadline()
+							suite.mu.Lock()
+							suite.deadline = ok
+							suite.mu.Unlock()
+							return Asn1OctetStringWrap(info.Community + "/" + info.UserName + "/" + info.ContextName), nil
+						},
+					},
+					{
+						OID:  "1.3.6.1.4.1.9999.80.2",
+						Type: gosnmp.OctetString,
+						OnGetContext: func(ctx context.Context) (value interface{}, err error) {
+							info := RequestInfoFromContext(ctx)
+							if info.SourceAddress == nil {
+								return Asn1OctetStringWrap(""), nil
+							}
+							return Asn1OctetStringWrap(info.SourceAddress.String()), nil
+						},
+						OnSetContext: func(ctx context.Context, value interface{}) error {
+							suite.mu.Lock()
+							defer suite.mu.Unlock()
+							suite.lastSet = RequestInfoFromContext(ctx)
+							return nil
+						},
+					},
+				},
+			},
+		},
+	}
+	suite.shandle = NewSNMPServer(master)
+	suite.shandle.SetWorkerPool(WorkerPoolConfig{Workers: 2, RequestTimeout: time.Second})
+	if err := suite.shandle.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	go suite.shandle.ServeForever()
+}
+
+func (suite *RequestContextTests) TearDownTest() {
+	suite.shandle.Shutdown()
+}
+
+func (suite *RequestContextTests) getClient(version gosnmp.SnmpVersion) *gosnmp.GoSNMP {
+	serverAddress := suite.shandle.Address().(*net.UDPAddr)
+	client := &gosnmp.GoSNMP{
+		Target:    serverAddress.IP.String(),
+		Port:      uint16(serverAddress.Port),
+		Version:   version,
+		Community: "public",
+		Timeout:   2 * time.Second,
+	}
+	if version == gosnmp.Version3 {
+		client.SecurityModel = gosnmp.UserSecurityModel
+		client.MsgFlags = gosnmp.AuthPriv
+		client.ContextName = "ctx"
+		client.SecurityParameters = &gosnmp.UsmSecurityParameters{
+			UserName:                 "ctxuser",
+			AuthenticationProtocol:   gosnmp.SHA,
+			PrivacyProtocol:          gosnmp.AES,
+			AuthenticationPassphrase: "ctxauthpass",
+			PrivacyPassphrase:        "ctxprivpass",
+		}
+	}
+	if err := client.Connect(); err != nil {
+		panic(err)
+	}
+	return client
+}
+
+func (suite *RequestContextTests) TestGet() {
+	client := suite.getClient(gosnmp.Version2c)
+	defer client.Conn.Close()
+	result, err := client.Get([]string{"1.3.6.1.4.1.9999.80.1", "1.3.6.1.4.1.9999.80.2"})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), "public//", string(result.Variables[0].Value.([]byte)))
+		assert.Equal(suite.T(), client.Conn.LocalAddr().String(), string(result.Variables[1].Value.([]byte)))
+	}
+	suite.mu.Lock()
+	assert.True(suite.T(), suite.deadline)
+	suite.mu.Unlock()
+
+	v3 := suite.getClient(gosnmp.Version3)
+	defer v3.Conn.Close()
+	result, err = v3.Get([]string{"1.3.6.1.4.1.9999.80.1"})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), "/ctxuser/ctx", string(result.Variables[0].Value.([]byte)))
+	}
+}
+
+func (suite *RequestContextTests) TestSet() {
+	client := suite.getClient(gosnmp.Version3)
+	defer client.Conn.Close()
+	result, err := client.Set([]gosnmp.SnmpPDU{
+		{Name: "1.3.6.1.4.1.9999.80.2", Type: gosnmp.OctetString, Value: "value"},
+	})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), gosnmp.NoError, result.Error)
+	}
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+	if assert.NotNil(suite.T(), suite.lastSet) {
+		assert.Equal(suite.T(), gosnmp.Version3, suite.lastSet.Version)
+		assert.Equal(suite.T(), gosnmp.SetRequest, suite.lastSet.PDUType)
+		assert.Equal(suite.T(), "ctxuser", suite.lastSet.UserName)
+		assert.Equal(suite.T(), gosnmp.AuthPriv, suite.lastSet.SecurityLevel)
+		assert.Equal(suite.T(), "ctx", suite.lastSet.ContextName)
+		assert.Equal(suite.T(), client.Conn.LocalAddr().String(), suite.lastSet.SourceAddress.String())
+	}
+}
+
+func (suite *RequestContextTests) TestResponseForBufferContext() {
+	request := &gosnmp.SnmpPacket{
+		Version:   gosnmp.Version2c,
+		Community: "ctx",
+		PDUType:   gosnmp.GetRequest,
+		RequestID: 1,
+		Variables: []gosnmp.SnmpPDU{{Name: "1.3.6.1.4.1.9999.80.2", Type: gosnmp.Null}},
+	}
+	out, err := request.MarshalMsg()
+	if !assert.Nil(suite.T(), err) {
+		return
+	}
+	source := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1161}
+	ctx := WithSourceAddress(context.Background(), source)
+	responseBytes, err := suite.shandle.master.ResponseForBufferContext(ctx, out)
+	if !assert.Nil(suite.T(), err) {
+		return
+	}
+	handle := gosnmp.GoSNMP{Logger: gosnmp.NewLogger(&SnmpLoggerAdapter{suite.Logger})}
+	response, err := handle.SnmpDecodePacket(responseBytes)
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), "192.0.2.1:1161", string(response.Variables[0].Value.([]byte)))
+	}
+	assert.Nil(suite.T(), RequestInfoFromContext(context.Background()))
+}
+
+func TestRequestContextTestsSuite(t *testing.T) {
+	suite.Run(t, new(RequestContextTests))
+}
@@ -0,0 +1,619 @@
+package GoSNMPServer
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+)
+
+// RowStatus is the RowStatus textual convention. See RFC 2579
+type RowStatus int
+
+// RowStatus values. See RFC 2579
+const (
+	RowStatusActive        RowStatus = 1
+	RowStatusNotInService  RowStatus = 2
+	RowStatusNotReady      RowStatus = 3
+	RowStatusCreateAndGo   RowStatus = 4
+	RowStatusCreateAndWait RowStatus = 5
+	RowStatusDestroy       RowStatus = 6
+)
+
+// RowStatusColumn describes a column of a RowStatusTable
+type RowStatusColumn struct {
+	// ID is the sub-identifier under RowStatusTable.OID
+	ID int
+	// Type defines which type this column is.
+	Type gosnmp.Asn1BER
+	// DefVal is used for new rows. nil means the column is required before the row could be active.
+	DefVal interface{}
+	// NotAccessible marks index columns. They are not served and filled by OnParseIndex.
+	NotAccessible bool
+	// OnTestSet validates a value set by managers. set to nil to accept all values of Type.
+	//           OctetString values are passed as string, ObjectIdentifier without the leading dot.
+	OnTestSet FuncPDUControlTestSet
+
+	//Document for this column. ignored by the program.
+	Document string
+}
+
+// RowStatusRow is a copy of a row in RowStatusTable
+type RowStatusRow struct {
+	// Index is the OID suffix of this row
+	Index string
+	// Values of columns by RowStatusColumn.ID
+	Values map[int]interface{}
+	Status RowStatus
+}
+
+// FuncRowStatusParseIndex parses the index of a new row into values of the index columns.
+//
+//	return error to reject the row.
+type FuncRowStatusParseIndex func(index string) (map[int]interface{}, error)
+
+// FuncRowStatusPersist will be called after a row is changed by managers.
+//
+//	row.Status is RowStatusDestroy for destroyed rows. return error to fail the SET.
+type FuncRowStatusPersist func(row RowStatusRow) error
+
+// RowStatusTable is a conceptual table whose rows could be created and destroyed by managers
+//
+//	with the RowStatus column. See RFC 2579
+//	Cells are served as items in SubAgent.OIDs, which are added or removed with the rows.
+//	A new row is created on SET of any cell of it, with its RowStatus in the same request.
+//	The RowStatus is decided with the other columns of the same SET, in any order of the varbinds.
+//	Rows of failed SETs are removed, and committed RowStatus are undone if a later varbind fails.
+type RowStatusTable struct {
+	// OID of the entry. eg 1.3.6.1.6.3.12.1.2.1 for snmpTargetAddrEntry
+	OID     string
+	Columns []*RowStatusColumn
+	// RowStatusColumn is the ID of the RowStatus column
+	RowStatusColumn int
+	// OnParseIndex set to nil to accept any index without index columns
+	OnParseIndex FuncRowStatusParseIndex
+	// OnPersist set to nil if the rows are not persisted
+	OnPersist FuncRowStatusPersist
+
+	//Document for this table. ignored by the program.
+	Document string
+
+	priv struct {
+		mu       sync.Mutex
+		rows     map[string]*rowStatusRow
+		subAgent *SubAgent
+		// created are the rows created by the SET being served. See endSet
+		created []*rowStatusRow
+		// pending are the rows with columns in the SET being served. See testSet
+		pending []*rowStatusRow
+	}
+}
+
+type rowStatusRow struct {
+	index  string
+	values map[int]interface{}
+	status RowStatus
+	// fresh marks a row created by SET and not yet given a RowStatus
+	fresh bool
+	// pending are the values of columns in the SET being served, applied with the RowStatus
+	pending map[int]interface{}
+	items   []*PDUValueControlItem
+}
+
+func (t *RowStatusTable) init() {
+	if t.priv.rows == nil {
+		t.OID = strings.TrimPrefix(t.OID, ".")
+		t.priv.rows = make(map[string]*rowStatusRow)
+	}
+}
+
+func (t *RowStatusTable) column(id int) *RowStatusColumn {
+	for _, each := range t.Columns {
+		if each.ID == id {
+			return each
+		}
+	}
+	return nil
+}
+
+// Attach serves the table in subAgent. Should be called before MasterAgent.ReadyForWork.
+//
+//	Set subAgent.UserErrorMarkPacket to report failed SET of OnSet to managers.
+func (t *RowStatusTable) Attach(subAgent *SubAgent) error {
+	if err := VerifyOid(t.OID); err != nil {
+		return errors.WithMessagef(err, "RowStatusTable %v", t.Document)
+	}
+	if t.column(t.RowStatusColumn) == nil {
+		return errors.Errorf("RowStatusTable %v: no RowStatus column %v", t.OID, t.RowStatusColumn)
+	}
+	t.priv.mu.Lock()
+	defer t.priv.mu.Unlock()
+	t.init()
+	t.priv.subAgent = subAgent
+	subAgent.setObservers = append(subAgent.setObservers, t)
+	for _, row := range t.priv.rows {
+		if err := subAgent.AddOIDs(row.items...); err != nil {
+			return err
+		}
+	}
+	prevOnCreateOID := subAgent.OnCreateOID
+	subAgent.OnCreateOID = func(oid string) ([]*PDUValueControlItem, error) {
+		if items, err := t.createForOID(oid); items != nil || err != nil || prevOnCreateOID == nil {
+			return items, err
+		}
+		return prevOnCreateOID(oid)
+	}
+	return nil
+}
+
+// AddRow adds an active row. values of index columns are filled by OnParseIndex.
+func (t *RowStatusTable) AddRow(index string, values map[int]interface{}) error {
+	t.priv.mu.Lock()
+	defer t.priv.mu.Unlock()
+	t.init()
+	if _, exists := t.priv.rows[index]; exists {
+		return errors.Errorf("RowStatusTable %v: duplicate row %v", t.OID, index)
+	}
+	r, err := t.newRow(index)
+	if err != nil {
+		return err
+	}
+	for id, val := range values {
+		r.values[id] = val
+	}
+	if !t.ready(r) {
+		return errors.Errorf("RowStatusTable %v: row %v is not ready", t.OID, index)
+	}
+	r.status = RowStatusActive
+	if t.priv.subAgent != nil {
+		if err := t.priv.subAgent.AddOIDs(r.items...); err != nil {
+			return err
+		}
+	}
+	t.priv.rows[index] = r
+	return nil
+}
+
+// RemoveRow removes a row. Not existing rows are ignored.
+func (t *RowStatusTable) RemoveRow(index string) {
+	t.priv.mu.Lock()
+	defer t.priv.mu.Unlock()
+	if r, ok := t.priv.rows[index]; ok {
+		t.destroy(r)
+	}
+}
+
+// All returns the OIDs of all rows
+func (t *RowStatusTable) All() []*PDUValueControlItem {
+	t.priv.mu.Lock()
+	defer t.priv.mu.Unlock()
+	toRet := []*PDUValueControlItem{}
+	for _, each := range t.priv.rows {
+		toRet = append(toRet, each.items...)
+	}
+	return toRet
+}
+
+// Rows returns copies of all rows sorted by index
+func (t *RowStatusTable) Rows() []RowStatusRow {
+	t.priv.mu.Lock()
+	defer t.priv.mu.Unlock()
+	toRet := make([]RowStatusRow, 0, len(t.priv.rows))
+	for _, each := range t.priv.rows {
+		toRet = append(toRet, each.copy())
+	}
+	// indexes are checked by newRow
+	arcs := make(map[string]ByteString, len(toRet))
+	for _, each := range toRet {
+		arcs[each.Index], _ = parseOID(each.Index)
+	}
+	sort.Slice(toRet, func(i, j int) bool {
+		return compareByteString(arcs[toRet[i].Index], arcs[toRet[j].Index]) == ByteStringCompareResultLessThen
+	})
+	return toRet
+}
+
+// ActiveRow returns a copy of the row if it exists and is active
+func (t *RowStatusTable) ActiveRow(index string) (RowStatusRow, bool) {
+	t.priv.mu.Lock()
+	defer t.priv.mu.Unlock()
+	if r, ok := t.priv.rows[index]; ok && r.status == RowStatusActive {
+		return r.copy(), true
+	}
+	return RowStatusRow{}, false
+}
+
+func (r *rowStatusRow) copy() RowStatusRow {
+	values := make(map[int]interface{}, len(r.values))
+	for id, val := range r.values {
+		values[id] = val
+	}
+	return RowStatusRow{Index: r.index, Values: values, Status: r.status}
+}
+
+// newRow makes a row with default values
+func (t *RowStatusTable) newRow(index string) (*rowStatusRow, error) {
+	if index == "" || VerifyOid(index) != nil {
+		return nil, errors.Errorf("RowStatusTable %v: not valid index %q", t.OID, index)
+	}
+	var indexValues map[int]interface{}
+	if t.OnParseIndex != nil {
+		var err error
+		if indexValues, err = t.OnParseIndex(index); err != nil {
+			return nil, errors.WithMessagef(err, "RowStatusTable %v: not valid index %v", t.OID, index)
+		}
+	}
+	ret := &rowStatusRow{
+		index:  index,
+		values: make(map[int]interface{}),
+		status: RowStatusNotReady,
+	}
+	for _, each := range t.Columns {
+		if each.DefVal != nil {
+			ret.values[each.ID] = each.DefVal
+		}
+	}
+	for id, val := range indexValues {
+		ret.values[id] = val
+	}
+	for _, each := range t.Columns {
+		if each.NotAccessible || each.ID == t.RowStatusColumn {
+			continue
+		}
+		ret.items = append(ret.items, t.makeItem(ret, each))
+	}
+	ret.items = append(ret.items, t.makeRowStatusItem(ret))
+	return ret, nil
+}
+
+// createForOID creates a row on SET of a not existing cell
+func (t *RowStatusTable) createForOID(oid string) ([]*PDUValueControlItem, error) {
+	t.priv.mu.Lock()
+	defer t.priv.mu.Unlock()
+	prefix := t.OID + "."
+	oid = strings.TrimPrefix(oid, ".")
+	if !strings.HasPrefix(oid, prefix) {
+		return nil, nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(oid, prefix), ".", 2)
+	if len(parts) != 2 {
+		return nil, errors.Errorf("RowStatusTable %v: no index in %v", t.OID, oid)
+	}
+	colID, err := strconv.Atoi(parts[0])
+	if column := t.column(colID); err != nil || column == nil || column.NotAccessible {
+		return nil, errors.Errorf("RowStatusTable %v: no column for %v", t.OID, oid)
+	}
+	if _, exists := t.priv.rows[parts[1]]; exists {
+		return nil, nil
+	}
+	r, err := t.newRow(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	r.fresh = true
+	t.priv.rows[r.index] = r
+	t.priv.created = append(t.priv.created, r)
+	return r.items, nil
+}
+
+// testSet checks the varbinds of the rows together, as they are set as if simultaneously. See RFC 3416 section 4.2.5
+//
+//	Values of the other columns are kept as pending, so that the RowStatus is decided with them.
+//	SETs of cells of new rows without their RowStatus fail, as the rows would never be created.
+func (t *RowStatusTable) testSet(items []setRequestItem) (*setRequestItem, gosnmp.SNMPError) {
+	t.priv.mu.Lock()
+	defer t.priv.mu.Unlock()
+	toSet := make(map[*PDUValueControlItem]bool, len(items))
+	for _, each := range items {
+		toSet[each.item] = true
+		r, col := t.rowForItem(each.item)
+		if r == nil || col.ID == t.RowStatusColumn {
+			continue
+		}
+		// wrong values are rejected by OnTestSet of the item
+		if val, err := col.unwrap(each.varItem.Value); err == nil {
+			if r.pending == nil {
+				r.pending = make(map[int]interface{})
+				t.priv.pending = append(t.priv.pending, r)
+			}
+			r.pending[col.ID] = val
+		}
+	}
+	for id, each := range items {
+		r, col := t.rowForItem(each.item)
+		if r == nil {
+			continue
+		}
+		// the RowStatus item is the last one
+		if r.fresh && !toSet[r.items[len(r.items)-1]] {
+			return &items[id], gosnmp.InconsistentName
+		}
+		if col.ID != t.RowStatusColumn {
+			continue
+		}
+		if val, ok := each.varItem.Value.(int); ok && !t.ready(r) &&
+			(RowStatus(val) == RowStatusCreateAndGo || RowStatus(val) == RowStatusActive) {
+			return &items[id], gosnmp.InconsistentValue
+		}
+	}
+	return nil, gosnmp.NoError
+}
+
+// endSet destroys rows created by the SET and not given a RowStatus, as the SET failed
+func (t *RowStatusTable) endSet() {
+	t.priv.mu.Lock()
+	defer t.priv.mu.Unlock()
+	for _, r := range t.priv.created {
+		if r.fresh && t.priv.rows[r.index] == r {
+			t.destroy(r)
+		}
+	}
+	for _, r := range t.priv.pending {
+		r.pending = nil
+	}
+	t.priv.created, t.priv.pending = nil, nil
+}
+
+// rowForItem returns the row and the column of a cell of this table. nil if not
+func (t *RowStatusTable) rowForItem(item *PDUValueControlItem) (*rowStatusRow, *RowStatusColumn) {
+	parts := strings.SplitN(strings.TrimPrefix(item.OID, t.OID+"."), ".", 2)
+	if len(parts) != 2 {
+		return nil, nil
+	}
+	r, ok := t.priv.rows[parts[1]]
+	if !ok || !r.hasItem(item) {
+		return nil, nil
+	}
+	colID, _ := strconv.Atoi(parts[0])
+	return r, t.column(colID)
+}
+
+func (r *rowStatusRow) hasItem(item *PDUValueControlItem) bool {
+	for _, each := range r.items {
+		if each == item {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *RowStatusTable) destroy(r *rowStatusRow) {
+	delete(t.priv.rows, r.index)
+	if t.priv.subAgent != nil {
+		oids := []string{}
+		for _, each := range r.items {
+			oids = append(oids, each.OID)
+		}
+		t.priv.subAgent.RemoveOIDs(oids...)
+	}
+}
+
+// ready checks if all columns without default value are set, or pending in the SET being served
+func (t *RowStatusTable) ready(r *rowStatusRow) bool {
+	for _, each := range t.Columns {
+		if each.ID == t.RowStatusColumn {
+			continue
+		}
+		if _, ok := r.values[each.ID]; ok {
+			continue
+		}
+		if _, ok := r.pending[each.ID]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// persist calls OnPersist for rows not fresh
+func (t *RowStatusTable) persist(r *rowStatusRow, destroyed bool) error {
+	if t.OnPersist == nil || r.fresh {
+		return nil
+	}
+	row := r.copy()
+	if destroyed {
+		row.Status = RowStatusDestroy
+	}
+	return t.OnPersist(row)
+}
+
+func (t *RowStatusTable) makeItem(r *rowStatusRow, col *RowStatusColumn) *PDUValueControlItem {
+	// prev keeps the value before the last commit for undo
+	var prev interface{}
+	return &PDUValueControlItem{
+		OID:  fmt.Sprintf("%v.%v.%v", t.OID, col.ID, r.index),
+		Type: col.Type,
+		OnGet: func() (value interface{}, err error) {
+			t.priv.mu.Lock()
+			defer t.priv.mu.Unlock()
+			if val, ok := r.values[col.ID]; ok {
+				return val, nil
+			}
+			return getRowStatusZeroValue(col.Type), nil
+		},
+		OnTestSet: func(value interface{}) error {
+			_, err := col.unwrap(value)
+			return err
+		},
+		OnSet: func(value interface{}) error {
+			t.priv.mu.Lock()
+			defer t.priv.mu.Unlock()
+			val, err := col.unwrap(value)
+			if err != nil {
+				return err
+			}
+			prev = r.values[col.ID]
+			r.values[col.ID] = val
+			status := r.status
+			if r.status == RowStatusNotReady && !r.fresh && t.ready(r) {
+				r.status = RowStatusNotInService
+			}
+			if status == r.status && reflect.DeepEqual(prev, val) {
+				// unchanged, or applied by the RowStatus of the same SET
+				return nil
+			}
+			return t.persist(r, false)
+		},
+		OnUndoSet: func(value interface{}) error {
+			t.priv.mu.Lock()
+			defer t.priv.mu.Unlock()
+			if prev == nil {
+				delete(r.values, col.ID)
+			} else {
+				r.values[col.ID] = prev
+			}
+			return t.persist(r, false)
+		},
+		Document: col.Document,
+	}
+}
+
+func (t *RowStatusTable) makeRowStatusItem(r *rowStatusRow) *PDUValueControlItem {
+	col := t.column(t.RowStatusColumn)
+	// status, fresh and values before the last commit for undo
+	var prevStatus RowStatus
+	var prevFresh bool
+	var prevValues map[int]interface{}
+	return &PDUValueControlItem{
+		OID:  fmt.Sprintf("%v.%v.%v", t.OID, col.ID, r.index),
+		Type: gosnmp.Integer,
+		OnGet: func() (value interface{}, err error) {
+			t.priv.mu.Lock()
+			defer t.priv.mu.Unlock()
+			return Asn1IntegerWrap(int(r.status)), nil
+		},
+		OnTestSet: func(value interface{}) error {
+			val, ok := value.(int)
+			if !ok || val < int(RowStatusActive) || val > int(RowStatusDestroy) || val == int(RowStatusNotReady) {
+				// notReady could not be set by managers
+				return errors.Errorf("RowStatusTable %v: wrong RowStatus %v", t.OID, value)
+			}
+			return nil
+		},
+		OnSet: func(value interface{}) error {
+			t.priv.mu.Lock()
+			defer t.priv.mu.Unlock()
+			val, ok := value.(int)
+			if !ok {
+				return errors.Errorf("RowStatusTable %v: wrong RowStatus %v", t.OID, value)
+			}
+			prevStatus, prevFresh, prevValues = r.status, r.fresh, r.copy().Values
+			// the other columns of the SET are applied first, whatever the order of the varbinds
+			for id, each := range r.pending {
+				r.values[id] = each
+			}
+			return t.setRowStatus(r, RowStatus(val))
+		},
+		OnUndoSet: func(value interface{}) error {
+			t.priv.mu.Lock()
+			defer t.priv.mu.Unlock()
+			r.status, r.fresh, r.values = prevStatus, prevFresh, prevValues
+			if _, exists := t.priv.rows[r.index]; !exists {
+				// destroyed by the SET
+				t.priv.rows[r.index] = r
+				if t.priv.subAgent != nil {
+					if err := t.priv.subAgent.AddOIDs(r.items...); err != nil {
+						return err
+					}
+				}
+			}
+			if r.fresh {
+				// created by the SET. it is destroyed by endSet, and removed from OnPersist
+				r.fresh = false
+				err := t.persist(r, true)
+				r.fresh = true
+				return err
+			}
+			return t.persist(r, false)
+		},
+		Document: col.Document,
+	}
+}
+
+// setRowStatus runs the RowStatus state machine. See RFC 2579
+func (t *RowStatusTable) setRowStatus(r *rowStatusRow, status RowStatus) error {
+	switch status {
+	case RowStatusCreateAndGo, RowStatusCreateAndWait:
+		if !r.fresh {
+			return errors.Errorf("RowStatusTable %v: row %v already exists", t.OID, r.index)
+		}
+		if !t.ready(r) && status == RowStatusCreateAndGo {
+			t.destroy(r)
+			return errors.Errorf("RowStatusTable %v: row %v is not ready", t.OID, r.index)
+		}
+		r.fresh = false
+		switch {
+		case !t.ready(r):
+			r.status = RowStatusNotReady
+		case status == RowStatusCreateAndGo:
+			r.status = RowStatusActive
+		default:
+			r.status = RowStatusNotInService
+		}
+	case RowStatusActive, RowStatusNotInService:
+		if r.fresh {
+			t.destroy(r)
+			return errors.Errorf("RowStatusTable %v: row %v does not exist", t.OID, r.index)
+		}
+		if !t.ready(r) {
+			return errors.Errorf("RowStatusTable %v: row %v is not ready", t.OID, r.index)
+		}
+		r.status = status
+	case RowStatusDestroy:
+		t.destroy(r)
+		return t.persist(r, true)
+	default:
+		return errors.Errorf("RowStatusTable %v: wrong RowStatus %v", t.OID, status)
+	}
+	return t.persist(r, false)
+}
+
+// unwrap converts and checks a SET value of the column
+func (col *RowStatusColumn) unwrap(value interface{}) (interface{}, error) {
+	var val interface{}
+	switch col.Type {
+	case gosnmp.Integer:
+		if each, ok := value.(int); ok {
+			val = each
+		}
+	case gosnmp.ObjectIdentifier:
+		if each, ok := value.(string); ok {
+			val = strings.TrimPrefix(each, ".")
+		}
+	case gosnmp.OctetString:
+		switch each := value.(type) {
+		case string:
+			val = each
+		case []byte:
+			val = string(each)
+		}
+	default:
+		val = value
+	}
+	if val == nil {
+		return nil, errors.Errorf("wrong type %T for %v", value, col.Document)
+	}
+	if col.OnTestSet != nil {
+		if err := col.OnTestSet(val); err != nil {
+			return nil, errors.WithMessagef(err, "%v", col.Document)
+		}
+	}
+	return val, nil
+}
+
+func getRowStatusZeroValue(typ gosnmp.Asn1BER) interface{} {
+	switch typ {
+	case gosnmp.Integer:
+		return Asn1IntegerWrap(0)
+	case gosnmp.ObjectIdentifier:
+		return Asn1ObjectIdentifierWrap("0.0")
+	case gosnmp.OctetString:
+		return Asn1OctetStringWrap("")
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,369 @@
+package GoSNMPServer
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+const rowStatusTestEntry = "1.3.6.1.4.1.9999.5.1"
+
+type RowStatusTests struct {
+	suite.Suite
+	Logger ILogger
+
+	shandle *SNMPServer
+	table   *RowStatusTable
+	// mu guards persisted, which OnPersist appends on the serving goroutine
+	mu        sync.Mutex
+	persisted []RowStatusRow
+}
+
+// getPersisted returns a copy of the rows persisted so far
+func (suite *RowStatusTests) getPersisted() []RowStatusRow {
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+	return append([]RowStatusRow{}, suite.persisted...)
+}
+
+// countOIDs returns the number of items of the SubAgent, which rows add and remove at runtime
+func (suite *RowStatusTests) countOIDs() int {
+	subAgent := suite.table.priv.subAgent
+	subAgent.mu.RLock()
+	defer subAgent.mu.RUnlock()
+	return len(subAgent.OIDs)
+}
+
+func (suite *RowStatusTests) SetupTest() {
+	logger := NewDefaultLogger()
+	logger.(*DefaultLogger).Level = logrus.InfoLevel
+	suite.Logger = logger
+	suite.persisted = nil
+
+	suite.table = &RowStatusTable{
+		OID: rowStatusTestEntry,
+		Columns: []*RowStatusColumn{
+			{ID: 1, Type: gosnmp.Integer, NotAccessible: true},
+			{ID: 2, Type: gosnmp.OctetString},
+			{ID: 3, Type: gosnmp.Integer, DefVal: 10,
+				OnTestSet: func(value interface{}) error {
+					if value.(int) < 0 {
+						return errors.New("negative")
+					}
+					return nil
+				}},
+			{ID: 4, Type: gosnmp.Integer},
+		},
+		RowStatusColumn: 4,
+		OnParseIndex: func(index string) (map[int]interface{}, error) {
+			id, err := strconv.Atoi(index)
+			if err != nil {
+				return nil, err
+			}
+			return map[int]interface{}{1: id}, nil
+		},
+		OnPersist: func(row RowStatusRow) error {
+			suite.mu.Lock()
+			suite.persisted = append(suite.persisted, row)
+			suite.mu.Unlock()
+			return nil
+		},
+	}
+	if err := suite.table.AddRow("1", map[int]interface{}{2: "first"}); err != nil {
+		panic(err)
+	}
+	subAgent := &SubAgent{CommunityIDs: []string{"private"}, UserErrorMarkPacket: true}
+	if err := suite.table.Attach(subAgent); err != nil {
+		panic(err)
+	}
+	master := MasterAgent{
+		Logger:    suite.Logger,
+		SubAgents: []*SubAgent{subAgent},
+	}
+	suite.shandle = NewSNMPServer(master)
+	if err := suite.shandle.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	go suite.shandle.ServeForever()
+}
+
+func (suite *RowStatusTests) TearDownTest() {
+	suite.shandle.Shutdown()
+}
+
+func (suite *RowStatusTests) getClient() *gosnmp.GoSNMP {
+	serverAddress := suite.shandle.Address().(*net.UDPAddr)
+	client := &gosnmp.GoSNMP{
+		Target:    serverAddress.IP.String(),
+		Port:      uint16(serverAddress.Port),
+		Version:   gosnmp.Version2c,
+		Community: "private",
+		Timeout:   time.Second,
+	}
+	if err := client.Connect(); err != nil {
+		panic(err)
+	}
+	return client
+}
+
+func rowStatusPDU(column int, index string, value interface{}) gosnmp.SnmpPDU {
+	pdu := gosnmp.SnmpPDU{Name: rowStatusTestEntry + "." + TableIndexJoin(TableIndexInteger(column), index), Value: value}
+	switch value.(type) {
+	case string:
+		pdu.Type = gosnmp.OctetString
+	case RowStatus:
+		pdu.Type, pdu.Value = gosnmp.Integer, int(value.(RowStatus))
+	default:
+		pdu.Type = gosnmp.Integer
+	}
+	return pdu
+}
+
+func (suite *RowStatusTests) TestCreateAndGo() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+
+	result, err := client.Set([]gosnmp.SnmpPDU{
+		rowStatusPDU(2, "2", "second"),
+		rowStatusPDU(4, "2", RowStatusCreateAndGo),
+	})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.NoError, result.Error)
+
+	rows := suite.table.Rows()
+	if assert.Equal(suite.T(), 2, len(rows)) {
+		assert.Equal(suite.T(), RowStatusRow{
+			Index:  "2",
+			Values: map[int]interface{}{1: 2, 2: "second", 3: 10},
+			Status: RowStatusActive,
+		}, rows[1])
+	}
+	if persisted := suite.getPersisted(); assert.Equal(suite.T(), 1, len(persisted)) {
+		assert.Equal(suite.T(), RowStatusActive, persisted[0].Status)
+	}
+
+	walked, err := client.WalkAll(rowStatusTestEntry)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), []string{
+		"." + rowStatusTestEntry + ".2.1",
+		"." + rowStatusTestEntry + ".2.2",
+		"." + rowStatusTestEntry + ".3.1",
+		"." + rowStatusTestEntry + ".3.2",
+		"." + rowStatusTestEntry + ".4.1",
+		"." + rowStatusTestEntry + ".4.2",
+	}, getNames(walked))
+
+	// the row exists
+	result, err = client.Set([]gosnmp.SnmpPDU{rowStatusPDU(4, "2", RowStatusCreateAndGo)})
+	assert.Nil(suite.T(), err)
+	assert.NotEqual(suite.T(), gosnmp.NoError, result.Error)
+}
+
+// TestRowStatusFirst sets the RowStatus before the other columns, as varbinds are set as if simultaneously
+func (suite *RowStatusTests) TestRowStatusFirst() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+
+	result, err := client.Set([]gosnmp.SnmpPDU{
+		rowStatusPDU(4, "2", RowStatusCreateAndGo),
+		rowStatusPDU(2, "2", "second"),
+	})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.NoError, result.Error)
+	row, active := suite.table.ActiveRow("2")
+	assert.True(suite.T(), active)
+	assert.Equal(suite.T(), "second", row.Values[2])
+	if persisted := suite.getPersisted(); assert.Equal(suite.T(), 1, len(persisted)) {
+		assert.Equal(suite.T(), row, persisted[0])
+	}
+
+	result, err = client.Set([]gosnmp.SnmpPDU{
+		rowStatusPDU(4, "3", RowStatusCreateAndWait),
+		rowStatusPDU(2, "3", "third"),
+	})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.NoError, result.Error)
+	result, err = client.Get([]string{rowStatusTestEntry + ".4.3"})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), int(RowStatusNotInService), result.Variables[0].Value)
+
+	// not ready without the column
+	result, err = client.Set([]gosnmp.SnmpPDU{
+		rowStatusPDU(4, "4", RowStatusCreateAndGo),
+		rowStatusPDU(3, "4", 5),
+	})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.InconsistentValue, result.Error)
+	assert.Equal(suite.T(), uint8(0), result.ErrorIndex)
+	assert.Equal(suite.T(), 3, len(suite.table.Rows()))
+}
+
+func (suite *RowStatusTests) TestCreateAndGoNotReady() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+
+	result, err := client.Set([]gosnmp.SnmpPDU{
+		rowStatusPDU(3, "3", 5),
+		rowStatusPDU(4, "3", RowStatusCreateAndGo),
+	})
+	assert.Nil(suite.T(), err)
+	assert.NotEqual(suite.T(), gosnmp.NoError, result.Error)
+	assert.Equal(suite.T(), 1, len(suite.table.Rows()))
+	assert.Equal(suite.T(), 0, len(suite.getPersisted()))
+
+	result, err = client.Get([]string{rowStatusTestEntry + ".3.3"})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.NoSuchInstance, result.Variables[0].Type)
+}
+
+func (suite *RowStatusTests) TestFailedCreation() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+	oids := suite.countOIDs()
+
+	// rejected by OnTestSet of the column
+	result, err := client.Set([]gosnmp.SnmpPDU{
+		rowStatusPDU(3, "7", -1),
+		rowStatusPDU(4, "7", RowStatusCreateAndGo),
+	})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.WrongValue, result.Error)
+	// cells of a new row without its RowStatus
+	result, err = client.Set([]gosnmp.SnmpPDU{rowStatusPDU(2, "7", "seventh")})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.InconsistentName, result.Error)
+
+	assert.Equal(suite.T(), 1, len(suite.table.Rows()))
+	assert.Equal(suite.T(), oids, suite.countOIDs())
+	walked, err := client.WalkAll(rowStatusTestEntry)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 3, len(walked))
+}
+
+func (suite *RowStatusTests) TestUndo() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+	assert.Nil(suite.T(), suite.table.priv.subAgent.AddOIDs(&PDUValueControlItem{
+		OID:         "1.3.6.1.4.1.9999.5.2.0",
+		Type:        gosnmp.Integer,
+		OnCommitSet: func(value interface{}) error { return errors.New("commit failed") },
+	}))
+	failing := gosnmp.SnmpPDU{Name: "1.3.6.1.4.1.9999.5.2.0", Type: gosnmp.Integer, Value: 1}
+
+	result, err := client.Set([]gosnmp.SnmpPDU{rowStatusPDU(4, "1", RowStatusDestroy), failing})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.CommitFailed, result.Error)
+	_, active := suite.table.ActiveRow("1")
+	assert.True(suite.T(), active)
+
+	result, err = client.Set([]gosnmp.SnmpPDU{
+		rowStatusPDU(2, "2", "second"),
+		rowStatusPDU(4, "2", RowStatusCreateAndGo),
+		failing,
+	})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.CommitFailed, result.Error)
+	assert.Equal(suite.T(), 1, len(suite.table.Rows()))
+
+	statuses := []RowStatus{}
+	for _, each := range suite.getPersisted() {
+		statuses = append(statuses, each.Status)
+	}
+	assert.Equal(suite.T(), []RowStatus{RowStatusDestroy, RowStatusActive, RowStatusActive, RowStatusDestroy}, statuses)
+}
+
+func (suite *RowStatusTests) TestCreateAndWait() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+
+	result, err := client.Set([]gosnmp.SnmpPDU{rowStatusPDU(4, "3", RowStatusCreateAndWait)})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.NoError, result.Error)
+	result, err = client.Get([]string{rowStatusTestEntry + ".4.3"})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), int(RowStatusNotReady), result.Variables[0].Value)
+
+	// not ready yet
+	result, err = client.Set([]gosnmp.SnmpPDU{rowStatusPDU(4, "3", RowStatusActive)})
+	assert.Nil(suite.T(), err)
+	assert.NotEqual(suite.T(), gosnmp.NoError, result.Error)
+
+	result, err = client.Set([]gosnmp.SnmpPDU{rowStatusPDU(2, "3", "third")})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.NoError, result.Error)
+	result, err = client.Get([]string{rowStatusTestEntry + ".4.3"})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), int(RowStatusNotInService), result.Variables[0].Value)
+	_, active := suite.table.ActiveRow("3")
+	assert.False(suite.T(), active)
+
+	result, err = client.Set([]gosnmp.SnmpPDU{rowStatusPDU(4, "3", RowStatusActive)})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.NoError, result.Error)
+	row, active := suite.table.ActiveRow("3")
+	assert.True(suite.T(), active)
+	assert.Equal(suite.T(), "third", row.Values[2])
+	persisted := suite.getPersisted()
+	assert.Equal(suite.T(), RowStatusActive, persisted[len(persisted)-1].Status)
+}
+
+func (suite *RowStatusTests) TestWrongValue() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+
+	for _, pdu := range []gosnmp.SnmpPDU{
+		rowStatusPDU(3, "1", -1),
+		rowStatusPDU(4, "1", RowStatusNotReady),
+		rowStatusPDU(4, "1", 7),
+	} {
+		result, err := client.Set([]gosnmp.SnmpPDU{pdu})
+		assert.Nil(suite.T(), err)
+		assert.Equal(suite.T(), gosnmp.WrongValue, result.Error)
+	}
+	// an Integer for an OctetString column
+	result, err := client.Set([]gosnmp.SnmpPDU{rowStatusPDU(2, "1", 1)})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.WrongType, result.Error)
+	row, _ := suite.table.ActiveRow("1")
+	assert.Equal(suite.T(), 10, row.Values[3])
+}
+
+func (suite *RowStatusTests) TestDestroy() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+
+	result, err := client.Set([]gosnmp.SnmpPDU{rowStatusPDU(4, "1", RowStatusDestroy)})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.NoError, result.Error)
+	assert.Equal(suite.T(), 0, len(suite.table.Rows()))
+	if persisted := suite.getPersisted(); assert.Equal(suite.T(), 1, len(persisted)) {
+		assert.Equal(suite.T(), "1", persisted[0].Index)
+		assert.Equal(suite.T(), RowStatusDestroy, persisted[0].Status)
+	}
+	result, err = client.Get([]string{rowStatusTestEntry + ".2.1"})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.NoSuchInstance, result.Variables[0].Type)
+}
+
+// TestLargeIndex sorts indexes with arcs above 2^31-1, which are valid sub-identifiers
+func (suite *RowStatusTests) TestLargeIndex() {
+	assert.Nil(suite.T(), suite.table.AddRow("3000000000", map[int]interface{}{2: "large"}))
+	assert.Nil(suite.T(), suite.table.AddRow("20", map[int]interface{}{2: "twenty"}))
+	indexes := []string{}
+	for _, each := range suite.table.Rows() {
+		indexes = append(indexes, each.Index)
+	}
+	assert.Equal(suite.T(), []string{"1", "20", "3000000000"}, indexes)
+}
+
+func TestRowStatusTestsSuite(t *testing.T) {
+	suite.Run(t, new(RowStatusTests))
+}
@@ -0,0 +1,198 @@
+package GoSNMPServer
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type SetRequestTests struct {
+	suite.Suite
+	Logger ILogger
+
+	shandle *SNMPServer
+	// mu guards values, which items set on the serving goroutine
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (suite *SetRequestTests) value(oid string) string {
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+	return suite.values[oid]
+}
+
+func (suite *SetRequestTests) setValue(oid string, value string) {
+	suite.mu.Lock()
+	suite.values[oid] = value
+	suite.mu.Unlock()
+}
+
+// copyValues returns a copy of values to compare
+func (suite *SetRequestTests) copyValues() map[string]string {
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+	ret := map[string]string{}
+	for oid, value := range suite.values {
+		ret[oid] = value
+	}
+	return ret
+}
+
+// makeItem makes an item which stores its value in suite.values
+//
+//	values starts with "bad" are rejected by OnTestSet, "fail" fails on commit.
+func (suite *SetRequestTests) makeItem(oid string, undoable bool) *PDUValueControlItem {
+	item := &PDUValueControlItem{
+		OID:  oid,
+		Type: gosnmp.OctetString,
+		OnGet: func() (value interface{}, err error) {
+			return Asn1OctetStringWrap(suite.value(oid)), nil
+		},
+		OnTestSet: func(value interface{}) error {
+			if Asn1OctetStringUnwrap(value) == "bad" {
+				return errors.New("bad value")
+			}
+			return nil
+		},
+	}
+	var prev string
+	item.OnCommitSet = func(value interface{}) error {
+		if Asn1OctetStringUnwrap(value) == "fail" {
+			return errors.New("commit failed")
+		}
+		prev = suite.value(oid)
+		suite.setValue(oid, Asn1OctetStringUnwrap(value))
+		return nil
+	}
+	if undoable {
+		item.OnUndoSet = func(value interface{}) error {
+			suite.setValue(oid, prev)
+			return nil
+		}
+	}
+	return item
+}
+
+func (suite *SetRequestTests) SetupTest() {
+	logger := NewDefaultLogger()
+	logger.(*DefaultLogger).Level = logrus.InfoLevel
+	suite.Logger = logger
+	suite.values = map[string]string{}
+
+	master := MasterAgent{
+		Logger: suite.Logger,
+		SubAgents: []*SubAgent{
+			{
+				CommunityIDs: []string{"private"},
+				OIDs: []*PDUValueControlItem{
+					suite.makeItem("1.3.6.1.4.1.9999.1.0", true),
+					suite.makeItem("1.3.6.1.4.1.9999.2.0", true),
+					suite.makeItem("1.3.6.1.4.1.9999.3.0", false),
+					{
+						OID:   "1.3.6.1.4.1.9999.4.0",
+						Type:  gosnmp.OctetString,
+						OnGet: func() (value interface{}, err error) { return Asn1OctetStringWrap("ro"), nil },
+					},
+				},
+			},
+		},
+	}
+	suite.shandle = NewSNMPServer(master)
+	if err := suite.shandle.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	go suite.shandle.ServeForever()
+}
+
+func (suite *SetRequestTests) TearDownTest() {
+	suite.shandle.Shutdown()
+}
+
+func (suite *SetRequestTests) set(version gosnmp.SnmpVersion, values ...string) *gosnmp.SnmpPacket {
+	serverAddress := suite.shandle.Address().(*net.UDPAddr)
+	client := &gosnmp.GoSNMP{
+		Target:    serverAddress.IP.String(),
+		Port:      uint16(serverAddress.Port),
+		Version:   version,
+		Community: "private",
+		Timeout:   time.Second,
+	}
+	if err := client.Connect(); err != nil {
+		panic(err)
+	}
+	defer client.Conn.Close()
+	pdus := []gosnmp.SnmpPDU{}
+	for id := 0; id+1 < len(values); id += 2 {
+		pdus = append(pdus, gosnmp.SnmpPDU{Name: values[id], Type: gosnmp.OctetString, Value: values[id+1]})
+	}
+	result, err := client.Set(pdus)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+func (suite *SetRequestTests) TestSetAll() {
+	result := suite.set(gosnmp.Version2c,
+		"1.3.6.1.4.1.9999.1.0", "a",
+		"1.3.6.1.4.1.9999.2.0", "b")
+	assert.Equal(suite.T(), gosnmp.NoError, result.Error)
+	assert.Equal(suite.T(), map[string]string{"1.3.6.1.4.1.9999.1.0": "a", "1.3.6.1.4.1.9999.2.0": "b"}, suite.copyValues())
+}
+
+func (suite *SetRequestTests) TestNothingSetOnTestFailure() {
+	result := suite.set(gosnmp.Version2c,
+		"1.3.6.1.4.1.9999.1.0", "a",
+		"1.3.6.1.4.1.9999.2.0", "bad")
+	assert.Equal(suite.T(), gosnmp.WrongValue, result.Error)
+	assert.Equal(suite.T(), 0, len(suite.copyValues()))
+
+	result = suite.set(gosnmp.Version1,
+		"1.3.6.1.4.1.9999.1.0", "bad")
+	assert.Equal(suite.T(), gosnmp.BadValue, result.Error)
+
+	result = suite.set(gosnmp.Version2c,
+		"1.3.6.1.4.1.9999.1.0", "a",
+		"1.3.6.1.4.1.9999.4.0", "b")
+	assert.Equal(suite.T(), gosnmp.ReadOnly, result.Error)
+	assert.Equal(suite.T(), 0, len(suite.copyValues()))
+}
+
+func (suite *SetRequestTests) TestUndoOnCommitFailure() {
+	suite.setValue("1.3.6.1.4.1.9999.1.0", "old")
+	result := suite.set(gosnmp.Version2c,
+		"1.3.6.1.4.1.9999.1.0", "a",
+		"1.3.6.1.4.1.9999.2.0", "b",
+		"1.3.6.1.4.1.9999.3.0", "fail")
+	assert.Equal(suite.T(), gosnmp.CommitFailed, result.Error)
+	assert.Equal(suite.T(), "old", suite.value("1.3.6.1.4.1.9999.1.0"))
+	assert.Equal(suite.T(), "", suite.value("1.3.6.1.4.1.9999.2.0"))
+
+	result = suite.set(gosnmp.Version1,
+		"1.3.6.1.4.1.9999.1.0", "a",
+		"1.3.6.1.4.1.9999.2.0", "fail")
+	assert.Equal(suite.T(), gosnmp.GenErr, result.Error)
+	assert.Equal(suite.T(), "old", suite.value("1.3.6.1.4.1.9999.1.0"))
+}
+
+func (suite *SetRequestTests) TestUndoFailure() {
+	result := suite.set(gosnmp.Version2c,
+		"1.3.6.1.4.1.9999.1.0", "a",
+		"1.3.6.1.4.1.9999.3.0", "c",
+		"1.3.6.1.4.1.9999.2.0", "fail")
+	assert.Equal(suite.T(), gosnmp.UndoFailed, result.Error)
+	assert.Equal(suite.T(), "", suite.value("1.3.6.1.4.1.9999.1.0"))
+	assert.Equal(suite.T(), "c", suite.value("1.3.6.1.4.1.9999.3.0"))
+}
+
+func TestSetRequestTestsSuite(t *testing.T) {
+	suite.Run(t, new(SetRequestTests))
+}
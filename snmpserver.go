@@ -1,13 +1,55 @@
 package GoSNMPServer
 
+import "context"
 import "net"
 import "github.com/pkg/errors"
 import "reflect"
+import "sync"
+import "sync/atomic"
+import "time"
 
 type SNMPServer struct {
-	wconnStream ISnmpServerListener
-	master      MasterAgent
-	logger      ILogger
+	wconnStreams []ISnmpServerListener
+	logger       ILogger
+
+	// master is replaced by Reload. See getMaster
+	master   *MasterAgent
+	masterMu sync.RWMutex
+
+	// requests merges requests of all listeners. See nextSnmp
+	mergeOnce sync.Once
+	requests  chan snmpRequest
+	done      chan struct{}
+	doneOnce  sync.Once
+
+	workerPool WorkerPoolConfig
+	// maxMessageSize of SetMaxMessageSize, applied to every MasterAgent of Reload. 0 if not set
+	maxMessageSize int
+}
+
+type snmpRequest struct {
+	bytePDU []byte
+	replyer IReplyer
+	err     error
+}
+
+// WorkerPoolConfig serves requests concurrently. See SNMPServer.SetWorkerPool
+type WorkerPoolConfig struct {
+	// Workers is the number of requests served at the same time. 0 or 1 serves requests one by one.
+	Workers int
+	// QueueDepth is the number of requests waiting for a worker. Requests are dropped when it is full,
+	//            managers will retry them.
+	QueueDepth int
+	// RequestTimeout is the deadline of a request since it is read. Requests waiting longer are dropped,
+	//                and responses done later are not sent. 0 for no deadline.
+	RequestTimeout time.Duration
+}
+
+// queuedRequest is a request waiting for a worker
+type queuedRequest struct {
+	bytePDU  []byte
+	replyer  IReplyer
+	deadline time.Time
 }
 
 func NewSNMPServer(master MasterAgent) *SNMPServer {
@@ -15,43 +57,197 @@ func NewSNMPServer(master MasterAgent) *SNMPServer {
 	if err := master.ReadyForWork(); err != nil {
 		panic(err)
 	}
-	ret.master = master
+	ret.master = &master
 	ret.logger = master.Logger
+	ret.requests = make(chan snmpRequest)
+	ret.done = make(chan struct{})
 	return ret
 }
 
-func (server *SNMPServer) ListenUDP(l3proto, address string) error {
-	if server.wconnStream != nil {
-		return errors.New("Listened")
+// Reload replaces the MasterAgent while serving, without closing listeners.
+//
+//	master is checked by ReadyForWork first. If it fails the error is returned and the running
+//	MasterAgent keeps serving. Requests being served finish with the MasterAgent they started with.
+//	AgentX sessions and their registrations are kept, as well as snmpEngineBoots and snmpEngineTime
+//	if the engineID is not changed. master should have SubAgents and VACM of its own,
+//	those of the running MasterAgent are rejected, as they could not be checked without changing them.
+func (server *SNMPServer) Reload(master MasterAgent) error {
+	old := server.getMaster()
+	for _, each := range master.SubAgents {
+		for _, running := range old.SubAgents {
+			if each == running {
+				return errors.Errorf("Reload: SubAgent %v is of the running MasterAgent", each.CommunityIDs)
+			}
+		}
 	}
+	if master.VACM != nil && master.VACM == old.VACM {
+		return errors.New("Reload: VACM is of the running MasterAgent")
+	}
+	master.priv.agentx = old.priv.agentx
+	master.priv.engine = old.priv.engine
+	if server.maxMessageSize != 0 {
+		master.MaxMessageSize = server.maxMessageSize
+	}
+	if err := master.ReadyForWork(); err != nil {
+		return errors.WithMessage(err, "Reload")
+	}
+	server.masterMu.Lock()
+	server.master = &master
+	server.masterMu.Unlock()
+	master.priv.agentx.setMaster(&master)
+	atomic.AddUint32(&master.priv.authFailures, old.AuthenticationFailures())
+	for stat := range master.priv.usmStats {
+		atomic.AddUint32(&master.priv.usmStats[stat], old.usmStat(usmStat(stat)))
+	}
+	server.logger.Infof("Reload: MasterAgent of %v SubAgents, %v users", len(master.SubAgents), len(master.SecurityConfig.Users))
+	return nil
+}
+
+// getMaster returns the MasterAgent serving now
+func (server *SNMPServer) getMaster() *MasterAgent {
+	server.masterMu.RLock()
+	defer server.masterMu.RUnlock()
+	return server.master
+}
+
+// Listen adds a listener. A server could serve many listeners at the same time.
+//
+//	Should be called before serving.
+func (server *SNMPServer) Listen(i ISnmpServerListener) {
+	i.SetupLogger(server.logger)
+	server.wconnStreams = append(server.wconnStreams, i)
+}
+
+func (server *SNMPServer) ListenUDP(l3proto, address string) error {
 	i, err := NewUDPListener(l3proto, address)
 	if err != nil {
 		return err
 	}
 	server.logger.Infof("ListenUDP: l3proto=%s, address=%s", l3proto, address)
-	i.SetupLogger(server.logger)
-	server.wconnStream = i
+	server.Listen(i)
+	return nil
+}
+
+// ListenTCP listens with a TCPListener of default limits. See RFC 3430
+func (server *SNMPServer) ListenTCP(l3proto, address string) error {
+	i, err := NewTCPListener(l3proto, address)
+	if err != nil {
+		return err
+	}
+	server.logger.Infof("ListenTCP: l3proto=%s, address=%s", l3proto, address)
+	server.Listen(i)
+	return nil
+}
+
+// ListenAgentX accepts AgentX subagents on network "unix" or "tcp". See MasterAgent.ServeAgentX
+func (server *SNMPServer) ListenAgentX(network, address string) error {
+	listener, err := listenAgentX(network, address)
+	if err != nil {
+		return err
+	}
+	server.logger.Infof("ListenAgentX: network=%s, address=%s", network, address)
+	master := server.getMaster()
+	master.priv.agentx.trackListener(listener)
+	go func() {
+		err := master.ServeAgentX(listener)
+		server.logger.Debugf("ListenAgentX: %v", err)
+	}()
 	return nil
 }
 
+// SetMaxMessageSize limits the size of responses of all listeners. See MasterAgent.MaxMessageSize
+//
+//	Should be called before serving. It overrides MaxMessageSize of MasterAgents of Reload as well.
+func (server *SNMPServer) SetMaxMessageSize(size int) {
+	server.maxMessageSize = size
+	server.getMaster().MaxMessageSize = size
+}
+
+// SetWorkerPool serves requests with a pool of workers.
+//
+//	MasterAgent and SubAgents are safe for concurrent use, while callbacks of PDUValueControlItem
+//	may be called concurrently. Should be called before serving.
+func (server *SNMPServer) SetWorkerPool(config WorkerPoolConfig) {
+	server.workerPool = config
+}
+
+// NotificationOriginator returns the originator to send traps / informs with. It changes on Reload
+func (server *SNMPServer) NotificationOriginator() *NotificationOriginator {
+	return &server.getMaster().NotificationOriginator
+}
+
+// Address returns the address of the first listener
 func (server *SNMPServer) Address() net.Addr {
-	return server.wconnStream.Address()
+	return server.wconnStreams[0].Address()
+}
+
+// Addresses returns the addresses of all listeners
+func (server *SNMPServer) Addresses() []net.Addr {
+	toRet := []net.Addr{}
+	for _, each := range server.wconnStreams {
+		toRet = append(toRet, each.Address())
+	}
+	return toRet
 }
 
 func (server *SNMPServer) Shutdown() {
 	server.logger.Infof("Shutdown server")
-	if server.wconnStream != nil {
-		server.wconnStream.Shutdown()
+	server.doneOnce.Do(func() { close(server.done) })
+	for _, each := range server.wconnStreams {
+		each.Shutdown()
+	}
+	server.getMaster().priv.agentx.shutdown()
+}
+
+// nextSnmp reads the next request of any listener
+func (server *SNMPServer) nextSnmp() ([]byte, IReplyer, error) {
+	switch len(server.wconnStreams) {
+	case 0:
+		return nil, nil, errors.New("Not Listen")
+	case 1:
+		return server.wconnStreams[0].NextSnmp()
+	}
+	server.mergeOnce.Do(func() {
+		for _, each := range server.wconnStreams {
+			go server.readListener(each)
+		}
+	})
+	select {
+	case request := <-server.requests:
+		return request.bytePDU, request.replyer, request.err
+	case <-server.done:
+		// readListener drops the errors of listeners closed by Shutdown
+		return nil, nil, &net.OpError{Op: "read", Net: "snmp", Err: errServerShutdown}
+	}
+}
+
+func (server *SNMPServer) readListener(i ISnmpServerListener) {
+	for {
+		bytePDU, replyer, err := i.NextSnmp()
+		select {
+		case server.requests <- snmpRequest{bytePDU, replyer, err}:
+		case <-server.done:
+			return
+		}
+		if err != nil {
+			return
+		}
 	}
 }
 
 func (server *SNMPServer) ServeForever() error {
-	if server.wconnStream == nil {
+	if len(server.wconnStreams) == 0 {
 		return errors.New("Not Listen")
 	}
 
+	serveNext := server.ServeNextRequest
+	if server.workerPool.Workers > 1 {
+		queue := server.startWorkers()
+		defer close(queue.requests)
+		serveNext = func() error { return server.queueNextRequest(queue) }
+	}
 	for {
-		err := server.ServeNextRequest()
+		err := serveNext()
 		if err != nil {
 			var opError *net.OpError
 			if errors.As(err, &opError) {
@@ -65,7 +261,66 @@ func (server *SNMPServer) ServeForever() error {
 	}
 }
 
-func (server *SNMPServer) ServeNextRequest() (err error) {
+// workerQueue passes requests to the workers of workerPool
+type workerQueue struct {
+	requests chan queuedRequest
+	// slots limits the requests being served or waiting to Workers + QueueDepth
+	slots chan struct{}
+}
+
+// startWorkers starts the workers of workerPool, which serve requests until the queue is closed
+func (server *SNMPServer) startWorkers() *workerQueue {
+	size := server.workerPool.Workers + server.workerPool.QueueDepth
+	queue := &workerQueue{
+		requests: make(chan queuedRequest, size),
+		slots:    make(chan struct{}, size),
+	}
+	server.logger.Infof("serving with %v workers, queue depth %v", server.workerPool.Workers, server.workerPool.QueueDepth)
+	for i := 0; i < server.workerPool.Workers; i++ {
+		go func() {
+			for request := range queue.requests {
+				server.serveRequest(request.bytePDU, request.replyer, request.deadline)
+				<-queue.slots
+			}
+		}()
+	}
+	return queue
+}
+
+// queueNextRequest reads the next request for the workers. It is dropped if the queue is full
+func (server *SNMPServer) queueNextRequest(queue *workerQueue) error {
+	bytePDU, replyer, err := server.nextSnmp()
+	if err != nil {
+		return err
+	}
+	request := queuedRequest{bytePDU: bytePDU, replyer: replyer}
+	if server.workerPool.RequestTimeout > 0 {
+		request.deadline = time.Now().Add(server.workerPool.RequestTimeout)
+	}
+	select {
+	case queue.slots <- struct{}{}:
+		queue.requests <- request
+	default:
+		server.logger.Warnf("request dropped: all workers are busy and the queue is full")
+	}
+	return nil
+}
+
+func (server *SNMPServer) ServeNextRequest() error {
+	bytePDU, replyer, err := server.nextSnmp()
+	if err != nil {
+		return err
+	}
+	var deadline time.Time
+	if server.workerPool.RequestTimeout > 0 {
+		deadline = time.Now().Add(server.workerPool.RequestTimeout)
+	}
+	server.serveRequest(bytePDU, replyer, deadline)
+	return nil
+}
+
+// serveRequest replies to a request. Requests past the deadline are dropped, zero deadline for none
+func (server *SNMPServer) serveRequest(bytePDU []byte, replyer IReplyer, deadline time.Time) {
 	defer func() {
 		if err := recover(); err != nil {
 			switch err.(type) {
@@ -78,11 +333,31 @@ func (server *SNMPServer) ServeNextRequest() (err error) {
 			return
 		}
 	}()
-	bytePDU, replyer, err := server.wconnStream.NextSnmp()
-	if err != nil {
-		return err
+	if !deadline.IsZero() && time.Now().After(deadline) {
+		server.logger.Warnf("request dropped: waited longer than %v", server.workerPool.RequestTimeout)
+		return
+	}
+	ctx := context.Background()
+	if addressed, ok := replyer.(IRemoteAddressReplyer); ok {
+		ctx = WithSourceAddress(ctx, addressed.RemoteAddress())
+	}
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	var result []byte
+	var err error
+	master := server.getMaster()
+	if secure, ok := replyer.(ISecureReplyer); ok {
+		result, err = master.ResponseForTransportBufferContext(ctx, bytePDU, secure.TransportSecurity())
+	} else {
+		result, err = master.ResponseForBufferContext(ctx, bytePDU)
+	}
+	if !deadline.IsZero() && time.Now().After(deadline) {
+		server.logger.Warnf("response dropped: served longer than %v", server.workerPool.RequestTimeout)
+		return
 	}
-	result, err := server.master.ResponseForBuffer(bytePDU)
 	if err != nil {
 		v := "with"
 		if len(result) == 0 {
@@ -92,13 +367,12 @@ func (server *SNMPServer) ServeNextRequest() (err error) {
 	}
 	if len(result) != 0 {
 		if errreply := replyer.ReplyPDU(result); errreply != nil {
-			server.logger.Errorf("Reply PDU meet err:", errreply)
+			server.logger.Errorf("Reply PDU meet err: %v", errreply)
+			// the connection of a stream could not be used any more. no-op for UDP
 			replyer.Shutdown()
-			return nil
+			return
 		}
 	}
-	if err != nil {
-		replyer.Shutdown()
-	}
-	return nil
+	// errors of a request do not close its connection, as other requests may be pending on it.
+	// framing errors of streams are handled by their listeners.
 }
@@ -0,0 +1,168 @@
+package GoSNMPServer
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type ReloadTests struct {
+	suite.Suite
+	Logger ILogger
+
+	shandle *SNMPServer
+	// started is signaled when the slow OID is being read, which returns after release
+	started chan struct{}
+	release chan struct{}
+}
+
+// newReloadMaster returns a MasterAgent of community, whose OIDs return value
+func (suite *ReloadTests) newReloadMaster(community, value string) MasterAgent {
+	return MasterAgent{
+		Logger: suite.Logger,
+		SubAgents: []*SubAgent{
+			{
+				CommunityIDs: []string{community},
+				OIDs: []*PDUValueControlItem{
+					{
+						OID:   "1.3.6.1.4.1.9999.100.1",
+						Type:  gosnmp.OctetString,
+						OnGet: func() (interface{}, error) { return Asn1OctetStringWrap(value), nil },
+					},
+					{
+						OID:  "1.3.6.1.4.1.9999.100.2",
+						Type: gosnmp.OctetString,
+						OnGet: func() (interface{}, error) {
+							suite.started <- struct{}{}
+							<-suite.release
+							return Asn1OctetStringWrap(value), nil
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (suite *ReloadTests) SetupTest() {
+	suite.Logger = NewDiscardLogger()
+	suite.started = make(chan struct{}, 1)
+	suite.release = make(chan struct{})
+	suite.shandle = NewSNMPServer(suite.newReloadMaster("old", "old value"))
+	suite.shandle.SetWorkerPool(WorkerPoolConfig{Workers: 2})
+	if err := suite.shandle.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	go suite.shandle.ServeForever()
+}
+
+func (suite *ReloadTests) TearDownTest() {
+	suite.shandle.Shutdown()
+}
+
+func (suite *ReloadTests) get(community, oid string) (string, error) {
+	serverAddress := suite.shandle.Address().(*net.UDPAddr)
+	client := &gosnmp.GoSNMP{
+		Target:    serverAddress.IP.String(),
+		Port:      uint16(serverAddress.Port),
+		Version:   gosnmp.Version2c,
+		Community: community,
+		Timeout:   time.Second,
+	}
+	if err := client.Connect(); err != nil {
+		panic(err)
+	}
+	defer client.Conn.Close()
+	result, err := client.Get([]string{oid})
+	if err != nil {
+		return "", err
+	}
+	if result.Error != gosnmp.NoError || result.Variables[0].Type != gosnmp.OctetString {
+		return "", errors.Errorf("%v of type %v", result.Error, result.Variables[0].Type)
+	}
+	return string(result.Variables[0].Value.([]byte)), nil
+}
+
+func (suite *ReloadTests) TestReload() {
+	value, err := suite.get("old", "1.3.6.1.4.1.9999.100.1")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "old value", value)
+	address := suite.shandle.Address().String()
+
+	assert.Nil(suite.T(), suite.shandle.Reload(suite.newReloadMaster("new", "new value")))
+	assert.Equal(suite.T(), address, suite.shandle.Address().String())
+	value, err = suite.get("new", "1.3.6.1.4.1.9999.100.1")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "new value", value)
+	_, err = suite.get("old", "1.3.6.1.4.1.9999.100.1")
+	assert.NotNil(suite.T(), err, "old community is removed")
+}
+
+func (suite *ReloadTests) TestMaxMessageSize() {
+	shandle := NewSNMPServer(suite.newReloadMaster("old", "old value"))
+	shandle.SetMaxMessageSize(1000)
+	assert.Nil(suite.T(), shandle.Reload(suite.newReloadMaster("new", "new value")))
+	assert.Equal(suite.T(), 1000, shandle.getMaster().MaxMessageSize)
+}
+
+func (suite *ReloadTests) TestInFlight() {
+	done := make(chan string)
+	go func() {
+		value, err := suite.get("old", "1.3.6.1.4.1.9999.100.2")
+		assert.Nil(suite.T(), err)
+		done <- value
+	}()
+	<-suite.started
+	assert.Nil(suite.T(), suite.shandle.Reload(suite.newReloadMaster("old", "new value")))
+	close(suite.release)
+	assert.Equal(suite.T(), "old value", <-done)
+
+	value, err := suite.get("old", "1.3.6.1.4.1.9999.100.1")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "new value", value)
+}
+
+func (suite *ReloadTests) TestRejected() {
+	bad := suite.newReloadMaster("new", "new value")
+	bad.SubAgents[0].OIDs[0].OID = "not an oid"
+	assert.NotNil(suite.T(), suite.shandle.Reload(bad))
+
+	duplicate := suite.newReloadMaster("new", "new value")
+	duplicate.SubAgents = append(duplicate.SubAgents, duplicate.SubAgents[0])
+	assert.NotNil(suite.T(), suite.shandle.Reload(duplicate))
+
+	shared := suite.newReloadMaster("new", "new value")
+	shared.SubAgents = suite.shandle.getMaster().SubAgents
+	assert.NotNil(suite.T(), suite.shandle.Reload(shared))
+
+	value, err := suite.get("old", "1.3.6.1.4.1.9999.100.1")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "old value", value)
+}
+
+func (suite *ReloadTests) TestSharedVACM() {
+	withVACM := suite.newReloadMaster("old", "new value")
+	withVACM.VACM = &VACMConfig{
+		Groups:   []VACMGroup{{SecurityModel: VACMSecurityModelSNMPv2c, SecurityName: "old", GroupName: "g"}},
+		Accesses: []VACMAccess{{GroupName: "g", SecurityModel: VACMSecurityModelAny, ReadView: "all"}},
+		Views:    []VACMViewFamily{{ViewName: "all", Subtree: "1"}},
+	}
+	assert.Nil(suite.T(), suite.shandle.Reload(withVACM))
+
+	shared := suite.newReloadMaster("old", "old value")
+	shared.VACM = withVACM.VACM
+	assert.NotNil(suite.T(), suite.shandle.Reload(shared))
+
+	value, err := suite.get("old", "1.3.6.1.4.1.9999.100.1")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "new value", value)
+}
+
+func TestReloadTestsSuite(t *testing.T) {
+	suite.Run(t, new(ReloadTests))
+}
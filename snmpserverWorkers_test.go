@@ -0,0 +1,197 @@
+package GoSNMPServer
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type WorkerPoolTests struct {
+	suite.Suite
+	Logger ILogger
+
+	shandle *SNMPServer
+	agent   *SubAgent
+	// release unblocks OnGet of the slow OID
+	release chan struct{}
+}
+
+func newWorkerPoolAgent(onSlowGet FuncPDUControlGet) *SubAgent {
+	return &SubAgent{
+		CommunityIDs: []string{"public"},
+		OIDs: []*PDUValueControlItem{
+			{
+				OID:   "1.3.6.1.4.1.9999.70.1",
+				Type:  gosnmp.Integer,
+				OnGet: func() (value interface{}, err error) { return Asn1IntegerWrap(1), nil },
+			},
+			{
+				OID:   "1.3.6.1.4.1.9999.70.2",
+				Type:  gosnmp.Integer,
+				OnGet: onSlowGet,
+			},
+		},
+	}
+}
+
+func (suite *WorkerPoolTests) SetupTest() {
+	suite.Logger = NewDiscardLogger()
+	suite.release = make(chan struct{})
+	release := suite.release
+	suite.agent = newWorkerPoolAgent(func() (value interface{}, err error) {
+		<-release
+		return Asn1IntegerWrap(2), nil
+	})
+	suite.shandle = NewSNMPServer(MasterAgent{Logger: suite.Logger, SubAgents: []*SubAgent{suite.agent}})
+	suite.shandle.SetWorkerPool(WorkerPoolConfig{Workers: 4, QueueDepth: 16, RequestTimeout: 500 * time.Millisecond})
+	if err := suite.shandle.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	go suite.shandle.ServeForever()
+}
+
+func (suite *WorkerPoolTests) TearDownTest() {
+	select {
+	case <-suite.release:
+	default:
+		close(suite.release)
+	}
+	suite.shandle.Shutdown()
+}
+
+func newWorkerPoolClient(address net.Addr, timeout time.Duration) *gosnmp.GoSNMP {
+	serverAddress := address.(*net.UDPAddr)
+	client := &gosnmp.GoSNMP{
+		Target:    serverAddress.IP.String(),
+		Port:      uint16(serverAddress.Port),
+		Version:   gosnmp.Version2c,
+		Community: "public",
+		Timeout:   timeout,
+	}
+	if err := client.Connect(); err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// TestSlowGet serves other requests while OnGet of one request is blocked
+func (suite *WorkerPoolTests) TestSlowGet() {
+	slow := newWorkerPoolClient(suite.shandle.Address(), 2*time.Second)
+	defer slow.Conn.Close()
+	slowDone := make(chan error, 1)
+	go func() {
+		result, err := slow.Get([]string{"1.3.6.1.4.1.9999.70.2"})
+		if err == nil && result.Variables[0].Value != 2 {
+			err = fmt.Errorf("unexpected value %v", result.Variables[0].Value)
+		}
+		slowDone <- err
+	}()
+
+	client := newWorkerPoolClient(suite.shandle.Address(), 200*time.Millisecond)
+	defer client.Conn.Close()
+	for i := 0; i < 10; i++ {
+		result, err := client.Get([]string{"1.3.6.1.4.1.9999.70.1"})
+		if assert.Nil(suite.T(), err) {
+			assert.Equal(suite.T(), 1, result.Variables[0].Value)
+		}
+	}
+	close(suite.release)
+	assert.Nil(suite.T(), <-slowDone)
+}
+
+// TestRequestTimeout drops responses later than RequestTimeout
+func (suite *WorkerPoolTests) TestRequestTimeout() {
+	client := newWorkerPoolClient(suite.shandle.Address(), time.Second)
+	defer client.Conn.Close()
+	go func() {
+		time.Sleep(700 * time.Millisecond)
+		close(suite.release)
+	}()
+	_, err := client.Get([]string{"1.3.6.1.4.1.9999.70.2"})
+	assert.NotNil(suite.T(), err)
+	result, err := client.Get([]string{"1.3.6.1.4.1.9999.70.2"})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), 2, result.Variables[0].Value)
+	}
+}
+
+// TestConcurrentAddOIDs walks while OIDs are added and removed
+func (suite *WorkerPoolTests) TestConcurrentAddOIDs() {
+	close(suite.release)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			oid := fmt.Sprintf("1.3.6.1.4.1.9999.70.3.%d", i)
+			assert.Nil(suite.T(), suite.agent.AddOIDs(&PDUValueControlItem{
+				OID:   oid,
+				Type:  gosnmp.Integer,
+				OnGet: func() (value interface{}, err error) { return Asn1IntegerWrap(3), nil },
+			}))
+			if i%2 == 0 {
+				suite.agent.RemoveOIDs(oid)
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client := newWorkerPoolClient(suite.shandle.Address(), 2*time.Second)
+			defer client.Conn.Close()
+			_, err := client.BulkWalkAll("1.3.6.1.4.1.9999.70")
+			assert.Nil(suite.T(), err)
+		}()
+	}
+	wg.Wait()
+	<-done
+	client := newWorkerPoolClient(suite.shandle.Address(), 2*time.Second)
+	defer client.Conn.Close()
+	walked, err := client.BulkWalkAll("1.3.6.1.4.1.9999.70")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 2+50, len(walked))
+}
+
+func TestWorkerPoolTestsSuite(t *testing.T) {
+	suite.Run(t, new(WorkerPoolTests))
+}
+
+// BenchmarkWorkerPool gets an OID whose OnGet takes 1ms with pools of different size
+func BenchmarkWorkerPool(b *testing.B) {
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			agent := newWorkerPoolAgent(func() (value interface{}, err error) {
+				time.Sleep(time.Millisecond)
+				return Asn1IntegerWrap(2), nil
+			})
+			shandle := NewSNMPServer(MasterAgent{Logger: NewDiscardLogger(), SubAgents: []*SubAgent{agent}})
+			shandle.SetWorkerPool(WorkerPoolConfig{Workers: workers, QueueDepth: 64})
+			if err := shandle.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+				b.Fatal(err)
+			}
+			go shandle.ServeForever()
+			defer shandle.Shutdown()
+
+			b.SetParallelism(4)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				client := newWorkerPoolClient(shandle.Address(), 2*time.Second)
+				client.Retries = 3
+				defer client.Conn.Close()
+				for pb.Next() {
+					if _, err := client.Get([]string{"1.3.6.1.4.1.9999.70.2"}); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package GoSNMPServer
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+)
+
+// SourceACL restricts a community or an SNMPV3 user to source networks.
+//
+//	As "rocommunity public 10.0.0.0/8" / "rwuser admin 2001:db8::/32" of net-snmp.
+//	A community / user with no SourceACL is allowed from any source. Otherwise requests from networks
+//	not allowed, or of unknown source, are dropped and counted as authentication failures.
+type SourceACL struct {
+	// Name is the community in SubAgent.CommunityACLs, or the user name in SecurityConfig.UserACLs
+	Name string
+	// Networks are IPv4 / IPv6 CIDRs as "10.0.0.0/8". A single address is taken as a host.
+	Networks []string
+	// ReadOnly denies SetRequests from Networks
+	ReadOnly bool
+
+	priv struct {
+		networks []*net.IPNet
+	}
+}
+
+// sourceAccess is the access of a request allowed by SourceACLs
+type sourceAccess int
+
+const (
+	sourceAccessDenied sourceAccess = iota
+	sourceAccessReadOnly
+	sourceAccessReadWrite
+)
+
+// syncSourceACLs parses Networks of acls. names are the names could be restricted, nil for any
+func syncSourceACLs(acls []*SourceACL, names []string) error {
+	for _, acl := range acls {
+		if names != nil && !stringInSlice(acl.Name, names) {
+			return errors.Errorf("SourceACL: %v is not configured", acl.Name)
+		}
+		acl.priv.networks = nil
+		for _, each := range acl.Networks {
+			network, err := parseSourceNetwork(each)
+			if err != nil {
+				return errors.WithMessagef(err, "SourceACL of %v", acl.Name)
+			}
+			acl.priv.networks = append(acl.priv.networks, network)
+		}
+	}
+	return nil
+}
+
+// parseSourceNetwork parses a CIDR, or an address as a host network
+func parseSourceNetwork(network string) (*net.IPNet, error) {
+	if strings.Contains(network, "/") {
+		_, ret, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, errors.Wrapf(err, "network %v", network)
+		}
+		return ret, nil
+	}
+	ip := net.ParseIP(network)
+	if ip == nil {
+		return nil, errors.Errorf("network %v: not valid ip", network)
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// checkSourceACLs returns the access of name from source. name without SourceACLs is readwrite
+func checkSourceACLs(acls []*SourceACL, name string, source net.Addr) sourceAccess {
+	restricted := false
+	access := sourceAccessDenied
+	ip := sourceIP(source)
+	for _, acl := range acls {
+		if acl.Name != name {
+			continue
+		}
+		restricted = true
+		if ip == nil {
+			continue
+		}
+		for _, network := range acl.priv.networks {
+			if !network.Contains(ip) {
+				continue
+			}
+			if !acl.ReadOnly {
+				return sourceAccessReadWrite
+			}
+			access = sourceAccessReadOnly
+		}
+	}
+	if !restricted {
+		return sourceAccessReadWrite
+	}
+	return access
+}
+
+// sourceIP returns the ip of addr. nil if unknown
+func sourceIP(addr net.Addr) net.IP {
+	switch val := addr.(type) {
+	case nil:
+		return nil
+	case *net.UDPAddr:
+		return val.IP
+	case *net.TCPAddr:
+		return val.IP
+	case *net.IPAddr:
+		return val.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return net.ParseIP(host)
+}
+
+// checkSourceAccess checks SourceACLs of the community or user of request.
+//
+//	returns ErrSourceNotAllowed for requests to drop, or ErrNoPermission for SET of read only sources.
+func (t *MasterAgent) checkSourceAccess(subAgent *SubAgent, request *gosnmp.SnmpPacket, source net.Addr) error {
+	var access sourceAccess
+	var name string
+	if request.Version == gosnmp.Version3 {
+		name = getUserNameOfPacket(request)
+		if name == "" {
+			// discovery
+			return nil
+		}
+		access = checkSourceACLs(t.SecurityConfig.UserACLs, name, source)
+	} else {
+		name = request.Community
+		access = checkSourceACLs(subAgent.CommunityACLs, name, source)
+	}
+	switch access {
+	case sourceAccessDenied:
+		t.countAuthenticationFailure()
+		return errors.WithMessagef(ErrSourceNotAllowed, "%v from %v", name, source)
+	case sourceAccessReadOnly:
+		if request.PDUType == gosnmp.SetRequest {
+			return errors.WithMessagef(ErrNoPermission, "%v from %v is read only", name, source)
+		}
+	}
+	return nil
+}
+
+// getUserNameOfPacket returns the USM user name of an SNMPV3 packet. "" if not exists
+func getUserNameOfPacket(request *gosnmp.SnmpPacket) string {
+	if usm, ok := request.SecurityParameters.(*gosnmp.UsmSecurityParameters); ok {
+		return usm.UserName
+	}
+	return ""
+}
+
+func stringInSlice(val string, list []string) bool {
+	for _, each := range list {
+		if each == val {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package GoSNMPServer
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type SourceACLTests struct {
+	suite.Suite
+	Logger ILogger
+
+	handle *MasterAgent
+	value  string
+}
+
+func (suite *SourceACLTests) newUser(name string) gosnmp.UsmSecurityParameters {
+	return gosnmp.UsmSecurityParameters{
+		UserName:                 name,
+		AuthenticationProtocol:   gosnmp.SHA,
+		PrivacyProtocol:          gosnmp.AES,
+		AuthenticationPassphrase: "aclauthpass",
+		PrivacyPassphrase:        "aclprivpass",
+	}
+}
+
+func (suite *SourceACLTests) SetupTest() {
+	suite.Logger = NewDiscardLogger()
+	suite.value = "init"
+	suite.handle = &MasterAgent{
+		Logger: suite.Logger,
+		SecurityConfig: SecurityConfig{
+			AuthoritativeEngineBoots: 1,
+			Users:                    []gosnmp.UsmSecurityParameters{suite.newUser("local"), suite.newUser("remote")},
+			UserACLs: []*SourceACL{
+				{Name: "local", Networks: []string{"127.0.0.0/8", "::1"}},
+				{Name: "remote", Networks: []string{"10.0.0.0/8"}},
+			},
+		},
+		SubAgents: []*SubAgent{
+			{
+				CommunityIDs: []string{"public", "private", "any"},
+				ContextNames: []string{"any"},
+				CommunityACLs: []*SourceACL{
+					{Name: "public", Networks: []string{"192.0.2.0/24", "2001:db8::/32"}, ReadOnly: true},
+					{Name: "private", Networks: []string{"192.0.2.0/24"}, ReadOnly: true},
+					{Name: "private", Networks: []string{"192.0.2.128/25", "2001:db8:1::/48"}},
+				},
+				OIDs: []*PDUValueControlItem{
+					{
+						OID:   "1.3.6.1.4.1.9999.90.1",
+						Type:  gosnmp.OctetString,
+						OnGet: func() (value interface{}, err error) { return Asn1OctetStringWrap(suite.value), nil },
+						OnSet: func(value interface{}) error {
+							suite.value = Asn1OctetStringUnwrap(value)
+							return nil
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := suite.handle.ReadyForWork(); err != nil {
+		panic(err)
+	}
+}
+
+// request serves a request of community from source. nil if dropped
+func (suite *SourceACLTests) request(community, source string, pduType gosnmp.PDUType) *gosnmp.SnmpPacket {
+	request := &gosnmp.SnmpPacket{
+		Version:   gosnmp.Version2c,
+		Community: community,
+		PDUType:   pduType,
+		RequestID: 1,
+		Variables: []gosnmp.SnmpPDU{{Name: "1.3.6.1.4.1.9999.90.1", Type: gosnmp.Null}},
+	}
+	if pduType == gosnmp.SetRequest {
+		request.Variables[0].Type = gosnmp.OctetString
+		request.Variables[0].Value = "set"
+	}
+	out, err := request.MarshalMsg()
+	if err != nil {
+		panic(err)
+	}
+	ctx := context.Background()
+	if source != "" {
+		ctx = WithSourceAddress(ctx, &net.UDPAddr{IP: net.ParseIP(source), Port: 1161})
+	}
+	responseBytes, err := suite.handle.ResponseForBufferContext(ctx, out)
+	if err != nil {
+		assert.True(suite.T(), errors.Is(err, ErrSourceNotAllowed))
+		assert.Equal(suite.T(), 0, len(responseBytes))
+		return nil
+	}
+	handle := gosnmp.GoSNMP{Logger: gosnmp.NewLogger(&SnmpLoggerAdapter{suite.Logger})}
+	response, err := handle.SnmpDecodePacket(responseBytes)
+	if err != nil {
+		panic(err)
+	}
+	return response
+}
+
+func (suite *SourceACLTests) TestCommunity() {
+	// read only
+	response := suite.request("public", "192.0.2.1", gosnmp.GetRequest)
+	if assert.NotNil(suite.T(), response) {
+		assert.Equal(suite.T(), gosnmp.NoError, response.Error)
+		assert.Equal(suite.T(), "init", string(response.Variables[0].Value.([]byte)))
+	}
+	response = suite.request("public", "2001:db8::1", gosnmp.SetRequest)
+	if assert.NotNil(suite.T(), response) {
+		assert.Equal(suite.T(), gosnmp.AuthorizationError, response.Error)
+	}
+	assert.Equal(suite.T(), "init", suite.value)
+	assert.Equal(suite.T(), uint32(0), suite.handle.AuthenticationFailures())
+
+	// not allowed or unknown source
+	assert.Nil(suite.T(), suite.request("public", "198.51.100.1", gosnmp.GetRequest))
+	assert.Nil(suite.T(), suite.request("public", "2001:db9::1", gosnmp.GetRequest))
+	assert.Nil(suite.T(), suite.request("public", "", gosnmp.GetRequest))
+	assert.Equal(suite.T(), uint32(3), suite.handle.AuthenticationFailures())
+
+	// read write wins
+	response = suite.request("private", "192.0.2.1", gosnmp.SetRequest)
+	if assert.NotNil(suite.T(), response) {
+		assert.Equal(suite.T(), gosnmp.AuthorizationError, response.Error)
+	}
+	response = suite.request("private", "192.0.2.200", gosnmp.SetRequest)
+	if assert.NotNil(suite.T(), response) {
+		assert.Equal(suite.T(), gosnmp.NoError, response.Error)
+	}
+	assert.Equal(suite.T(), "set", suite.value)
+
+	// not restricted
+	response = suite.request("any", "", gosnmp.GetRequest)
+	if assert.NotNil(suite.T(), response) {
+		assert.Equal(suite.T(), gosnmp.NoError, response.Error)
+	}
+}
+
+func (suite *SourceACLTests) TestUser() {
+	shandle := NewSNMPServer(*suite.handle)
+	if err := shandle.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	go shandle.ServeForever()
+	defer shandle.Shutdown()
+
+	get := func(user string) error {
+		serverAddress := shandle.Address().(*net.UDPAddr)
+		client := &gosnmp.GoSNMP{
+			Target:        serverAddress.IP.String(),
+			Port:          uint16(serverAddress.Port),
+			Version:       gosnmp.Version3,
+			Timeout:       500 * time.Millisecond,
+			SecurityModel: gosnmp.UserSecurityModel,
+			MsgFlags:      gosnmp.AuthPriv,
+			ContextName:   "any",
+			SecurityParameters: &gosnmp.UsmSecurityParameters{
+				UserName:                 user,
+				AuthenticationProtocol:   gosnmp.SHA,
+				PrivacyProtocol:          gosnmp.AES,
+				AuthenticationPassphrase: "aclauthpass",
+				PrivacyPassphrase:        "aclprivpass",
+			},
+		}
+		if err := client.Connect(); err != nil {
+			panic(err)
+		}
+		defer client.Conn.Close()
+		_, err := client.Get([]string{"1.3.6.1.4.1.9999.90.1"})
+		return err
+	}
+	assert.Nil(suite.T(), get("local"))
+	assert.NotNil(suite.T(), get("remote"))
+	assert.Equal(suite.T(), uint32(1), shandle.master.AuthenticationFailures())
+}
+
+func (suite *SourceACLTests) TestSyncConfig() {
+	agent := &SubAgent{
+		Logger:        suite.Logger,
+		CommunityIDs:  []string{"public"},
+		CommunityACLs: []*SourceACL{{Name: "private", Networks: []string{"10.0.0.0/8"}}},
+	}
+	assert.NotNil(suite.T(), agent.SyncConfig())
+	agent.CommunityACLs[0].Name = "public"
+	assert.Nil(suite.T(), agent.SyncConfig())
+	agent.CommunityACLs[0].Networks = []string{"10.0.0.0/33"}
+	assert.NotNil(suite.T(), agent.SyncConfig())
+	agent.CommunityACLs[0].Networks = []string{"not an address"}
+	assert.NotNil(suite.T(), agent.SyncConfig())
+}
+
+func TestSourceACLTestsSuite(t *testing.T) {
+	suite.Run(t, new(SourceACLTests))
+}
@@ -0,0 +1,102 @@
+package GoSNMPServer
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SubtreeHandler serves the OIDs under a subtree lazily, as MIB handlers of net-snmp.
+//
+//	It is for data too big or changing too fast to be kept in SubAgent.OIDs, as a table of processes.
+//	Items returned are served as items of SubAgent.OIDs. Both methods could be called concurrently.
+type SubtreeHandler interface {
+	// Get returns the item of oid, which is under the subtree. nil if not exists
+	Get(oid string) *PDUValueControlItem
+	// Next returns the first item after oid by the lexicographic order. nil if none is left in the subtree.
+	//
+	//	oid is under the subtree, or the OID of the subtree itself for the first item.
+	Next(oid string) *PDUValueControlItem
+}
+
+// Subtree registers a SubtreeHandler in SubAgent.Subtrees
+type Subtree struct {
+	// OID of the subtree. eg 1.3.6.1.4.1.2021.2 for prTable
+	OID     string
+	Handler SubtreeHandler
+
+	//Document for this subtree. ignored by the program.
+	Document string
+
+	arcs ByteString
+}
+
+// syncSubtrees verifies and sorts Subtrees, which should not overlap
+func (t *SubAgent) syncSubtrees() error {
+	for _, each := range t.Subtrees {
+		arcs, err := parseOID(each.OID)
+		if err != nil {
+			return errors.WithMessagef(err, "Subtree %v", each.Document)
+		}
+		each.OID = strings.TrimPrefix(each.OID, ".")
+		if each.OID == "" || each.Handler == nil {
+			return errors.Errorf("Subtree %q: empty OID or nil Handler", each.OID)
+		}
+		each.arcs = arcs
+	}
+	sort.Slice(t.Subtrees, func(i, j int) bool {
+		return compareByteString(t.Subtrees[i].arcs, t.Subtrees[j].arcs) == ByteStringCompareResultLessThen
+	})
+	for id := 1; id < len(t.Subtrees); id++ {
+		if isByteStringHasPrefix(t.Subtrees[id].arcs, t.Subtrees[id-1].arcs) {
+			return errors.Errorf("community %v: overlapped subtree %v of %v", t.CommunityIDs,
+				t.Subtrees[id].OID, t.Subtrees[id-1].OID)
+		}
+	}
+	return nil
+}
+
+// contains returns if oid is under the subtree
+func (t *Subtree) contains(oid ByteString) bool {
+	return len(oid) > len(t.arcs) && isByteStringHasPrefix(oid, t.arcs)
+}
+
+// get returns the item of oid from the handler. nil if not exists or the handler returns another OID
+func (t *Subtree) get(oid ByteString, logger ILogger) *PDUValueControlItem {
+	if !t.contains(oid) {
+		return nil
+	}
+	item := t.Handler.Get(byteStringToOID(oid))
+	if item == nil {
+		return nil
+	}
+	if arcs, err := parseOID(item.OID); err != nil || compareByteString(arcs, oid) != ByteStringCompareResultEqual {
+		logger.Warnf("Subtree %v: Get %v returns %v", t.OID, byteStringToOID(oid), item.OID)
+		return nil
+	}
+	return item
+}
+
+// next returns the first item after oid from the handler. nil if none is left
+//
+//	Items not in the subtree or not after oid are dropped, so that walks never loop.
+func (t *Subtree) next(oid ByteString, logger ILogger) *PDUValueControlItem {
+	query := oid
+	if !t.contains(oid) {
+		if compareByteString(oid, t.arcs) == ByteStringCompareResultGreaterThen {
+			return nil
+		}
+		query = t.arcs
+	}
+	item := t.Handler.Next(byteStringToOID(query))
+	if item == nil {
+		return nil
+	}
+	arcs, err := parseOID(item.OID)
+	if err != nil || !t.contains(arcs) || compareByteString(arcs, oid) != ByteStringCompareResultGreaterThen {
+		logger.Warnf("Subtree %v: Next %v returns %v", t.OID, byteStringToOID(query), item.OID)
+		return nil
+	}
+	return item
+}
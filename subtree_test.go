@@ -0,0 +1,245 @@
+package GoSNMPServer
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+// rowsHandler serves a column of rows under OID, as a table computed on each call
+type rowsHandler struct {
+	OID  string
+	rows func() []int
+	// nexts counts calls of Next
+	nexts uint32
+}
+
+func (h *rowsHandler) item(row int) *PDUValueControlItem {
+	return &PDUValueControlItem{
+		OID:   fmt.Sprintf("%v.%d", h.OID, row),
+		Type:  gosnmp.Integer,
+		OnGet: func() (value interface{}, err error) { return Asn1IntegerWrap(row * 10), nil },
+	}
+}
+
+func (h *rowsHandler) Get(oid string) *PDUValueControlItem {
+	for _, row := range h.rows() {
+		if oid == fmt.Sprintf("%v.%d", h.OID, row) {
+			return h.item(row)
+		}
+	}
+	return nil
+}
+
+func (h *rowsHandler) Next(oid string) *PDUValueControlItem {
+	atomic.AddUint32(&h.nexts, 1)
+	rows := h.rows()
+	sort.Ints(rows)
+	query, _ := parseOID(oid)
+	for _, row := range rows {
+		if compareByteString(h.item(row).oidArcs(), query) == ByteStringCompareResultGreaterThen {
+			return h.item(row)
+		}
+	}
+	return nil
+}
+
+// brokenHandler returns items out of order
+type brokenHandler struct{}
+
+func (brokenHandler) Get(oid string) *PDUValueControlItem {
+	return &PDUValueControlItem{OID: "1.3.6.1.4.1.9999.94.4.1", Type: gosnmp.Integer}
+}
+
+func (brokenHandler) Next(oid string) *PDUValueControlItem {
+	return &PDUValueControlItem{OID: "1.3.6.1.4.1.9999.94.1.0", Type: gosnmp.Integer}
+}
+
+type SubtreeTests struct {
+	suite.Suite
+	Logger ILogger
+
+	// mu guards rows, which the handler reads on the serving goroutine
+	mu      sync.Mutex
+	rows    []int
+	handler *rowsHandler
+	agent   *SubAgent
+	shandle *SNMPServer
+}
+
+func (suite *SubtreeTests) getRows() []int {
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+	return append([]int{}, suite.rows...)
+}
+
+func (suite *SubtreeTests) SetupTest() {
+	suite.Logger = NewDiscardLogger()
+	suite.rows = []int{3, 1, 20}
+	static := func(oid string) *PDUValueControlItem {
+		return &PDUValueControlItem{
+			OID:   oid,
+			Type:  gosnmp.Integer,
+			OnGet: func() (value interface{}, err error) { return Asn1IntegerWrap(0), nil },
+		}
+	}
+	suite.handler = &rowsHandler{OID: "1.3.6.1.4.1.9999.94.2", rows: suite.getRows}
+	suite.agent = &SubAgent{
+		CommunityIDs: []string{"public"},
+		OIDs: []*PDUValueControlItem{
+			static("1.3.6.1.4.1.9999.94.1.0"),
+			static("1.3.6.1.4.1.9999.94.2.2"),
+			static("1.3.6.1.4.1.9999.94.3.0"),
+		},
+		Subtrees: []*Subtree{
+			{
+				OID:     ".1.3.6.1.4.1.9999.94.2",
+				Handler: suite.handler,
+			},
+			{OID: "1.3.6.1.4.1.9999.94.4", Handler: brokenHandler{}},
+		},
+	}
+	master := MasterAgent{
+		Logger:    suite.Logger,
+		SubAgents: []*SubAgent{suite.agent},
+	}
+	suite.shandle = NewSNMPServer(master)
+	if err := suite.shandle.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	go suite.shandle.ServeForever()
+}
+
+func (suite *SubtreeTests) TearDownTest() {
+	suite.shandle.Shutdown()
+}
+
+func (suite *SubtreeTests) getClient() *gosnmp.GoSNMP {
+	serverAddress := suite.shandle.Address().(*net.UDPAddr)
+	client := &gosnmp.GoSNMP{
+		Target:    serverAddress.IP.String(),
+		Port:      uint16(serverAddress.Port),
+		Version:   gosnmp.Version2c,
+		Community: "public",
+		Timeout:   time.Second,
+	}
+	if err := client.Connect(); err != nil {
+		panic(err)
+	}
+	return client
+}
+
+func (suite *SubtreeTests) TestGet() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+	result, err := client.Get([]string{"1.3.6.1.4.1.9999.94.2.3", "1.3.6.1.4.1.9999.94.2.4", "1.3.6.1.4.1.9999.94.4.2"})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), 30, result.Variables[0].Value)
+		assert.Equal(suite.T(), gosnmp.NoSuchInstance, result.Variables[1].Type)
+		assert.Equal(suite.T(), gosnmp.NoSuchInstance, result.Variables[2].Type)
+	}
+}
+
+func (suite *SubtreeTests) TestWalk() {
+	expected := []string{
+		".1.3.6.1.4.1.9999.94.1.0",
+		".1.3.6.1.4.1.9999.94.2.1",
+		".1.3.6.1.4.1.9999.94.2.2",
+		".1.3.6.1.4.1.9999.94.2.3",
+		".1.3.6.1.4.1.9999.94.2.20",
+		".1.3.6.1.4.1.9999.94.3.0",
+	}
+	client := suite.getClient()
+	defer client.Conn.Close()
+	for _, walk := range []func(string) ([]gosnmp.SnmpPDU, error){client.WalkAll, client.BulkWalkAll} {
+		walked, err := walk("1.3.6.1.4.1.9999.94")
+		assert.Nil(suite.T(), err)
+		names := []string{}
+		for _, each := range walked {
+			names = append(names, each.Name)
+		}
+		assert.Equal(suite.T(), expected, names)
+	}
+
+	// rows are computed on each request
+	suite.mu.Lock()
+	suite.rows = []int{5}
+	suite.mu.Unlock()
+	walked, err := client.WalkAll("1.3.6.1.4.1.9999.94.2")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 2, len(walked))
+	assert.Equal(suite.T(), ".1.3.6.1.4.1.9999.94.2.5", walked[1].Name)
+}
+
+// TestSkipped asks no handler of subtrees after the item found
+func (suite *SubtreeTests) TestSkipped() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+	result, err := client.GetNext([]string{"1.3.6.1.4.1.9999.94.1"})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), ".1.3.6.1.4.1.9999.94.1.0", result.Variables[0].Name)
+	}
+	assert.Equal(suite.T(), uint32(0), atomic.LoadUint32(&suite.handler.nexts))
+	result, err = client.GetNext([]string{"1.3.6.1.4.1.9999.94.1.0"})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), ".1.3.6.1.4.1.9999.94.2.1", result.Variables[0].Name)
+	}
+	assert.Equal(suite.T(), uint32(1), atomic.LoadUint32(&suite.handler.nexts))
+}
+
+// TestLargeArcs walks sub-identifiers above 2^31-1, which are valid up to 2^32-1
+func (suite *SubtreeTests) TestLargeArcs() {
+	assert.Nil(suite.T(), suite.agent.AddOIDs(&PDUValueControlItem{
+		OID:   "1.3.6.1.4.1.9999.94.2.3000000000",
+		Type:  gosnmp.Integer,
+		OnGet: func() (value interface{}, err error) { return Asn1IntegerWrap(0), nil },
+	}))
+	client := suite.getClient()
+	defer client.Conn.Close()
+
+	walked, err := client.WalkAll("1.3.6.1.4.1.9999.94.2")
+	if assert.Nil(suite.T(), err) && assert.Equal(suite.T(), 5, len(walked)) {
+		assert.Equal(suite.T(), ".1.3.6.1.4.1.9999.94.2.3000000000", walked[4].Name)
+	}
+	result, err := client.Get([]string{"1.3.6.1.4.1.9999.94.2.4294967295"})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), gosnmp.NoSuchInstance, result.Variables[0].Type)
+	}
+	result, err = client.GetNext([]string{"1.3.6.1.4.1.9999.94.2.4294967295"})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), ".1.3.6.1.4.1.9999.94.3.0", result.Variables[0].Name)
+	}
+}
+
+func (suite *SubtreeTests) TestSyncConfig() {
+	agent := &SubAgent{
+		Logger: suite.Logger,
+		Subtrees: []*Subtree{
+			{OID: "1.3.6.1.4.1.9999.94.2.1", Handler: brokenHandler{}},
+			{OID: "1.3.6.1.4.1.9999.94.2", Handler: brokenHandler{}},
+		},
+	}
+	assert.NotNil(suite.T(), agent.SyncConfig())
+	agent.Subtrees[0].OID = "1.3.6.1.4.1.9999.94.21"
+	assert.Nil(suite.T(), agent.SyncConfig())
+	// arcs above 2^31-1 are valid sub-identifiers
+	agent.Subtrees[0].OID = "1.3.6.1.4.1.9999.94.3000000000"
+	assert.Nil(suite.T(), agent.SyncConfig())
+	agent.Subtrees[0].OID = "1.3.6.1.4.1.9999.94.5000000000"
+	assert.NotNil(suite.T(), agent.SyncConfig())
+	agent.Subtrees[0].OID = "1.3.6.1.4.1.9999.94.21"
+	agent.Subtrees[0].Handler = nil
+	assert.NotNil(suite.T(), agent.SyncConfig())
+}
+
+func TestSubtreeTestsSuite(t *testing.T) {
+	suite.Run(t, new(SubtreeTests))
+}
@@ -0,0 +1,274 @@
+package GoSNMPServer
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+)
+
+// TableRow is a row of a Table.
+type TableRow struct {
+	// Index is the OID suffix of this row. eg "1" or "4.192.168.1.1". See TableIndex* for encoders.
+	Index string
+	// Data is anything for TableColumn callbacks to read the row.
+	Data interface{}
+}
+
+// FuncTableRows provides the current rows of a Table. It will be called once per request, so
+//
+//	rows could be added or removed at runtime. The order does not matter.
+type FuncTableRows func() ([]TableRow, error)
+
+// FuncTableColumnGet will be called on get value of a cell
+type FuncTableColumnGet func(row TableRow) (value interface{}, err error)
+
+// FuncTableColumnSet will be called on set value of a cell
+type FuncTableColumnSet func(row TableRow, value interface{}) error
+
+// TableColumn describes a column of a Table
+type TableColumn struct {
+	// ID is the sub-identifier under Table.OID. eg 2 for ifDescr under ifEntry
+	ID int
+	// Type defines which type this column is.
+	Type gosnmp.Asn1BER
+
+	// NonWalkable works as PDUValueControlItem.NonWalkable
+	NonWalkable bool
+	// OnCheckPermission works as PDUValueControlItem.OnCheckPermission
+	OnCheckPermission FuncPDUControlCheckPermission
+	// Constraints works as PDUValueControlItem.Constraints
+	Constraints *SetConstraints
+
+	// OnGet will be called on any GET / walk option. set to nil for mark this as a write-only column
+	OnGet FuncTableColumnGet
+	// OnSet will be called on any Set option. set to nil for mark as a read-only column.
+	OnSet FuncTableColumnSet
+
+	//Document for this column. ignored by the program.
+	Document string
+}
+
+// Table is a conceptual table whose rows are read at request time.
+//
+//	GetNext / GetBulk walks the rows lazily, cells are never kept in SubAgent.OIDs.
+type Table struct {
+	// OID of the entry. eg 1.3.6.1.2.1.2.2.1 for ifEntry
+	OID     string
+	Columns []*TableColumn
+	// OnGetRows provides the rows.
+	OnGetRows FuncTableRows
+
+	//Document for this table. ignored by the program.
+	Document string
+}
+
+// SyncConfig verifies the table
+func (t *Table) SyncConfig() error {
+	if _, err := parseOID(t.OID); err != nil {
+		return errors.WithMessagef(err, "Table %v", t.Document)
+	}
+	t.OID = strings.TrimPrefix(t.OID, ".")
+	if t.OnGetRows == nil {
+		return errors.Errorf("Table %v: OnGetRows is nil", t.OID)
+	}
+	sort.Slice(t.Columns, func(i, j int) bool { return t.Columns[i].ID < t.Columns[j].ID })
+	for id, each := range t.Columns {
+		if each.ID <= 0 {
+			return errors.Errorf("Table %v: not valid column id %v", t.OID, each.ID)
+		}
+		if id != 0 && t.Columns[id-1].ID == each.ID {
+			return errors.Errorf("Table %v: meet duplicate column %v", t.OID, each.ID)
+		}
+	}
+	return nil
+}
+
+// tableSnapshot keeps the rows of a table for one request. rows are sorted by index.
+type tableSnapshot struct {
+	table   *Table
+	entry   ByteString
+	rows    []TableRow
+	indexes []ByteString
+}
+
+func newTableSnapshot(table *Table, logger ILogger) *tableSnapshot {
+	// table.OID is checked by SyncConfig
+	entry, _ := parseOID(table.OID)
+	ret := &tableSnapshot{
+		table: table,
+		entry: entry,
+	}
+	rows, err := table.OnGetRows()
+	if err != nil {
+		logger.Errorf("Table %v: OnGetRows meet %v", table.OID, err)
+		return ret
+	}
+	for _, each := range rows {
+		each.Index = strings.TrimPrefix(each.Index, ".")
+		index, err := parseOID(each.Index)
+		if each.Index == "" || err != nil {
+			logger.Errorf("Table %v: skip row with not valid index %q", table.OID, each.Index)
+			continue
+		}
+		ret.rows = append(ret.rows, each)
+		ret.indexes = append(ret.indexes, index)
+	}
+	sort.Sort(ret)
+	return ret
+}
+
+func (t *tableSnapshot) Len() int { return len(t.rows) }
+func (t *tableSnapshot) Less(i, j int) bool {
+	return compareByteString(t.indexes[i], t.indexes[j]) == ByteStringCompareResultLessThen
+}
+func (t *tableSnapshot) Swap(i, j int) {
+	t.rows[i], t.rows[j] = t.rows[j], t.rows[i]
+	t.indexes[i], t.indexes[j] = t.indexes[j], t.indexes[i]
+}
+
+// searchRow returns the first row whose index >= index, or > index if strict
+func (t *tableSnapshot) searchRow(index ByteString, strict bool) int {
+	return sort.Search(len(t.indexes), func(i int) bool {
+		result := compareByteString(t.indexes[i], index)
+		return result == ByteStringCompareResultGreaterThen || (!strict && result == ByteStringCompareResultEqual)
+	})
+}
+
+// get returns the cell of oid. nil if not exists
+func (t *tableSnapshot) get(oid ByteString) *PDUValueControlItem {
+	if len(oid) < len(t.entry)+2 || !isByteStringHasPrefix(oid, t.entry) {
+		return nil
+	}
+	var column *TableColumn
+	for _, each := range t.table.Columns {
+		if each.ID == oid[len(t.entry)] {
+			column = each
+		}
+	}
+	if column == nil {
+		return nil
+	}
+	index := oid[len(t.entry)+1:]
+	id := t.searchRow(index, false)
+	if id >= len(t.rows) || compareByteString(t.indexes[id], index) != ByteStringCompareResultEqual {
+		return nil
+	}
+	return t.makeItem(column, t.rows[id])
+}
+
+// next returns the first cell after oid. nil if not exists
+func (t *tableSnapshot) next(oid ByteString) *PDUValueControlItem {
+	if len(t.rows) == 0 {
+		return nil
+	}
+	for _, column := range t.table.Columns {
+		prefix := append(append(ByteString{}, t.entry...), column.ID)
+		if isByteStringHasPrefix(oid, prefix) {
+			if id := t.searchRow(oid[len(prefix):], true); id < len(t.rows) {
+				return t.makeItem(column, t.rows[id])
+			}
+			continue
+		}
+		if compareByteString(oid, prefix) == ByteStringCompareResultLessThen {
+			return t.makeItem(column, t.rows[0])
+		}
+	}
+	return nil
+}
+
+func (t *tableSnapshot) makeItem(column *TableColumn, row TableRow) *PDUValueControlItem {
+	item := &PDUValueControlItem{
+		OID:               fmt.Sprintf("%v.%v.%v", t.table.OID, column.ID, row.Index),
+		Type:              column.Type,
+		NonWalkable:       column.NonWalkable,
+		OnCheckPermission: column.OnCheckPermission,
+		Constraints:       column.Constraints,
+		Document:          column.Document,
+	}
+	if column.OnGet != nil {
+		item.OnGet = func() (value interface{}, err error) { return column.OnGet(row) }
+	}
+	if column.OnSet != nil {
+		item.OnSet = func(value interface{}) error { return column.OnSet(row, value) }
+	}
+	return item
+}
+
+func isByteStringHasPrefix(target, prefix ByteString) bool {
+	if len(target) < len(prefix) {
+		return false
+	}
+	return compareByteString(target[:len(prefix)], prefix) == ByteStringCompareResultEqual
+}
+
+// TableIndexInteger encodes an INTEGER index
+func TableIndexInteger(i int) string {
+	return strconv.Itoa(i)
+}
+
+// TableIndexString encodes an OCTET STRING index with its length
+func TableIndexString(s string) string {
+	if s == "" {
+		return "0"
+	}
+	return strconv.Itoa(len(s)) + "." + TableIndexImpliedString(s)
+}
+
+// TableIndexImpliedString encodes an IMPLIED OCTET STRING index, which must be the last index
+func TableIndexImpliedString(s string) string {
+	parts := make([]string, 0, len(s))
+	for _, each := range []byte(s) {
+		parts = append(parts, strconv.Itoa(int(each)))
+	}
+	return strings.Join(parts, ".")
+}
+
+// TableIndexParseString decodes an OCTET STRING index encoded by TableIndexString, or by
+//
+//	TableIndexImpliedString if implied. returns the string and the remaining index.
+func TableIndexParseString(index string, implied bool) (string, string, error) {
+	index = strings.TrimPrefix(index, ".")
+	parts := strings.Split(index, ".")
+	if index == "" {
+		parts = []string{}
+	}
+	length := len(parts)
+	if !implied {
+		if len(parts) == 0 {
+			return "", "", errors.New("empty index")
+		}
+		val, err := strconv.Atoi(parts[0])
+		if err != nil || val < 0 || val > len(parts)-1 {
+			return "", "", errors.Errorf("not valid string length in %v", index)
+		}
+		parts, length = parts[1:], val
+	}
+	out := make([]byte, length)
+	for i := 0; i < length; i++ {
+		val, err := strconv.Atoi(parts[i])
+		if err != nil || val < 0 || val > 255 {
+			return "", "", errors.Errorf("not valid string in %v", index)
+		}
+		out[i] = byte(val)
+	}
+	return string(out), strings.Join(parts[length:], "."), nil
+}
+
+// TableIndexIPAddress encodes an IpAddress index
+func TableIndexIPAddress(ip net.IP) string {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		ip4 = net.IPv4zero.To4()
+	}
+	return ip4.String()
+}
+
+// TableIndexJoin joins indexes of a table with multiple index columns
+func TableIndexJoin(indexes ...string) string {
+	return strings.Join(indexes, ".")
+}
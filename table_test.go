@@ -0,0 +1,228 @@
+package GoSNMPServer
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type TableTests struct {
+	suite.Suite
+	Logger ILogger
+
+	shandle *SNMPServer
+	// mu guards rows and getCount, which the table uses on the serving goroutine
+	mu       sync.Mutex
+	rows     map[int]string
+	getCount int
+}
+
+func (suite *TableTests) row(ifIndex int) string {
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+	return suite.rows[ifIndex]
+}
+
+func (suite *TableTests) setRow(ifIndex int, name string) {
+	suite.mu.Lock()
+	suite.rows[ifIndex] = name
+	suite.mu.Unlock()
+}
+
+func (suite *TableTests) SetupTest() {
+	logger := NewDefaultLogger()
+	logger.(*DefaultLogger).Level = logrus.InfoLevel
+	suite.Logger = logger
+	suite.rows = map[int]string{1: "eth0", 2: "eth1"}
+	suite.getCount = 0
+
+	master := MasterAgent{
+		Logger: suite.Logger,
+		SubAgents: []*SubAgent{
+			{
+				CommunityIDs: []string{"public"},
+				OIDs: []*PDUValueControlItem{
+					{
+						OID:  "1.3.6.1.2.1.2.1.0",
+						Type: gosnmp.Integer,
+						OnGet: func() (value interface{}, err error) {
+							suite.mu.Lock()
+							defer suite.mu.Unlock()
+							return Asn1IntegerWrap(len(suite.rows)), nil
+						},
+					},
+					{
+						OID:   "1.3.6.1.2.1.3.0",
+						Type:  gosnmp.Integer,
+						OnGet: func() (value interface{}, err error) { return Asn1IntegerWrap(3), nil },
+					},
+				},
+				Tables: []*Table{
+					{
+						OID: "1.3.6.1.2.1.2.2.1",
+						Columns: []*TableColumn{
+							{
+								ID:   2,
+								Type: gosnmp.OctetString,
+								OnGet: func(row TableRow) (value interface{}, err error) {
+									return Asn1OctetStringWrap(suite.row(row.Data.(int))), nil
+								},
+								OnSet: func(row TableRow, value interface{}) error {
+									suite.setRow(row.Data.(int), Asn1OctetStringUnwrap(value))
+									return nil
+								},
+							},
+							{
+								ID:   1,
+								Type: gosnmp.Integer,
+								OnGet: func(row TableRow) (value interface{}, err error) {
+									return Asn1IntegerWrap(row.Data.(int)), nil
+								},
+							},
+						},
+						OnGetRows: func() ([]TableRow, error) {
+							suite.mu.Lock()
+							defer suite.mu.Unlock()
+							suite.getCount += 1
+							rows := []TableRow{}
+							for ifIndex := range suite.rows {
+								rows = append(rows, TableRow{Index: TableIndexInteger(ifIndex), Data: ifIndex})
+							}
+							return rows, nil
+						},
+					},
+				},
+			},
+		},
+	}
+	suite.shandle = NewSNMPServer(master)
+	if err := suite.shandle.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	go suite.shandle.ServeForever()
+}
+
+func (suite *TableTests) TearDownTest() {
+	suite.shandle.Shutdown()
+}
+
+func (suite *TableTests) getClient() *gosnmp.GoSNMP {
+	serverAddress := suite.shandle.Address().(*net.UDPAddr)
+	client := &gosnmp.GoSNMP{
+		Target:         serverAddress.IP.String(),
+		Port:           uint16(serverAddress.Port),
+		Version:        gosnmp.Version2c,
+		Community:      "public",
+		Timeout:        time.Second,
+		MaxRepetitions: 3,
+	}
+	if err := client.Connect(); err != nil {
+		panic(err)
+	}
+	return client
+}
+
+func getNames(pdus []gosnmp.SnmpPDU) []string {
+	names := []string{}
+	for _, each := range pdus {
+		names = append(names, each.Name)
+	}
+	return names
+}
+
+func (suite *TableTests) TestWalk() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+
+	expected := []string{
+		".1.3.6.1.2.1.2.1.0",
+		".1.3.6.1.2.1.2.2.1.1.1",
+		".1.3.6.1.2.1.2.2.1.1.2",
+		".1.3.6.1.2.1.2.2.1.2.1",
+		".1.3.6.1.2.1.2.2.1.2.2",
+		".1.3.6.1.2.1.3.0",
+	}
+	walked, err := client.WalkAll("1.3.6.1.2.1")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), expected, getNames(walked))
+	bulked, err := client.BulkWalkAll("1.3.6.1.2.1")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), expected, getNames(bulked))
+
+	// rows changed at runtime
+	suite.mu.Lock()
+	delete(suite.rows, 1)
+	suite.rows[10] = "eth10"
+	suite.mu.Unlock()
+	walked, err = client.WalkAll("1.3.6.1.2.1.2.2")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), []string{
+		".1.3.6.1.2.1.2.2.1.1.2",
+		".1.3.6.1.2.1.2.2.1.1.10",
+		".1.3.6.1.2.1.2.2.1.2.2",
+		".1.3.6.1.2.1.2.2.1.2.10",
+	}, getNames(walked))
+}
+
+// TestLargeIndex serves rows whose index has arcs above 2^31-1, which are valid sub-identifiers
+func (suite *TableTests) TestLargeIndex() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+	suite.setRow(3000000000, "large")
+
+	result, err := client.GetNext([]string{"1.3.6.1.2.1.2.2.1.2.2"})
+	if assert.Nil(suite.T(), err) && assert.Equal(suite.T(), 1, len(result.Variables)) {
+		assert.Equal(suite.T(), ".1.3.6.1.2.1.2.2.1.2.3000000000", result.Variables[0].Name)
+		assert.Equal(suite.T(), []byte("large"), result.Variables[0].Value)
+	}
+}
+
+func (suite *TableTests) TestRowsReadOncePerRequest() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+
+	result, err := client.GetBulk([]string{"1.3.6.1.2.1.2.2"}, 0, 4)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 4, len(result.Variables))
+	suite.mu.Lock()
+	assert.Equal(suite.T(), 1, suite.getCount)
+	suite.mu.Unlock()
+}
+
+func (suite *TableTests) TestGetSet() {
+	client := suite.getClient()
+	defer client.Conn.Close()
+
+	result, err := client.Get([]string{"1.3.6.1.2.1.2.2.1.2.2", "1.3.6.1.2.1.2.2.1.2.3", "1.3.6.1.2.1.2.2.1.3.2"})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "eth1", Asn1OctetStringUnwrap(result.Variables[0].Value))
+	assert.Equal(suite.T(), gosnmp.NoSuchInstance, result.Variables[1].Type)
+	assert.Equal(suite.T(), gosnmp.NoSuchInstance, result.Variables[2].Type)
+
+	result, err = client.Set([]gosnmp.SnmpPDU{{Name: "1.3.6.1.2.1.2.2.1.2.2", Type: gosnmp.OctetString, Value: "wan"}})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.NoError, result.Error)
+	assert.Equal(suite.T(), "wan", suite.row(2))
+
+	result, err = client.Set([]gosnmp.SnmpPDU{{Name: "1.3.6.1.2.1.2.2.1.1.2", Type: gosnmp.Integer, Value: 3}})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.ReadOnly, result.Error)
+}
+
+func TestTableTestsSuite(t *testing.T) {
+	suite.Run(t, new(TableTests))
+}
+
+func TestTableIndex(t *testing.T) {
+	assert.Equal(t, "3.97.98.99", TableIndexString("abc"))
+	assert.Equal(t, "97.98.99", TableIndexImpliedString("abc"))
+	assert.Equal(t, "0", TableIndexString(""))
+	assert.Equal(t, "192.168.1.1", TableIndexIPAddress(net.ParseIP("192.168.1.1")))
+	assert.Equal(t, "1.192.168.1.1", TableIndexJoin(TableIndexInteger(1), TableIndexIPAddress(net.IPv4(192, 168, 1, 1))))
+}
@@ -0,0 +1,199 @@
+package GoSNMPServer
+
+import (
+	"context"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+)
+
+// TSMSecurityModel is the msgSecurityModel of the Transport Security Model. See RFC 5591
+const TSMSecurityModel gosnmp.SnmpV3SecurityModel = 4
+
+// TransportSecurity is provided by secure transports as TLS / DTLS. See RFC 5591 (tmStateReference)
+type TransportSecurity struct {
+	// Transport is "tls" or "dtls"
+	Transport string
+	// SecurityName is mapped from the certificate of the remote. See TLSCertToName
+	SecurityName string
+}
+
+// ISecureReplyer is implemented by replyers of secure transports.
+//
+//	Requests of them are served by MasterAgent.ResponseForTransportBuffer
+type ISecureReplyer interface {
+	IReplyer
+	TransportSecurity() TransportSecurity
+}
+
+// ResponseForTransportBuffer serves an SNMPv3 message of the Transport Security Model received on a secure transport.
+//
+//	security.SecurityName selects the SubAgent and VACM rights as the user name of USM does.
+//	See RFC 5591 and RFC 6353
+func (t *MasterAgent) ResponseForTransportBuffer(i []byte, security TransportSecurity) ([]byte, error) {
+	return t.ResponseForTransportBufferContext(context.Background(), i, security)
+}
+
+// ResponseForTransportBufferContext is ResponseForTransportBuffer with ctx. See ResponseForBufferContext
+func (t *MasterAgent) ResponseForTransportBufferContext(ctx context.Context, i []byte, security TransportSecurity) ([]byte, error) {
+	msg, err := parseV3Message(i)
+	if err != nil {
+		return nil, errors.WithMessagef(ErrUnsupportedPacketData, "%v", err)
+	}
+	if msg.securityModel != TSMSecurityModel {
+		return nil, errors.WithMessagef(ErrUnsupportedPacketData, "securityModel %v over %v", msg.securityModel, security.Transport)
+	}
+	if security.SecurityName == "" {
+		return nil, errors.WithMessagef(ErrNoPermission, "no securityName over %v", security.Transport)
+	}
+	// the transport protects the message. decode it as USM noAuthNoPriv with securityName as the user name
+	flags := msg.flags
+	msg.flags = flags &^ gosnmp.AuthPriv
+	msg.securityModel = gosnmp.UserSecurityModel
+	msg.securityParameters = marshalUsmSecurityName(security.SecurityName)
+	vhandle := gosnmp.GoSNMP{}
+	vhandle.Logger = gosnmp.NewLogger(&SnmpLoggerAdapter{t.Logger})
+	vhandle.SecurityParameters = &gosnmp.UsmSecurityParameters{Logger: vhandle.Logger}
+	request, err := vhandle.SnmpDecodePacket(msg.marshal())
+	if err != nil {
+		return nil, errors.WithMessagef(ErrUnsupportedPacketData, "GoSNMP Returns %v", err)
+	}
+	request.SecurityModel = TSMSecurityModel
+	request.MsgFlags = flags
+
+	val, err := t.ResponseForPktContext(ctx, request)
+	if val == nil {
+		val = request
+	}
+	usm, _ := t.getUsmSecurityParametersFromUser("")
+	usm.UserName = security.SecurityName
+	val.SecurityModel = gosnmp.UserSecurityModel
+	val.MsgFlags = gosnmp.NoAuthNoPriv
+	val.SecurityParameters = usm
+	out, err := t.marshalResponse(request, val, err)
+	if err != nil || len(out) == 0 {
+		return out, err
+	}
+	response, err := parseV3Message(out)
+	if err != nil {
+		return nil, err
+	}
+	response.flags = flags & gosnmp.AuthPriv
+	response.securityModel = TSMSecurityModel
+	response.securityParameters = nil
+	return response.marshal(), nil
+}
+
+// v3Message is an SNMPv3 message split as RFC 3412 section 6
+type v3Message struct {
+	// msgID and msgMaxSize are kept encoded
+	msgID              []byte
+	msgMaxSize         []byte
+	flags              gosnmp.SnmpV3MsgFlags
+	securityModel      gosnmp.SnmpV3SecurityModel
+	securityParameters []byte
+	// scopedPDU is kept encoded. it is an OCTET STRING if encrypted
+	scopedPDU []byte
+}
+
+func parseV3Message(i []byte) (*v3Message, error) {
+	tag, _, content, _, err := berSplit(i)
+	if err != nil || tag != byte(gosnmp.Sequence) {
+		return nil, errors.Errorf("not a SEQUENCE")
+	}
+	tag, _, version, content, err := berSplit(content)
+	if err != nil || tag != byte(gosnmp.Integer) || len(version) != 1 || version[0] != byte(gosnmp.Version3) {
+		return nil, errors.Errorf("not a SNMPv3 message")
+	}
+	tag, _, header, content, err := berSplit(content)
+	if err != nil || tag != byte(gosnmp.Sequence) {
+		return nil, errors.Errorf("not valid msgGlobalData")
+	}
+	ret := new(v3Message)
+	if _, ret.msgID, _, header, err = berSplit(header); err != nil {
+		return nil, errors.Wrap(err, "msgID")
+	}
+	if _, ret.msgMaxSize, _, header, err = berSplit(header); err != nil {
+		return nil, errors.Wrap(err, "msgMaxSize")
+	}
+	tag, _, flags, header, err := berSplit(header)
+	if err != nil || tag != byte(gosnmp.OctetString) || len(flags) != 1 {
+		return nil, errors.Errorf("not valid msgFlags")
+	}
+	ret.flags = gosnmp.SnmpV3MsgFlags(flags[0])
+	tag, _, securityModel, _, err := berSplit(header)
+	if err != nil || tag != byte(gosnmp.Integer) || len(securityModel) != 1 {
+		return nil, errors.Errorf("not valid msgSecurityModel")
+	}
+	ret.securityModel = gosnmp.SnmpV3SecurityModel(securityModel[0])
+	tag, _, ret.securityParameters, content, err = berSplit(content)
+	if err != nil || tag != byte(gosnmp.OctetString) {
+		return nil, errors.Errorf("not valid msgSecurityParameters")
+	}
+	if _, ret.scopedPDU, _, _, err = berSplit(content); err != nil {
+		return nil, errors.Wrap(err, "msgData")
+	}
+	return ret, nil
+}
+
+func (m *v3Message) marshal() []byte {
+	header := append(append([]byte{}, m.msgID...), m.msgMaxSize...)
+	header = append(header, berEncode(byte(gosnmp.OctetString), []byte{byte(m.flags)})...)
+	header = append(header, berEncode(byte(gosnmp.Integer), []byte{byte(m.securityModel)})...)
+	content := berEncode(byte(gosnmp.Integer), []byte{byte(gosnmp.Version3)})
+	content = append(content, berEncode(byte(gosnmp.Sequence), header)...)
+	content = append(content, berEncode(byte(gosnmp.OctetString), m.securityParameters)...)
+	content = append(content, m.scopedPDU...)
+	return berEncode(byte(gosnmp.Sequence), content)
+}
+
+// marshalUsmSecurityName encodes UsmSecurityParameters with the user name only. See RFC 3414 section 2.4
+func marshalUsmSecurityName(userName string) []byte {
+	content := berEncode(byte(gosnmp.OctetString), nil)
+	content = append(content, berEncode(byte(gosnmp.Integer), []byte{0})...)
+	content = append(content, berEncode(byte(gosnmp.Integer), []byte{0})...)
+	content = append(content, berEncode(byte(gosnmp.OctetString), []byte(userName))...)
+	content = append(content, berEncode(byte(gosnmp.OctetString), nil)...)
+	content = append(content, berEncode(byte(gosnmp.OctetString), nil)...)
+	return berEncode(byte(gosnmp.Sequence), content)
+}
+
+// berSplit splits the first TLV of i. returns the tag, the whole TLV, its content and the remaining bytes
+func berSplit(i []byte) (tag byte, tlv, content, rest []byte, err error) {
+	if len(i) < 2 {
+		return 0, nil, nil, nil, errors.New("truncated")
+	}
+	tag = i[0]
+	length, cursor := int(i[1]), 2
+	if i[1]&0x80 != 0 {
+		count := int(i[1] & 0x7f)
+		if count == 0 || count > 4 || len(i) < 2+count {
+			return 0, nil, nil, nil, errors.New("not valid length")
+		}
+		length = 0
+		for _, each := range i[2 : 2+count] {
+			length = length<<8 | int(each)
+		}
+		cursor += count
+	}
+	if length < 0 || len(i)-cursor < length {
+		return 0, nil, nil, nil, errors.New("truncated")
+	}
+	return tag, i[:cursor+length], i[cursor : cursor+length], i[cursor+length:], nil
+}
+
+func berEncode(tag byte, content []byte) []byte {
+	ret := []byte{tag}
+	length := len(content)
+	if length < 0x80 {
+		ret = append(ret, byte(length))
+	} else {
+		lengthBytes := []byte{}
+		for ; length > 0; length >>= 8 {
+			lengthBytes = append([]byte{byte(length)}, lengthBytes...)
+		}
+		ret = append(ret, 0x80|byte(len(lengthBytes)))
+		ret = append(ret, lengthBytes...)
+	}
+	return append(ret, content...)
+}
@@ -1,7 +1,13 @@
 package GoSNMPServer
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"sync"
+	"sync/atomic"
+
 	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
 )
 
 func GenKeys(sp *gosnmp.UsmSecurityParameters) {
@@ -21,3 +27,308 @@ func GenSalt(sp *gosnmp.UsmSecurityParameters) {
 		panic(err)
 	}
 }
+
+// OIDs of the usmStats counters. See RFC 3414 section 5
+const (
+	OIDUsmStatsUnsupportedSecLevels = "1.3.6.1.6.3.15.1.1.1.0"
+	OIDUsmStatsNotInTimeWindows     = "1.3.6.1.6.3.15.1.1.2.0"
+	OIDUsmStatsUnknownUserNames     = "1.3.6.1.6.3.15.1.1.3.0"
+	OIDUsmStatsUnknownEngineIDs     = "1.3.6.1.6.3.15.1.1.4.0"
+	OIDUsmStatsWrongDigests         = "1.3.6.1.6.3.15.1.1.5.0"
+	OIDUsmStatsDecryptionErrors     = "1.3.6.1.6.3.15.1.1.6.0"
+)
+
+// usmTimeWindow is the seconds a message could differ from snmpEngineTime. See RFC 3414 section 3.2 step 7
+const usmTimeWindow = 150
+
+// USMStats are the usmStats counters of RFC 3414. See MasterAgent.USMStats
+//
+//	They are served as OIDUsmStats* by all SubAgents if SecurityConfig.ServeUSMStats is set.
+type USMStats struct {
+	UnsupportedSecLevels uint32
+	NotInTimeWindows     uint32
+	UnknownUserNames     uint32
+	UnknownEngineIDs     uint32
+	WrongDigests         uint32
+	DecryptionErrors     uint32
+}
+
+// usmStat indexes the usmStats counters of MasterAgent
+type usmStat int
+
+const (
+	usmStatUnsupportedSecLevels usmStat = iota
+	usmStatNotInTimeWindows
+	usmStatUnknownUserNames
+	usmStatUnknownEngineIDs
+	usmStatWrongDigests
+	usmStatDecryptionErrors
+	usmStatCount
+)
+
+var usmStatOIDs = [usmStatCount]string{
+	OIDUsmStatsUnsupportedSecLevels,
+	OIDUsmStatsNotInTimeWindows,
+	OIDUsmStatsUnknownUserNames,
+	OIDUsmStatsUnknownEngineIDs,
+	OIDUsmStatsWrongDigests,
+	OIDUsmStatsDecryptionErrors,
+}
+
+// usmDigestSizes are the sizes of msgAuthenticationParameters. See RFC 3414 and RFC 7860
+var usmDigestSizes = map[gosnmp.SnmpV3AuthProtocol]int{
+	gosnmp.MD5:    12,
+	gosnmp.SHA:    12,
+	gosnmp.SHA224: 16,
+	gosnmp.SHA256: 24,
+	gosnmp.SHA384: 32,
+	gosnmp.SHA512: 48,
+}
+
+// USMStats returns the usmStats counters
+func (t *MasterAgent) USMStats() USMStats {
+	return USMStats{
+		UnsupportedSecLevels: t.usmStat(usmStatUnsupportedSecLevels),
+		NotInTimeWindows:     t.usmStat(usmStatNotInTimeWindows),
+		UnknownUserNames:     t.usmStat(usmStatUnknownUserNames),
+		UnknownEngineIDs:     t.usmStat(usmStatUnknownEngineIDs),
+		WrongDigests:         t.usmStat(usmStatWrongDigests),
+		DecryptionErrors:     t.usmStat(usmStatDecryptionErrors),
+	}
+}
+
+func (t *MasterAgent) usmStat(stat usmStat) uint32 {
+	return atomic.LoadUint32(&t.priv.usmStats[stat])
+}
+
+// usmStatsOIDs returns the items of the usmStats counters
+func (t *MasterAgent) usmStatsOIDs() []*PDUValueControlItem {
+	toRet := make([]*PDUValueControlItem, 0, usmStatCount)
+	for stat := usmStat(0); stat < usmStatCount; stat++ {
+		stat := stat
+		toRet = append(toRet, &PDUValueControlItem{
+			OID:      usmStatOIDs[stat],
+			Type:     gosnmp.Counter32,
+			OnGet:    func() (value interface{}, err error) { return Asn1Counter32Wrap(uint(t.usmStat(stat))), nil },
+			Document: "usmStats",
+		})
+	}
+	return toRet
+}
+
+// usmKeyCache keeps keys of Users localized to the engineID, which are slow to generate
+type usmKeyCache struct {
+	mu    sync.Mutex
+	users map[string]*gosnmp.UsmSecurityParameters
+}
+
+func newUsmKeyCache() *usmKeyCache {
+	return &usmKeyCache{users: make(map[string]*gosnmp.UsmSecurityParameters)}
+}
+
+// localize fills the keys of usm, whose AuthoritativeEngineID is set
+func (c *usmKeyCache) localize(usm *gosnmp.UsmSecurityParameters) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.users[usm.UserName]
+	if ok && cached.AuthoritativeEngineID != usm.AuthoritativeEngineID {
+		ok = false
+	}
+	if !ok {
+		cached = usm.Copy().(*gosnmp.UsmSecurityParameters)
+		GenKeys(cached)
+		c.users[usm.UserName] = cached
+	}
+	usm.SecretKey = cached.SecretKey
+	usm.PrivacyKey = cached.PrivacyKey
+}
+
+// usmHeader is the msgSecurityParameters of USM. See RFC 3414 section 2.4
+type usmHeader struct {
+	engineID []byte
+	boots    int64
+	time     int64
+	userName string
+	// authParams is a part of the message, whose digest is computed with it zeroed
+	authParams []byte
+}
+
+func parseUsmHeader(securityParameters []byte) (*usmHeader, error) {
+	tag, _, content, _, err := berSplit(securityParameters)
+	if err != nil || tag != byte(gosnmp.Sequence) {
+		return nil, errors.New("not valid UsmSecurityParameters")
+	}
+	fields := make([][]byte, 0, 6)
+	for len(content) != 0 && len(fields) < 6 {
+		var field []byte
+		if _, _, field, content, err = berSplit(content); err != nil {
+			return nil, errors.Wrap(err, "UsmSecurityParameters")
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) != 6 {
+		return nil, errors.New("not valid UsmSecurityParameters")
+	}
+	ret := &usmHeader{
+		engineID:   fields[0],
+		userName:   string(fields[3]),
+		authParams: fields[4],
+	}
+	if ret.boots, err = berInteger(fields[1]); err != nil {
+		return nil, errors.WithMessage(err, "msgAuthoritativeEngineBoots")
+	}
+	if ret.time, err = berInteger(fields[2]); err != nil {
+		return nil, errors.WithMessage(err, "msgAuthoritativeEngineTime")
+	}
+	return ret, nil
+}
+
+func berInteger(content []byte) (int64, error) {
+	if len(content) == 0 || len(content) > 8 {
+		return 0, errors.New("not valid INTEGER")
+	}
+	val := int64(int8(content[0]))
+	for _, each := range content[1:] {
+		val = val<<8 | int64(each)
+	}
+	return val, nil
+}
+
+// checkDigest checks msgAuthenticationParameters of the message i with the keys of usm
+func checkDigest(i []byte, header *usmHeader, usm *gosnmp.UsmSecurityParameters) bool {
+	size := usmDigestSizes[usm.AuthenticationProtocol]
+	if size == 0 || len(header.authParams) != size {
+		return false
+	}
+	// authParams is a part of i. zero it in a copy of i
+	offset := cap(i) - cap(header.authParams)
+	zeroed := append([]byte{}, i...)
+	copy(zeroed[offset:offset+size], make([]byte, size))
+	mac := hmac.New(usm.AuthenticationProtocol.HashType().New, usm.SecretKey)
+	mac.Write(zeroed)
+	return hmac.Equal(mac.Sum(nil)[:size], header.authParams)
+}
+
+// checkUSM checks an incoming message of USM as RFC 3414 section 3.2, before it is decrypted.
+//
+//	ok is false if the message fails, with the Report PDU to reply, or an error to drop the message.
+//	It is skipped with SecurityConfig.NoSecurity, which takes messages of any engineID and time.
+//	Messages not reportable of other engineIDs, as notifications of their authoritative engines,
+//	are passed unchecked.
+func (t *MasterAgent) checkUSM(i []byte) (report []byte, ok bool, err error) {
+	msg, err := parseV3Message(i)
+	if err != nil {
+		return nil, false, errors.WithMessagef(ErrUnsupportedPacketData, "%v", err)
+	}
+	if msg.securityModel != gosnmp.UserSecurityModel {
+		return nil, false, errors.WithMessagef(ErrUnsupportedPacketData, "securityModel %v", msg.securityModel)
+	}
+	header, err := parseUsmHeader(msg.securityParameters)
+	if err != nil {
+		return nil, false, errors.WithMessagef(ErrUnsupportedPacketData, "%v", err)
+	}
+	level := msg.flags & gosnmp.AuthPriv
+	if level == gosnmp.AuthPriv&^gosnmp.AuthNoPriv {
+		return nil, false, errors.WithMessagef(ErrUnsupportedPacketData, "msgFlags %v", msg.flags)
+	}
+	if !bytes.Equal(header.engineID, t.SecurityConfig.AuthoritativeEngineID.Marshal()) {
+		if msg.flags&gosnmp.Reportable == 0 {
+			return nil, true, nil
+		}
+		return t.usmReport(msg, header, usmStatUnknownEngineIDs, nil)
+	}
+	user := t.SecurityConfig.FindForUser(header.userName)
+	if user == nil {
+		return t.usmReport(msg, header, usmStatUnknownUserNames, nil)
+	}
+	if level != getUserSecurityLevel(user) {
+		return t.usmReport(msg, header, usmStatUnsupportedSecLevels, nil)
+	}
+	if level&gosnmp.AuthNoPriv == 0 {
+		return nil, true, nil
+	}
+	usm, err := t.getUsmSecurityParametersFromUser(header.userName)
+	if err != nil {
+		return nil, false, err
+	}
+	if !checkDigest(i, header, usm) {
+		return t.usmReport(msg, header, usmStatWrongDigests, nil)
+	}
+	diff := header.time - int64(usm.AuthoritativeEngineTime)
+	if usm.AuthoritativeEngineBoots == maxEngineValue || header.boots != int64(usm.AuthoritativeEngineBoots) ||
+		diff > usmTimeWindow || diff < -usmTimeWindow {
+		return t.usmReport(msg, header, usmStatNotInTimeWindows, usm)
+	}
+	return nil, true, nil
+}
+
+// reportDecryptionError reports a message of usm checked by checkUSM, which could not be decrypted
+func (t *MasterAgent) reportDecryptionError(i []byte, usm *gosnmp.UsmSecurityParameters) ([]byte, error) {
+	msg, err := parseV3Message(i)
+	if err != nil {
+		return nil, errors.WithMessagef(ErrUnsupportedPacketData, "%v", err)
+	}
+	report, _, err := t.usmReport(msg, &usmHeader{userName: usm.UserName}, usmStatDecryptionErrors, nil)
+	return report, err
+}
+
+// usmReport counts stat and returns the Report PDU of it. See RFC 3412 section 7.1 step 3
+//
+//	The report is authenticated with usm if not nil, so that managers could take the boots and time of it.
+//	Messages not reportable are dropped with an error.
+func (t *MasterAgent) usmReport(msg *v3Message, header *usmHeader, stat usmStat, usm *gosnmp.UsmSecurityParameters) ([]byte, bool, error) {
+	count := atomic.AddUint32(&t.priv.usmStats[stat], 1)
+	if msg.flags&gosnmp.Reportable == 0 {
+		return nil, false, errors.WithMessagef(ErrNoPermission, "USM: %v of user %q", usmStatOIDs[stat], header.userName)
+	}
+	report := &gosnmp.SnmpPacket{
+		Version:         gosnmp.Version3,
+		MsgMaxSize:      uint32(t.getMaxMessageSize(nil)),
+		MsgFlags:        gosnmp.NoAuthNoPriv,
+		SecurityModel:   gosnmp.UserSecurityModel,
+		ContextEngineID: string(t.SecurityConfig.AuthoritativeEngineID.Marshal()),
+		PDUType:         gosnmp.Report,
+		Variables:       []gosnmp.SnmpPDU{{Name: usmStatOIDs[stat], Type: gosnmp.Counter32, Value: uint(count)}},
+	}
+	if _, _, content, _, err := berSplit(msg.msgID); err == nil {
+		msgID, _ := berInteger(content)
+		report.MsgID = uint32(msgID)
+	}
+	report.ContextName, report.RequestID = parsePlainScopedPDU(msg.scopedPDU)
+	if usm != nil {
+		report.MsgFlags = gosnmp.AuthNoPriv
+	} else {
+		usm, _ = t.getUsmSecurityParametersFromUser("")
+		usm.UserName = header.userName
+	}
+	report.SecurityParameters = usm
+	out, err := report.MarshalMsg()
+	if err != nil {
+		return nil, false, errors.Wrap(err, "USM report")
+	}
+	t.Logger.Debugf("USM: %v of user %q. reported", usmStatOIDs[stat], header.userName)
+	return out, false, nil
+}
+
+// parsePlainScopedPDU returns the contextName and request-id of a scopedPDU not encrypted. "" and 0 if encrypted
+func parsePlainScopedPDU(scopedPDU []byte) (string, uint32) {
+	tag, _, content, _, err := berSplit(scopedPDU)
+	if err != nil || tag != byte(gosnmp.Sequence) {
+		return "", 0
+	}
+	var contextName, pdu, requestID []byte
+	if _, _, _, content, err = berSplit(content); err != nil {
+		return "", 0
+	}
+	if _, _, contextName, content, err = berSplit(content); err != nil {
+		return "", 0
+	}
+	if _, _, pdu, _, err = berSplit(content); err != nil {
+		return string(contextName), 0
+	}
+	if _, _, requestID, _, err = berSplit(pdu); err != nil {
+		return string(contextName), 0
+	}
+	val, _ := berInteger(requestID)
+	return string(contextName), uint32(val)
+}
@@ -0,0 +1,176 @@
+package GoSNMPServer
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type USMTests struct {
+	suite.Suite
+	Logger ILogger
+
+	shandle *SNMPServer
+}
+
+func (suite *USMTests) SetupTest() {
+	suite.Logger = NewDiscardLogger()
+	master := MasterAgent{
+		Logger: suite.Logger,
+		SecurityConfig: SecurityConfig{
+			AuthoritativeEngineBoots: 3,
+			ServeUSMStats:            true,
+			Users: []gosnmp.UsmSecurityParameters{
+				{
+					UserName:                 "privuser",
+					AuthenticationProtocol:   gosnmp.SHA256,
+					PrivacyProtocol:          gosnmp.AES,
+					AuthenticationPassphrase: "usmauthpass",
+					PrivacyPassphrase:        "usmprivpass",
+				},
+				{
+					UserName:                 "authuser",
+					AuthenticationProtocol:   gosnmp.MD5,
+					PrivacyProtocol:          gosnmp.NoPriv,
+					AuthenticationPassphrase: "usmauthpass",
+				},
+			},
+		},
+		SubAgents: []*SubAgent{
+			{
+				ContextNames: []string{"ctx"},
+				OIDs: []*PDUValueControlItem{
+					{
+						OID:   "1.3.6.1.4.1.9999.91.1",
+						Type:  gosnmp.OctetString,
+						OnGet: func() (value interface{}, err error) { return Asn1OctetStringWrap("usm"), nil },
+					},
+				},
+			},
+		},
+	}
+	suite.shandle = NewSNMPServer(master)
+	if err := suite.shandle.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	go suite.shandle.ServeForever()
+}
+
+func (suite *USMTests) TearDownTest() {
+	suite.shandle.Shutdown()
+}
+
+func (suite *USMTests) getClient(flags gosnmp.SnmpV3MsgFlags, usm *gosnmp.UsmSecurityParameters) *gosnmp.GoSNMP {
+	serverAddress := suite.shandle.Address().(*net.UDPAddr)
+	client := &gosnmp.GoSNMP{
+		Target:             serverAddress.IP.String(),
+		Port:               uint16(serverAddress.Port),
+		Version:            gosnmp.Version3,
+		Timeout:            500 * time.Millisecond,
+		SecurityModel:      gosnmp.UserSecurityModel,
+		MsgFlags:           flags,
+		ContextName:        "ctx",
+		SecurityParameters: usm,
+	}
+	if err := client.Connect(); err != nil {
+		panic(err)
+	}
+	return client
+}
+
+func (suite *USMTests) privUser() *gosnmp.UsmSecurityParameters {
+	return &gosnmp.UsmSecurityParameters{
+		UserName:                 "privuser",
+		AuthenticationProtocol:   gosnmp.SHA256,
+		PrivacyProtocol:          gosnmp.AES,
+		AuthenticationPassphrase: "usmauthpass",
+		PrivacyPassphrase:        "usmprivpass",
+	}
+}
+
+func (suite *USMTests) get(client *gosnmp.GoSNMP) error {
+	defer client.Conn.Close()
+	result, err := client.Get([]string{"1.3.6.1.4.1.9999.91.1"})
+	if err == nil {
+		assert.Equal(suite.T(), "usm", string(result.Variables[0].Value.([]byte)))
+	}
+	return err
+}
+
+func (suite *USMTests) TestDiscovery() {
+	assert.Nil(suite.T(), suite.get(suite.getClient(gosnmp.AuthPriv, suite.privUser())))
+	stats := suite.shandle.getMaster().USMStats()
+	assert.Equal(suite.T(), uint32(1), stats.UnknownEngineIDs)
+	assert.Equal(suite.T(), USMStats{UnknownEngineIDs: 1}, stats)
+}
+
+func (suite *USMTests) TestNotInTimeWindow() {
+	master := suite.shandle.getMaster()
+	boots, _ := master.engineBootsAndTime()
+	for _, each := range []struct {
+		boots, time uint32
+	}{{boots - 1, 0}, {boots, 1000}} {
+		usm := suite.privUser()
+		usm.AuthoritativeEngineID = string(master.SecurityConfig.AuthoritativeEngineID.Marshal())
+		usm.AuthoritativeEngineBoots = each.boots
+		usm.AuthoritativeEngineTime = each.time
+		assert.Nil(suite.T(), suite.get(suite.getClient(gosnmp.AuthPriv, usm)))
+	}
+	assert.Equal(suite.T(), USMStats{NotInTimeWindows: 2}, master.USMStats())
+}
+
+func (suite *USMTests) TestFailures() {
+	usm := suite.privUser()
+	usm.AuthenticationPassphrase = "wrongpass"
+	assert.Equal(suite.T(), gosnmp.ErrWrongDigest, suite.get(suite.getClient(gosnmp.AuthPriv, usm)))
+
+	usm = suite.privUser()
+	usm.UserName = "nobody"
+	assert.Equal(suite.T(), gosnmp.ErrUnknownUsername, suite.get(suite.getClient(gosnmp.AuthPriv, usm)))
+
+	usm = suite.privUser()
+	usm.PrivacyProtocol = gosnmp.NoPriv
+	assert.Equal(suite.T(), gosnmp.ErrUnknownSecurityLevel, suite.get(suite.getClient(gosnmp.AuthNoPriv, usm)))
+
+	usm = suite.privUser()
+	usm.PrivacyPassphrase = "wrongpass"
+	assert.Equal(suite.T(), gosnmp.ErrDecryption, suite.get(suite.getClient(gosnmp.AuthPriv, usm)))
+
+	assert.Equal(suite.T(), USMStats{
+		UnsupportedSecLevels: 1,
+		UnknownUserNames:     1,
+		UnknownEngineIDs:     4,
+		WrongDigests:         1,
+		DecryptionErrors:     1,
+	}, suite.shandle.getMaster().USMStats())
+}
+
+func (suite *USMTests) TestServeStats() {
+	usm := suite.privUser()
+	usm.UserName = "nobody"
+	assert.NotNil(suite.T(), suite.get(suite.getClient(gosnmp.AuthPriv, usm)))
+
+	client := suite.getClient(gosnmp.AuthNoPriv, &gosnmp.UsmSecurityParameters{
+		UserName:                 "authuser",
+		AuthenticationProtocol:   gosnmp.MD5,
+		AuthenticationPassphrase: "usmauthpass",
+	})
+	defer client.Conn.Close()
+	result, err := client.Get([]string{OIDUsmStatsUnknownUserNames, OIDUsmStatsUnknownEngineIDs})
+	if assert.Nil(suite.T(), err) {
+		assert.Equal(suite.T(), gosnmp.Counter32, result.Variables[0].Type)
+		assert.Equal(suite.T(), uint(1), result.Variables[0].Value)
+		assert.Equal(suite.T(), uint(2), result.Variables[1].Value)
+	}
+	walked, err := client.WalkAll("1.3.6.1.6.3.15.1.1")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 6, len(walked))
+}
+
+func TestUSMTestsSuite(t *testing.T) {
+	suite.Run(t, new(USMTests))
+}
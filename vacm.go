@@ -0,0 +1,304 @@
+package GoSNMPServer
+
+import (
+	"strings"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+)
+
+// VACMSecurityModel is the securityModel used in VACM tables.
+//
+//	See https://tools.ietf.org/html/rfc3411#section-5 (SnmpSecurityModel)
+type VACMSecurityModel int
+
+const (
+	// VACMSecurityModelAny matches any security model. Only valid in VACMAccess.
+	VACMSecurityModelAny VACMSecurityModel = 0
+	// VACMSecurityModelSNMPv1 is the community based security model of SNMPv1
+	VACMSecurityModelSNMPv1 VACMSecurityModel = 1
+	// VACMSecurityModelSNMPv2c is the community based security model of SNMPv2c
+	VACMSecurityModelSNMPv2c VACMSecurityModel = 2
+	// VACMSecurityModelUSM is the User-based Security Model of SNMPv3
+	VACMSecurityModelUSM VACMSecurityModel = 3
+	// VACMSecurityModelTSM is the Transport Security Model of SNMPv3 over TLS / DTLS. See RFC 5591
+	VACMSecurityModelTSM VACMSecurityModel = 4
+)
+
+// VACMViewType selects which view of a VACMAccess entry is used.
+type VACMViewType int
+
+const (
+	// VACMViewRead is used for Get / GetNext / GetBulk
+	VACMViewRead VACMViewType = iota
+	// VACMViewWrite is used for Set
+	VACMViewWrite
+	// VACMViewNotify is used for notifications
+	VACMViewNotify
+)
+
+// VACMContextMatch controls how VACMAccess.ContextPrefix matches the contextName.
+type VACMContextMatch int
+
+const (
+	// VACMContextMatchExact requires contextName == ContextPrefix
+	VACMContextMatchExact VACMContextMatch = 1
+	// VACMContextMatchPrefix requires contextName starts with ContextPrefix
+	VACMContextMatchPrefix VACMContextMatch = 2
+)
+
+// VACMGroup maps a (securityModel, securityName) to a group. (vacmSecurityToGroupTable)
+//
+//	For SNMPv1 / SNMPv2c the securityName is the community,
+//	for SNMPv3 it is the USM user name or the TSM securityName mapped from the certificate.
+type VACMGroup struct {
+	SecurityModel VACMSecurityModel
+	SecurityName  string
+	GroupName     string
+}
+
+// VACMAccess grants a group its views. (vacmAccessTable)
+type VACMAccess struct {
+	GroupName     string
+	ContextPrefix string
+	// ContextMatch defaults to VACMContextMatchExact
+	ContextMatch VACMContextMatch
+	// SecurityModel could be VACMSecurityModelAny to match all models
+	SecurityModel VACMSecurityModel
+	// SecurityLevel is the minimum level required: gosnmp.NoAuthNoPriv / AuthNoPriv / AuthPriv
+	SecurityLevel gosnmp.SnmpV3MsgFlags
+
+	// ReadView / WriteView / NotifyView names the view to use. empty means no access.
+	ReadView   string
+	WriteView  string
+	NotifyView string
+}
+
+// VACMViewFamily is one subtree of a view. (vacmViewTreeFamilyTable)
+type VACMViewFamily struct {
+	ViewName string
+	// Subtree is the OID prefix of this family
+	Subtree string
+	// Mask marks which sub-identifiers of Subtree must match. The most significant bit
+	//      of the first byte is the first sub-identifier. 1 for exact, 0 for wildcard.
+	//      Missing bits are treated as 1. nil means exact match of the whole subtree.
+	Mask []byte
+	// Excluded marks this family as excluded from the view
+	Excluded bool
+}
+
+// VACMConfig is a View-based Access Control Model as RFC 3415.
+//
+//	Set MasterAgent.VACM to nil to disable VACM checks.
+type VACMConfig struct {
+	Groups   []VACMGroup
+	Accesses []VACMAccess
+	Views    []VACMViewFamily
+
+	priv struct {
+		views map[string][]vacmViewFamily
+	}
+}
+
+type vacmViewFamily struct {
+	subtree  ByteString
+	mask     []byte
+	excluded bool
+}
+
+// vacmView is a resolved view for one request. nil vacmView allows everything.
+type vacmView struct {
+	families []vacmViewFamily
+}
+
+// SyncConfig verifies and indexes the VACM tables
+func (t *VACMConfig) SyncConfig() error {
+	t.priv.views = make(map[string][]vacmViewFamily)
+	for _, each := range t.Views {
+		if each.ViewName == "" {
+			return errors.Errorf("VACM: view with subtree %v has no name", each.Subtree)
+		}
+		subtree, err := parseOID(each.Subtree)
+		if err != nil {
+			return errors.WithMessagef(err, "VACM: view %v", each.ViewName)
+		}
+		t.priv.views[each.ViewName] = append(t.priv.views[each.ViewName], vacmViewFamily{
+			subtree:  subtree,
+			mask:     each.Mask,
+			excluded: each.Excluded,
+		})
+	}
+	for _, each := range t.Accesses {
+		if each.GroupName == "" {
+			return errors.Errorf("VACM: access entry with context %v has no group", each.ContextPrefix)
+		}
+	}
+	return nil
+}
+
+func (t *VACMConfig) findGroup(securityModel VACMSecurityModel, securityName string) (string, bool) {
+	for _, each := range t.Groups {
+		if each.SecurityModel == securityModel && each.SecurityName == securityName {
+			return each.GroupName, true
+		}
+	}
+	return "", false
+}
+
+// findAccess selects the best vacmAccessEntry as RFC 3415 section 4
+func (t *VACMConfig) findAccess(groupName string, securityModel VACMSecurityModel,
+	securityLevel gosnmp.SnmpV3MsgFlags, contextName string) *VACMAccess {
+	var best *VACMAccess
+	for id := range t.Accesses {
+		each := &t.Accesses[id]
+		if each.GroupName != groupName {
+			continue
+		}
+		if each.SecurityModel != VACMSecurityModelAny && each.SecurityModel != securityModel {
+			continue
+		}
+		if each.SecurityLevel > securityLevel {
+			continue
+		}
+		if each.ContextMatch == VACMContextMatchPrefix {
+			if !strings.HasPrefix(contextName, each.ContextPrefix) {
+				continue
+			}
+		} else if each.ContextPrefix != contextName {
+			continue
+		}
+		if best == nil || vacmAccessPreferred(each, best) {
+			best = each
+		}
+	}
+	return best
+}
+
+func vacmAccessPreferred(a, b *VACMAccess) bool {
+	if (a.SecurityModel == VACMSecurityModelAny) != (b.SecurityModel == VACMSecurityModelAny) {
+		return b.SecurityModel == VACMSecurityModelAny
+	}
+	if (a.ContextMatch == VACMContextMatchPrefix) != (b.ContextMatch == VACMContextMatchPrefix) {
+		return b.ContextMatch == VACMContextMatchPrefix
+	}
+	if len(a.ContextPrefix) != len(b.ContextPrefix) {
+		return len(a.ContextPrefix) > len(b.ContextPrefix)
+	}
+	return a.SecurityLevel > b.SecurityLevel
+}
+
+// view resolves the view for a request.
+//
+//	returns ErrNoPermission if there is no group, no access entry or no such view.
+func (t *VACMConfig) view(securityModel VACMSecurityModel, securityName string,
+	securityLevel gosnmp.SnmpV3MsgFlags, contextName string, viewType VACMViewType) (*vacmView, error) {
+	groupName, ok := t.findGroup(securityModel, securityName)
+	if !ok {
+		return nil, errors.WithMessagef(ErrNoPermission, "VACM: noGroupName for %v", securityName)
+	}
+	access := t.findAccess(groupName, securityModel, securityLevel, contextName)
+	if access == nil {
+		return nil, errors.WithMessagef(ErrNoPermission, "VACM: noAccessEntry for group %v context %v", groupName, contextName)
+	}
+	var viewName string
+	switch viewType {
+	case VACMViewRead:
+		viewName = access.ReadView
+	case VACMViewWrite:
+		viewName = access.WriteView
+	case VACMViewNotify:
+		viewName = access.NotifyView
+	}
+	families, ok := t.priv.views[viewName]
+	if viewName == "" || !ok {
+		return nil, errors.WithMessagef(ErrNoPermission, "VACM: noSuchView %q for group %v", viewName, groupName)
+	}
+	return &vacmView{families: families}, nil
+}
+
+// IsAccessAllowed checks an OID as isAccessAllowed in RFC 3415 section 3.2
+func (t *VACMConfig) IsAccessAllowed(securityModel VACMSecurityModel, securityName string,
+	securityLevel gosnmp.SnmpV3MsgFlags, contextName string, viewType VACMViewType, oid string) error {
+	view, err := t.view(securityModel, securityName, securityLevel, contextName, viewType)
+	if err != nil {
+		return err
+	}
+	if !view.contains(oid) {
+		return errors.WithMessagef(ErrNoPermission, "VACM: notInView %v", oid)
+	}
+	return nil
+}
+
+// contains reports if an oid is in this view. The most specific family wins.
+func (v *vacmView) contains(oid string) bool {
+	if v == nil {
+		return true
+	}
+	target, err := parseOID(oid)
+	if err != nil {
+		return false
+	}
+	return v.containsArcs(target)
+}
+
+// containsArcs reports if the parsed oid is in this view
+func (v *vacmView) containsArcs(target ByteString) bool {
+	if v == nil {
+		return true
+	}
+	var matched *vacmViewFamily
+	for id := range v.families {
+		each := &v.families[id]
+		if !each.matches(target) {
+			continue
+		}
+		if matched == nil || len(each.subtree) > len(matched.subtree) ||
+			(len(each.subtree) == len(matched.subtree) &&
+				compareByteString(each.subtree, matched.subtree) == ByteStringCompareResultGreaterThen) {
+			matched = each
+		}
+	}
+	return matched != nil && !matched.excluded
+}
+
+func (f *vacmViewFamily) matches(target ByteString) bool {
+	return isByteStringInSubtree(target, f.subtree, f.mask)
+}
+
+func getPktSecurityModel(i *gosnmp.SnmpPacket) VACMSecurityModel {
+	switch i.Version {
+	case gosnmp.Version1:
+		return VACMSecurityModelSNMPv1
+	case gosnmp.Version2c:
+		return VACMSecurityModelSNMPv2c
+	}
+	if i.SecurityModel == TSMSecurityModel {
+		return VACMSecurityModelTSM
+	}
+	return VACMSecurityModelUSM
+}
+
+func getPktSecurityName(i *gosnmp.SnmpPacket) string {
+	if i.Version != gosnmp.Version3 {
+		return i.Community
+	}
+	if val, ok := i.SecurityParameters.(*gosnmp.UsmSecurityParameters); ok {
+		return val.UserName
+	}
+	return ""
+}
+
+func getPktVACMContextName(i *gosnmp.SnmpPacket) string {
+	// SNMPv1 / SNMPv2c always uses the default context. See RFC 3584 section 5.2.1
+	if i.Version != gosnmp.Version3 {
+		return ""
+	}
+	return i.ContextName
+}
+
+func getPktSecurityLevel(i *gosnmp.SnmpPacket) gosnmp.SnmpV3MsgFlags {
+	if i.Version != gosnmp.Version3 {
+		return gosnmp.NoAuthNoPriv
+	}
+	return i.MsgFlags & gosnmp.AuthPriv
+}
@@ -0,0 +1,254 @@
+package GoSNMPServer
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type VACMTests struct {
+	suite.Suite
+	Logger ILogger
+
+	shandle *SNMPServer
+	// mu guards setResult, which is set on the serving goroutine
+	mu        sync.Mutex
+	setResult string
+}
+
+func (suite *VACMTests) getSetResult() string {
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+	return suite.setResult
+}
+
+func (suite *VACMTests) SetupTest() {
+	logger := NewDefaultLogger()
+	logger.(*DefaultLogger).Level = logrus.InfoLevel
+	suite.Logger = logger
+	suite.setResult = ""
+
+	master := MasterAgent{
+		Logger: suite.Logger,
+		SecurityConfig: SecurityConfig{
+			AuthoritativeEngineBoots: 1,
+			Users: []gosnmp.UsmSecurityParameters{
+				{
+					UserName:                 "testuser",
+					AuthenticationProtocol:   gosnmp.MD5,
+					PrivacyProtocol:          gosnmp.DES,
+					AuthenticationPassphrase: "testauth",
+					PrivacyPassphrase:        "testpriv",
+				},
+			},
+		},
+		VACM: &VACMConfig{
+			Groups: []VACMGroup{
+				{SecurityModel: VACMSecurityModelSNMPv2c, SecurityName: "public", GroupName: "readers"},
+				{SecurityModel: VACMSecurityModelSNMPv2c, SecurityName: "private", GroupName: "writers"},
+				{SecurityModel: VACMSecurityModelUSM, SecurityName: "testuser", GroupName: "writers"},
+			},
+			Accesses: []VACMAccess{
+				{GroupName: "readers", ReadView: "system"},
+				{
+					GroupName:     "writers",
+					ContextMatch:  VACMContextMatchPrefix,
+					SecurityModel: VACMSecurityModelAny,
+					ReadView:      "all",
+					WriteView:     "all",
+				},
+			},
+			Views: []VACMViewFamily{
+				{ViewName: "system", Subtree: "1.3.6.1.2.1.1"},
+				{ViewName: "all", Subtree: "1.3.6.1"},
+				{ViewName: "all", Subtree: "1.3.6.1.4.1.9999.1", Excluded: true},
+			},
+		},
+		SubAgents: []*SubAgent{
+			{
+				CommunityIDs: []string{"public", "private", "nogroup"},
+				ContextNames: []string{"public"},
+				OIDs: []*PDUValueControlItem{
+					{
+						OID:   "1.3.6.1.2.1.1.1.0",
+						Type:  gosnmp.OctetString,
+						OnGet: func() (value interface{}, err error) { return Asn1OctetStringWrap("sysDescr"), nil },
+					},
+					{
+						OID:   "1.3.6.1.4.1.9999.1.0",
+						Type:  gosnmp.OctetString,
+						OnGet: func() (value interface{}, err error) { return Asn1OctetStringWrap("secret"), nil },
+					},
+					{
+						OID:   "1.3.6.1.4.1.9999.2.0",
+						Type:  gosnmp.OctetString,
+						OnGet: func() (value interface{}, err error) { return Asn1OctetStringWrap(suite.getSetResult()), nil },
+						OnSet: func(value interface{}) error {
+							suite.mu.Lock()
+							suite.setResult = Asn1OctetStringUnwrap(value)
+							suite.mu.Unlock()
+							return nil
+						},
+					},
+				},
+			},
+		},
+	}
+	suite.shandle = NewSNMPServer(master)
+	if err := suite.shandle.ListenUDP("udp4", "127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	go suite.shandle.ServeForever()
+}
+
+func (suite *VACMTests) TearDownTest() {
+	suite.shandle.Shutdown()
+}
+
+func (suite *VACMTests) getClient(community string) *gosnmp.GoSNMP {
+	serverAddress := suite.shandle.Address().(*net.UDPAddr)
+	client := &gosnmp.GoSNMP{
+		Target:    serverAddress.IP.String(),
+		Port:      uint16(serverAddress.Port),
+		Version:   gosnmp.Version2c,
+		Community: community,
+		Timeout:   time.Second,
+	}
+	if err := client.Connect(); err != nil {
+		panic(err)
+	}
+	return client
+}
+
+func (suite *VACMTests) TestReadOnlyView() {
+	client := suite.getClient("public")
+	defer client.Conn.Close()
+
+	result, err := client.Get([]string{"1.3.6.1.2.1.1.1.0", "1.3.6.1.4.1.9999.1.0"})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.OctetString, result.Variables[0].Type)
+	assert.Equal(suite.T(), gosnmp.NoSuchInstance, result.Variables[1].Type)
+
+	walked, err := client.WalkAll("1.3.6.1")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 1, len(walked))
+	assert.Equal(suite.T(), ".1.3.6.1.2.1.1.1.0", walked[0].Name)
+
+	bulked, err := client.BulkWalkAll("1.3.6.1")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 1, len(bulked))
+
+	result, err = client.Set([]gosnmp.SnmpPDU{{Name: "1.3.6.1.4.1.9999.2.0", Type: gosnmp.OctetString, Value: "x"}})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.AuthorizationError, result.Error)
+	assert.Equal(suite.T(), "", suite.getSetResult())
+}
+
+func (suite *VACMTests) TestReadWriteView() {
+	client := suite.getClient("private")
+	defer client.Conn.Close()
+
+	walked, err := client.WalkAll("1.3.6.1")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 2, len(walked))
+	for _, each := range walked {
+		assert.NotEqual(suite.T(), ".1.3.6.1.4.1.9999.1.0", each.Name)
+	}
+
+	result, err := client.Set([]gosnmp.SnmpPDU{{Name: "1.3.6.1.4.1.9999.2.0", Type: gosnmp.OctetString, Value: "x"}})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.NoError, result.Error)
+	assert.Equal(suite.T(), "x", suite.getSetResult())
+
+	result, err = client.Set([]gosnmp.SnmpPDU{{Name: "1.3.6.1.4.1.9999.1.0", Type: gosnmp.OctetString, Value: "x"}})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.NoAccess, result.Error)
+}
+
+func (suite *VACMTests) TestNoGroup() {
+	client := suite.getClient("nogroup")
+	defer client.Conn.Close()
+
+	result, err := client.Get([]string{"1.3.6.1.2.1.1.1.0"})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), gosnmp.AuthorizationError, result.Error)
+}
+
+func (suite *VACMTests) TestSnmpV3User() {
+	serverAddress := suite.shandle.Address().(*net.UDPAddr)
+	client := &gosnmp.GoSNMP{
+		Target:        serverAddress.IP.String(),
+		Port:          uint16(serverAddress.Port),
+		Version:       gosnmp.Version3,
+		Timeout:       time.Second,
+		SecurityModel: gosnmp.UserSecurityModel,
+		MsgFlags:      gosnmp.AuthPriv,
+		ContextName:   "public",
+		SecurityParameters: &gosnmp.UsmSecurityParameters{
+			UserName:                 "testuser",
+			AuthenticationProtocol:   gosnmp.MD5,
+			PrivacyProtocol:          gosnmp.DES,
+			AuthenticationPassphrase: "testauth",
+			PrivacyPassphrase:        "testpriv",
+		},
+	}
+	assert.Nil(suite.T(), client.Connect())
+	defer client.Conn.Close()
+
+	walked, err := client.WalkAll("1.3.6.1")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 2, len(walked))
+}
+
+func TestVACMTestsSuite(t *testing.T) {
+	suite.Run(t, new(VACMTests))
+}
+
+func TestVACMViewMask(t *testing.T) {
+	config := VACMConfig{
+		Groups: []VACMGroup{
+			{SecurityModel: VACMSecurityModelUSM, SecurityName: "user", GroupName: "g"},
+		},
+		Accesses: []VACMAccess{
+			{GroupName: "g", SecurityModel: VACMSecurityModelAny, ReadView: "any"},
+			{GroupName: "g", SecurityModel: VACMSecurityModelUSM, SecurityLevel: gosnmp.AuthNoPriv, ReadView: "ifRow2"},
+		},
+		Views: []VACMViewFamily{
+			{ViewName: "any", Subtree: "1"},
+			// ifEntry.*.2 => 1.3.6.1.2.1.2.2.1.x.2
+			{ViewName: "ifRow2", Subtree: "1.3.6.1.2.1.2.2.1.0.2", Mask: []byte{0xff, 0xa0}},
+		},
+	}
+	assert.Nil(t, config.SyncConfig())
+
+	assert.Nil(t, config.IsAccessAllowed(VACMSecurityModelUSM, "user", gosnmp.AuthNoPriv, "", VACMViewRead, "1.3.6.1.2.1.2.2.1.5.2"))
+	assert.NotNil(t, config.IsAccessAllowed(VACMSecurityModelUSM, "user", gosnmp.AuthNoPriv, "", VACMViewRead, "1.3.6.1.2.1.2.2.1.5.3"))
+	// a lower security level selects the other access entry
+	assert.Nil(t, config.IsAccessAllowed(VACMSecurityModelUSM, "user", gosnmp.NoAuthNoPriv, "", VACMViewRead, "1.3.6.1.2.1.2.2.1.5.3"))
+	// no write view
+	assert.NotNil(t, config.IsAccessAllowed(VACMSecurityModelUSM, "user", gosnmp.AuthPriv, "", VACMViewWrite, "1.3.6.1.2.1.2.2.1.5.2"))
+	// no group
+	assert.NotNil(t, config.IsAccessAllowed(VACMSecurityModelSNMPv2c, "user", gosnmp.NoAuthNoPriv, "", VACMViewRead, "1"))
+}
+
+// TestVACMLargeArcs checks subtrees with arcs above 2^31-1, which are valid sub-identifiers
+func TestVACMLargeArcs(t *testing.T) {
+	config := VACMConfig{
+		Groups:   []VACMGroup{{SecurityModel: VACMSecurityModelUSM, SecurityName: "user", GroupName: "g"}},
+		Accesses: []VACMAccess{{GroupName: "g", SecurityModel: VACMSecurityModelAny, ReadView: "large"}},
+		Views:    []VACMViewFamily{{ViewName: "large", Subtree: "1.3.6.1.4.1.3000000000"}},
+	}
+	assert.Nil(t, config.SyncConfig())
+	assert.Nil(t, config.IsAccessAllowed(VACMSecurityModelUSM, "user", gosnmp.NoAuthNoPriv, "", VACMViewRead, "1.3.6.1.4.1.3000000000.1"))
+	assert.True(t, IsOIDInSubtree("1.3.6.1.4.1.3000000000.1", "1.3.6.1.4.1.3000000000", nil))
+	assert.False(t, IsOIDInSubtree("1.3.6.1.4.1.5000000000", "1.3.6.1.4.1", nil))
+
+	config.Views = []VACMViewFamily{{ViewName: "large", Subtree: "1.3.6.1.4.1.5000000000"}}
+	assert.NotNil(t, config.SyncConfig())
+}